@@ -4,7 +4,6 @@ import (
 	"context"
 	"database/sql"
 	"github.com/sirupsen/logrus"
-	"html/template"
 	"net/http"
 	"strconv"
 
@@ -13,9 +12,9 @@ import (
 )
 
 type adminService struct {
-	db   *sql.DB
-	log  *logrus.Logger
-	tpls *template.Template
+	stores stores
+	log    *logrus.Logger
+	tpls   *templateRegistry
 }
 
 type capability struct {
@@ -29,33 +28,29 @@ type role struct {
 	ID           int64
 	Name         string
 	Description  string
+	ParentID     int64
+	ParentName   string
 	Capabilities []*capability
 }
 
-func NewAdminService(db *sql.DB, logger *logrus.Logger, tpls *template.Template) *adminService {
-	return &adminService{db, logger, tpls}
+func NewAdminService(store stores, logger *logrus.Logger, tpls *templateRegistry) *adminService {
+	return &adminService{store, logger, tpls}
 }
 
 func (s *adminService) index(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.IsAdmin && !authUser.Can([]string{"admin"}) {
-
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	authUser := currentUser(r)
 
 	pageData := page{Title: "Admin Panel - Welcome, " + authUser.Name + "!"}
 
 	view := NewView(w, r)
 
-	view.make("templates/admin/index.gohtml")
+	view.make("admin/index.gohtml")
 
 	err := view.exec(mainLayout, pageData)
 
 	if err != nil {
-		http.Error(w, "Error loading admin panel", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error loading admin panel", nil))
 
 		return
 	}
@@ -65,118 +60,49 @@ func (s *adminService) index(w http.ResponseWriter, r *http.Request, _ httproute
 
 func (s *adminService) users(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.IsAdmin && !authUser.Can([]string{"read_users"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	users := []user{}
-	roles, err := s.getRoles()
-
-	if err != nil {
-		s.log.Error("Error admin.users.getroles", err)
-	}
+	authUser := currentUser(r)
 
-	stmt, err := s.db.Prepare(`
-SELECT
-id,
-name,
-username,
-photo_url,
-role_id,
-deleted_at
-FROM goissuez.users
-`)
+	roles, err := s.stores.roles.all()
 
 	if err != nil {
-		s.log.Error("Error admin.users.perpare", err)
-
-		return
+		s.log.WithContext(r.Context()).Error("Error admin.users.getroles", err)
 	}
 
-	defer stmt.Close()
-
-	rows, err := stmt.Query()
+	users, err := s.stores.users.all()
 
 	if err != nil {
-		s.log.Error("Error admin.users.query", err)
+		s.log.WithContext(r.Context()).Error("Error admin.users.query", err)
 
+		respondError(w, r, internalError("Error loading users.", nil))
 		return
 	}
 
-	for rows.Next() {
-
-		userData := user{}
-		var photo_url sql.NullString
-		var role_id sql.NullInt64
-		var deleted_at sql.NullString
-
-		err := rows.Scan(
-			&userData.ID,
-			&userData.Name,
-			&userData.Username,
-			&photo_url,
-			&role_id,
-			&deleted_at,
-		)
-
-		if err != nil {
-			s.log.Error("Error admin.users.scan", err)
-
-			return
-		}
-
-		if deleted_at.Valid {
-			userData.DeletedAt = deleted_at.String
-		}
-
-		if photo_url.Valid {
-			userData.PhotoUrl = photo_url.String
-		}
-
-		if role_id.Valid {
-			userData.RoleID = role_id.Int64
-
-			for _, role := range roles {
-				if role.ID == userData.RoleID {
-					userData.Role = role
-				}
+	for i := range users {
+		for _, role := range roles {
+			if role.ID == users[i].RoleID {
+				users[i].Role = role
 			}
 		}
-
-		users = append(users, userData)
 	}
 
 	pageData := page{
 		Title: "Users",
 		Data: struct {
-			Users []user
-			Roles []role
-		}{users, roles},
-		Funcs: make(map[string]interface{}),
-	}
-
-	pageData.Funcs["ToJSON"] = func(userData user) string {
-		b, err := json.Marshal(userData)
-
-		if err != nil {
-			return ""
-		}
-
-		return string(b)
+			Users          []user
+			Roles          []role
+			CanImpersonate bool
+		}{users, roles, authUser.Can([]string{"impersonate_users"})},
 	}
 
 	view := viewService{w: w, r: r}
-	view.make("templates/admin/users.gohtml")
+	view.make("admin/users.gohtml")
 
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error("Error admin.users.view.exec", err)
+		s.log.WithContext(r.Context()).Error("Error admin.users.view.exec", err)
 
-		http.Error(w, "Error", http.StatusInternalServerError)
+		respondError(w, r, internalError("Something went wrong.", nil))
 		return
 	}
 
@@ -186,95 +112,48 @@ FROM goissuez.users
 
 func (s *adminService) roles(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.IsAdmin && !authUser.Can([]string{"read_role"}) {
-
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	roles := []role{}
-
-	stmt, err := s.db.Prepare(`
-SELECT
-id,
-name,
-description
-FROM goissuez.roles
-ORDER BY name
-`)
+	roles, err := s.stores.roles.all()
 
 	if err != nil {
-		s.log.Error("Error admin.roles.prepare.roles", err)
-		return
-	}
-
-	defer stmt.Close()
-
-	rows, err := stmt.Query()
+		s.log.WithContext(r.Context()).Error("Error admin.roles.query.roles", err)
 
-	if err != nil {
-		s.log.Error("Error admin.roles.query.roles", err)
+		respondError(w, r, internalError("Error loading roles.", nil))
 		return
 	}
 
-	for rows.Next() {
-
-		roleData := role{}
-
-		description := sql.NullString{}
+	capabilities, _ := s.stores.roles.capabilities()
 
-		err := rows.Scan(
-			&roleData.ID,
-			&roleData.Name,
-			&description,
-		)
-
-		if err != nil {
-			s.log.Error("Error admin.roles.scan.roles", err)
-			return
-		}
-
-		if description.Valid {
-			roleData.Description = description.String
-		}
+	assigned, err := s.stores.roles.assignedCapabilityIDs()
 
-		roles = append(roles, roleData)
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error admin.roles.getassignedcapabilityids", err)
 	}
 
-	capabilities, _ := s.getCapabilities()
+	unused, orphaned := auditCapabilities(capabilities, assigned)
 
 	pageData := page{
 		Title: "Roles",
 		Data: struct {
-			Roles        []role
-			Capabilities []*capability
+			Roles                []role
+			Capabilities         []*capability
+			UnusedCapabilities   []*capability
+			OrphanedCapabilities []*capability
 		}{
 			roles,
 			capabilities,
+			unused,
+			orphaned,
 		},
-		Funcs: make(map[string]interface{}),
-	}
-
-	pageData.Funcs["ToJSON"] = func(roleData role) string {
-		b, err := json.Marshal(roleData)
-
-		if err != nil {
-			return ""
-		}
-
-		return string(b)
 	}
 
 	view := viewService{w: w, r: r}
 
-	view.make("templates/admin/roles.gohtml")
+	view.make("admin/roles.gohtml")
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error("Error admin.roles.exec", err)
-		http.Error(w, "Error loading roles.", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error("Error admin.roles.exec", err)
+		respondError(w, r, internalError("Error loading roles.", nil))
 
 		return
 	}
@@ -285,74 +164,45 @@ ORDER BY name
 
 func (s *adminService) role(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
-	authUser, _ := auth.getAuthUser(r)
+	roleData, err := s.stores.roles.find(parseID(ps.ByName("role_id")))
 
-	if !authUser.IsAdmin && !authUser.Can([]string{"read_role"}) {
-
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Role not found."))
 		return
 	}
 
-	roleData := role{Capabilities: []*capability{}}
-	role_id := ps.ByName("role_id")
-
-	stmt, err := s.db.Prepare(`
-SELECT
-id,
-name,
-description
-FROM goissuez.roles
-WHERE id = $1
-LIMIT 1
-`)
-
 	if err != nil {
-		s.log.Error("Error admin.show.prepare.role", err)
-
-		http.Error(w, "Error loading role.", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error("Error admin.show.scan.role", err)
 
+		respondError(w, r, internalError("Error loading role.", nil))
 		return
 	}
 
-	defer stmt.Close()
-
-	row := stmt.QueryRow(role_id)
-
-	description := sql.NullString{}
-
-	err = row.Scan(
-		&roleData.ID,
-		&roleData.Name,
-		&description,
-	)
+	capabilities, err := s.stores.roles.capabilities()
 
 	if err != nil {
-		s.log.Error("Error admin.show.scan.role", err)
-
-		http.Error(w, "Error loading role.", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error("Error admin.show.role.getcapabilities", err)
+		respondError(w, r, internalError("Error loading role.", nil))
 		return
 	}
 
-	if description.Valid {
-		roleData.Description = description.String
-	}
+	roleData.Capabilities = capabilities
 
-	capabilities, err := s.getCapabilities()
+	// only the role's own permissions are editable here;
+	// inherited ones are shown but can only be changed on the parent role
+	permissions, err := s.stores.roles.permissions(roleData.ID)
 
 	if err != nil {
-		s.log.Error("Error admin.show.role.getcapabilities", err)
-		http.Error(w, "Error loading role.", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error("Error admin.show.role.getrolepermissions", err)
+		respondError(w, r, internalError("Error loading role.", nil))
 		return
 	}
 
-	roleData.Capabilities = capabilities
-
-	roleID, _ := strconv.ParseInt(role_id, 10, 64)
-	permissions, err := s.getRolePermissions(roleID)
+	inherited, err := s.getInheritedPermissions(roleData.ID)
 
 	if err != nil {
-		s.log.Error("Error admin.show.role.getrolepermissions", err)
-		http.Error(w, "Error loading role.", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error("Error admin.show.role.getinheritedpermissions", err)
+		respondError(w, r, internalError("Error loading role.", nil))
 		return
 	}
 
@@ -361,20 +211,22 @@ LIMIT 1
 		Data: struct {
 			Role        role
 			Permissions map[string]capability
+			Inherited   map[string]string
 		}{
 			roleData,
 			permissions,
+			inherited,
 		},
 	}
 
 	view := viewService{w: w, r: r}
 
-	view.make("templates/admin/role.gohtml")
+	view.make("admin/role.gohtml")
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error("Error admin.role.exec", err)
-		http.Error(w, "Error loading role.", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error("Error admin.role.exec", err)
+		respondError(w, r, internalError("Error loading role.", nil))
 
 		return
 	}
@@ -390,13 +242,16 @@ func (s *adminService) demo(w http.ResponseWriter, r *http.Request, ps httproute
 	username, ok := roles[role]
 
 	if !ok {
+		respondError(w, r, notFoundError("There's no demo user with that role."))
 		return
 	}
 
-	userData, err := getUserByUsername(s.db, username)
+	userData, err := s.stores.users.findByUsername(username)
 
 	if err != nil {
-		s.log.Error("Error admin.demo.getuserbyusername."+role, err)
+		s.log.WithContext(r.Context()).Error("Error admin.demo.getuserbyusername."+role, err)
+
+		respondError(w, r, internalError("Error logging in.", nil))
 		return
 	}
 
@@ -404,7 +259,9 @@ func (s *adminService) demo(w http.ResponseWriter, r *http.Request, ps httproute
 	err = auth.authenticateUser(userData.ID, w)
 
 	if err != nil {
-		s.log.Error("Error admin.demo.authenticateuser."+role, err)
+		s.log.WithContext(r.Context()).Error("Error admin.demo.authenticateuser."+role, err)
+
+		respondError(w, r, internalError("Error logging in.", nil))
 		return
 	}
 
@@ -413,353 +270,292 @@ func (s *adminService) demo(w http.ResponseWriter, r *http.Request, ps httproute
 	http.Redirect(w, r.WithContext(ctx), "/dashboard", http.StatusSeeOther)
 }
 
-// savePermissions saves an array of capability ids for a given role_id.
-// Each capability is saved separately.
+// savePermissions replaces the capabilities of a given role_id
+// with the submitted array of capability ids.
 func (s *adminService) savePermissions(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.IsAdmin && !authUser.Can([]string{"update_permissions"}) {
-
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
 	role_id := ps.ByName("role_id")
 
 	r.ParseForm()
 
-	permissions := r.Form["permissions"]
+	capabilityIDs := []int64{}
 
-	ctx := context.Background()
+	for _, id := range r.Form["permissions"] {
+		capabilityIDs = append(capabilityIDs, parseID(id))
+	}
 
-	tx, err := s.db.BeginTx(ctx, nil)
+	err := s.stores.roles.setPermissions(parseID(role_id), capabilityIDs)
 
 	if err != nil {
-		s.log.Error("Error admin.savePermissiones.begintx", err)
+		s.log.WithContext(r.Context()).Error("Error admin.savePermissiones.exec", err)
+
+		respondError(w, r, internalError("Error saving permissions.", nil))
 		return
 	}
 
-	stmt, err := tx.Prepare(`
-DELETE FROM goissuez.permissions
-WHERE role_id = $1
-`)
+	auth.cache.clear()
 
-	if err != nil {
-		s.log.Error("Error admin.savePermissiones.delete.prepare.", err)
-		tx.Rollback()
-		return
-	}
+	http.Redirect(w, r, "/roles/"+role_id, http.StatusSeeOther)
+}
 
-	defer stmt.Close()
+// getRolePermissions returns the effective capabilities of a role:
+// its own permissions plus everything inherited from its ancestors.
+func (s *adminService) getRolePermissions(role_id int64) (map[string]capability, error) {
+	permissions := make(map[string]capability)
 
-	_, err = stmt.Exec(role_id)
+	lineage, err := s.getRoleLineage(role_id)
 
 	if err != nil {
-		s.log.Error("Error admin.savePermissiones.delete.exec", err)
-		tx.Rollback()
-		return
+		return permissions, err
 	}
 
-	insertQuery := `
-	  INSERT INTO goissuez.permissions
-	  (role_id, capability_id) VALUES
-	`
+	for _, roleData := range lineage {
+		direct, err := s.stores.roles.permissions(roleData.ID)
 
-	// stmt.Exec requires []interface{}{}
-	vals := []interface{}{}
-
-	paramCount := 1
-	for i, id := range permissions {
-		if i < len(permissions)-1 {
-			insertQuery += `($` + strconv.Itoa(paramCount) + `, $` + strconv.Itoa(paramCount+1) + `),`
-		} else {
-			insertQuery += `($` + strconv.Itoa(paramCount) + `, $` + strconv.Itoa(paramCount+1) + `)`
+		if err != nil {
+			return permissions, err
 		}
 
-		vals = append(vals, role_id, id)
-		paramCount += 2
+		for name, capData := range direct {
+			permissions[name] = capData
+		}
 	}
 
-	stmt, err = tx.Prepare(insertQuery)
-
-	if err != nil {
-		s.log.Error("Error admin.savePermissiones.insert.prepare", err)
-		tx.Rollback()
-		return
-	}
+	return permissions, nil
+}
 
-	defer stmt.Close()
+// getInheritedPermissions maps each capability a role inherits
+// to the name of the nearest ancestor that grants it.
+func (s *adminService) getInheritedPermissions(role_id int64) (map[string]string, error) {
+	inherited := make(map[string]string)
 
-	_, err = stmt.Exec(vals...)
+	lineage, err := s.getRoleLineage(role_id)
 
 	if err != nil {
-		s.log.Error("Error admin.savePermissiones.insert.exec", err)
-		tx.Rollback()
-		return
+		return inherited, err
 	}
 
-	err = tx.Commit()
+	// lineage[0] is the role itself
+	for i := len(lineage) - 1; i > 0; i-- {
+		direct, err := s.stores.roles.permissions(lineage[i].ID)
 
-	if err != nil {
-		s.log.Error("Error admin.savePermissiones.delete.exec", err)
-		return
+		if err != nil {
+			return inherited, err
+		}
+
+		for name := range direct {
+			inherited[name] = lineage[i].Name
+		}
 	}
 
-	http.Redirect(w, r, "/roles/"+role_id, http.StatusSeeOther)
+	return inherited, nil
 }
 
-func (s *adminService) getCapabilities() ([]*capability, error) {
-	capabilities := []*capability{}
+// getRoleLineage returns the role followed by its ancestors, nearest first.
+// The walk stops at the first role it has already seen,
+// so a cycle in the data can never loop forever.
+func (s *adminService) getRoleLineage(role_id int64) ([]role, error) {
+	lineage := []role{}
 
-	stmt, err := s.db.Prepare(`
-SELECT
-id,
-name,
-description,
-"group"
-FROM goissuez.capabilities
-`)
+	seen := make(map[int64]bool)
 
-	if err != nil {
-		return capabilities, err
-	}
-
-	defer stmt.Close()
-
-	rows, err := stmt.Query()
-
-	if err != nil {
-		return capabilities, err
-	}
+	for id := role_id; id != 0 && !seen[id]; {
+		seen[id] = true
 
-	for rows.Next() {
-		data := capability{}
+		roleData, err := s.stores.roles.find(id)
 
-		description := sql.NullString{}
-		err := rows.Scan(
-			&data.ID,
-			&data.Name,
-			&description,
-			&data.Group,
-		)
+		if err == sql.ErrNoRows {
+			break
+		}
 
 		if err != nil {
-			return capabilities, err
+			return lineage, err
 		}
 
-		if description.Valid {
-			data.Description = description.String
-		}
+		lineage = append(lineage, roleData)
 
-		capabilities = append(capabilities, &data)
+		id = roleData.ParentID
 	}
 
-	return capabilities, nil
+	return lineage, nil
 }
 
-func (s *adminService) getRoles() ([]role, error) {
-	roles := []role{}
-
-	stmt, err := s.db.Prepare(`
-SELECT
-id,
-name,
-description
-FROM goissuez.roles
-`)
-
-	if err != nil {
-		return roles, err
+// wouldCreateCycle reports whether making parent_id the parent of role_id
+// would make the role inherit from itself.
+func (s *adminService) wouldCreateCycle(role_id, parent_id int64) (bool, error) {
+	if parent_id == 0 {
+		return false, nil
 	}
 
-	defer stmt.Close()
-
-	rows, err := stmt.Query()
+	lineage, err := s.getRoleLineage(parent_id)
 
 	if err != nil {
-		return roles, err
+		return false, err
 	}
 
-	for rows.Next() {
-
-		roleData := role{}
-
-		err := rows.Scan(
-			&roleData.ID,
-			&roleData.Name,
-			&roleData.Description,
-		)
-
-		if err != nil {
-			return roles, err
+	for _, ancestor := range lineage {
+		if ancestor.ID == role_id {
+			return true, nil
 		}
-
-		roles = append(roles, roleData)
 	}
 
-	return roles, nil
+	return false, nil
 }
 
-func (s *adminService) getRolePermissions(role_id int64) (map[string]capability, error) {
-	permissions := make(map[string]capability)
-
-	stmt, err := s.db.Prepare(`
-SELECT
-p.capability_id,
-c.name,
-c.description,
-c.group
-FROM goissuez.permissions p
-JOIN goissuez.capabilities c
-ON c.id = p.capability_id
-WHERE role_id = $1
-`)
-
-	if err != nil {
-		return permissions, err
+// canSetParent reports whether authUser may make a role inherit from parent_id.
+// Inheriting grants capabilities, so it takes update_permissions, and like
+// impersonating, nobody can hand out a capability they don't hold themselves.
+func (s *adminService) canSetParent(authUser user, parent_id int64) (bool, error) {
+	if !authUser.IsAdmin && !authUser.Can([]string{"update_permissions"}) {
+		return false, nil
 	}
 
-	defer stmt.Close()
+	if parent_id == 0 {
+		return true, nil
+	}
 
-	rows, err := stmt.Query(role_id)
+	return s.holdsRole(authUser, parent_id)
+}
 
-	if err != nil {
-		return permissions, err
-	}
+func (s *adminService) storeRole(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	authUser := currentUser(r)
 
-	for rows.Next() {
-		capData := capability{}
+	r.ParseForm()
 
-		description := sql.NullString{}
+	// an empty or invalid parent_id means the role has no parent
+	roleData := role{
+		Name:        r.PostFormValue("name"),
+		Description: r.PostFormValue("description"),
+		ParentID:    parseID(r.PostFormValue("parent_id")),
+	}
 
-		err := rows.Scan(
-			&capData.ID,
-			&capData.Name,
-			&description,
-			&capData.Group,
-		)
+	if roleData.ParentID != 0 {
+		allowed, err := s.canSetParent(authUser, roleData.ParentID)
 
 		if err != nil {
-			return permissions, err
-		}
+			s.log.WithContext(r.Context()).Error("Error admin.createrole.cansetparent.", err)
 
-		if description.Valid {
-			capData.Description = description.String
+			respondError(w, r, internalError("Something went wrong.", nil))
+			return
 		}
 
-		permissions[capData.Name] = capData
+		if !allowed {
+			respondError(w, r, forbiddenError("You can't make a role inherit capabilities you don't have."))
+			return
+		}
 	}
 
-	return permissions, nil
-}
-
-func (s *adminService) storeRole(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
+	_, err := s.stores.roles.create(roleData)
 
-	if !authUser.IsAdmin && !authUser.Can([]string{"create_role"}) {
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error admin.createrole.query.", err)
 
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		respondError(w, r, internalError("Something went wrong.", nil))
 		return
 	}
 
-	r.ParseForm()
-
-	name := r.PostFormValue("name")
-	description := r.PostFormValue("description")
+	http.Redirect(w, r, "/admin/roles", http.StatusSeeOther)
+}
 
-	stmt, err := s.db.Prepare(`
-INSERT INTO goissuez.roles
-(name, description)
-VALUES ($1, $2)
-RETURNING id
-`)
+func (s *adminService) updateRole(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	authUser := currentUser(r)
 
-	if err != nil {
-		s.log.Error("Error admin.createrole.prepare.", err)
+	r.ParseForm()
 
-		http.Error(w, "Error", http.StatusInternalServerError)
-		return
+	roleData := role{
+		ID:          parseID(ps.ByName("role_id")),
+		Name:        r.PostFormValue("name"),
+		Description: r.PostFormValue("description"),
+		ParentID:    parseID(r.PostFormValue("parent_id")),
 	}
 
-	defer stmt.Close()
+	existing, err := s.stores.roles.find(roleData.ID)
 
-	_, err = stmt.Exec(name, description)
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Role not found."))
+		return
+	}
 
 	if err != nil {
-		s.log.Error("Error admin.createrole.query.", err)
+		s.log.WithContext(r.Context()).Error("Error admin.updaterole.find. ", err)
 
-		http.Error(w, "Error", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error updating role.", nil))
 		return
 	}
 
-	http.Redirect(w, r, "/admin/roles", http.StatusSeeOther)
-}
+	if roleData.ParentID != existing.ParentID {
+		allowed, err := s.canSetParent(authUser, roleData.ParentID)
 
-func (s *adminService) updateRole(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
+		if err != nil {
+			s.log.WithContext(r.Context()).Error("Error admin.updaterole.cansetparent. ", err)
 
-	if !authUser.IsAdmin && !authUser.Can([]string{"update_role"}) {
+			respondError(w, r, internalError("Error updating role.", nil))
+			return
+		}
 
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
+		if !allowed {
+			respondError(w, r, forbiddenError("You can't make a role inherit capabilities you don't have."))
+			return
+		}
 	}
 
-	role_id := ps.ByName("role_id")
-
-	r.ParseForm()
-
-	name := r.PostFormValue("name")
-	description := r.PostFormValue("description")
-
-	stmt, err := s.db.Prepare(`
-UPDATE goissuez.roles
-SET name = $2,
-description = $3
-WHERE id = $1
-`)
+	cycle, err := s.wouldCreateCycle(roleData.ID, roleData.ParentID)
 
 	if err != nil {
-		s.log.Error("Error admin.updaterole.sql.prepare. ", err)
+		s.log.WithContext(r.Context()).Error("Error admin.updaterole.wouldcreatecycle. ", err)
 
-		http.Error(w, "Error updating role.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error updating role.", nil))
 		return
 	}
 
-	defer stmt.Close()
+	if cycle {
+		respondError(w, r, validationError("A role cannot inherit from itself or from a role that inherits from it."))
+		return
+	}
 
-	_, err = stmt.Exec(role_id, name, description)
+	err = s.stores.roles.update(roleData)
 
 	if err != nil {
-		s.log.Error("Error admin.updaterole.sql.queryrow. ", err)
+		s.log.WithContext(r.Context()).Error("Error admin.updaterole.sql.queryrow. ", err)
 
-		http.Error(w, "Error updating role.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error updating role.", nil))
 		return
 	}
 
+	// the parent may have changed, and with it the inherited permissions
+	auth.cache.clear()
+
 	http.Redirect(w, r, "/admin/roles", http.StatusSeeOther)
 }
 
 func (s *adminService) createRole(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
+	roles, err := s.stores.roles.all()
 
-	if !authUser.IsAdmin && !authUser.Can([]string{"create_role"}) {
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error admin.createrole.getroles", err)
 
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		respondError(w, r, internalError("Something went wrong.", nil))
 		return
 	}
 
-	pageData := page{Title: "Create a Role"}
+	pageData := page{
+		Title: "Create a Role",
+		Data: struct {
+			Roles []role
+		}{
+			roles,
+		},
+	}
 
 	view := NewView(w, r)
 
-	view.make("templates/admin/newrole.gohtml")
+	view.make("admin/newrole.gohtml")
 
-	err := view.exec(mainLayout, pageData)
+	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error("Error admin.createrole.view.exec", err)
+		s.log.WithContext(r.Context()).Error("Error admin.createrole.view.exec", err)
 
-		http.Error(w, "Error", http.StatusInternalServerError)
+		respondError(w, r, internalError("Something went wrong.", nil))
 		return
 	}
 
@@ -767,75 +563,66 @@ func (s *adminService) createRole(w http.ResponseWriter, r *http.Request, _ http
 }
 
 func (s *adminService) editRole(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
+	roleData, err := s.stores.roles.find(parseID(ps.ByName("role_id")))
 
-	if !authUser.IsAdmin && !authUser.Can([]string{"update_role"}) {
-
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Role not found."))
 		return
 	}
 
-	role_id := ps.ByName("role_id")
-
-	stmt, err := s.db.Prepare(`
-SELECT
-id,
-name,
-description
-FROM goissuez.roles
-WHERE id = $1
-LIMIT 1
-`)
-
 	if err != nil {
-		s.log.Error("Error admin.editrole.prepare.", err)
-
-		http.Error(w, "Error loading role.", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error("Error admin.editrole.scan.", err)
 
+		respondError(w, r, internalError("Error loading role.", nil))
 		return
 	}
 
-	defer stmt.Close()
-
-	row := stmt.QueryRow(role_id)
-
-	roleData := role{}
-	description := sql.NullString{}
-
-	err = row.Scan(
-		&roleData.ID,
-		&roleData.Name,
-		&description,
-	)
+	roles, err := s.stores.roles.all()
 
 	if err != nil {
-		s.log.Error("Error admin.editrole.scan.", err)
+		s.log.WithContext(r.Context()).Error("Error admin.editrole.getroles.", err)
 
-		http.Error(w, "Error loading role.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error loading role.", nil))
 		return
 	}
 
-	if description.Valid {
-		roleData.Description = description.String
+	// only offer parents that won't make the role inherit from itself
+	parents := []role{}
+
+	for _, parent := range roles {
+		cycle, err := s.wouldCreateCycle(roleData.ID, parent.ID)
+
+		if err != nil {
+			s.log.WithContext(r.Context()).Error("Error admin.editrole.wouldcreatecycle.", err)
+
+			respondError(w, r, internalError("Error loading role.", nil))
+			return
+		}
+
+		if !cycle {
+			parents = append(parents, parent)
+		}
 	}
 
 	pageData := page{
 		Title: "Edit Role : " + roleData.Name,
 		Data: struct {
-			Role role
+			Role    role
+			Parents []role
 		}{
 			roleData,
+			parents,
 		},
 	}
 
 	view := viewService{w: w, r: r}
 
-	view.make("templates/admin/editrole.gohtml")
+	view.make("admin/editrole.gohtml")
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error("Error admin.editrole.view.exec", err)
-		http.Error(w, "Error loading role.", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error("Error admin.editrole.view.exec", err)
+		respondError(w, r, internalError("Error loading role.", nil))
 
 		return
 	}
@@ -846,91 +633,180 @@ LIMIT 1
 
 func (s *adminService) destroyRole(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
-	authUser, _ := auth.getAuthUser(r)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	err := s.stores.roles.destroy(parseID(ps.ByName("role_id")))
 
-	if !authUser.IsAdmin && !authUser.Can([]string{"delete_role"}) {
+	if isForeignKeyViolation(err) {
+		respondError(w, r, conflictError("The role still has users."))
 
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-
-	role_id := ps.ByName("role_id")
-
-	stmt, err := s.db.Prepare(`DELETE from goissuez.roles WHERE id = $1`)
-
 	if err != nil {
-		s.log.Error("Error roles.destroy.prepare.", err)
+		s.log.WithContext(r.Context()).Error("Error role.destroy.exec.", err)
 
-		http.Error(w, "Error deleting role.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error deleting role.", nil))
 
 		return
 	}
 
-	defer stmt.Close()
+	auth.cache.clear()
 
-	_, err = stmt.Exec(role_id)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Success"))
+}
+
+func (s *adminService) setUserRole(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	var request struct {
+		UserID string `json:"user_id"`
+		RoleID string `json:"role_id"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&request)
 
 	if err != nil {
-		s.log.Error("Error role.destroy.exec.", err)
+		s.log.WithContext(r.Context()).Error("Error admin.setuserrole.decodejson", err)
 
-		http.Error(w, "Error deleting role.", http.StatusInternalServerError)
+		respondError(w, r, validationError("Choose a user and a role."))
+		return
+	}
 
+	err = s.stores.users.setRole(parseID(request.UserID), parseID(request.RoleID))
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error admin.setuserrole.exec", err)
+
+		respondError(w, r, internalError("Error saving the user's role.", nil))
 		return
 	}
 
+	auth.cache.forgetUser(parseID(request.UserID))
+
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Success"))
 }
 
-func (s *adminService) setUserRole(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.IsAdmin && !authUser.Can([]string{"update_users"}) {
+// cloneRole copies a role, its parent and its own permissions into a new role
+// and opens the copy for editing.
+func (s *adminService) cloneRole(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	cloneID, err := s.stores.roles.clone(parseID(ps.ByName("role_id")))
 
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Role not found."))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	var request struct {
-		UserID string `json:"user_id"`
-		RoleID string `json:"role_id"`
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error admin.clonerole.exec.", err)
+
+		respondError(w, r, internalError("Error cloning role.", nil))
+		return
 	}
 
-	err := json.NewDecoder(r.Body).Decode(&request)
+	http.Redirect(w, r, "/roles/"+strconv.FormatInt(cloneID, 10)+"/edit", http.StatusSeeOther)
+}
+
+// roleDiff compares the effective capabilities of two roles.
+type roleDiff struct {
+	Left      role
+	Right     role
+	OnlyLeft  []capability
+	OnlyRight []capability
+	Both      []capability
+}
+
+// diffRoles shows which effective capabilities two roles have in common
+// and which only one of them has. The roles are picked with ?left=&right=.
+func (s *adminService) diffRoles(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	roles, err := s.stores.roles.all()
 
 	if err != nil {
-		s.log.Error("Error admin.setuserrole.decodejson", err)
+		s.log.WithContext(r.Context()).Error("Error admin.diffroles.getroles.", err)
 
-		w.WriteHeader(http.StatusUnprocessableEntity)
+		respondError(w, r, internalError("Error comparing roles.", nil))
 		return
 	}
 
-	stmt, err := s.db.Prepare(`
-UPDATE goissuez.users
-SET role_id = $2
-WHERE id = $1
-`)
+	leftID := parseID(r.URL.Query().Get("left"))
+	rightID := parseID(r.URL.Query().Get("right"))
 
-	if err != nil {
-		s.log.Error("Error admin.setuserrole.prepare", err)
+	diff := roleDiff{
+		OnlyLeft:  []capability{},
+		OnlyRight: []capability{},
+		Both:      []capability{},
+	}
 
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		return
+	for _, roleData := range roles {
+		if roleData.ID == leftID {
+			diff.Left = roleData
+		}
+
+		if roleData.ID == rightID {
+			diff.Right = roleData
+		}
 	}
 
-	defer stmt.Close()
+	if diff.Left.ID != 0 && diff.Right.ID != 0 {
+		left, err := s.getRolePermissions(diff.Left.ID)
+
+		if err != nil {
+			s.log.WithContext(r.Context()).Error("Error admin.diffroles.getrolepermissions.left.", err)
+
+			respondError(w, r, internalError("Error comparing roles.", nil))
+			return
+		}
+
+		right, err := s.getRolePermissions(diff.Right.ID)
 
-	_, err = stmt.Exec(request.UserID, request.RoleID)
+		if err != nil {
+			s.log.WithContext(r.Context()).Error("Error admin.diffroles.getrolepermissions.right.", err)
+
+			respondError(w, r, internalError("Error comparing roles.", nil))
+			return
+		}
+
+		for name, capData := range left {
+			if _, ok := right[name]; ok {
+				diff.Both = append(diff.Both, capData)
+			} else {
+				diff.OnlyLeft = append(diff.OnlyLeft, capData)
+			}
+		}
+
+		for name, capData := range right {
+			if _, ok := left[name]; !ok {
+				diff.OnlyRight = append(diff.OnlyRight, capData)
+			}
+		}
+
+		sortCapabilities(diff.OnlyLeft)
+		sortCapabilities(diff.OnlyRight)
+		sortCapabilities(diff.Both)
+	}
+
+	pageData := page{
+		Title: "Compare Roles",
+		Data: struct {
+			Roles []role
+			Diff  roleDiff
+		}{
+			roles,
+			diff,
+		},
+	}
+
+	view := viewService{w: w, r: r}
+
+	view.make("admin/rolediff.gohtml")
+	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error("Error admin.setuserrole.exec", err)
+		s.log.WithContext(r.Context()).Error("Error admin.diffroles.exec", err)
+		respondError(w, r, internalError("Error comparing roles.", nil))
 
-		w.WriteHeader(http.StatusUnprocessableEntity)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	view.send(http.StatusOK)
 }
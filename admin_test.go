@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// TestRoleParentPrivileges checks a role can only be made to inherit capabilities
+// the user changing it has, and only by a user who can change permissions.
+func TestRoleParentPrivileges(t *testing.T) {
+	a := testApplication(t)
+
+	a.reset(t)
+	defer a.reset(t)
+
+	store := newSQLStores(a.db)
+	fx := a.fixtures
+
+	developer := fx.Roles["Developer"]
+
+	grantCapability(t, store, developer, "update_role")
+
+	reporter, err := store.roles.create(role{Name: "Reporter"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inherit := func(role string, parentID int64) int {
+		c := a.loginAs(t, role)
+
+		resp := c.do("POST", "/roles/"+formatID(reporter)+"/update", form(url.Values{
+			"name":      {"Reporter"},
+			"parent_id": {formatID(parentID)},
+		}))
+
+		return resp.StatusCode
+	}
+
+	// update_role alone can rename a role, but not change what it inherits
+	if status := inherit("developer", ADMIN); status != http.StatusForbidden {
+		t.Errorf("inheriting from Admin with update_role: got %d, want %d", status, http.StatusForbidden)
+	}
+
+	grantCapability(t, store, developer, "update_permissions")
+
+	if status := inherit("developer", ADMIN); status != http.StatusForbidden {
+		t.Errorf("inheriting from Admin with update_permissions: got %d, want %d", status, http.StatusForbidden)
+	}
+
+	if status := inherit("developer", fx.Roles["Manager"]); status != http.StatusForbidden {
+		t.Errorf("inheriting from Manager: got %d, want %d", status, http.StatusForbidden)
+	}
+
+	grantCapability(t, store, developer, "create_role")
+
+	c := a.loginAs(t, "developer")
+
+	resp := c.do("POST", "/roles", form(url.Values{"name": {"Deputy"}, "parent_id": {formatID(ADMIN)}}))
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("adding a role that inherits from Admin: got %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+
+	roleData, err := store.roles.find(reporter)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if roleData.ParentID != 0 {
+		t.Errorf("after the refused changes: got parent %d, want none", roleData.ParentID)
+	}
+
+	// a role whose capabilities the user has is fine
+	if status := inherit("developer", developer); status != http.StatusSeeOther {
+		t.Errorf("inheriting from Developer: got %d, want %d", status, http.StatusSeeOther)
+	}
+
+	if status := inherit("admin", fx.Roles["Manager"]); status != http.StatusSeeOther {
+		t.Errorf("an admin inheriting from Manager: got %d, want %d", status, http.StatusSeeOther)
+	}
+}
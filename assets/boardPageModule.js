@@ -0,0 +1,90 @@
+import env from './env'
+import axios from 'axios'
+
+export default () => {
+
+    const board = document.querySelector('[data-board]')
+
+    if (!board) {
+        return
+    }
+
+    const moveUrl = board.getAttribute('data-move-url')
+
+    let dragged = null
+
+    board.querySelectorAll('[data-board-card]').forEach(card => {
+        card.addEventListener('dragstart', evt => {
+            dragged = card
+            card.classList.add('dragging')
+            evt.dataTransfer.effectAllowed = 'move'
+        })
+
+        card.addEventListener('dragend', evt => {
+            card.classList.remove('dragging')
+            dragged = null
+        })
+    })
+
+    board.querySelectorAll('[data-board-cell]').forEach(cell => {
+        cell.addEventListener('dragover', evt => {
+            if (dragged) {
+                evt.preventDefault()
+                cell.classList.add('drop-target')
+            }
+        })
+
+        cell.addEventListener('dragleave', evt => {
+            cell.classList.remove('drop-target')
+        })
+
+        cell.addEventListener('drop', evt => {
+            evt.preventDefault()
+            cell.classList.remove('drop-target')
+
+            if (!dragged || dragged.parentNode === cell) {
+                return
+            }
+
+            const card = dragged
+            const from = card.parentNode
+
+            cell.appendChild(card)
+
+            axios.post(env.APP_URL + moveUrl, {
+                type: card.getAttribute('data-type'),
+                id: card.getAttribute('data-id'),
+                column_id: cell.getAttribute('data-board-cell'),
+            })
+                .then(resp => {
+                    resp.data.columns.forEach(updateColumn)
+                })
+                .catch(err => {
+                    from.appendChild(card)
+
+                    const message = err.response && err.response.data && err.response.data.error
+                    alert(message || "Could not move the issue.")
+                    console.error(err)
+                })
+        })
+    })
+
+    function updateColumn(column) {
+        const header = board.querySelector(`[data-board-column="${column.id}"]`)
+
+        if (!header) {
+            return
+        }
+
+        const count = header.querySelector('[data-board-count]')
+
+        count.textContent = column.wip_limit
+            ? `${column.count} / ${column.wip_limit}`
+            : `${column.count}`
+
+        count.classList.toggle('badge-danger', column.over_limit)
+        count.classList.toggle('badge-light', !column.over_limit)
+
+        header.querySelector('[data-board-warning]').classList.toggle('d-none', !column.over_limit)
+    }
+}
@@ -1,3 +1,6 @@
+// the base URL is set in the app config and handed to the page by the layout
+const baseURL = document.querySelector('meta[name="base-url"]')
+
 export default {
-    APP_URL: "http://localhost:8080"
+    APP_URL: baseURL ? baseURL.content : window.location.origin
 }
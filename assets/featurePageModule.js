@@ -56,6 +56,65 @@ export default () => {
             })
     }
 
+    // Ranking: drag a story or bug onto another to move it there
+    const rankUrls = {
+        story: id => `${env.APP_URL}/stories/${id}`,
+        bug: id => `${env.APP_URL}/bugs/${id}`,
+    }
+
+    document.querySelectorAll('[data-rank-list]').forEach(list => {
+        const type = list.getAttribute('data-rank-list')
+
+        let dragged = null
+
+        list.querySelectorAll('[data-rank-id]').forEach(item => {
+            item.addEventListener('dragstart', evt => {
+                dragged = item
+                item.classList.add('dragging')
+                evt.dataTransfer.effectAllowed = 'move'
+            })
+
+            item.addEventListener('dragend', evt => {
+                item.classList.remove('dragging')
+                dragged = null
+            })
+
+            item.addEventListener('dragover', evt => {
+                if (dragged && dragged !== item) {
+                    evt.preventDefault()
+                }
+            })
+
+            item.addEventListener('drop', evt => {
+                evt.preventDefault()
+
+                if (!dragged || dragged === item) {
+                    return
+                }
+
+                const moved = dragged
+                const next = moved.nextSibling
+
+                // dropped on the top half goes before the item, on the bottom half after it
+                const box = item.getBoundingClientRect()
+                const before = evt.clientY < box.top + box.height / 2
+
+                list.insertBefore(moved, before ? item : item.nextSibling)
+
+                axios.post(`${rankUrls[type](moved.getAttribute('data-rank-id'))}/rank-${before ? 'before' : 'after'}`, {
+                    [type + '_id']: item.getAttribute('data-rank-id'),
+                })
+                    .catch(err => {
+                        list.insertBefore(moved, next)
+
+                        const message = err.response && err.response.data && err.response.data.error
+                        alert(message || "Could not reorder.")
+                        console.error(err)
+                    })
+            })
+        })
+    })
+
     // Modal Events
     $modal.on('hidden.bs.modal', function (e) {
         deleteConfirmBtn.removeEventListener('click', confirmDelete)
@@ -8,6 +8,7 @@ import storyPageModule from './storyPageModule'
 import bugPageModule from './bugPageModule'
 import adminUserPageModule from './adminUserPageModule'
 import rolesPageModule from './rolesPageModule'
+import boardPageModule from './boardPageModule'
 
 window.onload = () => {
 
@@ -20,3 +21,4 @@ window.storyPageModule = storyPageModule
 window.bugPageModule = bugPageModule
 window.adminUserPageModule = adminUserPageModule
 window.rolesPageModule = rolesPageModule
+window.boardPageModule = boardPageModule
@@ -0,0 +1,12 @@
+//go:build !embedassets
+// +build !embedassets
+
+package main
+
+import "embed"
+
+// Without the embedassets tag the bundle is served from the assets directory,
+// so the app builds before webpack has run.
+var embeddedAssets embed.FS
+
+const assetsEmbedded = false
@@ -0,0 +1,14 @@
+//go:build embedassets
+// +build embedassets
+
+package main
+
+import "embed"
+
+// embeddedAssets holds the webpack bundle. Build with `npm run build` first,
+// then `go build -tags embedassets`; build.sh does both.
+//
+//go:embed public/assets/bundle*
+var embeddedAssets embed.FS
+
+const assetsEmbedded = true
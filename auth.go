@@ -4,7 +4,6 @@ import (
 	"context"
 	"database/sql"
 	"github.com/sirupsen/logrus"
-	"html/template"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -16,25 +15,52 @@ import (
 )
 
 type authService struct {
-	db   *sql.DB
-	log  *logrus.Logger
-	tpls *template.Template
+	stores stores
+	log    *logrus.Logger
+	tpls   *templateRegistry
+	cache  *sessionCache
 }
 
-func (s *authService) guard(next httprouter.Handle) httprouter.Handle {
+// guard loads the authenticated user and runs the route's permission checks
+// before calling next. Guests are sent to the login page, or get a 401
+// for non-GET requests; users failing a permission check get a 403.
+func (s *authService) guard(next httprouter.Handle, permissions ...permission) httprouter.Handle {
 
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
-		s.log.Error("Auth Middleware Used")
-
 		authUser, ok := s.getAuthUser(r)
 
 		if !ok {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			if r.Method == http.MethodGet {
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+			} else {
+				respondError(w, r, unauthorizedError("Log in to do that."))
+			}
 
 			return
 		}
 
+		for _, p := range permissions {
+			allowed, err := p(authUser, ps)
+
+			if err == sql.ErrNoRows {
+				respondError(w, r, notFoundError("Not found."))
+				return
+			}
+
+			if err != nil {
+				s.log.WithContext(r.Context()).Error("Error auth.guard.permission.", err)
+
+				respondError(w, r, internalError("Something went wrong.", nil))
+				return
+			}
+
+			if !allowed {
+				respondError(w, r, forbiddenError("You don't have permission to do that."))
+				return
+			}
+		}
+
 		// add our auth user to our context so other handlers
 		// have access to the user data
 		ctx := context.WithValue(r.Context(), "user", authUser)
@@ -43,8 +69,8 @@ func (s *authService) guard(next httprouter.Handle) httprouter.Handle {
 	}
 }
 
-func NewAuthService(db *sql.DB, logger *logrus.Logger, tpls *template.Template) *authService {
-	return &authService{db, logger, tpls}
+func NewAuthService(store stores, logger *logrus.Logger, tpls *templateRegistry) *authService {
+	return &authService{store, logger, tpls, newSessionCache(cfg.SessionCacheTTL)}
 }
 
 // Display a registration form.
@@ -63,12 +89,12 @@ func (s *authService) showRegistrationForm(w http.ResponseWriter, r *http.Reques
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/auth/registration-form.gohtml")
+	view.make("auth/registration-form.gohtml")
 	err := view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -96,44 +122,41 @@ func (s *authService) registerUser(w http.ResponseWriter, r *http.Request, _ htt
 	if len(form_names) > 0 && form_names[0] != "" {
 		name = form_names[0]
 	} else {
-		http.Error(w, "NAME is required.", http.StatusUnprocessableEntity)
+		respondError(w, r, validationError("NAME is required."))
 		return
 	}
 
 	if len(form_usernames) > 0 && form_usernames[0] != "" {
 		username = form_usernames[0]
 	} else {
-		http.Error(w, "USERNAME is required.", http.StatusUnprocessableEntity)
+		respondError(w, r, validationError("USERNAME is required."))
 		return
 	}
 
 	if len(form_emails) > 0 && form_emails[0] != "" {
 		email = form_emails[0]
 	} else {
-		http.Error(w, "EMAIL is required.", http.StatusUnprocessableEntity)
+		respondError(w, r, validationError("EMAIL is required."))
 		return
 	}
 
 	if len(form_passwords) > 0 && form_passwords[0] != "" {
-		pb := []byte(form_passwords[0])
-
-		b, err := bcrypt.GenerateFromPassword(pb, bcrypt.DefaultCost)
+		hash, err := hashPassword(form_passwords[0])
 
 		if err != nil {
-			http.Error(w, "There was an error saving your password.", http.StatusUnprocessableEntity)
+			respondError(w, r, validationError("There was an error saving your password."))
 			return
 		}
 
-		password = string(b)
+		password = hash
 	} else {
-		http.Error(w, "PASSWORD is required.", http.StatusUnprocessableEntity)
+		respondError(w, r, validationError("PASSWORD is required."))
 		return
 	}
 
 	// Profile photo is NOT required
-	// must use sql.NullString to ensure we have NULL set in the DB
-	// when we don't have a file.
-	var photoPathToSave sql.NullString
+	// the store saves NULL when we don't have a file.
+	var photoPathToSave string
 	{
 		// the profile_url is not required
 		pic, pic_header, err := r.FormFile("pic")
@@ -144,20 +167,22 @@ func (s *authService) registerUser(w http.ResponseWriter, r *http.Request, _ htt
 			bs, err := ioutil.ReadAll(pic)
 
 			if err != nil {
-				http.Error(w, err.Error(), 500)
+				respondError(w, r, internalError("Error saving the photo.", err))
 
 				return
 			}
 
-			photoPathToSave.String = "img/users/" + pic_header.Filename
-			photoPathToSave.Valid = true
+			// only keep the base name so uploads can't be written outside the upload dir
+			filename := filepath.Base(pic_header.Filename)
+
+			photoPathToSave = "uploads/" + filename
 
-			dstPath := filepath.Join("./public/assets/" + photoPathToSave.String)
+			dstPath := filepath.Join(cfg.UploadDir, filename)
 
 			dst, err := os.Create(dstPath)
 
 			if err != nil {
-				http.Error(w, err.Error(), 500)
+				respondError(w, r, internalError("Error saving the photo.", err))
 
 				return
 			}
@@ -168,53 +193,51 @@ func (s *authService) registerUser(w http.ResponseWriter, r *http.Request, _ htt
 			_, err = dst.Write(bs)
 
 			if err != nil {
-				http.Error(w, err.Error(), 500)
+				respondError(w, r, internalError("Error saving the photo.", err))
 
 				return
 			}
 		}
 	}
 
-	s.log.Error("Creating user: ", name, email, password, username, photoPathToSave)
+	s.log.WithContext(r.Context()).Debug("Creating user ", username)
 
 	// default to GUEST role
-	stmt, err := s.db.Prepare(`
-INSERT into goissuez.users (name, email, password, username, photo_url, role_id, created_at, updated_at, last_login )
-VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP) RETURNING id
-`)
-
-	if handleError(err, "Failed to prepare statement.") {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-
-		return
+	userData := user{
+		Name:     name,
+		Email:    email,
+		Password: password,
+		Username: username,
+		PhotoUrl: photoPathToSave,
+		RoleID:   GUEST,
 	}
 
-	defer stmt.Close()
+	id, err := s.stores.users.create(userData)
 
-	var (
-		id int64
-	)
+	if isUniqueViolation(err) {
+		respondError(w, r, conflictError("That username or email is already taken."))
 
-	err = stmt.QueryRow(name, email, password, username, photoPathToSave, GUEST).Scan(&id)
+		return
+	}
 
-	if handleError(err, "Failed to query row.") {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err != nil {
+		respondError(w, r, internalError("Error creating user.", err))
 
 		return
 	}
 
-	s.log.Info("Created user - ", id)
+	s.log.WithContext(r.Context()).Info("Created user - ", id)
 
 	// login
 	err = s.authenticateUser(id, w)
 
 	if err != nil {
-		http.Error(w, "Cannot login.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Cannot login.", nil))
 
 		return
 	}
 
-	authUser := user{ID: id, Name: name, Email: email, Username: username, PhotoUrl: photoPathToSave.String}
+	authUser := user{ID: id, Name: name, Email: email, Username: username, PhotoUrl: photoPathToSave}
 
 	ctx := context.WithValue(r.Context(), "user", authUser)
 
@@ -236,12 +259,12 @@ func (s *authService) showLoginForm(w http.ResponseWriter, r *http.Request, _ ht
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/auth/loginform.gohtml")
+	view.make("auth/loginform.gohtml")
 	err := view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -256,35 +279,57 @@ func (s *authService) loginUser(w http.ResponseWriter, r *http.Request, _ httpro
 	username := r.PostForm.Get("username")
 	password := r.PostForm.Get("password")
 
-	authUser, err := getUserByUsername(s.db, username)
+	authUser, err := s.stores.users.findByUsername(username)
+
+	// an unknown username gets the same answer as a wrong password
+	if err == sql.ErrNoRows {
+		metrics.login("failure")
+		respondError(w, r, unauthorizedError("Wrong username or password."))
+		return
+	}
 
 	if err != nil {
-		s.log.Error("Error auth.loginuser.", err)
+		metrics.login("failure")
+		s.log.WithContext(r.Context()).Error("Error auth.loginuser.", err)
 
-		http.Error(w, "Login failed.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Login failed.", nil))
 		return
 	}
 
 	if !s.verifyPassword(authUser, password) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		metrics.login("failure")
+		respondError(w, r, unauthorizedError("Wrong username or password."))
 		return
 	}
 
 	err = s.authenticateUser(authUser.ID, w)
 
 	if err != nil {
-		http.Error(w, "Cannot login.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Cannot login.", nil))
 
 		return
 	}
 
-	s.log.Error("Logged in user", authUser)
+	metrics.login("success")
+
+	s.log.WithField("user_id", authUser.ID).Info("Logged in user")
 
 	ctx := context.WithValue(r.Context(), "user", authUser)
 
 	http.Redirect(w, r.WithContext(ctx), "/dashboard", http.StatusSeeOther)
 }
 
+// hashPassword returns the bcrypt hash stored in place of a password.
+func hashPassword(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
 func (s *authService) verifyPassword(userData user, password string) bool {
 	if err := bcrypt.CompareHashAndPassword([]byte(userData.Password), []byte(password)); err != nil {
 		return false
@@ -303,49 +348,23 @@ func (s *authService) authenticateUser(user_id int64, w http.ResponseWriter) err
 		return err
 	}
 
-	stmt, err := s.db.Prepare(`INSERT into goissuez.sessions (uuid, user_id, created_at) values ($1, $2, CURRENT_TIMESTAMP) RETURNING user_id`)
+	err = s.stores.sessions.create(uuid.String(), user_id)
 
 	if err != nil {
 		s.log.Error("Error ", err)
 		return err
 	}
 
-	defer stmt.Close()
-
-	// clear out old sessions
-	// it's easier to just record a new session and clear old ones
-	// rather than update existing records with a new uuid
-	defer func() {
-		stmt, err := s.db.Prepare(`DELETE from goissuez.sessions WHERE user_id = $1 AND uuid != $2`)
-
-		if err != nil {
-			s.log.Error("Error s.db.Prepare() flushing sessions for user id: ", user_id, err)
-
-			return
-		}
-
-		defer stmt.Close()
-
-		_, err = stmt.Exec(user_id, uuid.String())
-
-		if err != nil {
-			s.log.Error("Error stmt.Exec() flushing sessions for user id: ", user_id, err)
-		}
-	}()
-
-	_, err = stmt.Exec(uuid.String(), user_id)
-
-	if err != nil {
-		s.log.Error("Error ", err)
-		return err
-	}
+	// the user's other sessions just ended, so they mustn't be served from the cache
+	s.cache.forgetUser(user_id)
 
 	http.SetCookie(w, &http.Cookie{
-		Name:   "goissuez",
-		Value:  uuid.String(),
-		MaxAge: (60 * 60 * 24),
-		Path:   "/",
-		// Secure: true,
+		Name:     cfg.CookieName,
+		Value:    uuid.String(),
+		MaxAge:   (60 * 60 * 24),
+		Path:     "/",
+		Secure:   cfg.CookieSecure,
+		SameSite: cfg.sameSite(),
 		HttpOnly: true, // not available to JS
 	})
 
@@ -353,7 +372,7 @@ func (s *authService) authenticateUser(user_id int64, w http.ResponseWriter) err
 }
 
 func (s *authService) logout(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	cookie, err := r.Cookie("goissuez")
+	cookie, err := r.Cookie(cfg.CookieName)
 
 	if err != nil {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
@@ -361,6 +380,15 @@ func (s *authService) logout(w http.ResponseWriter, r *http.Request, _ httproute
 		return
 	}
 
+	// don't leave an impersonation open once the admin signs out
+	err = admin.endImpersonation(cookie.Value)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error auth.logout.endimpersonation.", err)
+	}
+
+	s.cache.forget(cookie.Value)
+
 	cookie.MaxAge = -1
 
 	http.SetCookie(w, cookie)
@@ -374,12 +402,12 @@ func (s *authService) demo(w http.ResponseWriter, r *http.Request, ps httprouter
 
 	view := NewView(w, r)
 
-	view.make("templates/auth/demo.gohtml")
+	view.make("auth/demo.gohtml")
 
 	err := view.exec(mainLayout, pageData)
 
 	if err != nil {
-		http.Error(w, "Could not load page", http.StatusInternalServerError)
+		respondError(w, r, internalError("Could not load page", nil))
 		return
 	}
 
@@ -394,7 +422,7 @@ func (s *authService) getAuthUser(r *http.Request) (authUser user, ok bool) {
 		return authUser, true
 	}
 
-	cookie, err := r.Cookie("goissuez")
+	cookie, err := r.Cookie(cfg.CookieName)
 	userData := user{Role: role{}}
 
 	// no cookie == error
@@ -402,75 +430,33 @@ func (s *authService) getAuthUser(r *http.Request) (authUser user, ok bool) {
 		return userData, false
 	}
 
-	stmt, err := s.db.Prepare(`
-SELECT
-u.id,
-u.name,
-u.username,
-u.email,
-u.created_at,
-u.updated_at,
-u.last_login,
-u.role_id,
-r.name as role_name,
-r.description as role_description
-FROM goissuez.users u
-INNER JOIN goissuez.sessions s ON s.user_id = u.id
-LEFT JOIN goissuez.roles r ON r.id = u.role_id
-WHERE s.uuid = $1
-LIMIT 1
-`)
-
-	if err != nil {
-		s.log.Error("Error Prepare getAuthUser: ", err)
-		return userData, false
+	if cached, ok := s.cache.get(cookie.Value); ok {
+		return cached, true
 	}
 
-	defer stmt.Close()
-
-	row := stmt.QueryRow(cookie.Value)
-
-	roleID := sql.NullInt64{}
-	role_name := sql.NullString{}
-	role_description := sql.NullString{}
-
-	if err := row.Scan(
-		&userData.ID,
-		&userData.Name,
-		&userData.Username,
-		&userData.Email,
-		&userData.CreatedAt,
-		&userData.UpdatedAt,
-		&userData.LastLogin,
-		&roleID,
-		&role_name,
-		&role_description,
-	); err != nil {
+	generation := s.cache.begin()
+
+	userData, err = s.stores.sessions.user(cookie.Value)
+
+	if err != nil {
 		return userData, false
 	}
 
-	if roleID.Valid {
-		userData.RoleID = roleID.Int64
+	if userData.RoleID != 0 {
 		permissions, err := admin.getRolePermissions(userData.RoleID)
 
 		if err != nil {
-			s.log.Error("error getting user permissions", err)
+			s.log.WithContext(r.Context()).Error("error getting user permissions", err)
 			return userData, false
 		}
 
 		userData.Permissions = permissions
 	}
 
-	if role_name.Valid {
-		userData.Role.Name = role_name.String
-	}
-
-	if role_description.Valid {
-		userData.Role.Description = role_description.String
-	}
-
-	userData.IsAdmin = roleID.Valid && roleID.Int64 == ADMIN
+	userData.IsAdmin = userData.RoleID == ADMIN
 	userData.CanAdmin = userData.Can([]string{"admin"})
 
+	s.cache.put(cookie.Value, userData, generation)
+
 	return userData, true
 }
@@ -0,0 +1,704 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+type boardService struct {
+	stores stores
+	log    *logrus.Logger
+	tpls   *templateRegistry
+}
+
+// boardColumn is one column of a project's kanban board.
+type boardColumn struct {
+	ID        int64
+	ProjectID int64
+	Name      string
+	// Position orders the columns left to right.
+	Position int
+	// WIPLimit is how many issues the column should hold at most, 0 for no limit.
+	// Going over it is allowed, but the board warns about it.
+	WIPLimit int
+	// Done marks the columns that hold finished work. Moving an issue into one marks it done.
+	Done bool
+	// Count is how many issues are in the column, filled in when the board is built.
+	Count int
+}
+
+// OverLimit reports whether the column holds more issues than its WIP limit.
+func (c boardColumn) OverLimit() bool {
+	return c.WIPLimit > 0 && c.Count > c.WIPLimit
+}
+
+// defaultColumns are the columns every new project's board starts with.
+var defaultColumns = []boardColumn{
+	{Name: "To Do"},
+	{Name: "Doing"},
+	{Name: "Done", Done: true},
+}
+
+// boardCard is a story or bug on the board.
+type boardCard struct {
+	// Type is "story" or "bug".
+	Type       string
+	ID         int64
+	Name       string
+	Priority   priority
+	Severity   severity
+	Points     string
+	FeatureID  int64
+	Feature    string
+	AssigneeID int64
+	Assignee   *user
+	// columnID is the column the issue was last moved to and done whether it's done;
+	// the two decide the column the card is shown in.
+	columnID int64
+	done     bool
+}
+
+// Path is the card's issue page.
+func (c boardCard) Path() string {
+	if c.Type == "bug" {
+		return "/bugs/" + strconv.FormatInt(c.ID, 10)
+	}
+
+	return "/stories/" + strconv.FormatInt(c.ID, 10)
+}
+
+// boardLane is one row of the board; without swimlanes the board is a single lane.
+type boardLane struct {
+	Title string
+	// Cells has the lane's cards in each column, in the order of the board's columns.
+	Cells [][]boardCard
+}
+
+type board struct {
+	Columns []boardColumn
+	Lanes   []boardLane
+	// Swimlanes is what the lanes are split by: "assignee", "feature" or "" for none.
+	Swimlanes string
+}
+
+// swimlaneOptions are the ways the board can be split into lanes with ?lanes=.
+var swimlaneOptions = []sortOption{
+	{"", "No Swimlanes"},
+	{"assignee", "By Assignee"},
+	{"feature", "By Feature"},
+}
+
+func NewBoardService(store stores, log *logrus.Logger, tpls *templateRegistry) *boardService {
+	return &boardService{store, log, tpls}
+}
+
+// columnFor picks the column an issue is shown in: the one it was last moved to, unless it
+// has been marked done or reopened since, in which case it's the first column that fits.
+func columnFor(columns []boardColumn, columnID int64, done bool) int {
+	for i, c := range columns {
+		if c.ID == columnID && c.Done == done {
+			return i
+		}
+	}
+
+	for i, c := range columns {
+		if c.Done == done {
+			return i
+		}
+	}
+
+	// a board without a done column shows finished work at the end
+	if done {
+		return len(columns) - 1
+	}
+
+	return 0
+}
+
+// buildBoard places the cards in the columns, counting each column's cards
+// and splitting them into lanes by swimlanes.
+func buildBoard(columns []boardColumn, cards []boardCard, swimlanes string) board {
+	b := board{Columns: columns, Swimlanes: swimlanes}
+
+	if len(columns) == 0 {
+		return b
+	}
+
+	laneKey := func(c boardCard) (int64, string) { return 0, "" }
+
+	switch swimlanes {
+	case "assignee":
+		laneKey = func(c boardCard) (int64, string) {
+			if c.Assignee == nil {
+				return 0, "Unassigned"
+			}
+
+			return c.AssigneeID, c.Assignee.Name
+		}
+	case "feature":
+		laneKey = func(c boardCard) (int64, string) { return c.FeatureID, c.Feature }
+	default:
+		b.Swimlanes = ""
+	}
+
+	lanes := make(map[int64]*boardLane)
+	keys := []int64{}
+
+	for _, c := range cards {
+		key, title := laneKey(c)
+
+		lane, ok := lanes[key]
+
+		if !ok {
+			lane = &boardLane{Title: title, Cells: make([][]boardCard, len(columns))}
+			lanes[key] = lane
+			keys = append(keys, key)
+		}
+
+		i := columnFor(columns, c.columnID, c.done)
+
+		lane.Cells[i] = append(lane.Cells[i], c)
+		b.Columns[i].Count++
+	}
+
+	// lanes go by title, with the unassigned last
+	sort.SliceStable(keys, func(i, j int) bool {
+		if (keys[i] == 0) != (keys[j] == 0) {
+			return keys[j] == 0
+		}
+
+		return strings.ToLower(lanes[keys[i]].Title) < strings.ToLower(lanes[keys[j]].Title)
+	})
+
+	for _, key := range keys {
+		b.Lanes = append(b.Lanes, *lanes[key])
+	}
+
+	// an empty board still shows its columns
+	if len(b.Lanes) == 0 {
+		b.Lanes = []boardLane{{Cells: make([][]boardCard, len(columns))}}
+	}
+
+	return b
+}
+
+// load builds the project's board from its columns and the stories and bugs the user may read.
+func (s *boardService) load(authUser user, projectData project, swimlanes string) (board, error) {
+	columns, err := s.stores.boards.columns(projectData.ID)
+
+	if err != nil {
+		return board{}, err
+	}
+
+	stories, err := s.stores.stories.byProject(projectData.ID)
+
+	if err != nil {
+		return board{}, err
+	}
+
+	bugs, err := s.stores.bugs.byProject(projectData.ID)
+
+	if err != nil {
+		return board{}, err
+	}
+
+	stories = readableStories(authUser, stories)
+	bugs = readableBugs(authUser, bugs)
+
+	users, err := s.stores.users.all()
+
+	if err != nil {
+		return board{}, err
+	}
+
+	usersByID := make(map[int64]*user)
+
+	for i := range users {
+		usersByID[users[i].ID] = &users[i]
+	}
+
+	// stories then bugs, each in rank order
+	cards := []boardCard{}
+
+	for _, st := range stories {
+		st.Project = &projectData
+
+		cards = append(cards, boardCard{
+			Type:       "story",
+			ID:         st.ID,
+			Name:       st.Name,
+			Priority:   st.Priority,
+			Points:     st.PointsLabel(),
+			FeatureID:  st.FeatureID,
+			Feature:    st.Feature.Name,
+			AssigneeID: st.AssigneeID,
+			Assignee:   usersByID[st.AssigneeID],
+			columnID:   st.ColumnID,
+			done:       st.Done(),
+		})
+	}
+
+	for _, b := range bugs {
+		cards = append(cards, boardCard{
+			Type:       "bug",
+			ID:         b.ID,
+			Name:       b.Name,
+			Priority:   b.Priority,
+			Severity:   b.Severity,
+			FeatureID:  b.FeatureID,
+			Feature:    b.Feature.Name,
+			AssigneeID: b.AssigneeID,
+			Assignee:   usersByID[b.AssigneeID],
+			columnID:   b.ColumnID,
+			done:       b.Done(),
+		})
+	}
+
+	return buildBoard(columns, cards, swimlanes), nil
+}
+
+// show is the project's kanban board.
+func (s *boardService) show(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectData, ok := s.findProject(w, r, ps, "show")
+
+	if !ok {
+		return
+	}
+
+	b, err := s.load(currentUser(r), projectData, r.URL.Query().Get("lanes"))
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error boards.show.load.", err)
+
+		respondError(w, r, internalError("Error getting board.", nil))
+		return
+	}
+
+	pageData := page{
+		Title: projectData.Name + " Board",
+		Data: struct {
+			Project      project
+			Board        board
+			Swimlanes    sortLinks
+			CanConfigure bool
+		}{
+			projectData,
+			b,
+			sortLinks{Current: b.Swimlanes, Options: swimlaneOptions},
+			canOwnOrOthers(currentUser(r), "update_projects", projectData.UserID),
+		},
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+
+	view := viewService{w: w, r: r}
+	view.make("boards/board.gohtml")
+	err = view.exec(mainLayout, pageData)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
+
+		return
+	}
+
+	view.send(http.StatusOK)
+}
+
+// columnCount is a column's card count after a move, for the board to update its headers.
+type columnCount struct {
+	ID        int64 `json:"id"`
+	Count     int   `json:"count"`
+	WIPLimit  int   `json:"wip_limit"`
+	OverLimit bool  `json:"over_limit"`
+}
+
+// move puts a story or bug in another column of its project's board.
+// It's called by the board's drag and drop with JSON, and answers with every column's count.
+func (s *boardService) move(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	authUser := currentUser(r)
+
+	var request struct {
+		Type     string `json:"type"`
+		ID       string `json:"id"`
+		ColumnID string `json:"column_id"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+
+	if err != nil {
+		respondError(w, r, validationError("Send the type and id of the issue and the column_id to move it to."))
+		return
+	}
+
+	projectData, ok := s.findProject(w, r, ps, "move")
+
+	if !ok {
+		return
+	}
+
+	columnData, err := s.stores.boards.findColumn(parseID(request.ColumnID))
+
+	if err == sql.ErrNoRows || (err == nil && columnData.ProjectID != projectData.ID) {
+		respondError(w, r, validationError("That column isn't on the project's board."))
+		return
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error boards.move.column.", err)
+
+		respondError(w, r, internalError("Error moving issue.", nil))
+		return
+	}
+
+	var featureID int64
+	var done, allowed bool
+	var setColumn func(id, columnID int64) error
+	var setDone func(id int64, done bool) error
+
+	id := parseID(request.ID)
+
+	switch request.Type {
+	case "story":
+		var storyData story
+
+		storyData, err = s.stores.stories.find(id)
+		featureID, done = storyData.FeatureID, storyData.Done()
+		allowed = canOwnOrOthers(authUser, "update_stories", storyData.UserID, storyData.AssigneeID)
+		setColumn, setDone = s.stores.stories.setColumn, s.stores.stories.setDone
+	case "bug":
+		var bugData bug
+
+		bugData, err = s.stores.bugs.find(id)
+		featureID, done = bugData.FeatureID, bugData.Done()
+		allowed = canOwnOrOthers(authUser, "update_bugs", bugData.UserID, bugData.AssigneeID)
+		setColumn, setDone = s.stores.bugs.setColumn, s.stores.bugs.setDone
+	default:
+		respondError(w, r, validationError(`The type must be "story" or "bug".`))
+		return
+	}
+
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Issue not found."))
+		return
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error boards.move.issue.", err)
+
+		respondError(w, r, internalError("Error moving issue.", nil))
+		return
+	}
+
+	if !allowed {
+		respondError(w, r, forbiddenError("You can't move that issue."))
+		return
+	}
+
+	featureData, err := s.stores.features.find(featureID)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error boards.move.feature.", err)
+
+		respondError(w, r, internalError("Error moving issue.", nil))
+		return
+	}
+
+	if featureData.ProjectID != projectData.ID {
+		respondError(w, r, validationError("That issue isn't on the project's board."))
+		return
+	}
+
+	err = setColumn(id, columnData.ID)
+
+	if err == nil && done != columnData.Done {
+		err = setDone(id, columnData.Done)
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error boards.move.exec.", err)
+
+		respondError(w, r, internalError("Error moving issue.", nil))
+		return
+	}
+
+	b, err := s.load(authUser, projectData, "")
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error boards.move.load.", err)
+
+		respondError(w, r, internalError("Error moving issue.", nil))
+		return
+	}
+
+	counts := []columnCount{}
+
+	for _, c := range b.Columns {
+		counts = append(counts, columnCount{c.ID, c.Count, c.WIPLimit, c.OverLimit()})
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	json.NewEncoder(w).Encode(struct {
+		Columns []columnCount `json:"columns"`
+	}{counts})
+}
+
+// columns is the page for setting up the board's columns.
+func (s *boardService) columns(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectData, ok := s.findProject(w, r, ps, "columns")
+
+	if !ok {
+		return
+	}
+
+	columns, err := s.stores.boards.columns(projectData.ID)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error boards.columns.query.", err)
+
+		respondError(w, r, internalError("Error getting board columns.", nil))
+		return
+	}
+
+	pageData := page{
+		Title: projectData.Name + " Board Columns",
+		Data: struct {
+			Project project
+			Columns []boardColumn
+		}{
+			projectData,
+			columns,
+		},
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+
+	view := viewService{w: w, r: r}
+	view.make("boards/columns.gohtml")
+	err = view.exec(mainLayout, pageData)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
+
+		return
+	}
+
+	view.send(http.StatusOK)
+}
+
+// readColumn reads the name, position, WIP limit and done box of a column form into columnData.
+// A blank position keeps the column where it is.
+func readColumn(form url.Values, columnData *boardColumn) error {
+	columnData.Name = strings.TrimSpace(form.Get("name"))
+	columnData.Done = form.Get("done") != ""
+	columnData.WIPLimit = 0
+
+	if columnData.Name == "" {
+		return validationError("Give the column a name.")
+	}
+
+	if value := form.Get("position"); value != "" {
+		position, err := strconv.Atoi(value)
+
+		if err != nil {
+			return validationError("The position must be a number.")
+		}
+
+		columnData.Position = position
+	}
+
+	if value := form.Get("wip_limit"); value != "" && value != "0" {
+		limit, err := strconv.Atoi(value)
+
+		if err != nil || limit < 0 {
+			return validationError("The WIP limit must be a positive number, or blank for no limit.")
+		}
+
+		columnData.WIPLimit = limit
+	}
+
+	return nil
+}
+
+// addColumn adds a column to the right of the board.
+func (s *boardService) addColumn(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectData, ok := s.findProject(w, r, ps, "addColumn")
+
+	if !ok {
+		return
+	}
+
+	columns, err := s.stores.boards.columns(projectData.ID)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error boards.addColumn.query.", err)
+
+		respondError(w, r, internalError("Error saving column.", nil))
+		return
+	}
+
+	columnData := boardColumn{ProjectID: projectData.ID, Position: 1}
+
+	if len(columns) > 0 {
+		columnData.Position = columns[len(columns)-1].Position + 1
+	}
+
+	r.ParseForm()
+
+	err = readColumn(r.PostForm, &columnData)
+
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	_, err = s.stores.boards.createColumn(columnData)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error boards.addColumn.exec.", err)
+
+		respondError(w, r, internalError("Error saving column.", nil))
+		return
+	}
+
+	http.Redirect(w, r, columnsPath(projectData.ID), http.StatusSeeOther)
+}
+
+func (s *boardService) updateColumn(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	columnData, ok := s.findColumn(w, r, ps, "updateColumn")
+
+	if !ok {
+		return
+	}
+
+	r.ParseForm()
+
+	err := readColumn(r.PostForm, &columnData)
+
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	err = s.stores.boards.updateColumn(columnData)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error boards.updateColumn.exec.", err)
+
+		respondError(w, r, internalError("Error updating column.", nil))
+		return
+	}
+
+	http.Redirect(w, r, columnsPath(columnData.ProjectID), http.StatusSeeOther)
+}
+
+// destroyColumn deletes a column. Its issues move to the first column that fits them.
+func (s *boardService) destroyColumn(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	columnData, ok := s.findColumn(w, r, ps, "destroyColumn")
+
+	if !ok {
+		return
+	}
+
+	columns, err := s.stores.boards.columns(columnData.ProjectID)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error boards.destroyColumn.query.", err)
+
+		respondError(w, r, internalError("Error deleting column.", nil))
+		return
+	}
+
+	if len(columns) <= 1 {
+		respondError(w, r, conflictError("A board needs at least one column."))
+		return
+	}
+
+	err = s.stores.boards.destroyColumn(columnData.ID)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error boards.destroyColumn.exec.", err)
+
+		respondError(w, r, internalError("Error deleting column.", nil))
+		return
+	}
+
+	http.Redirect(w, r, columnsPath(columnData.ProjectID), http.StatusSeeOther)
+}
+
+// findProject looks up the project in the route, responding with an error if it can't.
+func (s *boardService) findProject(w http.ResponseWriter, r *http.Request, ps httprouter.Params, action string) (project, bool) {
+	projectData, err := s.stores.projects.find(parseID(ps.ByName("project_id")))
+
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Project not found."))
+		return project{}, false
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error boards."+action+".project.", err)
+
+		respondError(w, r, internalError("Error getting board.", nil))
+		return project{}, false
+	}
+
+	return projectData, true
+}
+
+// findColumn looks up the column in the route, responding with an error if it can't.
+func (s *boardService) findColumn(w http.ResponseWriter, r *http.Request, ps httprouter.Params, action string) (boardColumn, bool) {
+	columnData, err := s.stores.boards.findColumn(parseID(ps.ByName("column_id")))
+
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Column not found."))
+		return boardColumn{}, false
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error boards."+action+".find.", err)
+
+		respondError(w, r, internalError("Error getting column.", nil))
+		return boardColumn{}, false
+	}
+
+	return columnData, true
+}
+
+// owners returns the creator of the project named in the route, or of the project a column is on.
+func (s *boardService) owners(ps httprouter.Params) ([]int64, error) {
+	projectID := parseID(ps.ByName("project_id"))
+
+	if ps.ByName("column_id") != "" {
+		columnData, err := s.stores.boards.findColumn(parseID(ps.ByName("column_id")))
+
+		if err != nil {
+			return nil, err
+		}
+
+		projectID = columnData.ProjectID
+	}
+
+	projectData, err := s.stores.projects.find(projectID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []int64{projectData.UserID}, nil
+}
+
+// columnsPath is the page for setting up a project's board columns.
+func columnsPath(projectID int64) string {
+	return "/projects/" + strconv.FormatInt(projectID, 10) + "/board/columns"
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// testColumns is a board with its done column between columns for open work.
+func testColumns() []boardColumn {
+	return []boardColumn{
+		{ID: 1, Name: "To Do", WIPLimit: 0},
+		{ID: 2, Name: "Doing", WIPLimit: 2},
+		{ID: 3, Name: "Done", Done: true},
+		{ID: 4, Name: "Review"},
+	}
+}
+
+func TestColumnFor(t *testing.T) {
+	cases := []struct {
+		name     string
+		columns  []boardColumn
+		columnID int64
+		done     bool
+		want     int
+	}{
+		{"never moved", testColumns(), 0, false, 0},
+		{"moved to an open column", testColumns(), 2, false, 1},
+		{"moved to the done column", testColumns(), 3, true, 2},
+		{"moved past the done column", testColumns(), 4, false, 3},
+		{"marked done since", testColumns(), 2, true, 2},
+		{"reopened since", testColumns(), 3, false, 0},
+		{"column deleted since", testColumns(), 9, false, 0},
+		{"done, never moved", testColumns(), 0, true, 2},
+		{"done without a done column", []boardColumn{{ID: 1}, {ID: 2}}, 1, true, 1},
+		{"open with only done columns", []boardColumn{{ID: 1, Done: true}}, 0, false, 0},
+	}
+
+	for _, tc := range cases {
+		if got := columnFor(tc.columns, tc.columnID, tc.done); got != tc.want {
+			t.Errorf("%s: got column %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestBuildBoard(t *testing.T) {
+	ana := &user{Name: "ana"}
+	bo := &user{Name: "Bo"}
+
+	cards := []boardCard{
+		{Type: "story", ID: 1, FeatureID: 20, Feature: "Search", AssigneeID: 7, Assignee: ana, columnID: 2},
+		{Type: "story", ID: 2, FeatureID: 10, Feature: "Login", columnID: 2},
+		{Type: "bug", ID: 3, FeatureID: 10, Feature: "Login", AssigneeID: 8, Assignee: bo, columnID: 2},
+		{Type: "bug", ID: 4, FeatureID: 20, Feature: "Search", AssigneeID: 7, Assignee: ana, done: true},
+		{Type: "story", ID: 5, FeatureID: 10, Feature: "Login", AssigneeID: 8, Assignee: bo},
+	}
+
+	cases := []struct {
+		swimlanes string
+		want      string
+		// lanes are written title: cards in each column | separated
+		lanes []string
+	}{
+		{"", "", []string{": 5 | 1,2,3 | 4 | "}},
+		{"assignee", "assignee", []string{"ana:  | 1 | 4 | ", "Bo: 5 | 3 |  | ", "Unassigned:  | 2 |  | "}},
+		{"feature", "feature", []string{"Login: 5 | 2,3 |  | ", "Search:  | 1 | 4 | "}},
+		{"bogus", "", []string{": 5 | 1,2,3 | 4 | "}},
+	}
+
+	for _, tc := range cases {
+		b := buildBoard(testColumns(), cards, tc.swimlanes)
+
+		if b.Swimlanes != tc.want {
+			t.Errorf("%q lanes: got swimlanes %q, want %q", tc.swimlanes, b.Swimlanes, tc.want)
+		}
+
+		lanes := []string{}
+
+		for _, lane := range b.Lanes {
+			cells := []string{}
+
+			for _, cell := range lane.Cells {
+				ids := []string{}
+
+				for _, c := range cell {
+					ids = append(ids, formatID(c.ID))
+				}
+
+				cells = append(cells, strings.Join(ids, ","))
+			}
+
+			lanes = append(lanes, lane.Title+": "+strings.Join(cells, " | "))
+		}
+
+		if got, want := strings.Join(lanes, "\n"), strings.Join(tc.lanes, "\n"); got != want {
+			t.Errorf("%q lanes: got\n%s\nwant\n%s", tc.swimlanes, got, want)
+		}
+
+		// the counts and the WIP limit are across every lane
+		counts := []int{}
+		over := []bool{}
+
+		for _, c := range b.Columns {
+			counts = append(counts, c.Count)
+			over = append(over, c.OverLimit())
+		}
+
+		if counts[0] != 1 || counts[1] != 3 || counts[2] != 1 || counts[3] != 0 {
+			t.Errorf("%q lanes: got counts %v", tc.swimlanes, counts)
+		}
+
+		if over[0] || !over[1] || over[2] || over[3] {
+			t.Errorf("%q lanes: got over limit %v, want only Doing", tc.swimlanes, over)
+		}
+	}
+}
+
+func TestOverLimit(t *testing.T) {
+	cases := []struct {
+		limit, count int
+		want         bool
+	}{
+		{0, 100, false},
+		{3, 2, false},
+		{3, 3, false},
+		{3, 4, true},
+	}
+
+	for _, tc := range cases {
+		if got := (boardColumn{WIPLimit: tc.limit, Count: tc.count}).OverLimit(); got != tc.want {
+			t.Errorf("%d of %d: got %v, want %v", tc.count, tc.limit, got, tc.want)
+		}
+	}
+}
+
+func TestBuildBoardEmpty(t *testing.T) {
+	b := buildBoard(testColumns(), nil, "assignee")
+
+	if len(b.Lanes) != 1 || len(b.Lanes[0].Cells) != 4 {
+		t.Errorf("empty board: got %+v, want one lane of empty columns", b.Lanes)
+	}
+
+	if b := buildBoard(nil, []boardCard{{ID: 1}}, ""); len(b.Lanes) != 0 {
+		t.Errorf("board without columns: got %+v", b.Lanes)
+	}
+}
+
+// TestBoardReadable checks the board only shows the stories and bugs the user may read.
+func TestBoardReadable(t *testing.T) {
+	m := newMemoryApp(t)
+
+	viewerID, err := m.store.users.create(user{Name: "Viewer", Username: "viewer", Email: "viewer@example.com", Password: "-"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storyData, err := m.store.stories.find(m.fx.StoryIDs[1])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storyData.AssigneeID = viewerID
+
+	err = m.store.stories.update(storyData)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	projectData, err := m.store.projects.find(m.fx.ProjectID)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the viewer may read their own stories and everyone's bugs
+	viewer := user{ID: viewerID, RoleID: 99, Permissions: map[string]capability{
+		"read_stories_mine": {Name: "read_stories_mine"},
+		"read_bugs_mine":    {Name: "read_bugs_mine"},
+		"read_bugs_others":  {Name: "read_bugs_others"},
+	}}
+
+	b, err := m.boards.load(viewer, projectData, "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cards := []string{}
+
+	for _, lane := range b.Lanes {
+		for _, cell := range lane.Cells {
+			for _, c := range cell {
+				cards = append(cards, c.Type+" "+formatID(c.ID))
+			}
+		}
+	}
+
+	want := []string{"story " + formatID(m.fx.StoryIDs[1]), "bug " + formatID(m.fx.BugIDs[0]), "bug " + formatID(m.fx.BugIDs[1])}
+
+	if got := strings.Join(cards, ", "); got != strings.Join(want, ", ") {
+		t.Errorf("cards: got %s, want %s", got, strings.Join(want, ", "))
+	}
+
+	if b.Columns[0].Count != 3 {
+		t.Errorf("count of the first column: got %d, want only the readable 3", b.Columns[0].Count)
+	}
+}
@@ -2,19 +2,23 @@ package main
 
 import (
 	"database/sql"
-	"encoding/json"
-	"html/template"
 	"net/http"
-	"strconv"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 )
 
 type bugService struct {
-	db   *sql.DB
-	log  *logrus.Logger
-	tpls *template.Template
+	stores stores
+	log    *logrus.Logger
+	tpls   *templateRegistry
+}
+
+// projectBugCount is how many open bugs a project has.
+type projectBugCount struct {
+	ProjectID   int64
+	ProjectName string
+	Count       int
 }
 
 type bug struct {
@@ -24,98 +28,48 @@ type bug struct {
 	FeatureID   int64
 	UserID      int64
 	AssigneeID  int64
-	CreatedAt   string
-	UpdatedAt   string
-	Creator     *user
-	Assignee    *user
-	Feature     *feature
-	Project     *project
+	Priority    priority
+	Severity    severity
+	// SprintID is the sprint the bug is planned in, 0 while it's in the project's backlog.
+	SprintID int64
+	// CompletedAt is when the bug was marked done.
+	CompletedAt string
+	// ColumnID is the board column the bug was last moved to, 0 if it never was.
+	ColumnID int64
+	// Rank orders the bug among the others; lists are sorted by it.
+	Rank      string
+	CreatedAt string
+	UpdatedAt string
+	DeletedAt string
+	Creator   *user
+	Assignee  *user
+	Feature   *feature
+	Project   *project
+	// Labels are filled in by withBugLabels on the pages that show them.
+	Labels []label
 }
 
-func NewBugService(db *sql.DB, log *logrus.Logger, tpls *template.Template) *bugService {
-	return &bugService{db, log, tpls}
+func NewBugService(store stores, log *logrus.Logger, tpls *templateRegistry) *bugService {
+	return &bugService{store, log, tpls}
 }
 
 func (s *bugService) all(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"read_bugs_mine"}) && !authUser.Can([]string{"read_bugs_others"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	authUser := currentUser(r)
 
-	bugs := []bug{}
-
-	stmt, err := s.db.Prepare(`
-SELECT
-b.id,
-b.name,
-b.feature_id,
-b.user_id,
-b.assignee_id,
-b.created_at,
-b.updated_at,
-f.name as feature_name
-FROM goissuez.bugs b
-JOIN goissuez.features f
-ON f.id = b.feature_id
-WHERE b.deleted_at IS NULL
-ORDER BY b.updated_at
-`)
+	bugs, err := s.stores.bugs.all()
 
 	if err != nil {
-		s.log.Error("Error bugs.all.prepare.", err)
-		http.Error(w, "Error listing bugs.", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error("Error bugs.all.query.", err)
+		respondError(w, r, internalError("Error listing bugs.", nil))
 
 		return
 	}
 
-	defer stmt.Close()
-
-	rows, err := stmt.Query()
-
-	if err != nil {
-		s.log.Error("Error bugs.all.query.", err)
-		http.Error(w, "Error listing bugs.", http.StatusInternalServerError)
-
-		return
-	}
-
-	for rows.Next() {
-		bugData := bug{Feature: &feature{}}
-
-		var assignee_id sql.NullInt64
-
-		err := rows.Scan(
-			&bugData.ID,
-			&bugData.Name,
-			&bugData.FeatureID,
-			&bugData.UserID,
-			&assignee_id,
-			&bugData.CreatedAt,
-			&bugData.UpdatedAt,
-			&bugData.Feature.Name,
-		)
-
-		if err != nil {
-			s.log.Error("Error bugs.all.scan.", err)
-			http.Error(w, "Error listing bugs.", http.StatusInternalServerError)
-
-			return
-		}
-
-		if assignee_id.Valid {
-			bugData.AssigneeID = assignee_id.Int64
-		}
-
-		bugs = append(bugs, bugData)
-	}
-
 	// filter with permission checks
 	filteredBugs := []bug{}
 	{
 		for _, s := range bugs {
-			if s.AssigneeID == authUser.ID {
+			if isOwner(authUser.ID, s.UserID, s.AssigneeID) {
 				if authUser.Can([]string{"read_bugs_mine"}) {
 					filteredBugs = append(filteredBugs, s)
 				}
@@ -130,7 +84,21 @@ ORDER BY b.updated_at
 		bugs = filteredBugs
 	}
 
-	users, err := getUsers(s.db)
+	err = withBugLabels(s.stores, bugs)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error bugs.all.labels.", err)
+		respondError(w, r, internalError("Error listing bugs.", nil))
+
+		return
+	}
+
+	bugs, filter := filterBugs(bugs, r.URL.Query().Get("label"))
+
+	sorts := sortBugs(bugs, r.URL.Query().Get("sort"))
+	sorts.Label, filter.Sort = filter.Current, sorts.Current
+
+	users, err := s.stores.users.all()
 
 	usersByID := make(map[int64]*user)
 
@@ -162,21 +130,25 @@ ORDER BY b.updated_at
 	pageData := page{
 		Title: "Bugs",
 		Data: struct {
-			Bugs []bug
+			Bugs   []bug
+			Sorts  sortLinks
+			Labels labelFilter
 		}{
 			bugs,
+			sorts,
+			filter,
 		},
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/bugs/all.gohtml")
+	view.make("bugs/all.gohtml")
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -186,115 +158,39 @@ ORDER BY b.updated_at
 
 func (s *bugService) featureBugs(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
-	authUser, _ := auth.getAuthUser(r)
+	authUser := currentUser(r)
 
-	if !authUser.Can([]string{"read_bugs_mine"}) && !authUser.Can([]string{"read_bugs_others"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	featureData := feature{}
-
-	parentFeatureID := ps.ByName("feature_id")
-
-	stmt, err := s.db.Prepare(`SELECT id, name, description, project_id FROM goissuez.features WHERE id = $1 AND deleted_at IS NULL`)
-
-	if err != nil {
-		s.log.Error("Error bugs.index.prepare.feature.", err)
-
-		http.Error(w, "Error listing bugs.", http.StatusInternalServerError)
-
-		return
-	}
-
-	defer stmt.Close()
-
-	err = stmt.QueryRow(parentFeatureID).Scan(&featureData.ID, &featureData.Name, &featureData.Description, &featureData.ProjectID)
+	featureData, err := s.stores.features.find(parseID(ps.ByName("feature_id")))
 
 	if err != nil {
-		s.log.Error("Error bugs.index.scan.feature.", err)
+		s.log.WithContext(r.Context()).Error("Error bugs.index.feature.", err)
 
-		http.Error(w, "Error listing bugs.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error listing bugs.", nil))
 
 		return
 	}
 
-	query := `
-SELECT
-id,
-name,
-description,
-feature_id,
-user_id,
-assignee_id,
-created_at,
-updated_at
-FROM goissuez.bugs
-WHERE feature_id = $1
-ORDER BY created_at
-`
-	stmt, err = s.db.Prepare(query)
-
-	if err != nil {
-
-		s.log.Error("Error bugs.index.prepare.", err)
-
-		http.Error(w, "Error listing bugs.", http.StatusInternalServerError)
-
+	if featureData.DeletedAt != "" {
+		deletedEntityNotice("This feature has been deleted, so you cannot view its bugs.", w, r, s.log)
 		return
 	}
 
-	defer stmt.Close()
-
-	rows, err := stmt.Query(parentFeatureID)
+	bugs, err := s.stores.bugs.byFeature(featureData.ID)
 
 	if err != nil {
 
-		s.log.Error("Error bugs.index.query.", err)
+		s.log.WithContext(r.Context()).Error("Error bugs.index.query.", err)
 
-		http.Error(w, "Error listing bugs.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error listing bugs.", nil))
 
 		return
 	}
 
-	bugs := []bug{}
-	for rows.Next() {
-
-		bugData := bug{}
-		var assigneeID sql.NullInt64
-
-		err := rows.Scan(
-			&bugData.ID,
-			&bugData.Name,
-			&bugData.Description,
-			&bugData.FeatureID,
-			&bugData.UserID,
-			&assigneeID,
-			&bugData.CreatedAt,
-			&bugData.UpdatedAt,
-		)
-
-		if err != nil {
-
-			s.log.Error("Error bugs.index.scan.", err)
-
-			http.Error(w, "Error listing bugs.", http.StatusInternalServerError)
-
-			return
-		}
-
-		if assigneeID.Valid {
-			bugData.AssigneeID = assigneeID.Int64
-		}
-
-		bugs = append(bugs, bugData)
-	}
-
 	// filter with permission checks
 	filteredBugs := []bug{}
 	{
 		for _, s := range bugs {
-			if s.AssigneeID == authUser.ID || s.UserID == authUser.ID {
+			if isOwner(authUser.ID, s.UserID, s.AssigneeID) {
 				if authUser.Can([]string{"read_bugs_mine"}) {
 					filteredBugs = append(filteredBugs, s)
 				}
@@ -308,17 +204,38 @@ ORDER BY created_at
 		featureData.Bugs = filteredBugs
 	}
 
-	pageData := page{Title: "Bugs", Data: featureData}
+	err = withBugLabels(s.stores, featureData.Bugs)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error bugs.index.labels.", err)
+
+		respondError(w, r, internalError("Error listing bugs.", nil))
+
+		return
+	}
+
+	var filter labelFilter
+
+	featureData.Bugs, filter = filterBugs(featureData.Bugs, r.URL.Query().Get("label"))
+
+	sorts := sortBugs(featureData.Bugs, r.URL.Query().Get("sort"))
+	sorts.Label, filter.Sort = filter.Current, sorts.Current
+
+	pageData := page{Title: "Bugs", Data: struct {
+		Feature feature
+		Sorts   sortLinks
+		Labels  labelFilter
+	}{featureData, sorts, filter}}
 
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/bugs/bugs.gohtml")
+	view.make("bugs/bugs.gohtml")
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -327,62 +244,69 @@ ORDER BY created_at
 }
 
 func (s *bugService) store(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"create_bugs"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	authUser := currentUser(r)
 
 	feature_id := ps.ByName("feature_id")
 
 	r.ParseForm()
 
-	name := r.PostForm.Get("name")
-	description := r.PostForm.Get("description")
-	assignee_id := r.PostForm.Get("assignee_id")
+	bugData := bug{
+		Name:        r.PostForm.Get("name"),
+		Description: r.PostForm.Get("description"),
+		FeatureID:   parseID(feature_id),
+		UserID:      authUser.ID,
+		AssigneeID:  parseID(r.PostForm.Get("assignee_id")),
+	}
+
+	var err error
+
+	bugData.Priority, err = readPriority(authUser, r.PostForm, defaultPriority)
 
-	query := `
-INSERT INTO goissuez.bugs
-(name, description, feature_id, user_id, assignee_id, created_at, updated_at)
-VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-RETURNING id
-`
-	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	bugData.Severity, err = readSeverity(r.PostForm, defaultSeverity)
 
 	if err != nil {
-		s.log.Error("Error bugs.store.prepare.", err)
+		respondError(w, r, err)
+		return
+	}
 
-		http.Error(w, "Error creating bug.", http.StatusInternalServerError)
+	featureData, err := s.stores.features.find(bugData.FeatureID)
 
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Feature not found."))
 		return
 	}
 
-	defer stmt.Close()
+	var projectLabels []label
+
+	if err == nil {
+		projectLabels, err = s.stores.labels.byProject(featureData.ProjectID)
+	}
 
-	var assignee sql.NullInt64
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error bugs.store.labels.", err)
 
-	if assignee_id == "0" {
-		assignee = sql.NullInt64{}
-	} else {
-		v, err := strconv.ParseInt(assignee_id, 10, 64)
+		respondError(w, r, internalError("Error saving bug.", nil))
+		return
+	}
 
-		if err != nil {
-			assignee = sql.NullInt64{}
-		}
+	labelIDs, err := readLabelIDs(r.PostForm, projectLabels)
 
-		assignee = sql.NullInt64{
-			Int64: v,
-			Valid: true,
-		}
+	if err != nil {
+		respondError(w, r, err)
+		return
 	}
 
-	_, err = stmt.Exec(name, description, feature_id, authUser.ID, assignee)
+	_, err = s.stores.bugs.createWithLabels(bugData, labelIDs)
 
 	if err != nil {
-		s.log.Error("Error bugs.store.exec.", err)
+		s.log.WithContext(r.Context()).Error("Error bugs.store.exec.", err)
 
-		http.Error(w, "Error saving bug.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error saving bug.", nil))
 		return
 	}
 
@@ -390,105 +314,83 @@ RETURNING id
 }
 
 func (s *bugService) update(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
+	authUser := currentUser(r)
 
 	bug_id := ps.ByName("bug_id")
 
-	// ensure we have the correct permissions
-	{
-		bugData := bug{}
-
-		stmt, err := s.db.Prepare(`SELECT user_id, assignee_id FROM goissuez.bugs WHERE id = $1`)
+	existing, err := s.stores.bugs.find(parseID(bug_id))
 
-		if err != nil {
-			s.log.Error("Error bugs.update.prepare.", err)
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Bug not found."))
+		return
+	}
 
-			http.Error(w, "Error updating bug.", http.StatusInternalServerError)
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error bugs.update.find.", err)
 
-			return
-		}
+		respondError(w, r, internalError("Error updating bug.", nil))
 
-		assignee_id := sql.NullInt64{}
+		return
+	}
 
-		err = stmt.QueryRow(bug_id).Scan(
-			&bugData.UserID,
-			&assignee_id,
-		)
+	r.ParseForm()
 
-		if err != nil {
-			s.log.Error("Error bugs.update.scan.", err)
+	bugData := bug{
+		ID:          existing.ID,
+		Name:        r.PostForm.Get("name"),
+		Description: r.PostForm.Get("description"),
+		AssigneeID:  parseID(r.PostForm.Get("assignee_id")),
+	}
 
-			http.Error(w, "Error updating bug.", http.StatusInternalServerError)
+	bugData.Priority, err = readPriority(authUser, r.PostForm, existing.Priority)
 
-			return
-		}
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
 
-		if assignee_id.Valid {
-			bugData.AssigneeID = assignee_id.Int64
-		} else {
-			bugData.AssigneeID = 0
-		}
+	bugData.Severity, err = readSeverity(r.PostForm, existing.Severity)
 
-		if bugData.UserID == authUser.ID || bugData.AssigneeID == authUser.ID {
-			if !authUser.Can([]string{"update_bugs_mine"}) {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-		} else {
-			if !authUser.Can([]string{"update_bugs_others"}) {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-		}
+	if err != nil {
+		respondError(w, r, err)
+		return
 	}
 
-	r.ParseForm()
+	featureData, err := s.stores.features.find(existing.FeatureID)
 
-	name := r.PostForm.Get("name")
-	description := r.PostForm.Get("description")
-	assignee_id := r.PostForm.Get("assignee_id")
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Feature not found."))
+		return
+	}
 
-	query := `
-UPDATE goissuez.bugs
-SET name = $2, description = $3, assignee_id = $4, updated_at = CURRENT_TIMESTAMP
-WHERE id = $1
-`
+	var projectLabels []label
 
-	stmt, err := s.db.Prepare(query)
+	if err == nil {
+		projectLabels, err = s.stores.labels.byProject(featureData.ProjectID)
+	}
 
 	if err != nil {
-		s.log.Error("Error bugs.update.prepare.", err)
+		s.log.WithContext(r.Context()).Error("Error bugs.update.labels.", err)
 
-		http.Error(w, "Error updating bug.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error updating bug.", nil))
 
 		return
 	}
 
-	defer stmt.Close()
-
-	var assignee sql.NullInt64
+	labelIDs, err := readLabelIDs(r.PostForm, projectLabels)
 
-	if assignee_id == "0" {
-		assignee = sql.NullInt64{}
-	} else {
-		v, err := strconv.ParseInt(assignee_id, 10, 64)
-
-		if err != nil {
-			assignee = sql.NullInt64{}
-		} else {
-			assignee = sql.NullInt64{
-				Int64: v,
-				Valid: true,
-			}
-		}
+	if err != nil {
+		respondError(w, r, err)
+		return
 	}
 
-	_, err = stmt.Exec(bug_id, name, description, assignee)
+	// the done box is unchecked to reopen the bug
+	err = s.stores.bugs.save(bugData, labelIDs, r.PostForm.Get("done") != "")
 
 	if err != nil {
-		s.log.Error("Error bugs.update.exec.", err)
+		s.log.WithContext(r.Context()).Error("Error bugs.update.exec.", err)
 
-		http.Error(w, "Error updating bug.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error updating bug.", nil))
 
 		return
 	}
@@ -497,105 +399,65 @@ WHERE id = $1
 }
 
 func (s *bugService) edit(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"update_bugs_mine"}) && !authUser.Can([]string{"update_bugs_others"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	bug_id := ps.ByName("bug_id")
-
-	query := `
-SELECT
-id,
-name,
-description,
-feature_id,
-user_id,
-assignee_id,
-created_at,
-updated_at
-
-FROM goissuez.bugs
-WHERE id = $1
-LIMIT 1
-`
-
-	stmt, err := s.db.Prepare(query)
+	bugData, err := s.stores.bugs.find(parseID(ps.ByName("bug_id")))
 
 	if err != nil {
-		s.log.Error("Error bugs.edit.prepare.", err)
+		s.log.WithContext(r.Context()).Error("Error bugs.edit.scan.", err)
 
-		http.Error(w, "Error editing bug.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error editing bug.", nil))
 
 		return
 	}
 
-	defer stmt.Close()
+	featureData, err := s.stores.features.find(bugData.FeatureID)
 
-	row := stmt.QueryRow(bug_id)
+	var projectLabels []label
+	var bugLabels map[int64][]label
 
-	bugData := bug{}
-	var assigneeID sql.NullInt64
+	if err == nil {
+		projectLabels, err = s.stores.labels.byProject(featureData.ProjectID)
+	}
 
-	err = row.Scan(
-		&bugData.ID,
-		&bugData.Name,
-		&bugData.Description,
-		&bugData.FeatureID,
-		&bugData.UserID,
-		&assigneeID,
-		&bugData.CreatedAt,
-		&bugData.UpdatedAt,
-	)
+	if err == nil {
+		bugLabels, err = s.stores.labels.forBugs([]int64{bugData.ID})
+	}
 
 	if err != nil {
-		s.log.Error("Error bugs.edit.scan.", err)
+		s.log.WithContext(r.Context()).Error("Error bugs.edit.labels.", err)
 
-		http.Error(w, "Error editing bug.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error editing bug.", nil))
 
 		return
 	}
 
-	if assigneeID.Int64 == authUser.ID || bugData.UserID == authUser.ID {
-		if !authUser.Can([]string{"update_bugs_mine"}) {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-	} else {
-		if !authUser.Can([]string{"update_bugs_others"}) {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-	}
-
-	if assigneeID.Valid {
-		bugData.AssigneeID = assigneeID.Int64
-	}
-
-	users, _ := getUsers(s.db)
+	users, _ := s.stores.users.all()
 
 	pageData := page{
 		Title: "Edit Bug - " + bugData.Name,
 		Data: struct {
-			Bug   bug
-			Users []user
+			Bug        bug
+			Users      []user
+			Priority   priorityField
+			Severities []severity
+			Labels     labelPicker
 		}{
 			bugData,
 			users,
+			newPriorityField(currentUser(r), bugData.Priority),
+			severities,
+			newLabelPicker(projectLabels, bugLabels[bugData.ID]),
 		},
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/bugs/edit.gohtml")
+	view.make("bugs/edit.gohtml")
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -606,77 +468,53 @@ LIMIT 1
 // Show the new / create feature form.
 func (s *bugService) create(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"create_bugs"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	parentFeatureID := ps.ByName("feature_id")
-
-	query := `
-SELECT
-id,
-name,
-description,
-user_id,
-created_at,
-updated_at
-FROM goissuez.features
-WHERE id = $1
-LIMIT 1
-`
-
-	stmt, err := s.db.Prepare(query)
+	featureData, err := s.stores.features.find(parseID(ps.ByName("feature_id")))
 
 	if err != nil {
-		s.log.Error("Error bugs.create.prepare.", err)
+		s.log.WithContext(r.Context()).Error("Error bugs.create.scan.", err)
 
-		http.Error(w, "Error creating bug", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error creating bug.", nil))
 
 		return
 	}
 
-	defer stmt.Close()
-
-	row := stmt.QueryRow(parentFeatureID)
-
-	featureData := feature{}
-
-	err = row.Scan(
-		&featureData.ID,
-		&featureData.Name,
-		&featureData.Description,
-		&featureData.UserID,
-		&featureData.CreatedAt,
-		&featureData.UpdatedAt,
-	)
+	projectLabels, err := s.stores.labels.byProject(featureData.ProjectID)
 
 	if err != nil {
-		s.log.Error("Error bugs.create.scan.", err)
+		s.log.WithContext(r.Context()).Error("Error bugs.create.labels.", err)
 
-		http.Error(w, "Error creating bug.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error creating bug.", nil))
 
 		return
 	}
 
-	users, _ := getUsers(s.db)
+	users, _ := s.stores.users.all()
 
 	pageData := page{Title: "Log a Bug for " + featureData.Name, Data: struct {
-		Feature feature
-		Users   []user
-	}{Feature: featureData, Users: users}}
+		Feature         feature
+		Users           []user
+		Priority        priorityField
+		Severities      []severity
+		DefaultSeverity severity
+		Labels          labelPicker
+	}{
+		Feature:         featureData,
+		Users:           users,
+		Priority:        newPriorityField(currentUser(r), defaultPriority),
+		Severities:      severities,
+		DefaultSeverity: defaultSeverity,
+		Labels:          newLabelPicker(projectLabels, nil),
+	}}
 
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/bugs/new.gohtml")
+	view.make("bugs/new.gohtml")
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -685,129 +523,74 @@ LIMIT 1
 }
 
 func (s *bugService) show(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"read_bugs_mine"}) && !authUser.Can([]string{"read_bugs_others"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	bug_id := ps.ByName("bug_id")
-
-	query := `
-SELECT
-s.id,
-s.name,
-s.description,
-s.feature_id,
-s.user_id,
-s.assignee_id,
-s.created_at,
-s.updated_at,
-f.id,
-f.name
-FROM goissuez.bugs s
-JOIN goissuez.features f
-ON f.id = s.feature_id
-WHERE s.id = $1
-LIMIT 1
-`
-
-	stmt, err := s.db.Prepare(query)
-
-	if err != nil {
-		s.log.Error("Error bugs.show.prepare.", err)
-
-		http.Error(w, "Error getting bug.", http.StatusInternalServerError)
-
-		return
-	}
-
-	defer stmt.Close()
-
-	row := stmt.QueryRow(bug_id)
-
-	bugData := bug{Feature: &feature{}}
-
-	// this could be null if there is no assignee
-	var assigneeID sql.NullInt64
-
-	err = row.Scan(
-		&bugData.ID,
-		&bugData.Name,
-		&bugData.Description,
-		&bugData.FeatureID,
-		&bugData.UserID,
-		&assigneeID,
-		&bugData.CreatedAt,
-		&bugData.UpdatedAt,
-		&bugData.Feature.ID,
-		&bugData.Feature.Name,
-	)
+	bugData, err := s.stores.bugs.find(parseID(ps.ByName("bug_id")))
 
 	if err != nil {
-		s.log.Error("Error bugs.show.scan.", err)
+		s.log.WithContext(r.Context()).Error("Error bugs.show.scan.", err)
 
-		http.Error(w, "Error getting bug.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error getting bug.", nil))
 
 		return
 	}
 
-	if assigneeID.Int64 == authUser.ID || bugData.UserID == authUser.ID {
-		if !authUser.Can([]string{"read_bugs_mine"}) {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-	} else {
-		if !authUser.Can([]string{"read_bugs_others"}) {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-	}
-
-	if assigneeID.Valid {
-		bugData.AssigneeID = assigneeID.Int64
-
-		id := strconv.FormatInt(assigneeID.Int64, 10)
-
-		assignee, err := getUserByID(s.db, id)
+	if bugData.AssigneeID != 0 {
+		assignee, err := s.stores.users.find(bugData.AssigneeID)
 
 		if err != nil {
-			s.log.Error("Error bugs.show.getUserByID", err)
+			s.log.WithContext(r.Context()).Error("Error bugs.show.getUserByID", err)
 		} else {
 			bugData.Assignee = &assignee
 		}
 	}
 
-	creator, err := getUserByID(s.db, strconv.FormatInt(bugData.UserID, 10))
+	creator, err := s.stores.users.find(bugData.UserID)
 
 	if err != nil {
-		s.log.Error("Error bugs.show.getUserByID", err)
+		s.log.WithContext(r.Context()).Error("Error bugs.show.getUserByID", err)
 	} else {
 		bugData.Creator = &creator
 	}
 
-	pageData := page{Title: "Bug Details", Data: bugData, Funcs: make(map[string]interface{})}
+	bugLabels, err := s.stores.labels.forBugs([]int64{bugData.ID})
 
-	pageData.Funcs["ToJSON"] = func(bugData bug) string {
-		b, err := json.Marshal(bugData)
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error bugs.show.labels.", err)
 
-		if err != nil {
-			return ""
-		}
+		respondError(w, r, internalError("Error getting bug labels.", nil))
+
+		return
+	}
+
+	bugData.Labels = bugLabels[bugData.ID]
+
+	links, err := loadIssueLinks(
+		s.stores,
+		issueRef{Type: "bug", ID: bugData.ID, Name: bugData.Name},
+		canOwnOrOthers(currentUser(r), "update_bugs", bugData.UserID, bugData.AssigneeID),
+	)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error bugs.show.links.", err)
+
+		respondError(w, r, internalError("Error getting bug links.", nil))
 
-		return string(b)
+		return
 	}
 
+	pageData := page{Title: "Bug Details", Data: struct {
+		bug
+		Links issueLinks
+	}{bugData, links}}
+
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/bugs/bug.gohtml")
+	view.make("bugs/bug.gohtml")
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -816,128 +599,70 @@ LIMIT 1
 }
 
 func (s *bugService) destroy(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
-	bug_id := ps.ByName("bug_id")
+	err := s.stores.bugs.destroy(parseID(ps.ByName("bug_id")))
 
-	// ensure we have the correct permissions
-	{
-		bugData := bug{}
-
-		stmt, err := s.db.Prepare(`SELECT user_id, assignee_id FROM goissuez.bugs WHERE id = $1`)
-
-		if err != nil {
-			s.log.Error("Error bugs.destroy.prepare.", err)
-
-			http.Error(w, "Error deleting bug.", http.StatusInternalServerError)
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error bugs.destroy.exec.", err)
 
-			return
-		}
+		respondError(w, r, internalError("Error deleting bug.", nil))
 
-		assignee_id := sql.NullInt64{}
+		return
+	}
 
-		err = stmt.QueryRow(bug_id).Scan(
-			&bugData.UserID,
-			&assignee_id,
-		)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Success"))
+}
 
-		if err != nil {
-			s.log.Error("Error bugs.destroy.scan.", err)
+// rankBefore moves the bug just before another in every list, for drag and drop on the feature page.
+func (s *bugService) rankBefore(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s.rank(w, r, ps, s.stores.bugs.rankBefore)
+}
 
-			http.Error(w, "Error deleting bug.", http.StatusInternalServerError)
+// rankAfter moves the bug just after another in every list.
+func (s *bugService) rankAfter(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s.rank(w, r, ps, s.stores.bugs.rankAfter)
+}
 
-			return
-		}
+// rank ranks the bug in the route next to the one named by a JSON bug_id.
+func (s *bugService) rank(w http.ResponseWriter, r *http.Request, ps httprouter.Params, rankNextTo func(id, targetID int64) (string, error)) {
+	id := parseID(ps.ByName("bug_id"))
 
-		if assignee_id.Valid {
-			bugData.AssigneeID = assignee_id.Int64
-		} else {
-			bugData.AssigneeID = 0
-		}
+	targetID, err := readRankTarget(r, "bug_id")
 
-		if bugData.UserID == authUser.ID || bugData.AssigneeID == authUser.ID {
-			if !authUser.Can([]string{"delete_bugs_mine"}) {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-		} else {
-			if !authUser.Can([]string{"delete_bugs_others"}) {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-		}
+	if err == nil && targetID == id {
+		err = validationError("A bug can't be ranked next to itself.")
 	}
 
-	stmt, err := s.db.Prepare(`UPDATE goissuez.bugs SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1`)
-
 	if err != nil {
-		s.log.Error("Error bugs.destroy.prepare.", err)
-
-		http.Error(w, "Error deleting bug.", http.StatusInternalServerError)
-
+		respondError(w, r, err)
 		return
 	}
 
-	defer stmt.Close()
+	rank, err := rankNextTo(id, targetID)
 
-	_, err = stmt.Exec(bug_id)
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Bug not found."))
+		return
+	}
 
 	if err != nil {
-		s.log.Error("Error bugs.destroy.exec.", err)
-
-		http.Error(w, "Error deleting bug.", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error("Error bugs.rank.exec.", err)
 
+		respondError(w, r, internalError("Error ranking bug.", nil))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Success"))
+	sendRank(w, rank)
 }
 
-func (s *bugService) isPermitted(authUser user, bug_id string, permission string) (bool, error) {
-	permissions := map[string][]string{
-		"read":   []string{"read_bugs_mine", "read_bugs_others"},
-		"update": []string{"update_bugs_mine", "update_bugs_others"},
-		"delete": []string{"delete_bugs_mine", "delete_bugs_others"},
-	}
-
-	// ensure we have the correct permissions
-	bugData := bug{}
-
-	stmt, err := s.db.Prepare(`SELECT user_id, assignee_id FROM goissuez.bugs WHERE id = $1`)
-
-	if err != nil {
-		s.log.Error("Error bugs.destroy.getbug.prepare.", err)
-		return false, err
-	}
-
-	assignee_id := sql.NullInt64{}
-	err = stmt.QueryRow(bug_id).Scan(
-		&bugData.UserID,
-		&assignee_id,
-	)
+// owners returns the creator and assignee of the bug named in the route.
+func (s *bugService) owners(ps httprouter.Params) ([]int64, error) {
+	bugData, err := s.stores.bugs.find(parseID(ps.ByName("bug_id")))
 
 	if err != nil {
-		s.log.Error("Error bugs.destroy.getbug.scan.", err)
-		return false, err
-	}
-
-	if assignee_id.Valid {
-		bugData.AssigneeID = assignee_id.Int64
-	} else {
-		bugData.AssigneeID = 0
-	}
-
-	if bugData.UserID == authUser.ID || bugData.AssigneeID == authUser.ID {
-		if !authUser.Can([]string{permissions[permission][0]}) {
-			return false, nil
-		}
-	} else {
-		if !authUser.Can([]string{permissions[permission][1]}) {
-			return false, nil
-		}
+		return nil, err
 	}
 
-	return true, nil
+	return []int64{bugData.UserID, bugData.AssigneeID}, nil
 }
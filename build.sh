@@ -1,3 +1,9 @@
 #!/bin/sh
+set -e
 
-go run main.go views.go users.go stories.go projects.go features.go bugs.go auth.go admin.go
+# Builds the go-issuez binary with the templates, migrations and the
+# webpack bundle inside it. Run `./go-issuez help` for the commands,
+# or `./go-issuez serve` to start the server.
+# Add -from-disk (or -dev) to serve the files in the working directory instead.
+npm run build
+go build -tags embedassets -o go-issuez .
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// capabilityRegistry is the single source of truth for the capability names
+// checked with user.Can. It is synced to goissuez.capabilities at startup,
+// so adding a capability here is all that's needed to make it assignable to roles.
+var capabilityRegistry = []capability{
+	// projects
+	{Name: "create_projects", Group: "projects", Description: "Create new projects."},
+	{Name: "read_projects_mine", Group: "projects", Description: "View projects you created."},
+	{Name: "read_projects_others", Group: "projects", Description: "View projects created by others."},
+	{Name: "update_projects_mine", Group: "projects", Description: "Edit projects you created."},
+	{Name: "update_projects_others", Group: "projects", Description: "Edit projects created by others."},
+	{Name: "delete_projects_mine", Group: "projects", Description: "Delete projects you created."},
+	{Name: "delete_projects_others", Group: "projects", Description: "Delete projects created by others."},
+
+	// features
+	{Name: "create_features", Group: "features", Description: "Create project features."},
+	{Name: "read_features", Group: "features", Description: "View features."},
+	{Name: "update_features", Group: "features", Description: "Edit features."},
+	{Name: "delete_features", Group: "features", Description: "Delete features."},
+
+	// stories
+	{Name: "create_stories", Group: "stories", Description: "Create stories."},
+	{Name: "read_stories_mine", Group: "stories", Description: "View stories you created or are assigned to."},
+	{Name: "read_stories_others", Group: "stories", Description: "View everyone else's stories."},
+	{Name: "update_stories_mine", Group: "stories", Description: "Edit stories you created or are assigned to."},
+	{Name: "update_stories_others", Group: "stories", Description: "Edit everyone else's stories."},
+	{Name: "delete_stories_mine", Group: "stories", Description: "Delete stories you created or are assigned to."},
+	{Name: "delete_stories_others", Group: "stories", Description: "Delete everyone else's stories."},
+
+	// bugs
+	{Name: "create_bugs", Group: "bugs", Description: "Log bugs."},
+	{Name: "read_bugs_mine", Group: "bugs", Description: "View bugs you logged or are assigned to."},
+	{Name: "read_bugs_others", Group: "bugs", Description: "View everyone else's bugs."},
+	{Name: "update_bugs_mine", Group: "bugs", Description: "Edit bugs you logged or are assigned to."},
+	{Name: "update_bugs_others", Group: "bugs", Description: "Edit everyone else's bugs."},
+	{Name: "delete_bugs_mine", Group: "bugs", Description: "Delete bugs you logged or are assigned to."},
+	{Name: "delete_bugs_others", Group: "bugs", Description: "Delete everyone else's bugs."},
+
+	// triage
+	{Name: "set_p0_priority", Group: "triage", Description: "Make stories and bugs P0, ahead of everything else."},
+
+	// sprints
+	{Name: "plan_sprints", Group: "sprints", Description: "Create, start and close sprints and choose the stories and bugs in them."},
+
+	// admin
+	{Name: "admin", Group: "admin", Description: "Access the admin panel."},
+	{Name: "read_users", Group: "admin", Description: "View users."},
+	{Name: "update_users", Group: "admin", Description: "Change a user's role."},
+	{Name: "delete_users", Group: "admin", Description: "Delete users."},
+	{Name: "impersonate_users", Group: "admin", Description: "View the app as another user."},
+	{Name: "manage_jobs", Group: "admin", Description: "View background jobs and retry or discard failed ones."},
+	{Name: "manage_labels", Group: "admin", Description: "Rename and merge the labels of every project."},
+
+	// roles
+	{Name: "create_role", Group: "roles", Description: "Create roles."},
+	{Name: "read_role", Group: "roles", Description: "View roles."},
+	{Name: "update_role", Group: "roles", Description: "Rename roles."},
+	{Name: "delete_role", Group: "roles", Description: "Delete roles."},
+
+	// permissions
+	{Name: "update_permissions", Group: "permissions", Description: "Assign capabilities to roles."},
+}
+
+var registeredCapabilities map[string]capability
+
+// unknownCapabilities remembers names that were already flagged
+// so the log isn't flooded on every request.
+var unknownCapabilities sync.Map
+
+func init() {
+	registeredCapabilities = make(map[string]capability)
+
+	for _, c := range capabilityRegistry {
+		registeredCapabilities[c.Name] = c
+	}
+}
+
+// isRegisteredCapability reports whether name is in the capability registry.
+func isRegisteredCapability(name string) bool {
+	_, ok := registeredCapabilities[name]
+
+	return ok
+}
+
+// flagUnknownCapabilities logs any capability name that isn't in the registry.
+// These are almost always typos that would silently deny access.
+func flagUnknownCapabilities(capabilities []string) {
+	for _, c := range capabilities {
+		if isRegisteredCapability(c) {
+			continue
+		}
+
+		if _, seen := unknownCapabilities.LoadOrStore(c, true); seen {
+			continue
+		}
+
+		if log != nil {
+			log.Warn("Unknown capability checked with user.Can: ", c)
+		}
+	}
+}
+
+// syncCapabilities makes goissuez.capabilities match the registry.
+// Registered capabilities are inserted or updated; rows that are no longer
+// registered are left alone so existing permissions aren't lost,
+// and are reported as orphaned on the roles page instead.
+func (s *adminService) syncCapabilities() error {
+	return s.stores.roles.syncCapabilities(capabilityRegistry)
+}
+
+// auditCapabilities splits the stored capabilities into those no role has been granted
+// and those that exist in the database but not in the registry.
+func auditCapabilities(capabilities []*capability, assigned map[int64]bool) (unused []*capability, orphaned []*capability) {
+	unused = []*capability{}
+	orphaned = []*capability{}
+
+	for _, c := range capabilities {
+		if !isRegisteredCapability(c.Name) {
+			orphaned = append(orphaned, c)
+			continue
+		}
+
+		if !assigned[c.ID] {
+			unused = append(unused, c)
+		}
+	}
+
+	sort.Slice(unused, func(i, j int) bool { return unused[i].Name < unused[j].Name })
+	sort.Slice(orphaned, func(i, j int) bool { return orphaned[i].Name < orphaned[j].Name })
+
+	return unused, orphaned
+}
+
+// sortCapabilities orders capabilities by group, then by name.
+func sortCapabilities(capabilities []capability) {
+	sort.Slice(capabilities, func(i, j int) bool {
+		if capabilities[i].Group != capabilities[j].Group {
+			return capabilities[i].Group < capabilities[j].Group
+		}
+
+		return capabilities[i].Name < capabilities[j].Name
+	})
+}
@@ -0,0 +1,608 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+// commands are run as `go-issuez [flags] <command> [command flags] [args]`.
+// With no command the server is started.
+var commands = []command{
+	{"serve", "Start the web server. This is the default.", runServe},
+	{"migrate", "Apply database migrations.", runMigrate},
+	{"seed", "Load demo roles, users and a sample project.", runSeed},
+	{"create-admin", "Create an admin user.", runCreateAdmin},
+	{"user", "Manage users: reset-password.", runUserCommand},
+	{"export", "Write every table to a JSON file.", runExport},
+	{"import", "Load a JSON export into an empty database.", runImport},
+	{"roles", "Export roles to YAML or apply a YAML file.", runRolesCommand},
+}
+
+// runCommand runs the command named by the first argument.
+func runCommand(args []string) error {
+	if len(args) == 0 {
+		return runServe(nil)
+	}
+
+	if args[0] == "help" {
+		printUsage(os.Stdout)
+		return nil
+	}
+
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
+	}
+
+	return fmt.Errorf("unknown command %q, run `go-issuez help` for the list", args[0])
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: go-issuez [flags] <command> [command flags] [args]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-14s %s\n", c.name, c.summary)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run `go-issuez <command> -h` for a command's flags. The flags below apply to every command.")
+	fmt.Fprintln(w)
+}
+
+// commandFlags returns a flag set whose -h prints the command's usage and help text.
+func commandFlags(name, usage, help string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: go-issuez %s\n\n%s\n", usage, help)
+
+		hasFlags := false
+		flags.VisitAll(func(*flag.Flag) { hasFlags = true })
+
+		if hasFlags {
+			fmt.Fprintln(flags.Output())
+			flags.PrintDefaults()
+		}
+	}
+
+	return flags
+}
+
+func runServe(args []string) error {
+	flags := commandFlags("serve", "serve", "Start the web server on the configured address.")
+
+	err := flags.Parse(args)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(cfg.UploadDir, 0755)
+
+	if err != nil {
+		return err
+	}
+
+	db, err := connect()
+
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	_, err = startServices(db)
+
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:         cfg.ListenAddr,
+		Handler:      newRouter(),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+
+	serverErr := make(chan error, 1)
+
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	log.Info("Listening on ", cfg.ListenAddr)
+
+	stopWatching := make(chan struct{})
+	defer close(stopWatching)
+
+	if cfg.Dev {
+		go tpls.watch(time.Second, stopWatching)
+	}
+
+	if cfg.Workers > 0 {
+		jobs.start(cfg.Workers, cfg.JobPollInterval)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	select {
+	case err = <-serverErr:
+		return err
+	case sig := <-stop:
+		log.Info("Received ", sig, ", shutting down.")
+	}
+
+	// fail readiness checks so no new requests are routed here, keep serving
+	// until the load balancers notice, then let the requests in flight finish
+	// before the deadline
+	health.drain()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	select {
+	case <-time.After(cfg.DrainDelay):
+	case <-ctx.Done():
+	}
+
+	err = server.Shutdown(ctx)
+
+	if err != nil {
+		log.Error("Error serve.shutdown.", err)
+		return err
+	}
+
+	// the jobs get whatever time the requests left
+	err = jobs.shutdown(ctx)
+
+	if err != nil {
+		log.Error("Error serve.shutdown.jobs.", err)
+		return err
+	}
+
+	log.Info("Server stopped.")
+
+	return nil
+}
+
+func runMigrate(args []string) error {
+	flags := commandFlags("migrate", "migrate [-status]", "Apply the migrations that haven't been applied yet, in file name order.")
+	status := flags.Bool("status", false, "list the pending migrations without applying them")
+
+	err := flags.Parse(args)
+
+	if err != nil {
+		return err
+	}
+
+	db, err := connect()
+
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	if *status {
+		pending, err := db.pendingMigrations()
+
+		if err != nil {
+			return err
+		}
+
+		if len(pending) == 0 {
+			fmt.Println("Up to date.")
+		}
+
+		for _, name := range pending {
+			fmt.Println("pending", name)
+		}
+
+		return nil
+	}
+
+	applied, err := db.migrate()
+
+	for _, name := range applied {
+		fmt.Println("applied", name)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(applied) == 0 {
+		fmt.Println("Up to date.")
+	}
+
+	return nil
+}
+
+func runCreateAdmin(args []string) error {
+	flags := commandFlags("create-admin", "create-admin -username name -email address [-name full-name] [-password password]",
+		"Create a user in the Admin role. The password is read from stdin when -password isn't given.")
+	username := flags.String("username", "", "login name (required)")
+	email := flags.String("email", "", "email address (required)")
+	name := flags.String("name", "", "display name, defaults to the username")
+	password := flags.String("password", "", "password, read from stdin when empty")
+
+	err := flags.Parse(args)
+
+	if err != nil {
+		return err
+	}
+
+	if *username == "" || *email == "" {
+		flags.Usage()
+		return errors.New("-username and -email are required")
+	}
+
+	if *name == "" {
+		*name = *username
+	}
+
+	if *password == "" {
+		*password, err = readPassword()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	db, err := connect()
+
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	store, err := startServices(db)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = store.users.findByUsername(*username)
+
+	if err == nil {
+		return fmt.Errorf("user %q already exists", *username)
+	}
+
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	hash, err := hashPassword(*password)
+
+	if err != nil {
+		return err
+	}
+
+	id, err := store.users.create(user{Name: *name, Email: *email, Username: *username, Password: hash, RoleID: ADMIN})
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created admin %s (id %d).\n", *username, id)
+
+	return nil
+}
+
+const userUsage = `user reset-password [-password password] username`
+
+func runUserCommand(args []string) error {
+	if len(args) == 0 || args[0] != "reset-password" {
+		return errors.New("usage: go-issuez " + userUsage)
+	}
+
+	flags := commandFlags("user reset-password", userUsage,
+		"Set a new password for a user. The password is read from stdin when -password isn't given.")
+	password := flags.String("password", "", "new password, read from stdin when empty")
+
+	err := flags.Parse(args[1:])
+
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("a username is required")
+	}
+
+	if *password == "" {
+		*password, err = readPassword()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	db, err := connect()
+
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	store, err := startServices(db)
+
+	if err != nil {
+		return err
+	}
+
+	userData, err := store.users.findByUsername(flags.Arg(0))
+
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("no user %q", flags.Arg(0))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	hash, err := hashPassword(*password)
+
+	if err != nil {
+		return err
+	}
+
+	err = store.users.setPassword(userData.ID, hash)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Reset the password of %s.\n", userData.Username)
+
+	return nil
+}
+
+// readPassword reads a password from the first line of stdin.
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	password := strings.TrimRight(line, "\r\n")
+
+	if password == "" {
+		return "", errors.New("the password can't be empty")
+	}
+
+	return password, nil
+}
+
+func runExport(args []string) error {
+	flags := commandFlags("export", "export [file]",
+		"Write every table except sessions to JSON, or to stdout when no file is given.\n"+
+			"The export can be loaded into either database with `go-issuez import`.")
+
+	err := flags.Parse(args)
+
+	if err != nil {
+		return err
+	}
+
+	db, err := connect()
+
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	out := os.Stdout
+
+	if flags.NArg() > 0 {
+		out, err = os.Create(flags.Arg(0))
+
+		if err != nil {
+			return err
+		}
+
+		defer out.Close()
+	}
+
+	return exportData(db, out)
+}
+
+func runImport(args []string) error {
+	flags := commandFlags("import", "import file",
+		"Load an export made with `go-issuez export`. The database must not have any users yet;\n"+
+			"the default roles and capabilities are replaced by those in the file. A file of \"-\" reads stdin.")
+
+	err := flags.Parse(args)
+
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("a file is required")
+	}
+
+	in := os.Stdin
+
+	if flags.Arg(0) != "-" {
+		in, err = os.Open(flags.Arg(0))
+
+		if err != nil {
+			return err
+		}
+
+		defer in.Close()
+	}
+
+	db, err := connect()
+
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	if db.dialect == postgresDialect {
+		// make sure the tables exist before filling them
+		_, err = db.migrate()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	counts, err := importData(db, in)
+
+	if err != nil {
+		return err
+	}
+
+	for _, t := range exportTables {
+		fmt.Printf("%-15s %d\n", t, counts[t])
+	}
+
+	return nil
+}
+
+const rolesUsage = `usage:
+  go-issuez roles export [file]
+  go-issuez roles apply [-dry-run] file`
+
+// runRolesCommand exports roles to YAML or applies a YAML file back.
+// A file name of "-" reads from stdin.
+func runRolesCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(rolesUsage)
+	}
+
+	db, err := connect()
+
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	_, err = startServices(db)
+
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "export":
+		file, err := admin.exportRoles()
+
+		if err != nil {
+			return err
+		}
+
+		out, err := yaml.Marshal(file)
+
+		if err != nil {
+			return err
+		}
+
+		if len(args) > 1 {
+			return ioutil.WriteFile(args[1], out, 0644)
+		}
+
+		_, err = os.Stdout.Write(out)
+
+		return err
+
+	case "apply":
+		flags := flag.NewFlagSet("roles apply", flag.ContinueOnError)
+		dryRun := flags.Bool("dry-run", false, "show what would change without saving anything")
+
+		err := flags.Parse(args[1:])
+
+		if err != nil {
+			return err
+		}
+
+		if flags.NArg() != 1 {
+			return errors.New(rolesUsage)
+		}
+
+		var data []byte
+
+		if flags.Arg(0) == "-" {
+			data, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			data, err = ioutil.ReadFile(flags.Arg(0))
+		}
+
+		if err != nil {
+			return err
+		}
+
+		file, err := parseRoleFile(data)
+
+		if err != nil {
+			return err
+		}
+
+		plan, err := admin.planRoles(file)
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(plan.String())
+
+		for _, name := range plan.Unmanaged {
+			fmt.Printf("  (not in file, left alone: %s)\n", name)
+		}
+
+		if *dryRun || !plan.HasChanges() {
+			return nil
+		}
+
+		err = admin.applyRoles(plan)
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Applied.")
+
+		return nil
+	}
+
+	return errors.New(rolesUsage)
+}
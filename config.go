@@ -0,0 +1,333 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// config holds every setting the app reads at startup.
+type config struct {
+	ListenAddr string
+	// the server's timeouts, and how long shutdown waits for requests to finish
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+	// DrainDelay is how long shutdown keeps serving after failing /readyz, so load balancers
+	// stop sending requests first. It counts towards the ShutdownTimeout.
+	DrainDelay time.Duration
+	// BaseURL is the address users reach the app at, eg: https://issues.example.com
+	BaseURL string
+	// LogPath is a file, or "stdout" or "stderr".
+	LogPath      string
+	LogLevel     string
+	TemplatesDir string
+	AssetsDir    string
+	MainLayout   string
+	// UploadDir is where user photos are saved. It is served at /uploads.
+	UploadDir string
+	// Dev reloads templates when they change on disk, and implies FromDisk.
+	Dev bool
+	// FromDisk reads templates, assets and migrations from their directories
+	// instead of the copies built into the binary.
+	FromDisk bool
+	// SessionCacheTTL is how long a session's user and permissions are reused
+	// before being loaded again. 0 turns the cache off.
+	SessionCacheTTL time.Duration
+	// MetricsToken is the bearer token a scraper sends to read /metrics.
+	// Without one /metrics is off, as it names every project.
+	MetricsToken string
+	// Workers is how many background jobs serve runs at once. 0 runs none,
+	// eg: on servers that should only answer requests.
+	Workers         int
+	JobPollInterval time.Duration
+
+	CookieName     string
+	CookieSecure   bool
+	CookieSameSite string
+
+	DBDriver          string
+	PostgresConn      string
+	SQLitePath        string
+	DBMaxOpenConns    int
+	DBMaxIdleConns    int
+	DBConnMaxLifetime time.Duration
+}
+
+func defaultConfig() config {
+	return config{
+		ListenAddr:        ":8080",
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		ShutdownTimeout:   20 * time.Second,
+		DrainDelay:        5 * time.Second,
+		SessionCacheTTL:   time.Minute,
+		Workers:           2,
+		JobPollInterval:   time.Second,
+		BaseURL:           "http://localhost:8080",
+		LogPath:           "log",
+		LogLevel:          "info",
+		TemplatesDir:      "templates",
+		AssetsDir:         "public/assets",
+		MainLayout:        "dashboard_layout",
+		UploadDir:         "public/assets/img/users",
+		CookieName:        "goissuez",
+		CookieSecure:      false,
+		CookieSameSite:    "lax",
+		DBDriver:          "postgres",
+		SQLitePath:        "goissuez.db",
+		DBMaxOpenConns:    25,
+		DBMaxIdleConns:    5,
+		DBConnMaxLifetime: 30 * time.Minute,
+	}
+}
+
+// loadConfig builds the config from, in increasing order of precedence:
+// the defaults, the optional YAML file named by -config or $CONFIG_FILE,
+// environment variables, and command line flags.
+// It also returns the arguments left after the flags, which name a command to run.
+func loadConfig(args []string) (config, []string, error) {
+	cfg := defaultConfig()
+
+	flags := flag.NewFlagSet("goissuez", flag.ContinueOnError)
+	flags.Usage = func() {
+		printUsage(flags.Output())
+		flags.PrintDefaults()
+	}
+
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "optional YAML file of settings keyed by flag name ($CONFIG_FILE)")
+
+	// flag name -> environment variable
+	env := make(map[string]string)
+
+	str := func(p *string, name, key, usage string) {
+		flags.StringVar(p, name, *p, usage+" ($"+key+")")
+		env[name] = key
+	}
+
+	num := func(p *int, name, key, usage string) {
+		flags.IntVar(p, name, *p, usage+" ($"+key+")")
+		env[name] = key
+	}
+
+	dur := func(p *time.Duration, name, key, usage string) {
+		flags.DurationVar(p, name, *p, usage+" ($"+key+")")
+		env[name] = key
+	}
+
+	str(&cfg.ListenAddr, "listen", "LISTEN_ADDR", "address to serve on")
+	dur(&cfg.ReadTimeout, "read-timeout", "READ_TIMEOUT", "longest the server waits to read a request")
+	dur(&cfg.WriteTimeout, "write-timeout", "WRITE_TIMEOUT", "longest the server takes to write a response")
+	dur(&cfg.IdleTimeout, "idle-timeout", "IDLE_TIMEOUT", "how long idle keep-alive connections stay open")
+	dur(&cfg.ShutdownTimeout, "shutdown-timeout", "SHUTDOWN_TIMEOUT", "how long to wait for requests to finish when shutting down")
+	dur(&cfg.DrainDelay, "drain-delay", "DRAIN_DELAY", "how long to keep serving after failing readiness checks when shutting down, 0 to stop at once")
+	str(&cfg.BaseURL, "base-url", "BASE_URL", "public URL of the app")
+	str(&cfg.LogPath, "log", "LOG_PATH", "file to write the log to, or stdout or stderr")
+	str(&cfg.LogLevel, "log-level", "LOG_LEVEL", "least severe level logged: debug, info, warn or error")
+	str(&cfg.TemplatesDir, "templates", "TEMPLATES_DIR", "directory of .gohtml templates")
+	str(&cfg.AssetsDir, "assets", "ASSETS_DIR", "directory served at /resources")
+	str(&cfg.MainLayout, "layout", "MAIN_LAYOUT", "layout template pages render into")
+	str(&cfg.UploadDir, "uploads", "UPLOAD_DIR", "directory uploaded files are saved to")
+	flags.BoolVar(&cfg.Dev, "dev", cfg.Dev, "development mode: reload templates when they change ($DEV)")
+	env["dev"] = "DEV"
+	flags.BoolVar(&cfg.FromDisk, "from-disk", cfg.FromDisk, "serve templates, assets and migrations from disk instead of the binary ($FROM_DISK)")
+	env["from-disk"] = "FROM_DISK"
+	dur(&cfg.SessionCacheTTL, "session-cache-ttl", "SESSION_CACHE_TTL", "how long a session's user and permissions are reused, 0 to always load them")
+	str(&cfg.CookieName, "cookie-name", "COOKIE_NAME", "name of the session cookie")
+	flags.BoolVar(&cfg.CookieSecure, "cookie-secure", cfg.CookieSecure, "only send the session cookie over HTTPS ($COOKIE_SECURE)")
+	env["cookie-secure"] = "COOKIE_SECURE"
+	str(&cfg.CookieSameSite, "cookie-samesite", "COOKIE_SAMESITE", "SameSite mode of the session cookie: lax, strict or none")
+	str(&cfg.DBDriver, "db-driver", "DB_DRIVER", "database to use: postgres or sqlite")
+	str(&cfg.PostgresConn, "postgres", "POSTGRES_CONN_STRING", "Postgres connection string")
+	str(&cfg.SQLitePath, "sqlite-path", "SQLITE_PATH", "SQLite database file")
+	str(&cfg.MetricsToken, "metrics-token", "METRICS_TOKEN", "bearer token that reads /metrics, which is off without one")
+	num(&cfg.Workers, "workers", "WORKERS", "background jobs run at once, 0 to run none")
+	dur(&cfg.JobPollInterval, "job-poll", "JOB_POLL_INTERVAL", "how often idle workers check for jobs")
+	num(&cfg.DBMaxOpenConns, "db-max-open", "DB_MAX_OPEN_CONNS", "most open database connections, 0 for no limit")
+	num(&cfg.DBMaxIdleConns, "db-max-idle", "DB_MAX_IDLE_CONNS", "most idle database connections")
+	dur(&cfg.DBConnMaxLifetime, "db-conn-lifetime", "DB_CONN_MAX_LIFETIME", "longest a database connection is reused, 0 for forever")
+
+	err := flags.Parse(args)
+
+	if err != nil {
+		return cfg, nil, err
+	}
+
+	// flags win over everything, so remember them before the file and environment are applied
+	explicit := make(map[string]string)
+
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	if *configFile != "" {
+		err = applyConfigFile(flags, *configFile)
+
+		if err != nil {
+			return cfg, nil, err
+		}
+	}
+
+	for name, key := range env {
+		value, ok := os.LookupEnv(key)
+
+		if !ok {
+			continue
+		}
+
+		err = flags.Set(name, value)
+
+		if err != nil {
+			return cfg, nil, fmt.Errorf("$%s: %v", key, err)
+		}
+	}
+
+	for name, value := range explicit {
+		flags.Set(name, value)
+	}
+
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+
+	// reloading templates only makes sense if they're read from disk
+	if cfg.Dev {
+		cfg.FromDisk = true
+	}
+
+	return cfg, flags.Args(), cfg.validate()
+}
+
+// applyConfigFile sets each flag named in the YAML file, eg:
+//
+//	listen: ":8080"
+//	cookie-secure: true
+func applyConfigFile(flags *flag.FlagSet, path string) error {
+	contents, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	settings := make(map[string]interface{})
+
+	err = yaml.Unmarshal(contents, &settings)
+
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	for name, value := range settings {
+		if name == "config" || flags.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+
+		err = flags.Set(name, fmt.Sprint(value))
+
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", path, name, err)
+		}
+	}
+
+	return nil
+}
+
+// validate reports every problem with the config at once.
+func (c config) validate() error {
+	problems := []string{}
+
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		problems = append(problems, "listen: "+err.Error())
+	}
+
+	if u, err := url.Parse(c.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, "base-url must be an absolute http or https URL")
+	}
+
+	if info, err := os.Stat(c.TemplatesDir); c.FromDisk && (err != nil || !info.IsDir()) {
+		problems = append(problems, "templates: "+c.TemplatesDir+" is not a directory")
+	}
+
+	// the assets are built separately, so they may not exist yet
+	required := map[string]string{
+		"assets":      c.AssetsDir,
+		"log":         c.LogPath,
+		"layout":      c.MainLayout,
+		"uploads":     c.UploadDir,
+		"cookie-name": c.CookieName,
+	}
+
+	for name, value := range required {
+		if value == "" {
+			problems = append(problems, name+" is required")
+		}
+	}
+
+	if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+		problems = append(problems, "log-level: "+err.Error())
+	}
+
+	switch c.CookieSameSite {
+	case "lax", "strict":
+	case "none":
+		// browsers drop SameSite=None cookies that aren't Secure
+		if !c.CookieSecure {
+			problems = append(problems, "cookie-samesite none requires cookie-secure")
+		}
+	default:
+		problems = append(problems, "cookie-samesite must be lax, strict or none")
+	}
+
+	switch c.DBDriver {
+	case "postgres":
+		if c.PostgresConn == "" {
+			problems = append(problems, "postgres connection string is required")
+		}
+	case "sqlite":
+		if c.SQLitePath == "" {
+			problems = append(problems, "sqlite-path is required")
+		}
+	default:
+		problems = append(problems, "db-driver must be postgres or sqlite")
+	}
+
+	// requests in flight need some of the shutdown timeout too
+	if c.DrainDelay < 0 || c.DrainDelay >= c.ShutdownTimeout {
+		problems = append(problems, "drain-delay can't be negative and must be shorter than shutdown-timeout")
+	}
+
+	if c.Workers < 0 || c.JobPollInterval <= 0 {
+		problems = append(problems, "workers can't be negative and job-poll must be positive")
+	}
+
+	if c.DBMaxOpenConns < 0 || c.DBMaxIdleConns < 0 || c.DBConnMaxLifetime < 0 {
+		problems = append(problems, "database pool settings can't be negative")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+func (c config) sameSite() http.SameSite {
+	switch c.CookieSameSite {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+
+	return http.SameSiteLaxMode
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed five field cron expression:
+// minute, hour, day of month, month and day of week, eg: "30 3 * * 1-5".
+// Fields take *, numbers, ranges (1-5), lists (1,15) and steps (*/10, 0-30/5).
+// @hourly, @daily, @weekly and @monthly are also accepted.
+// Times are matched in UTC.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// with both days restricted a time matches either, as in cron
+	domAny, dowAny bool
+}
+
+var cronShortcuts = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+func parseCron(spec string) (cronSchedule, error) {
+	if expanded, ok := cronShortcuts[spec]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+
+	if len(fields) != 5 {
+		return cronSchedule{}, fmt.Errorf("cron %q: want 5 fields, got %d", spec, len(fields))
+	}
+
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+	sets := [5]uint64{}
+
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+
+		if err != nil {
+			return cronSchedule{}, fmt.Errorf("cron %q: %v", spec, err)
+		}
+
+		sets[i] = set
+	}
+
+	return cronSchedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField returns a bit set of the values the field allows.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+
+			step = n
+			part = part[:i]
+		}
+
+		from, to := min, max
+
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+
+			n, err := strconv.Atoi(bounds[0])
+
+			if err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+
+			from, to = n, n
+
+			if len(bounds) == 2 {
+				to, err = strconv.Atoi(bounds[1])
+
+				if err != nil {
+					return 0, fmt.Errorf("bad range %q", part)
+				}
+			} else if step > 1 {
+				// "5/15" means from 5 to the end, every 15
+				to = max
+			}
+		}
+
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+
+		for v := from; v <= to; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+
+	return set, nil
+}
+
+// matches reports whether the schedule fires in the minute t falls in.
+func (c cronSchedule) matches(t time.Time) bool {
+	t = t.UTC()
+
+	has := func(set uint64, v int) bool {
+		return set&(1<<uint(v)) != 0
+	}
+
+	if !has(c.minute, t.Minute()) || !has(c.hour, t.Hour()) || !has(c.month, int(t.Month())) {
+		return false
+	}
+
+	dom := has(c.dom, t.Day())
+	dow := has(c.dow, int(t.Weekday()))
+
+	if !c.domAny && !c.dowAny {
+		return dom || dow
+	}
+
+	return dom && dow
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// exportTables are exported and imported in this order, so the rows a row refers to already exist.
+// Sessions and background jobs are left out; everyone logs in again after an import.
+var exportTables = []string{
+	"roles",
+	"capabilities",
+	"permissions",
+	"users",
+	"impersonations",
+	"projects",
+	"board_columns",
+	"labels",
+	"features",
+	"sprints",
+	"stories",
+	"bugs",
+	"issue_links",
+	"story_labels",
+	"bug_labels",
+}
+
+// dataExport is the JSON form of every exported table, one object per row keyed by column.
+// Timestamps are written in UTC as RFC 3339 so an export from one database loads into the other.
+type dataExport struct {
+	Version int                                 `json:"version"`
+	Tables  map[string][]map[string]interface{} `json:"tables"`
+}
+
+var columnName = regexp.MustCompile(`^[a-z_]+$`)
+
+// isTimestampColumn reports whether a column holds a timestamp, going by the schema's naming.
+func isTimestampColumn(column string) bool {
+	return strings.HasSuffix(column, "_at") || column == "last_login"
+}
+
+// isDateColumn reports whether a column holds a day without a time, eg: a sprint's starts_on.
+// Dates are written as "2006-01-02", which both databases read back as the same day.
+func isDateColumn(column string) bool {
+	return strings.HasSuffix(column, "_on")
+}
+
+func exportData(db *sqlDB, w io.Writer) error {
+	export := dataExport{Version: 1, Tables: make(map[string][]map[string]interface{})}
+
+	for _, table := range exportTables {
+		rows, err := db.Query(`SELECT * FROM goissuez.` + table + ` ORDER BY 1`)
+
+		if err != nil {
+			return err
+		}
+
+		columns, err := rows.Columns()
+
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		export.Tables[table] = []map[string]interface{}{}
+
+		for rows.Next() {
+			values := make([]interface{}, len(columns))
+			pointers := make([]interface{}, len(columns))
+
+			for i := range values {
+				pointers[i] = &values[i]
+			}
+
+			err = rows.Scan(pointers...)
+
+			if err != nil {
+				rows.Close()
+				return err
+			}
+
+			row := make(map[string]interface{})
+
+			for i, column := range columns {
+				switch v := values[i].(type) {
+				case []byte:
+					row[column] = string(v)
+				case time.Time:
+					if isDateColumn(column) {
+						row[column] = v.Format(dateLayout)
+					} else {
+						row[column] = v.UTC().Format(time.RFC3339Nano)
+					}
+				default:
+					row[column] = v
+				}
+			}
+
+			export.Tables[table] = append(export.Tables[table], row)
+		}
+
+		err = rows.Err()
+		rows.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(export)
+}
+
+// importData loads an export into a database without any users
+// and returns how many rows went into each table.
+// The roles and capabilities the migrations seed are replaced by those in the export.
+func importData(db *sqlDB, r io.Reader) (map[string]int, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	data := dataExport{}
+
+	err := dec.Decode(&data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if data.Version != 1 {
+		return nil, fmt.Errorf("unsupported export version %d", data.Version)
+	}
+
+	var userCount int
+
+	err = db.QueryRow(`SELECT count(*) FROM goissuez.users`).Scan(&userCount)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if userCount > 0 {
+		return nil, errors.New("the database already has users, import only loads into an empty database")
+	}
+
+	tx, err := db.begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, table := range []string{"permissions", "capabilities", "roles"} {
+		_, err = tx.Exec(`DELETE FROM goissuez.` + table)
+
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	counts := make(map[string]int)
+
+	// roles may inherit from roles further down the file,
+	// so parents are set once every role exists
+	parents := make(map[interface{}]interface{})
+
+	for _, table := range exportTables {
+		for _, row := range data.Tables[table] {
+			if table == "roles" && row["parent_id"] != nil {
+				parents[row["id"]] = row["parent_id"]
+				row["parent_id"] = nil
+			}
+
+			query, args, err := importRow(db.dialect, table, row)
+
+			if err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+
+			_, err = tx.Exec(query, args...)
+
+			if err != nil {
+				tx.Rollback()
+				return nil, fmt.Errorf("%s: %v", table, err)
+			}
+
+			counts[table]++
+		}
+	}
+
+	for id, parentID := range parents {
+		_, err = tx.Exec(`UPDATE goissuez.roles SET parent_id = $2 WHERE id = $1`, importValue(db.dialect, "id", id), importValue(db.dialect, "parent_id", parentID))
+
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	for _, table := range exportTables {
+		query := db.dialect.resetSequence(table)
+
+		// permissions and the label links don't have an id
+		if query == "" || table == "permissions" || table == "story_labels" || table == "bug_labels" {
+			continue
+		}
+
+		_, err = tx.Tx.Exec(query)
+
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	return counts, tx.Commit()
+}
+
+// importRow builds the INSERT for one exported row.
+func importRow(d dialect, table string, row map[string]interface{}) (string, []interface{}, error) {
+	columns := []string{}
+
+	for column := range row {
+		// column names go into the query, so only accept names the schema could have
+		if !columnName.MatchString(column) {
+			return "", nil, fmt.Errorf("%s: invalid column %q", table, column)
+		}
+
+		columns = append(columns, column)
+	}
+
+	sort.Strings(columns)
+
+	quoted := []string{}
+	placeholders := []string{}
+	args := []interface{}{}
+
+	for i, column := range columns {
+		quoted = append(quoted, `"`+column+`"`)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
+		args = append(args, importValue(d, column, row[column]))
+	}
+
+	query := `INSERT INTO goissuez.` + table + ` (` + strings.Join(quoted, ", ") + `) VALUES (` + strings.Join(placeholders, ", ") + `)`
+
+	return query, args, nil
+}
+
+// importValue converts a decoded JSON value back into what the column holds.
+func importValue(d dialect, column string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+
+		f, _ := v.Float64()
+
+		return f
+
+	case string:
+		if !isTimestampColumn(column) {
+			return v
+		}
+
+		t, err := time.Parse(time.RFC3339Nano, v)
+
+		if err != nil {
+			return v
+		}
+
+		return d.timestamp(t)
+	}
+
+	return value
+}
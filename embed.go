@@ -0,0 +1,54 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+)
+
+// The templates and migrations are built into the binary, so it runs on its own.
+// With -from-disk they are read from the working directory instead,
+// and edits show up without rebuilding.
+//
+//go:embed templates migrations
+var embeddedFiles embed.FS
+
+// templateFiles returns the templates, named relative to the templates directory.
+func templateFiles() fs.FS {
+	if cfg.FromDisk {
+		return os.DirFS(cfg.TemplatesDir)
+	}
+
+	return subFS(embeddedFiles, "templates")
+}
+
+// migrationFiles returns the migrations under the paths in dialect.migrations.
+func migrationFiles() fs.FS {
+	if cfg.FromDisk {
+		return os.DirFS(".")
+	}
+
+	return embeddedFiles
+}
+
+// assetFiles returns the files served at /resources.
+// The webpack bundle is only embedded in builds tagged embedassets,
+// other builds always serve it from the assets directory.
+func assetFiles() fs.FS {
+	if cfg.FromDisk || !assetsEmbedded {
+		return os.DirFS(cfg.AssetsDir)
+	}
+
+	return subFS(embeddedAssets, "public/assets")
+}
+
+func subFS(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+
+	// only fails for invalid paths, and these are constants
+	if err != nil {
+		panic(err)
+	}
+
+	return sub
+}
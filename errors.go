@@ -0,0 +1,177 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+type errorKind int
+
+const (
+	errInternal errorKind = iota
+	errNotFound
+	errForbidden
+	errUnauthorized
+	errValidation
+	errConflict
+	errMethodNotAllowed
+)
+
+func (k errorKind) status() int {
+	switch k {
+	case errNotFound:
+		return http.StatusNotFound
+	case errForbidden:
+		return http.StatusForbidden
+	case errUnauthorized:
+		return http.StatusUnauthorized
+	case errValidation:
+		return http.StatusUnprocessableEntity
+	case errConflict:
+		return http.StatusConflict
+	case errMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	}
+
+	return http.StatusInternalServerError
+}
+
+// appError is an error with a message that is safe to show the client.
+// The cause, if any, only goes to the log.
+type appError struct {
+	kind    errorKind
+	message string
+	cause   error
+}
+
+func (e *appError) Error() string {
+	if e.cause != nil {
+		return e.message + ": " + e.cause.Error()
+	}
+
+	return e.message
+}
+
+func (e *appError) Unwrap() error {
+	return e.cause
+}
+
+func notFoundError(message string) *appError {
+	return &appError{kind: errNotFound, message: message}
+}
+
+func forbiddenError(message string) *appError {
+	return &appError{kind: errForbidden, message: message}
+}
+
+func unauthorizedError(message string) *appError {
+	return &appError{kind: errUnauthorized, message: message}
+}
+
+func validationError(message string) *appError {
+	return &appError{kind: errValidation, message: message}
+}
+
+func conflictError(message string) *appError {
+	return &appError{kind: errConflict, message: message}
+}
+
+// internalError tells the client only the message. A non-nil cause is logged
+// by respondError; pass nil when the handler has logged it already.
+func internalError(message string, cause error) *appError {
+	return &appError{kind: errInternal, message: message, cause: cause}
+}
+
+// asAppError turns any error into an appError.
+// sql.ErrNoRows means the thing asked for doesn't exist,
+// anything else is internal and its details stay out of the response.
+func asAppError(err error) *appError {
+	var appErr *appError
+
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFoundError("Not found.")
+	}
+
+	return internalError("Something went wrong.", err)
+}
+
+// wantsJSON reports whether the request came from a script rather than a page load.
+// The page modules send requests with axios, which asks for JSON.
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json") ||
+		strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") ||
+		r.Method == http.MethodDelete
+}
+
+// errorPage is the data of the errors/error.gohtml page and the body of JSON errors.
+type errorPage struct {
+	Status    int    `json:"status"`
+	Message   string `json:"error"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// respondError sends the error as JSON to scripts and as an error page to browsers.
+// Causes are logged with the request's ID, which the response includes so the two can be matched up.
+func respondError(w http.ResponseWriter, r *http.Request, err error) {
+	appErr := asAppError(err)
+	status := appErr.kind.status()
+
+	if appErr.cause != nil {
+		log.WithContext(r.Context()).WithField("status", status).Error(appErr.message, " ", appErr.cause)
+	}
+
+	data := errorPage{Status: status, Message: appErr.message, RequestID: requestID(r.Context())}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(status)
+
+		json.NewEncoder(w).Encode(data)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+
+	view := viewService{w: w, r: r}
+	view.make("errors/error.gohtml")
+	renderErr := view.exec(mainLayout, page{Title: http.StatusText(status), Data: data})
+
+	if renderErr != nil {
+		log.WithContext(r.Context()).Error("Error respondError.view.exec.", renderErr)
+
+		http.Error(w, appErr.message, status)
+		return
+	}
+
+	view.send(status)
+}
+
+// recoverPanic is the router's panic handler. The panic and stack go to the log
+// and the client gets a 500 like any other internal error.
+func recoverPanic(w http.ResponseWriter, r *http.Request, recovered interface{}) {
+	log.WithContext(r.Context()).WithField("stack", string(debug.Stack())).Error("Panic serving ", r.Method, " ", r.URL.Path, ": ", recovered)
+
+	respondError(w, r, internalError("Something went wrong.", nil))
+}
+
+// notFoundHandler answers requests that match no route.
+var notFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	respondError(w, r, notFoundError("Page not found."))
+})
+
+// methodNotAllowedHandler answers requests to a route with the wrong method.
+var methodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	respondError(w, r, &appError{kind: errMethodNotAllowed, message: fmt.Sprintf("%s isn't allowed here.", r.Method)})
+})
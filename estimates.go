@@ -0,0 +1,291 @@
+package main
+
+import (
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// pointScale is how a project sizes its stories. Whatever the scale,
+// stories store a number of points, so they can be added up.
+type pointScale string
+
+const (
+	scaleFibonacci pointScale = "fibonacci"
+	scaleTShirt    pointScale = "tshirt"
+	scaleLinear    pointScale = "linear"
+	// scaleNone turns story points off for the project.
+	scaleNone pointScale = "none"
+
+	defaultPointScale = scaleFibonacci
+)
+
+// pointScales are the choices offered in the project forms.
+var pointScales = []pointScale{scaleFibonacci, scaleTShirt, scaleLinear, scaleNone}
+
+// pointChoice is one size on a scale.
+type pointChoice struct {
+	Points int
+	Label  string
+}
+
+// tshirtSizes are the T-shirt sizes and the points each is worth.
+var tshirtSizes = []pointChoice{{1, "XS"}, {2, "S"}, {3, "M"}, {5, "L"}, {8, "XL"}, {13, "XXL"}}
+
+// Label is the scale as shown in the project forms.
+func (s pointScale) Label() string {
+	switch s {
+	case scaleFibonacci:
+		return "Fibonacci (1, 2, 3, 5, 8, 13, 21)"
+	case scaleTShirt:
+		return "T-shirt sizes (XS to XXL)"
+	case scaleLinear:
+		return "Linear (1 to 10)"
+	}
+
+	return "No story points"
+}
+
+// Choices are the sizes a story can be given on the scale, smallest first.
+func (s pointScale) Choices() []pointChoice {
+	numbers := func(points ...int) []pointChoice {
+		choices := []pointChoice{}
+
+		for _, p := range points {
+			choices = append(choices, pointChoice{p, strconv.Itoa(p)})
+		}
+
+		return choices
+	}
+
+	switch s {
+	case scaleFibonacci:
+		return numbers(1, 2, 3, 5, 8, 13, 21)
+	case scaleTShirt:
+		return tshirtSizes
+	case scaleLinear:
+		return numbers(1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+	}
+
+	return nil
+}
+
+// label shows points on the scale. Points that aren't on it,
+// eg: after the project changed scales, are shown as a number.
+func (s pointScale) label(points int) string {
+	if points == 0 {
+		return ""
+	}
+
+	for _, c := range s.Choices() {
+		if c.Points == points {
+			return c.Label
+		}
+	}
+
+	return strconv.Itoa(points)
+}
+
+// has reports whether points is one of the sizes on the scale.
+func (s pointScale) has(points int) bool {
+	for _, c := range s.Choices() {
+		if c.Points == points {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parsePointScale(value string) (pointScale, bool) {
+	for _, s := range pointScales {
+		if string(s) == value {
+			return s, true
+		}
+	}
+
+	return "", false
+}
+
+// readPointScale reads the point_scale field of a project form. A blank field is the default scale.
+func readPointScale(form url.Values, fallback pointScale) (pointScale, error) {
+	value := form.Get("point_scale")
+
+	if value == "" {
+		return fallback, nil
+	}
+
+	scale, ok := parsePointScale(value)
+
+	if !ok {
+		return fallback, validationError("Pick one of the story point scales.")
+	}
+
+	return scale, nil
+}
+
+// minutesPerDay and daysPerWeek turn estimates in days and weeks into working time.
+const (
+	minutesPerDay = 8 * 60
+	daysPerWeek   = 5
+)
+
+// maxEstimateMinutes is the longest a story can be estimated to take, a year of working time.
+const maxEstimateMinutes = 52 * daysPerWeek * minutesPerDay
+
+// parseEstimate reads a time estimate such as "1d 4h", "2.5h" or "90m" into minutes.
+// A number on its own is hours. Days are 8 hours and weeks 5 days.
+func parseEstimate(value string) (int, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	if value == "" {
+		return 0, nil
+	}
+
+	units := map[byte]float64{'w': daysPerWeek * minutesPerDay, 'd': minutesPerDay, 'h': 60, 'm': 1}
+
+	total := 0.0
+
+	for _, part := range strings.Fields(value) {
+		unit := units['h']
+
+		if perUnit, ok := units[part[len(part)-1]]; ok {
+			unit = perUnit
+			part = part[:len(part)-1]
+		}
+
+		n, err := strconv.ParseFloat(part, 64)
+
+		// ParseFloat also takes "NaN" and "Inf"
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) || n < 0 {
+			return 0, validationError(`Write estimates like "1d 4h", "2.5h" or "90m".`)
+		}
+
+		total += n * unit
+
+		if total > maxEstimateMinutes {
+			return 0, validationError("Estimates can't be longer than 52 weeks.")
+		}
+	}
+
+	return int(total + 0.5), nil
+}
+
+// formatEstimate shows minutes of work in days, hours and minutes, eg: "1d 4h 30m".
+func formatEstimate(minutes int) string {
+	if minutes <= 0 {
+		return ""
+	}
+
+	parts := []string{}
+
+	for _, unit := range []struct {
+		minutes int
+		suffix  string
+	}{{minutesPerDay, "d"}, {60, "h"}, {1, "m"}} {
+		if n := minutes / unit.minutes; n > 0 {
+			parts = append(parts, strconv.Itoa(n)+unit.suffix)
+			minutes -= n * unit.minutes
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// PointsLabel shows the story's points on its project's scale, eg: "M" for 3 points
+// in T-shirt sizes, or nothing if it hasn't been sized. Without the project, points are shown as a number.
+func (s story) PointsLabel() string {
+	if s.Project == nil {
+		return scaleLinear.label(s.Points)
+	}
+
+	return s.Project.PointScale.label(s.Points)
+}
+
+// EstimateLabel shows the story's time estimate, or nothing if it hasn't been estimated.
+func (s story) EstimateLabel() string {
+	return formatEstimate(s.EstimateMinutes)
+}
+
+// readEstimates reads the points and estimate fields of a story form into storyData,
+// checking the points are on the project's scale. Blank fields clear an estimate.
+// Estimates the project doesn't use are left as they are.
+func readEstimates(form url.Values, projectData project, storyData *story) error {
+	if projectData.PointScale != scaleNone {
+		storyData.Points = 0
+
+		if value := form.Get("points"); value != "" {
+			points, err := strconv.Atoi(value)
+
+			if err != nil || !projectData.PointScale.has(points) {
+				return validationError("Story points must be one of the sizes on the project's scale.")
+			}
+
+			storyData.Points = points
+		}
+	}
+
+	if projectData.TrackTime {
+		minutes, err := parseEstimate(form.Get("estimate"))
+
+		if err != nil {
+			return err
+		}
+
+		storyData.EstimateMinutes = minutes
+	}
+
+	return nil
+}
+
+// estimateField is what the estimate_fields template needs to offer a project's estimates on a story form.
+type estimateField struct {
+	Scale     pointScale
+	TrackTime bool
+	Points    int
+	Estimate  string
+}
+
+func newEstimateField(projectData project, storyData story) estimateField {
+	return estimateField{
+		Scale:     projectData.PointScale,
+		TrackTime: projectData.TrackTime,
+		Points:    storyData.Points,
+		Estimate:  formatEstimate(storyData.EstimateMinutes),
+	}
+}
+
+// estimateTotals adds up the estimates of a feature's stories.
+type estimateTotals struct {
+	Stories int
+	Points  int
+	Minutes int
+	// Unsized and Unestimated count the stories without points or a time estimate.
+	Unsized     int
+	Unestimated int
+}
+
+func sumEstimates(stories []story) estimateTotals {
+	totals := estimateTotals{Stories: len(stories)}
+
+	for _, st := range stories {
+		totals.Points += st.Points
+		totals.Minutes += st.EstimateMinutes
+
+		if st.Points == 0 {
+			totals.Unsized++
+		}
+
+		if st.EstimateMinutes == 0 {
+			totals.Unestimated++
+		}
+	}
+
+	return totals
+}
+
+// Time shows the total time estimate.
+func (t estimateTotals) Time() string {
+	return formatEstimate(t.Minutes)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseEstimate(t *testing.T) {
+	cases := []struct {
+		value  string
+		want   int
+		status int
+	}{
+		{"", 0, 0},
+		{"  ", 0, 0},
+		{"90m", 90, 0},
+		{"2.5h", 150, 0},
+		// a number on its own is hours
+		{"3", 180, 0},
+		{"1d 4h", 720, 0},
+		{"1W 2D 3H 4M", 2400 + 960 + 180 + 4, 0},
+		{"0.5d 30m", 270, 0},
+		// rounded to the nearest minute
+		{"0.01h", 1, 0},
+		{"0.4m", 0, 0},
+		{"52w", maxEstimateMinutes, 0},
+		{"abc", 0, http.StatusUnprocessableEntity},
+		{"h", 0, http.StatusUnprocessableEntity},
+		{"1x", 0, http.StatusUnprocessableEntity},
+		{"-1h", 0, http.StatusUnprocessableEntity},
+		{"1h -30m", 0, http.StatusUnprocessableEntity},
+		{"NaN", 0, http.StatusUnprocessableEntity},
+		{"nanh", 0, http.StatusUnprocessableEntity},
+		{"Inf", 0, http.StatusUnprocessableEntity},
+		{"+infm", 0, http.StatusUnprocessableEntity},
+		{"1e300", 0, http.StatusUnprocessableEntity},
+		{"1e400h", 0, http.StatusUnprocessableEntity},
+		{"52w 1m", 0, http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range cases {
+		got, err := parseEstimate(tc.value)
+
+		status := 0
+
+		if err != nil {
+			status = asAppError(err).kind.status()
+		}
+
+		if got != tc.want || status != tc.status {
+			t.Errorf("parseEstimate(%q): got %d and %d, want %d and %d", tc.value, got, status, tc.want, tc.status)
+		}
+	}
+}
+
+func TestFormatEstimate(t *testing.T) {
+	cases := []struct {
+		minutes int
+		want    string
+	}{
+		{0, ""},
+		{-5, ""},
+		{1, "1m"},
+		{90, "1h 30m"},
+		{480, "1d"},
+		{481, "1d 1m"},
+		{720, "1d 4h"},
+		// weeks are shown as days
+		{2400, "5d"},
+	}
+
+	for _, tc := range cases {
+		if got := formatEstimate(tc.minutes); got != tc.want {
+			t.Errorf("formatEstimate(%d): got %q, want %q", tc.minutes, got, tc.want)
+		}
+
+		if tc.minutes <= 0 {
+			continue
+		}
+
+		// what's shown reads back as the same estimate
+		if back, err := parseEstimate(formatEstimate(tc.minutes)); err != nil || back != tc.minutes {
+			t.Errorf("parseEstimate(formatEstimate(%d)): got %d, %v", tc.minutes, back, err)
+		}
+	}
+}
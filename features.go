@@ -1,19 +1,17 @@
 package main
 
 import (
-	"database/sql"
-	"encoding/json"
 	"github.com/sirupsen/logrus"
-	"html/template"
 	"net/http"
+	"strconv"
 
 	"github.com/julienschmidt/httprouter"
 )
 
 type featureService struct {
-	db   *sql.DB
-	log  *logrus.Logger
-	tpls *template.Template
+	stores stores
+	log    *logrus.Logger
+	tpls   *templateRegistry
 }
 
 type feature struct {
@@ -30,102 +28,29 @@ type feature struct {
 	Bugs        []bug
 }
 
-func NewFeatureService(db *sql.DB, log *logrus.Logger, tpls *template.Template) *featureService {
-	return &featureService{db, log, tpls}
-}
-
-func (s *featureService) all(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"read_features"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	features := []struct {
-		Feature     feature
-		RelatedData struct {
-			StoryCount int
-			BugCount   int
-		}
-	}{}
-
-	stmt, err := s.db.Prepare(`
-SELECT
-f.id,
-f.name,
-f.user_id,
-f.created_at,
-f.updated_at,
-count(DISTINCT s.id) as count_stories,
-count(DISTINCT b.id) as count_bugs,
-p.name as project_name
-FROM goissuez.features f
-LEFT JOIN goissuez.stories s
-ON f.id = s.feature_id
-LEFT JOIN goissuez.bugs b
-ON f.id = b.feature_id
-JOIN goissuez.projects p
-ON p.id = f.project_id
-WHERE f.deleted_at IS NULL
-GROUP BY f.id, p.id
-ORDER BY f.updated_at
-`)
-
-	if err != nil {
-		s.log.Error("Error features.all.prepare.", err)
-		http.Error(w, "Error listing features.", http.StatusInternalServerError)
-
-		return
+// featureSummary is a feature listed with how much work it holds.
+type featureSummary struct {
+	Feature     feature
+	RelatedData struct {
+		StoryCount int
+		BugCount   int
 	}
+}
 
-	defer stmt.Close()
+func NewFeatureService(store stores, log *logrus.Logger, tpls *templateRegistry) *featureService {
+	return &featureService{store, log, tpls}
+}
 
-	rows, err := stmt.Query()
+func (s *featureService) all(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	features, err := s.stores.features.all()
 
 	if err != nil {
-		s.log.Error("Error features.all.query.", err)
-		http.Error(w, "Error listing features.", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error("Error features.all.query.", err)
+		respondError(w, r, internalError("Error listing features.", nil))
 
 		return
 	}
 
-	for rows.Next() {
-		featureData := struct {
-			Feature     feature
-			RelatedData struct {
-				StoryCount int
-				BugCount   int
-			}
-		}{
-			feature{Project: &project{}},
-			struct {
-				StoryCount int
-				BugCount   int
-			}{0, 0},
-		}
-
-		err := rows.Scan(
-			&featureData.Feature.ID,
-			&featureData.Feature.Name,
-			&featureData.Feature.UserID,
-			&featureData.Feature.CreatedAt,
-			&featureData.Feature.UpdatedAt,
-			&featureData.RelatedData.StoryCount,
-			&featureData.RelatedData.BugCount,
-			&featureData.Feature.Project.Name,
-		)
-
-		if err != nil {
-			s.log.Error("Error features.all.scan.", err)
-			http.Error(w, "Error listing features.", http.StatusInternalServerError)
-
-			return
-		}
-
-		features = append(features, featureData)
-	}
-
 	pageData := page{
 		Title: "Features",
 		Data:  features,
@@ -134,12 +59,12 @@ ORDER BY f.updated_at
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/features/all.gohtml")
+	view.make("features/all.gohtml")
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -151,111 +76,38 @@ ORDER BY f.updated_at
 // First, we'll get the project details, and then
 // we'll query the related features separately.
 func (s *featureService) index(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"read_features"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	projectData := project{}
-
-	parentProjectID := ps.ByName("project_id")
-
-	stmt, err := s.db.Prepare(`SELECT p.id, p.name, p.description FROM goissuez.projects p WHERE p.id = $1`)
-
-	if err != nil {
-		s.log.Error("Error features.index.prepare.project.", err)
-
-		http.Error(w, "Error listing features.", http.StatusInternalServerError)
-
-		return
-	}
-
-	defer stmt.Close()
-
-	err = stmt.QueryRow(parentProjectID).Scan(&projectData.ID, &projectData.Name, &projectData.Description)
-
-	if err != nil {
-		s.log.Error("Error features.index.scan.project.", err)
-
-		http.Error(w, "Error listing features.", http.StatusInternalServerError)
-
-		return
-	}
-
-	stmt, err = s.db.Prepare(`
-SELECT f.id,
-f.name,
-f.description,
-f.project_id,
-f.user_id,
-f.created_at,
-f.updated_at
-FROM goissuez.features f
-WHERE f.project_id = $1
-ORDER BY f.created_at
-`)
+	projectData, err := s.stores.projects.find(parseID(ps.ByName("project_id")))
 
 	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error features.index.scan.project.", err)
 
-		s.log.Error("Error features.index.prepare.features", err)
-
-		http.Error(w, "Error listing features.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error listing features.", nil))
 
 		return
 	}
 
-	defer stmt.Close()
-
-	rows, err := stmt.Query(parentProjectID)
+	projectData.Features, err = s.stores.features.byProject(projectData.ID)
 
 	if err != nil {
 
-		s.log.Error("Error features.index.query.", err)
+		s.log.WithContext(r.Context()).Error("Error features.index.query.", err)
 
-		http.Error(w, "Error listing features.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error listing features.", nil))
 
 		return
 	}
 
-	for rows.Next() {
-
-		featureData := &feature{}
-
-		err := rows.Scan(
-			&featureData.ID,
-			&featureData.Name,
-			&featureData.Description,
-			&featureData.ProjectID,
-			&featureData.UserID,
-			&featureData.CreatedAt,
-			&featureData.UpdatedAt,
-		)
-
-		if err != nil {
-
-			s.log.Error("Error features.index.scan.", err)
-
-			http.Error(w, "Error listing features.", http.StatusInternalServerError)
-
-			return
-		}
-
-		projectData.Features = append(projectData.Features, *featureData)
-	}
-
 	pageData := page{Title: "Features", Data: projectData}
 
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/features/features.gohtml")
+	view.make("features/features.gohtml")
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -265,43 +117,25 @@ ORDER BY f.created_at
 
 // Save a project feature.
 func (s *featureService) store(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"create_features"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	authUser := currentUser(r)
 
 	project_id := ps.ByName("project_id")
 
 	r.ParseForm()
 
-	featureName := r.PostForm.Get("name")
-	featureDescription := r.PostForm.Get("description")
-
-	stmt, err := s.db.Prepare(`
-INSERT INTO goissuez.features
-(name, description, project_id, user_id, created_at, updated_at)
-VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-RETURNING id
-`)
-
-	if err != nil {
-		s.log.Error("Error features.store.prepare.", err)
-
-		http.Error(w, "Error creating feature.", http.StatusInternalServerError)
-
-		return
+	featureData := feature{
+		Name:        r.PostForm.Get("name"),
+		Description: r.PostForm.Get("description"),
+		ProjectID:   parseID(project_id),
+		UserID:      authUser.ID,
 	}
 
-	defer stmt.Close()
-
-	_, err = stmt.Exec(featureName, featureDescription, project_id, authUser.ID)
+	_, err := s.stores.features.create(featureData)
 
 	if err != nil {
-		s.log.Error("Error features.store.queryrow.", err)
+		s.log.WithContext(r.Context()).Error("Error features.store.queryrow.", err)
 
-		http.Error(w, "Error saving feature.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error saving feature.", nil))
 		return
 	}
 
@@ -310,108 +144,44 @@ RETURNING id
 
 // Update a project feature.
 func (s *featureService) update(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"update_features"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	feature_id := ps.ByName("feature_id")
-
 	r.ParseForm()
 
-	featureName := r.PostForm.Get("name")
-	featureDescription := r.PostForm.Get("description")
-
-	// return the project_id so we can redirect back to the project / features page
-	stmt, err := s.db.Prepare(`
-UPDATE goissuez.features
-SET name = $2, description = $3, updated_at = CURRENT_TIMESTAMP
-WHERE id = $1
-RETURNING project_id
-`)
+	// find the project_id so we can redirect back to the project / features page
+	featureData, err := s.stores.features.find(parseID(ps.ByName("feature_id")))
 
 	if err != nil {
-		s.log.Error("Error features.update.prepare.", err)
+		s.log.WithContext(r.Context()).Error("Error features.update.find.", err)
 
-		http.Error(w, "Error updating feature.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error updating feature.", nil))
 
 		return
 	}
 
-	defer stmt.Close()
+	featureData.Name = r.PostForm.Get("name")
+	featureData.Description = r.PostForm.Get("description")
 
-	var (
-		project_id string
-	)
-
-	err = stmt.QueryRow(feature_id, featureName, featureDescription).Scan(&project_id)
+	err = s.stores.features.update(featureData)
 
 	if err != nil {
-		s.log.Error("Error features.update.exec.", err)
+		s.log.WithContext(r.Context()).Error("Error features.update.exec.", err)
 
-		http.Error(w, "Error updating feature.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error updating feature.", nil))
 
 		return
 	}
 
+	project_id := strconv.FormatInt(featureData.ProjectID, 10)
 	http.Redirect(w, r, "/projects/"+project_id, http.StatusSeeOther)
 }
 
 // Show the edit feature form.
 func (s *featureService) edit(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"update_features"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	feature_id := ps.ByName("feature_id")
-
-	stmt, err := s.db.Prepare(`
-SELECT
-id,
-name,
-description,
-project_id,
-user_id,
-created_at,
-updated_at
-FROM goissuez.features
-WHERE id = $1
-LIMIT 1
-`)
-
-	if err != nil {
-		s.log.Error("Error features.edit.prepare.", err)
-
-		http.Error(w, "Error editing feature.", http.StatusInternalServerError)
-
-		return
-	}
-
-	defer stmt.Close()
-
-	row := stmt.QueryRow(feature_id)
-
-	featureData := feature{}
-
-	err = row.Scan(
-		&featureData.ID,
-		&featureData.Name,
-		&featureData.Description,
-		&featureData.ProjectID,
-		&featureData.UserID,
-		&featureData.CreatedAt,
-		&featureData.UpdatedAt,
-	)
+	featureData, err := s.stores.features.find(parseID(ps.ByName("feature_id")))
 
 	if err != nil {
-		s.log.Error("Error features.edit.scan.", err)
+		s.log.WithContext(r.Context()).Error("Error features.edit.scan.", err)
 
-		http.Error(w, "Error editing feature.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error editing feature.", nil))
 
 		return
 	}
@@ -421,12 +191,12 @@ LIMIT 1
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/features/edit.gohtml")
+	view.make("features/edit.gohtml")
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -436,55 +206,12 @@ LIMIT 1
 
 // Show the new / create feature form.
 func (s *featureService) create(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"create_features"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	parentProjectID := ps.ByName("project_id")
-
-	stmt, err := s.db.Prepare(`
-SELECT
-id,
-name,
-description,
-user_id,
-created_at,
-updated_at
-FROM goissuez.projects
-WHERE id = $1
-LIMIT 1
-`)
+	projectData, err := s.stores.projects.find(parseID(ps.ByName("project_id")))
 
 	if err != nil {
-		s.log.Error("Error features.create.prepare.", err)
+		s.log.WithContext(r.Context()).Error("Error features.create.scan.", err)
 
-		http.Error(w, "Error creating feature", http.StatusInternalServerError)
-
-		return
-	}
-
-	defer stmt.Close()
-
-	row := stmt.QueryRow(parentProjectID)
-
-	projectData := project{}
-
-	err = row.Scan(
-		&projectData.ID,
-		&projectData.Name,
-		&projectData.Description,
-		&projectData.UserID,
-		&projectData.CreatedAt,
-		&projectData.UpdatedAt,
-	)
-
-	if err != nil {
-		s.log.Error("Error features.create.scan.", err)
-
-		http.Error(w, "Error creating feature.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error creating feature.", nil))
 
 		return
 	}
@@ -494,12 +221,12 @@ LIMIT 1
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/features/new.gohtml")
+	view.make("features/new.gohtml")
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -508,195 +235,64 @@ LIMIT 1
 }
 
 func (s *featureService) show(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
+	featureData, err := s.stores.features.find(parseID(ps.ByName("feature_id")))
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error features.show.scan.", err)
+
+		respondError(w, r, internalError("Error getting feature.", nil))
 
-	if !authUser.Can([]string{"read_features"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	feature_id := ps.ByName("feature_id")
-	stories := []story{}
-	bugs := []bug{}
-
-	stmt, err := s.db.Prepare(`
-SELECT
-f.id,
-f.name,
-f.description,
-f.project_id,
-f.user_id,
-f.created_at,
-f.updated_at,
-f.deleted_at,
-p.name as project_name
-FROM goissuez.features f
-JOIN goissuez.projects p
-ON p.id = f.project_id
-WHERE f.id = $1
-LIMIT 1
-`)
+	featureData.Stories, err = s.stores.stories.byFeature(featureData.ID)
 
 	if err != nil {
-		s.log.Error("Error features.show.prepare.", err)
+		s.log.WithContext(r.Context()).Error("Error features.show.query.stories.", err)
 
-		http.Error(w, "Error getting feature.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error getting feature stories.", nil))
 
 		return
 	}
 
-	defer stmt.Close()
-
-	row := stmt.QueryRow(feature_id)
-
-	featureData := feature{Project: &project{}}
-	deleted_at := sql.NullString{}
-
-	err = row.Scan(
-		&featureData.ID,
-		&featureData.Name,
-		&featureData.Description,
-		&featureData.ProjectID,
-		&featureData.UserID,
-		&featureData.CreatedAt,
-		&featureData.UpdatedAt,
-		&deleted_at,
-		&featureData.Project.Name,
-	)
+	featureData.Bugs, err = s.stores.bugs.byFeature(featureData.ID)
 
 	if err != nil {
-		s.log.Error("Error features.show.scan.", err)
+		s.log.WithContext(r.Context()).Error("Error features.show.query.bugs.", err)
 
-		http.Error(w, "Error getting feature.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error getting feature bugs.", nil))
 
 		return
 	}
 
-	if deleted_at.Valid {
-		featureData.DeletedAt = deleted_at.String
+	// the project is needed to show story points on its scale
+	for i := range featureData.Stories {
+		featureData.Stories[i].Project = featureData.Project
 	}
 
-	// get the stories for the feature
-	{
-		stmt, err := s.db.Prepare(`
-			SELECT
-			id,
-			name,
-			feature_id,
-			created_at,
-			updated_at
-			FROM goissuez.stories
-			WHERE feature_id = $1
-			AND deleted_at IS NULL
-		`)
-
-		if err != nil {
-			s.log.Error("Error features.show.prepare.stories.", err)
-
-			http.Error(w, "Error getting feature stories.", http.StatusInternalServerError)
-
-			return
-		}
-
-		defer stmt.Close()
-
-		rows, err := stmt.Query(feature_id)
-
-		if err != nil {
-			s.log.Error("Error features.show.query.stories.", err)
-
-			http.Error(w, "Error getting feature stories.", http.StatusInternalServerError)
-
-			return
-		}
-
-		for rows.Next() {
-
-			storyData := story{}
-
-			err := rows.Scan(
-				&storyData.ID,
-				&storyData.Name,
-				&storyData.FeatureID,
-				&storyData.CreatedAt,
-				&storyData.UpdatedAt,
-			)
-
-			if err != nil {
-
-				s.log.Error("Error features.show.scan.stories.", err)
-
-				http.Error(w, "Error getting feature stories.", http.StatusInternalServerError)
+	err = withStoryLabels(s.stores, featureData.Stories)
 
-				return
-			}
-
-			stories = append(stories, storyData)
-		}
-
-		featureData.Stories = stories
+	if err == nil {
+		err = withBugLabels(s.stores, featureData.Bugs)
 	}
 
-	// get the bugs for the feature
-	{
-		stmt, err := s.db.Prepare(`
-			SELECT
-			id,
-			name,
-			feature_id,
-			created_at,
-			updated_at
-			FROM goissuez.bugs
-			WHERE feature_id = $1
-			AND deleted_at IS NULL
-		`)
-
-		if err != nil {
-			s.log.Error("Error features.show.prepare.bugs.", err)
-
-			http.Error(w, "Error getting feature bugs.", http.StatusInternalServerError)
-
-			return
-		}
-
-		defer stmt.Close()
-
-		rows, err := stmt.Query(feature_id)
-
-		if err != nil {
-			s.log.Error("Error features.show.query.bugs.", err)
-
-			http.Error(w, "Error getting feature bugs.", http.StatusInternalServerError)
-
-			return
-		}
-
-		for rows.Next() {
-
-			bugData := bug{}
-
-			err := rows.Scan(
-				&bugData.ID,
-				&bugData.Name,
-				&bugData.FeatureID,
-				&bugData.CreatedAt,
-				&bugData.UpdatedAt,
-			)
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error features.show.query.labels.", err)
 
-			if err != nil {
+		respondError(w, r, internalError("Error getting feature labels.", nil))
 
-				s.log.Error("Error features.show.scan.bugs.", err)
+		return
+	}
 
-				http.Error(w, "Error getting feature bugs.", http.StatusInternalServerError)
+	// the effort is the whole feature's, whichever label the lists are filtered by
+	totals := sumEstimates(featureData.Stories)
 
-				return
-			}
+	labelName := r.URL.Query().Get("label")
 
-			bugs = append(bugs, bugData)
-		}
+	var storyFilter, bugFilter labelFilter
 
-		featureData.Bugs = bugs
-	}
+	featureData.Stories, storyFilter = filterStories(featureData.Stories, labelName)
+	featureData.Bugs, bugFilter = filterBugs(featureData.Bugs, labelName)
 
 	var title string
 
@@ -708,46 +304,26 @@ LIMIT 1
 
 	pageData := page{
 		Title: title,
-		Data:  featureData,
-		Funcs: make(map[string]interface{}),
-	}
-
-	pageData.Funcs["ToJSON"] = func(data interface{}) string {
-
-		var storyData story
-		var bugData bug
-		var featureData feature
-		var b []byte
-		var err error
-
-		bugData, bug_ok := data.(bug)
-		storyData, story_ok := data.(story)
-		featureData, feature_ok := data.(feature)
-
-		if bug_ok {
-			b, err = json.Marshal(bugData)
-		} else if story_ok {
-			b, err = json.Marshal(storyData)
-		} else if feature_ok {
-			b, err = json.Marshal(featureData)
-		}
-
-		if err != nil {
-			return ""
-		}
-
-		return string(b)
+		Data: struct {
+			feature
+			Totals estimateTotals
+			Labels labelFilter
+		}{
+			featureData,
+			totals,
+			combineLabelFilters(storyFilter, bugFilter),
+		},
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/features/feature.gohtml")
+	view.make("features/feature.gohtml")
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -756,33 +332,12 @@ LIMIT 1
 }
 
 func (s *featureService) destroy(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"delete_features"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	feature_id := ps.ByName("feature_id")
-
-	stmt, err := s.db.Prepare(`UPDATE goissuez.features SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1`)
-
-	if err != nil {
-		s.log.Error("Error features.destroy.prepare.", err)
-
-		http.Error(w, "Error deleting feature.", http.StatusInternalServerError)
-
-		return
-	}
-
-	defer stmt.Close()
-
-	_, err = stmt.Exec(feature_id)
+	err := s.stores.features.destroy(parseID(ps.ByName("feature_id")))
 
 	if err != nil {
-		s.log.Error("Error features.destroy.exec.", err)
+		s.log.WithContext(r.Context()).Error("Error features.destroy.exec.", err)
 
-		http.Error(w, "Error deleting feature.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error deleting feature.", nil))
 
 		return
 	}
@@ -1,10 +1,12 @@
 module github.com/patrickodacre/go-issuez
 
-go 1.14
+go 1.16
 
 require (
 	github.com/google/uuid v1.1.1
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/lib/pq v1.7.1
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/sirupsen/logrus v1.6.0
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,501 @@
+package main
+
+// The integration tests run the real router from newRouter against a throwaway
+// database holding the migrations and the demo fixtures from seedDemo.
+//
+// The database is, in order of preference:
+//   - a new database on the server in $TEST_POSTGRES_CONN_STRING,
+//     which must let the user create databases
+//   - a Postgres started from initdb and pg_ctl on the PATH (these refuse to run as root)
+//   - a Postgres container, when docker is on the PATH ($TEST_POSTGRES_IMAGE, default postgres:13-alpine)
+//   - an SQLite file, only when $TEST_DB_DRIVER is "sqlite"
+//
+// Tests that need the app are skipped when none of these is available.
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// demoPassword is the password seedDemo gives the demo users in the tests.
+const demoPassword = "demo"
+
+// testApp is the app under test, shared by every test in the package.
+type testApp struct {
+	db       *sqlDB
+	server   *httptest.Server
+	fixtures demoFixtures
+	// snapshot is an export of the freshly seeded database that reset loads back.
+	snapshot []byte
+}
+
+var (
+	appOnce sync.Once
+	app     *testApp
+	// appSkip is why there's no app, if there isn't one.
+	appSkip string
+	// cleanups run after every test, in reverse order.
+	cleanups []func()
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+
+	os.Exit(code)
+}
+
+// testApplication returns the app, starting it on first use,
+// or skips the test when there's no database to run it on.
+func testApplication(t *testing.T) *testApp {
+	t.Helper()
+
+	appOnce.Do(func() {
+		var err error
+
+		app, err = startTestApp()
+
+		if err != nil {
+			appSkip = err.Error()
+		}
+	})
+
+	if app == nil {
+		t.Skip("no test database: " + appSkip)
+	}
+
+	return app
+}
+
+func startTestApp() (*testApp, error) {
+	dir, err := ioutil.TempDir("", "goissuez-test")
+
+	if err != nil {
+		return nil, err
+	}
+
+	cleanups = append(cleanups, func() { os.RemoveAll(dir) })
+
+	cfg = defaultConfig()
+	cfg.BaseURL = "http://localhost"
+	cfg.UploadDir = filepath.Join(dir, "uploads")
+	mainLayout = cfg.MainLayout
+
+	log = logrus.New()
+	log.SetOutput(ioutil.Discard)
+
+	if os.Getenv("TEST_LOG") != "" {
+		log.SetOutput(os.Stderr)
+		log.SetLevel(logrus.DebugLevel)
+	}
+
+	err = startTestDatabase(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := connect()
+
+	if err != nil {
+		return nil, err
+	}
+
+	cleanups = append(cleanups, func() { db.Close() })
+
+	// connect only migrates SQLite
+	_, err = db.migrate()
+
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := startServices(db)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fixtures, err := seedDemo(store, demoPassword)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot bytes.Buffer
+
+	err = exportData(db, &snapshot)
+
+	if err != nil {
+		return nil, err
+	}
+
+	server := httptest.NewServer(newRouter())
+
+	cleanups = append(cleanups, server.Close)
+
+	return &testApp{db: db, server: server, fixtures: fixtures, snapshot: snapshot.Bytes()}, nil
+}
+
+// startTestDatabase points cfg at a new, empty database.
+func startTestDatabase(dir string) error {
+	if conn := os.Getenv("TEST_POSTGRES_CONN_STRING"); conn != "" {
+		return createTestDatabase(conn)
+	}
+
+	if _, err := exec.LookPath("initdb"); err == nil {
+		err = startLocalPostgres(dir)
+
+		if err == nil {
+			return nil
+		}
+
+		// eg: running as root
+		fmt.Fprintln(os.Stderr, "Couldn't start Postgres with initdb:", err)
+	}
+
+	if _, err := exec.LookPath("docker"); err == nil {
+		return startPostgresContainer()
+	}
+
+	if os.Getenv("TEST_DB_DRIVER") == "sqlite" {
+		cfg.DBDriver = "sqlite"
+		cfg.SQLitePath = filepath.Join(dir, "goissuez.db")
+
+		return nil
+	}
+
+	return fmt.Errorf("set TEST_POSTGRES_CONN_STRING, install Postgres or docker, or set TEST_DB_DRIVER=sqlite")
+}
+
+// createTestDatabase creates a database for this run on an existing server
+// and drops it afterwards.
+func createTestDatabase(conn string) error {
+	server, err := sql.Open("postgres", conn)
+
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("goissuez_test_%d_%d", os.Getpid(), rand.New(rand.NewSource(time.Now().UnixNano())).Intn(1e6))
+
+	_, err = server.Exec(`CREATE DATABASE ` + name)
+
+	if err != nil {
+		server.Close()
+		return err
+	}
+
+	cleanups = append(cleanups, func() {
+		server.Exec(`DROP DATABASE IF EXISTS ` + name)
+		server.Close()
+	})
+
+	cfg.DBDriver = "postgres"
+	cfg.PostgresConn = withDatabase(conn, name)
+
+	return nil
+}
+
+// withDatabase returns the connection string with its database replaced.
+func withDatabase(conn, name string) string {
+	if u, err := url.Parse(conn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		u.Path = "/" + name
+		return u.String()
+	}
+
+	// the last of a repeated keyword wins
+	return conn + " dbname=" + name
+}
+
+// startLocalPostgres runs a Postgres cluster in dir, listening only on a socket there.
+func startLocalPostgres(dir string) error {
+	data := filepath.Join(dir, "pgdata")
+
+	out, err := exec.Command("initdb", "-D", data, "-U", "postgres", "-A", "trust", "--no-sync").CombinedOutput()
+
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+
+	port, err := freePort()
+
+	if err != nil {
+		return err
+	}
+
+	options := fmt.Sprintf("-k %s -p %d -c listen_addresses='' -c fsync=off", dir, port)
+
+	out, err = exec.Command("pg_ctl", "-D", data, "-o", options, "-l", filepath.Join(dir, "postgres.log"), "-w", "start").CombinedOutput()
+
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+
+	cleanups = append(cleanups, func() {
+		exec.Command("pg_ctl", "-D", data, "-m", "immediate", "stop").Run()
+	})
+
+	cfg.DBDriver = "postgres"
+	cfg.PostgresConn = fmt.Sprintf("host=%s port=%d user=postgres dbname=postgres sslmode=disable", dir, port)
+
+	return nil
+}
+
+// startPostgresContainer runs Postgres in docker on a free local port
+// and waits for it to take connections.
+func startPostgresContainer() error {
+	image := os.Getenv("TEST_POSTGRES_IMAGE")
+
+	if image == "" {
+		image = "postgres:13-alpine"
+	}
+
+	out, err := exec.Command("docker", "run", "-d", "--rm",
+		"-e", "POSTGRES_HOST_AUTH_METHOD=trust",
+		"-p", "127.0.0.1::5432",
+		image, "-c", "fsync=off").Output()
+
+	if err != nil {
+		return fmt.Errorf("docker run: %v", err)
+	}
+
+	container := strings.TrimSpace(string(out))
+
+	cleanups = append(cleanups, func() {
+		exec.Command("docker", "rm", "-f", container).Run()
+	})
+
+	out, err = exec.Command("docker", "port", container, "5432/tcp").Output()
+
+	if err != nil {
+		return fmt.Errorf("docker port: %v", err)
+	}
+
+	// eg: 127.0.0.1:49153, possibly followed by the IPv6 mapping
+	address := strings.Fields(string(out))[0]
+
+	cfg.DBDriver = "postgres"
+	cfg.PostgresConn = "postgres://postgres@" + address + "/postgres?sslmode=disable"
+
+	pg, err := sql.Open("postgres", cfg.PostgresConn)
+
+	if err != nil {
+		return err
+	}
+
+	defer pg.Close()
+
+	// the server restarts once while the image initialises the cluster
+	deadline := time.Now().Add(30 * time.Second)
+
+	for {
+		err = pg.Ping()
+
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("postgres container didn't start: %v", err)
+		}
+
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// reset puts the database back to how seedDemo left it, ending every session.
+func (a *testApp) reset(t *testing.T) {
+	t.Helper()
+
+	// rows go before the rows they refer to
+	tables := []string{"sessions", "jobs"}
+
+	for i := len(exportTables) - 1; i >= 0; i-- {
+		tables = append(tables, exportTables[i])
+	}
+
+	for _, table := range tables {
+		_, err := a.db.Exec(`DELETE FROM goissuez.` + table)
+
+		if err != nil {
+			t.Fatalf("reset: emptying %s: %v", table, err)
+		}
+	}
+
+	_, err := importData(a.db, bytes.NewReader(a.snapshot))
+
+	if err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+
+	auth.cache.clear()
+}
+
+// testClient makes requests to the app as one visitor. It keeps cookies
+// and doesn't follow redirects, so tests see where they lead.
+type testClient struct {
+	t    *testing.T
+	app  *testApp
+	http *http.Client
+}
+
+func (a *testApp) client(t *testing.T) *testClient {
+	t.Helper()
+
+	jar, err := cookiejar.New(nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testClient{t: t, app: a, http: &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+// demoRoleNames are the roles with a demo login, least privileged first.
+var demoRoleNames = []string{"developer", "qa", "manager", "admin"}
+
+// loginAs returns a client logged in as the demo user of the role,
+// eg: "developer", "qa", "manager" or "admin".
+func (a *testApp) loginAs(t *testing.T, role string) *testClient {
+	t.Helper()
+
+	c := a.client(t)
+
+	resp := c.get("/demo/" + role)
+
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/dashboard" {
+		t.Fatalf("logging in as %s: got %d to %q", role, resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	return c
+}
+
+// testBody is a request body and its content type.
+type testBody struct {
+	contentType string
+	content     []byte
+}
+
+// form encodes values as a urlencoded form.
+func form(values url.Values) *testBody {
+	return &testBody{"application/x-www-form-urlencoded", []byte(values.Encode())}
+}
+
+// multipartForm encodes values as a multipart form, as the registration forms send.
+func multipartForm(values url.Values) *testBody {
+	var b bytes.Buffer
+
+	w := multipart.NewWriter(&b)
+
+	for name, vs := range values {
+		for _, v := range vs {
+			w.WriteField(name, v)
+		}
+	}
+
+	w.Close()
+
+	return &testBody{w.FormDataContentType(), b.Bytes()}
+}
+
+// jsonBody encodes v as JSON, as the page modules send.
+func jsonBody(v interface{}) *testBody {
+	b, _ := json.Marshal(v)
+
+	return &testBody{"application/json", b}
+}
+
+// do sends a request and returns the response with its body read.
+func (c *testClient) do(method, path string, body *testBody) *http.Response {
+	c.t.Helper()
+
+	var content io.Reader
+
+	if body != nil {
+		content = bytes.NewReader(body.content)
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), method, c.app.server.URL+path, content)
+
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", body.contentType)
+	}
+
+	resp, err := c.http.Do(req)
+
+	if err != nil {
+		c.t.Fatalf("%s %s: %v", method, path, err)
+	}
+
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		c.t.Fatalf("%s %s: reading body: %v", method, path, err)
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	return resp
+}
+
+func (c *testClient) get(path string) *http.Response {
+	c.t.Helper()
+
+	return c.do(http.MethodGet, path, nil)
+}
+
+// bodyString returns a response body read by do.
+func bodyString(resp *http.Response) string {
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	return string(b)
+}
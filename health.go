@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+type healthService struct {
+	db  *sqlDB
+	log *logrus.Logger
+	// draining is set once shutdown starts, so load balancers stop sending new requests
+	draining int32
+}
+
+func NewHealthService(db *sqlDB, logger *logrus.Logger) *healthService {
+	return &healthService{db: db, log: logger}
+}
+
+// liveness reports that the process is up and serving requests.
+func (s *healthService) liveness(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok\n"))
+}
+
+// readiness reports whether the app can serve pages:
+// the database answers and the templates are loaded.
+// It fails while the server is shutting down.
+func (s *healthService) readiness(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if atomic.LoadInt32(&s.draining) == 1 {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	err := s.db.PingContext(ctx)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error health.readiness.ping.", err)
+
+		http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	if tpls == nil || !tpls.hasLayout(mainLayout) {
+		http.Error(w, "templates not loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ready\n"))
+}
+
+// drain marks the app as not ready, ahead of shutting the server down.
+func (s *healthService) drain() {
+	atomic.StoreInt32(&s.draining, 1)
+}
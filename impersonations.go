@@ -0,0 +1,205 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type impersonation struct {
+	ID        int64
+	Admin     user
+	User      user
+	StartedAt string
+	EndedAt   string
+	Duration  string
+}
+
+// impersonate lets an admin view the app as another user.
+// The admin keeps their own session; the session is pointed at a new
+// impersonation record until the admin returns to their own account.
+func (s *adminService) impersonate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	authUser := currentUser(r)
+
+	if authUser.Impersonator != nil {
+		respondError(w, r, conflictError("Return to your own account before viewing as another user."))
+		return
+	}
+
+	user_id := ps.ByName("user_id")
+
+	targetID, err := strconv.ParseInt(user_id, 10, 64)
+
+	if err != nil || targetID == authUser.ID {
+		respondError(w, r, validationError("Cannot view as this user."))
+		return
+	}
+
+	cookie, err := r.Cookie(cfg.CookieName)
+
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	// make sure the target is a real, active user
+	target, err := s.stores.users.find(targetID)
+
+	if err == sql.ErrNoRows || (err == nil && target.DeletedAt != "") {
+		respondError(w, r, notFoundError("User not found."))
+		return
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error admin.impersonate.getuser.", err)
+
+		respondError(w, r, internalError("Error viewing as user.", nil))
+		return
+	}
+
+	allowed, err := s.canImpersonate(authUser, target)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error admin.impersonate.permissions.", err)
+
+		respondError(w, r, internalError("Error viewing as user.", nil))
+		return
+	}
+
+	if !allowed {
+		respondError(w, r, forbiddenError("You can't view as a user who can do more than you."))
+		return
+	}
+
+	err = s.stores.sessions.startImpersonation(cookie.Value, authUser.ID, targetID)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error admin.impersonate.start.", err)
+
+		respondError(w, r, internalError("Error viewing as user.", nil))
+		return
+	}
+
+	auth.cache.forget(cookie.Value)
+
+	s.log.WithContext(r.Context()).Info("Admin ", authUser.ID, " is viewing as user ", targetID)
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+// canImpersonate reports whether authUser may view the app as target. Nobody can view as an admin,
+// and only admins can view as a user with a capability they don't have themselves,
+// so impersonate_users never grants more than the impersonator already holds.
+func (s *adminService) canImpersonate(authUser, target user) (bool, error) {
+	if target.RoleID == ADMIN {
+		return false, nil
+	}
+
+	return s.holdsRole(authUser, target.RoleID)
+}
+
+// holdsRole reports whether authUser has every capability the role has, its own and
+// those it inherits. Admins hold every role, and only admins hold the admin role.
+func (s *adminService) holdsRole(authUser user, role_id int64) (bool, error) {
+	if authUser.IsAdmin {
+		return true, nil
+	}
+
+	if role_id == ADMIN {
+		return false, nil
+	}
+
+	permissions, err := s.getRolePermissions(role_id)
+
+	if err != nil {
+		return false, err
+	}
+
+	for name := range permissions {
+		if !authUser.Can([]string{name}) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// stopImpersonating returns the admin to their own account.
+// The auth user here is the impersonated user, so there is no capability check;
+// only a session with an open impersonation can be returned.
+func (s *adminService) stopImpersonating(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	authUser := currentUser(r)
+
+	if authUser.Impersonator == nil {
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	cookie, err := r.Cookie(cfg.CookieName)
+
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	err = s.endImpersonation(cookie.Value)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error admin.stopimpersonating.", err)
+
+		respondError(w, r, internalError("Error returning to your account.", nil))
+		return
+	}
+
+	s.log.WithContext(r.Context()).Info("Admin ", authUser.Impersonator.ID, " stopped viewing as user ", authUser.ID)
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// endImpersonation closes any open impersonation for the given session.
+func (s *adminService) endImpersonation(sessionUUID string) error {
+	err := s.stores.sessions.endImpersonation(sessionUUID)
+
+	auth.cache.forget(sessionUUID)
+
+	return err
+}
+
+// impersonations lists every time an admin viewed the app as another user.
+func (s *adminService) impersonations(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	impersonations, err := s.stores.sessions.impersonations()
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error admin.impersonations.query.", err)
+
+		respondError(w, r, internalError("Error listing impersonations.", nil))
+		return
+	}
+
+	pageData := page{
+		Title: "Impersonation Log",
+		Data: struct {
+			Impersonations []impersonation
+		}{
+			impersonations,
+		},
+	}
+
+	view := NewView(w, r)
+
+	view.make("admin/impersonations.gohtml")
+
+	err = view.exec(mainLayout, pageData)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error admin.impersonations.view.exec", err)
+
+		respondError(w, r, internalError("Something went wrong.", nil))
+		return
+	}
+
+	view.send(http.StatusOK)
+}
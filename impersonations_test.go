@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// grantCapability gives the role one more capability of its own.
+func grantCapability(t *testing.T, store stores, roleID int64, name string) {
+	t.Helper()
+
+	own, err := store.roles.permissions(roleID)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []int64{}
+
+	for _, capData := range own {
+		ids = append(ids, capData.ID)
+	}
+
+	all, err := store.roles.capabilities()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, capData := range all {
+		if capData.Name == name {
+			ids = append(ids, capData.ID)
+		}
+	}
+
+	err = store.roles.setPermissions(roleID, ids)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth.cache.clear()
+}
+
+// TestImpersonatePrivileges checks impersonate_users only lets a user view as
+// someone who can't do more than they can.
+func TestImpersonatePrivileges(t *testing.T) {
+	a := testApplication(t)
+
+	a.reset(t)
+	defer a.reset(t)
+
+	store := newSQLStores(a.db)
+	fx := a.fixtures
+
+	grantCapability(t, store, fx.Roles["Manager"], "impersonate_users")
+	grantCapability(t, store, fx.Roles["Developer"], "impersonate_users")
+
+	cases := []struct {
+		role   string
+		target string
+		want   int
+	}{
+		// admins can view as anyone but another admin
+		{"admin", "manager_demo", http.StatusSeeOther},
+		{"manager", "admin_demo", http.StatusForbidden},
+		// a manager has everything QA and developers have
+		{"manager", "qa_demo", http.StatusSeeOther},
+		{"manager", "developer_demo", http.StatusSeeOther},
+		// a developer can't take on a manager's capabilities
+		{"developer", "manager_demo", http.StatusForbidden},
+		{"developer", "qa_demo", http.StatusForbidden},
+	}
+
+	for _, tc := range cases {
+		c := a.loginAs(t, tc.role)
+
+		resp := c.do("POST", "/admin/users/"+strconv.FormatInt(fx.Users[tc.target], 10)+"/impersonate", nil)
+
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s viewing as %s: got %d, want %d", tc.role, tc.target, resp.StatusCode, tc.want)
+		}
+	}
+}
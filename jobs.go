@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+// job is one unit of background work in goissuez.jobs.
+type job struct {
+	ID   int64
+	Kind string
+	// Payload is the job's arguments as JSON.
+	Payload string
+	// Status is queued, running, done or dead.
+	Status      string
+	Attempts    int
+	MaxAttempts int
+	RunAt       string
+	LastError   string
+	// UniqueKey, if set, keeps the job from being enqueued twice.
+	UniqueKey  string
+	CreatedAt  string
+	FinishedAt string
+}
+
+// decode reads the job's payload into v.
+func (j job) decode(v interface{}) error {
+	return json.Unmarshal([]byte(j.Payload), v)
+}
+
+// jobHandler does the work of one kind of job. Returning an error, or panicking,
+// fails the attempt and the job is tried again later. The context ends at the kind's timeout.
+type jobHandler func(ctx context.Context, j job) error
+
+// jobType is a registered kind of job.
+type jobType struct {
+	Kind        string
+	MaxAttempts int
+	Timeout     time.Duration
+	// Schedule is the cron expression the job is enqueued on, if any.
+	Schedule string
+
+	handler  jobHandler
+	schedule *cronSchedule
+}
+
+const (
+	// jobLockTimeout is how long a job can run before it's taken
+	// to have lost its worker and is claimed by another.
+	jobLockTimeout = 15 * time.Minute
+	// failed jobs wait jobRetryBase before the second attempt,
+	// twice as long before each one after that, and never more than jobRetryMax.
+	jobRetryBase = 10 * time.Second
+	jobRetryMax  = time.Hour
+	// deadJobsShown is how many dead jobs the admin page lists.
+	deadJobsShown = 100
+)
+
+// The kinds of job the app runs.
+const (
+	jobPurgeSessions = "purge_sessions"
+	jobPurgeJobs     = "purge_jobs"
+)
+
+type jobService struct {
+	stores stores
+	log    *logrus.Logger
+	tpls   *templateRegistry
+
+	types map[string]*jobType
+
+	stop    chan struct{}
+	running sync.WaitGroup
+}
+
+func NewJobService(store stores, logger *logrus.Logger, tpls *templateRegistry) *jobService {
+	s := &jobService{stores: store, log: logger, tpls: tpls, types: make(map[string]*jobType)}
+
+	// sessions last as long as the cookie, a day
+	s.register(jobPurgeSessions, 3, time.Minute, func(ctx context.Context, j job) error {
+		n, err := s.stores.sessions.purge(time.Now().Add(-24 * time.Hour))
+
+		if err == nil && n > 0 {
+			s.log.WithField("sessions", n).Info("Purged expired sessions.")
+		}
+
+		return err
+	})
+	s.schedule(jobPurgeSessions, "@hourly")
+
+	// done jobs are kept a week to look into; dead jobs stay until an admin deals with them
+	s.register(jobPurgeJobs, 3, time.Minute, func(ctx context.Context, j job) error {
+		_, err := s.stores.jobs.purge(time.Now().Add(-7 * 24 * time.Hour))
+
+		return err
+	})
+	s.schedule(jobPurgeJobs, "30 3 * * *")
+
+	return s
+}
+
+// register adds a kind of job. It panics on a duplicate kind;
+// jobs are registered at startup, so that's a programming error.
+func (s *jobService) register(kind string, maxAttempts int, timeout time.Duration, handler jobHandler) {
+	if _, ok := s.types[kind]; ok {
+		panic("job kind registered twice: " + kind)
+	}
+
+	s.types[kind] = &jobType{Kind: kind, MaxAttempts: maxAttempts, Timeout: timeout, handler: handler}
+}
+
+// schedule enqueues a registered kind of job whenever the cron expression matches.
+// Runs missed while no server was up are skipped. It panics on an unknown kind
+// or a bad expression, for the same reason as register.
+func (s *jobService) schedule(kind, spec string) {
+	t, ok := s.types[kind]
+
+	if !ok {
+		panic("scheduling unregistered job kind: " + kind)
+	}
+
+	schedule, err := parseCron(spec)
+
+	if err != nil {
+		panic(err)
+	}
+
+	t.Schedule = spec
+	t.schedule = &schedule
+}
+
+// enqueue queues a job to run as soon as a worker is free.
+func (s *jobService) enqueue(kind string, payload interface{}) (int64, error) {
+	return s.enqueueAt(kind, payload, time.Now())
+}
+
+// enqueueAt queues a job to run at the given time. The payload is stored as JSON.
+func (s *jobService) enqueueAt(kind string, payload interface{}, runAt time.Time) (int64, error) {
+	t, ok := s.types[kind]
+
+	if !ok {
+		return 0, fmt.Errorf("no handler for job kind %q", kind)
+	}
+
+	b, err := json.Marshal(payload)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return s.stores.jobs.enqueue(job{Kind: kind, Payload: string(b), MaxAttempts: t.MaxAttempts}, runAt)
+}
+
+// start runs the given number of workers, each checking for due jobs every poll,
+// and the scheduler that enqueues cron jobs. Stop them with stop.
+func (s *jobService) start(workers int, poll time.Duration) {
+	s.stop = make(chan struct{})
+
+	for i := 0; i < workers; i++ {
+		s.running.Add(1)
+		go s.work(poll)
+	}
+
+	s.running.Add(1)
+	go s.scheduler()
+
+	s.log.WithField("workers", workers).Info("Started background jobs.")
+}
+
+// shutdown stops claiming jobs and waits for the ones running to finish
+// until ctx is done. Jobs still running then are picked up again
+// once their lock times out.
+func (s *jobService) shutdown(ctx context.Context) error {
+	if s.stop == nil {
+		return nil
+	}
+
+	close(s.stop)
+
+	done := make(chan struct{})
+
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *jobService) work(poll time.Duration) {
+	defer s.running.Done()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		if s.next() {
+			continue
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(poll):
+		}
+	}
+}
+
+// next claims and runs the next due job, reporting whether there was one.
+func (s *jobService) next() bool {
+	now := time.Now()
+
+	j, err := s.stores.jobs.claim(now, now.Add(-jobLockTimeout))
+
+	if err == sql.ErrNoRows {
+		return false
+	}
+
+	if err != nil {
+		s.log.Error("Error jobs.claim.", err)
+		return false
+	}
+
+	s.run(j)
+
+	return true
+}
+
+// run performs a claimed job and records how it went. A failed job is retried
+// with backoff until it has used its attempts and is then marked dead.
+func (s *jobService) run(j job) {
+	entry := s.log.WithFields(logrus.Fields{"job_id": j.ID, "job": j.Kind, "attempt": j.Attempts})
+
+	started := time.Now()
+
+	err := s.perform(j)
+
+	entry = entry.WithField("duration_ms", time.Since(started).Milliseconds())
+
+	if err == nil {
+		entry.Debug("Job done.")
+
+		err = s.stores.jobs.complete(j.ID)
+
+		if err != nil {
+			entry.Error("Error jobs.complete.", err)
+		}
+
+		return
+	}
+
+	var retryAt time.Time
+
+	if j.Attempts < j.MaxAttempts {
+		retryAt = time.Now().Add(jobBackoff(j.Attempts))
+
+		entry.WithField("retry_at", retryAt.UTC().Format(time.RFC3339)).Warn("Job failed: ", err)
+	} else {
+		entry.Error("Job failed on its last attempt and is dead: ", err)
+	}
+
+	err = s.stores.jobs.fail(j.ID, err.Error(), retryAt)
+
+	if err != nil {
+		entry.Error("Error jobs.fail.", err)
+	}
+}
+
+// perform calls the job's handler, turning a panic into an error.
+func (s *jobService) perform(j job) (err error) {
+	t, ok := s.types[j.Kind]
+
+	if !ok {
+		return fmt.Errorf("no handler for job kind %q", j.Kind)
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			s.log.WithField("stack", string(debug.Stack())).Error("Panic running job ", j.ID, ": ", recovered)
+
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.Timeout)
+	defer cancel()
+
+	return t.handler(ctx, j)
+}
+
+// jobBackoff returns how long to wait after the given failed attempt.
+func jobBackoff(attempt int) time.Duration {
+	wait := jobRetryBase
+
+	for i := 1; i < attempt && wait < jobRetryMax; i++ {
+		wait *= 2
+	}
+
+	if wait > jobRetryMax {
+		wait = jobRetryMax
+	}
+
+	return wait
+}
+
+// scheduler enqueues the scheduled jobs at the start of every minute they match.
+func (s *jobService) scheduler() {
+	defer s.running.Done()
+
+	for {
+		next := time.Now().Truncate(time.Minute).Add(time.Minute)
+
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		s.enqueueScheduled(next)
+	}
+}
+
+// enqueueScheduled queues the scheduled jobs due in the minute starting at t.
+// Every server runs a scheduler; the unique key lets only one of them queue each run.
+func (s *jobService) enqueueScheduled(t time.Time) {
+	for _, jobData := range s.sortedTypes() {
+		if jobData.schedule == nil || !jobData.schedule.matches(t) {
+			continue
+		}
+
+		_, err := s.stores.jobs.enqueue(job{
+			Kind:        jobData.Kind,
+			Payload:     "{}",
+			MaxAttempts: jobData.MaxAttempts,
+			UniqueKey:   "cron:" + jobData.Kind + ":" + t.UTC().Format("2006-01-02T15:04"),
+		}, t)
+
+		if err != nil {
+			s.log.WithField("job", jobData.Kind).Error("Error jobs.enqueuescheduled.", err)
+		}
+	}
+}
+
+func (s *jobService) sortedTypes() []jobType {
+	types := []jobType{}
+
+	for _, t := range s.types {
+		types = append(types, *t)
+	}
+
+	sort.Slice(types, func(i, j int) bool { return types[i].Kind < types[j].Kind })
+
+	return types
+}
+
+// index shows the queue depth, the kinds of job and the dead jobs.
+func (s *jobService) index(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	counts, err := s.stores.jobs.counts()
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error jobs.index.counts.", err)
+
+		respondError(w, r, internalError("Error loading jobs.", nil))
+		return
+	}
+
+	dead, err := s.stores.jobs.dead(deadJobsShown)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error jobs.index.dead.", err)
+
+		respondError(w, r, internalError("Error loading jobs.", nil))
+		return
+	}
+
+	pageData := page{
+		Title: "Background Jobs",
+		Data: struct {
+			Counts  map[string]int
+			Types   []jobType
+			Dead    []job
+			Workers int
+		}{
+			counts,
+			s.sortedTypes(),
+			dead,
+			cfg.Workers,
+		},
+	}
+
+	view := NewView(w, r)
+
+	view.make("admin/jobs.gohtml")
+
+	err = view.exec(mainLayout, pageData)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error jobs.index.view.exec", err)
+
+		respondError(w, r, internalError("Something went wrong.", nil))
+		return
+	}
+
+	view.send(http.StatusOK)
+}
+
+// retry queues a dead job again.
+func (s *jobService) retry(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	err := s.stores.jobs.retry(parseID(ps.ByName("job_id")))
+
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Dead job not found."))
+		return
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error jobs.retry.exec.", err)
+
+		respondError(w, r, internalError("Error retrying job.", nil))
+		return
+	}
+
+	http.Redirect(w, r, "/admin/jobs", http.StatusSeeOther)
+}
+
+// discard deletes a dead job.
+func (s *jobService) discard(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	err := s.stores.jobs.destroy(parseID(ps.ByName("job_id")))
+
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Dead job not found."))
+		return
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error jobs.discard.exec.", err)
+
+		respondError(w, r, internalError("Error discarding job.", nil))
+		return
+	}
+
+	http.Redirect(w, r, "/admin/jobs", http.StatusSeeOther)
+}
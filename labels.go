@@ -0,0 +1,535 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+type labelService struct {
+	stores stores
+	log    *logrus.Logger
+	tpls   *templateRegistry
+}
+
+// label categorises stories and bugs across a project's features, eg: "frontend" or "regression".
+type label struct {
+	ID        int64
+	ProjectID int64
+	Name      string
+	// Color is the label's background, eg: "#d73a4a".
+	Color string
+	// Project is the name of the label's project, and Uses how many stories and bugs have it.
+	// Neither is filled in for the labels of an issue.
+	Project   string
+	Uses      int
+	CreatedAt string
+	UpdatedAt string
+}
+
+// labelNameLength is the longest a label's name can be.
+const labelNameLength = 50
+
+const defaultLabelColor = "#6c757d"
+
+var labelColorPattern = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+
+// errLabelExists is returned when a project already has a label with the name, whatever its case.
+var errLabelExists = errors.New("the project already has a label with the name")
+
+// TextColor is dark text on light labels and white on dark ones.
+func (l label) TextColor() string {
+	value, err := strconv.ParseUint(strings.TrimPrefix(l.Color, "#"), 16, 32)
+
+	if err != nil {
+		return "#fff"
+	}
+
+	red, green, blue := value>>16&0xff, value>>8&0xff, value&0xff
+
+	if 299*red+587*green+114*blue > 150000 {
+		return "#212529"
+	}
+
+	return "#fff"
+}
+
+// labelPicker is what the label_picker template needs to show a project's labels in an issue's form.
+type labelPicker struct {
+	Labels   []label
+	Selected map[int64]bool
+}
+
+// labelFilter is what the label_filter template needs to link to the issues with each label.
+type labelFilter struct {
+	// Current is the name of the label the list is filtered by, "" when it isn't.
+	Current string
+	// Options are the labels of the issues in the list before it was filtered, one of each name.
+	Options []label
+	// Sort is the order the list is in, kept by the links.
+	Sort string
+}
+
+func NewLabelService(store stores, log *logrus.Logger, tpls *templateRegistry) *labelService {
+	return &labelService{store, log, tpls}
+}
+
+// readLabel reads the name and colour of a label form into labelData.
+// A blank colour is the default grey.
+func readLabel(form url.Values, labelData *label) error {
+	labelData.Name = strings.TrimSpace(form.Get("name"))
+	labelData.Color = strings.ToLower(strings.TrimSpace(form.Get("color")))
+
+	if labelData.Name == "" {
+		return validationError("Give the label a name.")
+	}
+
+	if utf8.RuneCountInString(labelData.Name) > labelNameLength {
+		return validationError("Label names can be at most " + strconv.Itoa(labelNameLength) + " characters.")
+	}
+
+	if labelData.Color == "" {
+		labelData.Color = defaultLabelColor
+	}
+
+	if !labelColorPattern.MatchString(labelData.Color) {
+		return validationError("Write the colour as a hex code, eg: #d73a4a.")
+	}
+
+	return nil
+}
+
+// readLabelIDs reads the labels picked in an issue's form, which must be among the project's labels.
+func readLabelIDs(form url.Values, projectLabels []label) ([]int64, error) {
+	ids := []int64{}
+	picked := make(map[int64]bool)
+
+	for _, value := range form["labels"] {
+		if value == "" {
+			continue
+		}
+
+		id := parseID(value)
+		found := false
+
+		for _, l := range projectLabels {
+			if l.ID == id {
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, validationError("Pick labels from the project's labels.")
+		}
+
+		if !picked[id] {
+			ids = append(ids, id)
+			picked[id] = true
+		}
+	}
+
+	return ids, nil
+}
+
+// newLabelPicker shows the project's labels with the issue's own labels picked.
+func newLabelPicker(projectLabels []label, selected []label) labelPicker {
+	picker := labelPicker{Labels: projectLabels, Selected: make(map[int64]bool)}
+
+	for _, l := range selected {
+		picker.Selected[l.ID] = true
+	}
+
+	return picker
+}
+
+// withStoryLabels fills in the labels of each of the stories.
+func withStoryLabels(store stores, stories []story) error {
+	ids := make([]int64, len(stories))
+
+	for i := range stories {
+		ids[i] = stories[i].ID
+	}
+
+	labels, err := store.labels.forStories(ids)
+
+	if err != nil {
+		return err
+	}
+
+	for i := range stories {
+		stories[i].Labels = labels[stories[i].ID]
+	}
+
+	return nil
+}
+
+// withBugLabels fills in the labels of each of the bugs.
+func withBugLabels(store stores, bugs []bug) error {
+	ids := make([]int64, len(bugs))
+
+	for i := range bugs {
+		ids[i] = bugs[i].ID
+	}
+
+	labels, err := store.labels.forBugs(ids)
+
+	if err != nil {
+		return err
+	}
+
+	for i := range bugs {
+		bugs[i].Labels = labels[bugs[i].ID]
+	}
+
+	return nil
+}
+
+// hasLabel reports whether one of the labels is called name, whatever its case.
+func hasLabel(labels []label, name string) bool {
+	for _, l := range labels {
+		if strings.EqualFold(l.Name, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addLabelOptions adds the labels to a filter's options, skipping names it already has.
+func (f *labelFilter) addLabelOptions(labels []label) {
+	for _, l := range labels {
+		if !hasLabel(f.Options, l.Name) {
+			f.Options = append(f.Options, l)
+		}
+	}
+}
+
+// filterStories keeps the stories with the label called name, all of them when name is "".
+// Labels of the same name in different projects are treated as one.
+func filterStories(stories []story, name string) ([]story, labelFilter) {
+	filter := labelFilter{Current: name, Options: []label{}}
+	filtered := []story{}
+
+	for _, s := range stories {
+		filter.addLabelOptions(s.Labels)
+
+		if name == "" || hasLabel(s.Labels, name) {
+			filtered = append(filtered, s)
+		}
+	}
+
+	sortLabels(filter.Options)
+
+	return filtered, filter
+}
+
+// filterBugs keeps the bugs with the label called name, all of them when name is "".
+func filterBugs(bugs []bug, name string) ([]bug, labelFilter) {
+	filter := labelFilter{Current: name, Options: []label{}}
+	filtered := []bug{}
+
+	for _, b := range bugs {
+		filter.addLabelOptions(b.Labels)
+
+		if name == "" || hasLabel(b.Labels, name) {
+			filtered = append(filtered, b)
+		}
+	}
+
+	sortLabels(filter.Options)
+
+	return filtered, filter
+}
+
+// combineLabelFilters makes one filter for a page with several lists, offering the labels of all of them.
+func combineLabelFilters(filters ...labelFilter) labelFilter {
+	combined := labelFilter{Options: []label{}}
+
+	for _, f := range filters {
+		combined.Current = f.Current
+		combined.addLabelOptions(f.Options)
+	}
+
+	sortLabels(combined.Options)
+
+	return combined
+}
+
+// index lists a project's labels with the form to add one.
+func (s *labelService) index(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	authUser := currentUser(r)
+
+	projectData, err := s.stores.projects.find(parseID(ps.ByName("project_id")))
+
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Project not found."))
+		return
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error labels.index.project.", err)
+
+		respondError(w, r, internalError("Error listing labels.", nil))
+		return
+	}
+
+	labels, err := s.stores.labels.byProject(projectData.ID)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error labels.index.query.", err)
+
+		respondError(w, r, internalError("Error listing labels.", nil))
+		return
+	}
+
+	pageData := page{
+		Title: projectData.Name + " Labels",
+		Data: struct {
+			Project project
+			Labels  []label
+			// CanManage links to the admin page where labels are renamed and merged.
+			CanManage    bool
+			DefaultColor string
+		}{
+			projectData,
+			labels,
+			authUser.IsAdmin || authUser.Can([]string{"manage_labels"}),
+			defaultLabelColor,
+		},
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+
+	view := viewService{w: w, r: r}
+	view.make("labels/index.gohtml")
+	err = view.exec(mainLayout, pageData)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
+
+		return
+	}
+
+	view.send(http.StatusOK)
+}
+
+// store adds a label to the project in the route.
+func (s *labelService) store(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectData, err := s.stores.projects.find(parseID(ps.ByName("project_id")))
+
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Project not found."))
+		return
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error labels.store.project.", err)
+
+		respondError(w, r, internalError("Error saving label.", nil))
+		return
+	}
+
+	r.ParseForm()
+
+	labelData := label{ProjectID: projectData.ID}
+
+	err = readLabel(r.PostForm, &labelData)
+
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	_, err = s.stores.labels.create(labelData)
+
+	if err == errLabelExists {
+		respondError(w, r, conflictError("The project already has a label called "+labelData.Name+"."))
+		return
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error labels.store.exec.", err)
+
+		respondError(w, r, internalError("Error saving label.", nil))
+		return
+	}
+
+	http.Redirect(w, r, labelsPath(projectData.ID), http.StatusSeeOther)
+}
+
+// labelGroup is one project's labels on the admin page.
+type labelGroup struct {
+	ProjectID int64
+	Project   string
+	Labels    []label
+}
+
+// admin lists the labels of every project, to rename and merge them.
+func (s *labelService) admin(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	labels, err := s.stores.labels.all()
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error labels.admin.query.", err)
+
+		respondError(w, r, internalError("Error listing labels.", nil))
+		return
+	}
+
+	// the labels come grouped by project
+	groups := []labelGroup{}
+
+	for _, l := range labels {
+		if len(groups) == 0 || groups[len(groups)-1].ProjectID != l.ProjectID {
+			groups = append(groups, labelGroup{ProjectID: l.ProjectID, Project: l.Project})
+		}
+
+		last := &groups[len(groups)-1]
+		last.Labels = append(last.Labels, l)
+	}
+
+	pageData := page{Title: "Labels", Data: groups}
+
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+
+	view := viewService{w: w, r: r}
+	view.make("admin/labels.gohtml")
+	err = view.exec(mainLayout, pageData)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
+
+		return
+	}
+
+	view.send(http.StatusOK)
+}
+
+// update renames and recolours a label on every story and bug that has it.
+func (s *labelService) update(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	labelData, ok := s.findLabel(w, r, ps, "update")
+
+	if !ok {
+		return
+	}
+
+	r.ParseForm()
+
+	err := readLabel(r.PostForm, &labelData)
+
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	err = s.stores.labels.update(labelData)
+
+	if err == errLabelExists {
+		respondError(w, r, conflictError("The project already has a label called "+labelData.Name+"."))
+		return
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error labels.update.exec.", err)
+
+		respondError(w, r, internalError("Error updating label.", nil))
+		return
+	}
+
+	http.Redirect(w, r, "/admin/labels", http.StatusSeeOther)
+}
+
+// merge replaces a label with another of its project's labels on every story and bug, then deletes it.
+func (s *labelService) merge(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	labelData, ok := s.findLabel(w, r, ps, "merge")
+
+	if !ok {
+		return
+	}
+
+	r.ParseForm()
+
+	into, err := s.stores.labels.find(parseID(r.PostForm.Get("into_id")))
+
+	if err == sql.ErrNoRows || (err == nil && into.ProjectID != labelData.ProjectID) {
+		respondError(w, r, validationError("Choose another of the project's labels to merge into."))
+		return
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error labels.merge.into.", err)
+
+		respondError(w, r, internalError("Error merging labels.", nil))
+		return
+	}
+
+	if into.ID == labelData.ID {
+		respondError(w, r, validationError("A label can't be merged into itself."))
+		return
+	}
+
+	err = s.stores.labels.merge(labelData.ID, into.ID)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error labels.merge.exec.", err)
+
+		respondError(w, r, internalError("Error merging labels.", nil))
+		return
+	}
+
+	http.Redirect(w, r, "/admin/labels", http.StatusSeeOther)
+}
+
+// findLabel looks up the label in the route, responding with an error if it can't.
+func (s *labelService) findLabel(w http.ResponseWriter, r *http.Request, ps httprouter.Params, action string) (label, bool) {
+	labelData, err := s.stores.labels.find(parseID(ps.ByName("label_id")))
+
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Label not found."))
+		return label{}, false
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error labels."+action+".find.", err)
+
+		respondError(w, r, internalError("Error getting label.", nil))
+		return label{}, false
+	}
+
+	return labelData, true
+}
+
+// owners returns the creator of the project named in the route.
+func (s *labelService) owners(ps httprouter.Params) ([]int64, error) {
+	projectData, err := s.stores.projects.find(parseID(ps.ByName("project_id")))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []int64{projectData.UserID}, nil
+}
+
+// labelsPath is the page listing a project's labels.
+func labelsPath(projectID int64) string {
+	return "/projects/" + strconv.FormatInt(projectID, 10) + "/labels"
+}
+
+// sortLabels sorts labels by name, whatever its case.
+func sortLabels(labels []label) []label {
+	sort.SliceStable(labels, func(i, j int) bool {
+		return strings.ToLower(labels[i].Name) < strings.ToLower(labels[j].Name)
+	})
+
+	return labels
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestLabelMerge merges the regression label into frontend, including on a story that has both.
+func TestLabelMerge(t *testing.T) {
+	a := testApplication(t)
+
+	a.reset(t)
+	defer a.reset(t)
+
+	store := newSQLStores(a.db)
+	fx := a.fixtures
+
+	frontend, regression, customer := fx.LabelIDs[0], fx.LabelIDs[1], fx.LabelIDs[2]
+
+	err := store.labels.setStoryLabels(fx.StoryIDs[2], []int64{frontend, regression})
+
+	if err == nil {
+		err = store.labels.setStoryLabels(fx.StoryIDs[0], []int64{regression})
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := a.loginAs(t, "admin")
+
+	merge := func(id, into int64) int {
+		resp := c.do("POST", "/labels/"+formatID(id)+"/merge", form(url.Values{"into_id": {formatID(into)}}))
+
+		return resp.StatusCode
+	}
+
+	if status := merge(regression, regression); status != http.StatusUnprocessableEntity {
+		t.Errorf("merging into itself: got %d, want %d", status, http.StatusUnprocessableEntity)
+	}
+
+	if status := merge(regression, 0); status != http.StatusUnprocessableEntity {
+		t.Errorf("merging into nothing: got %d, want %d", status, http.StatusUnprocessableEntity)
+	}
+
+	if status := merge(regression, frontend); status != http.StatusSeeOther {
+		t.Fatalf("merging: got %d, want %d", status, http.StatusSeeOther)
+	}
+
+	stories, err := store.labels.forStories(fx.StoryIDs)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bugs, err := store.labels.forBugs(fx.BugIDs)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the story that had both has frontend once
+	if got := labelNames(stories[fx.StoryIDs[2]]); got != "frontend" {
+		t.Errorf("story with both labels: got %q, want frontend", got)
+	}
+
+	if got := labelNames(stories[fx.StoryIDs[0]]); got != "frontend" {
+		t.Errorf("story with regression: got %q, want frontend", got)
+	}
+
+	if got := labelNames(bugs[fx.BugIDs[0]]); got != "customer-reported,frontend" {
+		t.Errorf("bug with regression: got %q, want customer-reported,frontend", got)
+	}
+
+	_, err = store.labels.find(regression)
+
+	if err != sql.ErrNoRows {
+		t.Errorf("finding the merged label: got %v, want sql.ErrNoRows", err)
+	}
+
+	if _, err = store.labels.find(customer); err != nil {
+		t.Errorf("finding another label: %v", err)
+	}
+
+	if status := merge(regression, frontend); status != http.StatusNotFound {
+		t.Errorf("merging the merged label again: got %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestStoreLabels(t *testing.T) {
+	eachStore(t, func(t *testing.T, store stores, fx storeFixtures) {
+		backend, flaky := fx.LabelIDs[0], fx.LabelIDs[1]
+
+		_, err := store.labels.create(label{ProjectID: fx.ProjectID, Name: "Backend", Color: "#000000"})
+
+		if err != errLabelExists {
+			t.Errorf("creating a label with another's name: got %v, want errLabelExists", err)
+		}
+
+		err = store.labels.setStoryLabels(fx.StoryIDs[0], []int64{flaky, backend})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := store.labels.forStories(fx.StoryIDs)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := labelNames(found[fx.StoryIDs[0]]); got != "backend,flaky" {
+			t.Errorf("story labels: got %q, want them by name", got)
+		}
+
+		if len(found[fx.StoryIDs[1]]) != 0 {
+			t.Errorf("unlabelled story: got %+v", found[fx.StoryIDs[1]])
+		}
+
+		// more issues than fit in one query, with the labelled story last and asked for twice
+		many := []int64{fx.StoryIDs[0]}
+
+		for i := int64(1); i <= 2*labelQueryIDs; i++ {
+			many = append(many, -i)
+		}
+
+		found, err = store.labels.forStories(append(many, fx.StoryIDs[0]))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := labelNames(found[fx.StoryIDs[0]]); len(found) != 1 || got != "backend,flaky" {
+			t.Errorf("labels of many stories: got %d stories, %q", len(found), got)
+		}
+
+		inProject, err := store.labels.byProject(fx.ProjectID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(inProject) != 2 || inProject[0].Uses != 1 || inProject[1].Uses != 1 {
+			t.Errorf("project labels: got %+v, want both used once", inProject)
+		}
+	})
+}
+
+// labelNames joins the names of the labels, in order.
+func labelNames(labels []label) string {
+	names := []string{}
+
+	for _, labelData := range labels {
+		names = append(names, labelData.Name)
+	}
+
+	return strings.Join(names, ",")
+}
+
+func TestMemoryLabelMerge(t *testing.T) {
+	m := newMemoryApp(t)
+
+	backend, flaky := m.fx.LabelIDs[0], m.fx.LabelIDs[1]
+
+	m.store.labels.setStoryLabels(m.fx.StoryIDs[0], []int64{backend, flaky})
+	m.store.labels.setBugLabels(m.fx.BugIDs[0], []int64{flaky})
+
+	w := m.call(m.labels.merge, "POST", url.Values{"into_id": {formatID(flaky)}}, "label_id", formatID(flaky))
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("merging into itself: got %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+
+	w = m.call(m.labels.merge, "POST", url.Values{"into_id": {formatID(backend)}}, "label_id", formatID(flaky))
+
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("merging: got %d, want %d: %s", w.Code, http.StatusSeeOther, w.Body)
+	}
+
+	stories, _ := m.store.labels.forStories([]int64{m.fx.StoryIDs[0]})
+	bugs, _ := m.store.labels.forBugs([]int64{m.fx.BugIDs[0]})
+
+	if got := labelNames(stories[m.fx.StoryIDs[0]]); got != "backend" {
+		t.Errorf("story labels: got %q, want backend", got)
+	}
+
+	if got := labelNames(bugs[m.fx.BugIDs[0]]); got != "backend" {
+		t.Errorf("bug labels: got %q, want backend", got)
+	}
+
+	_, err := m.store.labels.find(flaky)
+
+	if err != sql.ErrNoRows {
+		t.Errorf("finding the merged label: got %v, want sql.ErrNoRows", err)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+type linkService struct {
+	stores stores
+	log    *logrus.Logger
+	tpls   *templateRegistry
+}
+
+// linkType is a kind of link between two issues. It reads one way from the issue the link
+// goes from, and the other way from the issue it goes to.
+type linkType struct {
+	Key string
+	// Outward is how the link reads from the issue it goes from, eg: "blocks".
+	Outward string
+	// Inward is how it reads from the issue it goes to, eg: "is blocked by".
+	Inward string
+}
+
+// linkTypes are the kinds of links, in the order they're listed on an issue's page.
+var linkTypes = []linkType{
+	{"blocks", "blocks", "is blocked by"},
+	{"duplicates", "duplicates", "is duplicated by"},
+	{"causes", "causes", "is caused by"},
+	{"relates", "relates to", "relates to"},
+}
+
+// errLinkExists is returned when linking two issues that are already linked with the type in the same direction.
+var errLinkExists = errors.New("the issues are already linked")
+
+// issueRef is the story or bug at one end of a link.
+type issueRef struct {
+	// Type is "story" or "bug".
+	Type    string
+	ID      int64
+	Name    string
+	Deleted bool
+}
+
+// Path is the issue's page.
+func (i issueRef) Path() string {
+	if i.Type == "bug" {
+		return "/bugs/" + strconv.FormatInt(i.ID, 10)
+	}
+
+	return "/stories/" + strconv.FormatInt(i.ID, 10)
+}
+
+// Label names the issue by type and number, eg: "Bug #4".
+func (i issueRef) Label() string {
+	if i.Type == "bug" {
+		return "Bug #" + strconv.FormatInt(i.ID, 10)
+	}
+
+	return "Story #" + strconv.FormatInt(i.ID, 10)
+}
+
+// same reports whether the two refer to the same issue.
+func (i issueRef) same(other issueRef) bool {
+	return i.Type == other.Type && i.ID == other.ID
+}
+
+type issueLink struct {
+	ID int64
+	// Type is the key of one of the linkTypes.
+	Type      string
+	From      issueRef
+	To        issueRef
+	UserID    int64
+	CreatedAt string
+}
+
+// linkedIssue is the issue at the other end of one of an issue's links.
+type linkedIssue struct {
+	LinkID int64
+	Issue  issueRef
+}
+
+// linkGroup is an issue's links of one type in one direction, eg: the issues it blocks.
+type linkGroup struct {
+	Label string
+	Links []linkedIssue
+}
+
+// linkChoice is an option of the new link form: a link type read one way.
+// Inward choices have values like "blocks:inward", and link the other issue to this one.
+type linkChoice struct {
+	Value string
+	Label string
+}
+
+// linkChoices lists every link type outward, then inward where it reads differently.
+func linkChoices() []linkChoice {
+	choices := []linkChoice{}
+
+	for _, t := range linkTypes {
+		choices = append(choices, linkChoice{t.Key, t.Outward})
+
+		if t.Inward != t.Outward {
+			choices = append(choices, linkChoice{t.Key + ":inward", t.Inward})
+		}
+	}
+
+	return choices
+}
+
+// issueLinks is what the issue_links template needs to show an issue's links and the form to add one.
+type issueLinks struct {
+	Issue   issueRef
+	Groups  []linkGroup
+	Choices []linkChoice
+	// CanEdit shows the form and the buttons to remove links.
+	CanEdit bool
+}
+
+func NewLinkService(store stores, log *logrus.Logger, tpls *templateRegistry) *linkService {
+	return &linkService{store, log, tpls}
+}
+
+// groupLinks groups the issue's links by type and direction, in the order of linkTypes with the
+// outward links of each type first. Links of a type that reads the same both ways are grouped together.
+func groupLinks(issue issueRef, links []issueLink) []linkGroup {
+	groups := []linkGroup{}
+
+	for _, t := range linkTypes {
+		outward := linkGroup{Label: t.Outward}
+		inward := linkGroup{Label: t.Inward}
+
+		for _, l := range links {
+			if l.Type != t.Key {
+				continue
+			}
+
+			if l.From.same(issue) {
+				outward.Links = append(outward.Links, linkedIssue{l.ID, l.To})
+			} else {
+				inward.Links = append(inward.Links, linkedIssue{l.ID, l.From})
+			}
+		}
+
+		if t.Inward == t.Outward {
+			outward.Links = append(outward.Links, inward.Links...)
+			inward.Links = nil
+		}
+
+		for _, g := range []linkGroup{outward, inward} {
+			if len(g.Links) > 0 {
+				groups = append(groups, g)
+			}
+		}
+	}
+
+	return groups
+}
+
+// loadIssueLinks loads the issue's links for its page.
+func loadIssueLinks(store stores, issue issueRef, canEdit bool) (issueLinks, error) {
+	links, err := store.links.byIssue(issue)
+
+	if err != nil {
+		return issueLinks{}, err
+	}
+
+	return issueLinks{
+		Issue:   issue,
+		Groups:  groupLinks(issue, links),
+		Choices: linkChoices(),
+		CanEdit: canEdit,
+	}, nil
+}
+
+// routeIssue is the story or bug named in the route.
+func routeIssue(ps httprouter.Params) issueRef {
+	if id := ps.ByName("bug_id"); id != "" {
+		return issueRef{Type: "bug", ID: parseID(id)}
+	}
+
+	return issueRef{Type: "story", ID: parseID(ps.ByName("story_id"))}
+}
+
+// findIssue fills in the issue's name, returning sql.ErrNoRows if it doesn't exist.
+func (s *linkService) findIssue(issue issueRef) (issueRef, error) {
+	switch issue.Type {
+	case "story":
+		storyData, err := s.stores.stories.find(issue.ID)
+		issue.Name, issue.Deleted = storyData.Name, storyData.DeletedAt != ""
+
+		return issue, err
+	case "bug":
+		bugData, err := s.stores.bugs.find(issue.ID)
+		issue.Name, issue.Deleted = bugData.Name, bugData.DeletedAt != ""
+
+		return issue, err
+	}
+
+	return issue, sql.ErrNoRows
+}
+
+// store links the story or bug in the route to the one in the form.
+func (s *linkService) store(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	authUser := currentUser(r)
+
+	issue := routeIssue(ps)
+
+	r.ParseForm()
+
+	choice := r.PostForm.Get("link_type")
+	key := strings.TrimSuffix(choice, ":inward")
+
+	var t *linkType
+
+	for i := range linkTypes {
+		if linkTypes[i].Key == key {
+			t = &linkTypes[i]
+		}
+	}
+
+	if t == nil {
+		respondError(w, r, validationError("Choose how the issues are linked."))
+		return
+	}
+
+	target, err := s.findIssue(issueRef{
+		Type: r.PostForm.Get("target_type"),
+		ID:   parseID(strings.TrimPrefix(strings.TrimSpace(r.PostForm.Get("target_id")), "#")),
+	})
+
+	if err == sql.ErrNoRows {
+		respondError(w, r, validationError("There's no story or bug with that number."))
+		return
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error links.store.target.", err)
+
+		respondError(w, r, internalError("Error saving link.", nil))
+		return
+	}
+
+	if target.Deleted {
+		respondError(w, r, validationError(target.Label()+" has been deleted."))
+		return
+	}
+
+	if target.same(issue) {
+		respondError(w, r, validationError("An issue can't be linked to itself."))
+		return
+	}
+
+	linkData := issueLink{Type: t.Key, From: issue, To: target, UserID: authUser.ID}
+
+	if choice != key {
+		linkData.From, linkData.To = target, issue
+	}
+
+	_, err = s.stores.links.create(linkData)
+
+	if err == errLinkExists {
+		respondError(w, r, conflictError("The issues are already linked that way."))
+		return
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error links.store.exec.", err)
+
+		respondError(w, r, internalError("Error saving link.", nil))
+		return
+	}
+
+	http.Redirect(w, r, issue.Path(), http.StatusSeeOther)
+}
+
+// destroy removes one of the links of the story or bug in the route.
+func (s *linkService) destroy(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	issue := routeIssue(ps)
+
+	linkData, err := s.stores.links.find(parseID(ps.ByName("link_id")))
+
+	if err == sql.ErrNoRows || (err == nil && !linkData.From.same(issue) && !linkData.To.same(issue)) {
+		respondError(w, r, notFoundError("Link not found."))
+		return
+	}
+
+	if err == nil {
+		err = s.stores.links.destroy(linkData.ID)
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error links.destroy.exec.", err)
+
+		respondError(w, r, internalError("Error removing link.", nil))
+		return
+	}
+
+	http.Redirect(w, r, issue.Path(), http.StatusSeeOther)
+}
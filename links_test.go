@@ -0,0 +1,191 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// describeGroups writes the groups as "label: Story #1, Bug #2; ...".
+func describeGroups(groups []linkGroup) string {
+	described := []string{}
+
+	for _, g := range groups {
+		issues := []string{}
+
+		for _, l := range g.Links {
+			issues = append(issues, l.Issue.Label())
+		}
+
+		described = append(described, g.Label+": "+strings.Join(issues, ", "))
+	}
+
+	return strings.Join(described, "; ")
+}
+
+func TestGroupLinks(t *testing.T) {
+	story1 := issueRef{Type: "story", ID: 1}
+	story2 := issueRef{Type: "story", ID: 2}
+	bug1 := issueRef{Type: "bug", ID: 1}
+
+	links := []issueLink{
+		{ID: 1, Type: "blocks", From: story1, To: bug1},
+		{ID: 2, Type: "relates", From: story2, To: story1},
+		{ID: 3, Type: "relates", From: story1, To: bug1},
+		{ID: 4, Type: "duplicates", From: bug1, To: story2},
+		{ID: 5, Type: "blocks", From: story2, To: story1},
+	}
+
+	byIssue := func(issue issueRef) []issueLink {
+		found := []issueLink{}
+
+		for _, l := range links {
+			if l.From.same(issue) || l.To.same(issue) {
+				found = append(found, l)
+			}
+		}
+
+		return found
+	}
+
+	cases := []struct {
+		issue issueRef
+		want  string
+	}{
+		// story 1 and bug 1 share an id, but aren't the same issue; links that read
+		// the same both ways are listed together, those from the issue first
+		{story1, "blocks: Bug #1; is blocked by: Story #2; relates to: Bug #1, Story #2"},
+		// the other end reads the inverse way
+		{bug1, "is blocked by: Story #1; duplicates: Story #2; relates to: Story #1"},
+		{story2, "blocks: Story #1; is duplicated by: Bug #1; relates to: Story #1"},
+		{issueRef{Type: "bug", ID: 9}, ""},
+	}
+
+	for _, tc := range cases {
+		if got := describeGroups(groupLinks(tc.issue, byIssue(tc.issue))); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.issue.Label(), got, tc.want)
+		}
+	}
+}
+
+func TestLinkStore(t *testing.T) {
+	m := newMemoryApp(t)
+
+	fx := m.fx
+
+	m.store.bugs.destroy(fx.BugIDs[1])
+
+	link := func(choice, targetType string, targetID int64) url.Values {
+		return url.Values{"link_type": {choice}, "target_type": {targetType}, "target_id": {formatID(targetID)}}
+	}
+
+	// the route of the story and the bug the links are made from
+	story := []string{"story_id", formatID(fx.StoryIDs[0])}
+	bug := []string{"bug_id", formatID(fx.BugIDs[0])}
+
+	cases := []struct {
+		name   string
+		from   []string
+		form   url.Values
+		status int
+	}{
+		{"blocking a bug", story, link("blocks", "bug", fx.BugIDs[0]), http.StatusSeeOther},
+		{"blocking it again", story, link("blocks", "bug", fx.BugIDs[0]), http.StatusConflict},
+		// the bug being blocked by the story is the same link
+		{"blocking it again from the other end", bug, link("blocks:inward", "story", fx.StoryIDs[0]), http.StatusConflict},
+		// but the two may be linked another way
+		{"relating the bug to the story", bug, link("relates", "story", fx.StoryIDs[0]), http.StatusSeeOther},
+		{"a self-link", story, link("relates", "story", fx.StoryIDs[0]), http.StatusUnprocessableEntity},
+		{"a deleted bug", story, link("relates", "bug", fx.BugIDs[1]), http.StatusUnprocessableEntity},
+		{"a missing story", story, link("relates", "story", 999), http.StatusUnprocessableEntity},
+		{"an unknown type", story, link("fixes", "story", fx.StoryIDs[1]), http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range cases {
+		w := m.call(m.links.store, "POST", tc.form, tc.from...)
+
+		if w.Code != tc.status {
+			t.Errorf("%s: got %d, want %d: %s", tc.name, w.Code, tc.status, w.Body)
+		}
+	}
+
+	issueLinks, err := loadIssueLinks(m.store, issueRef{Type: "bug", ID: fx.BugIDs[0]}, true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := describeGroups(issueLinks.Groups), "is blocked by: Story #"+formatID(fx.StoryIDs[0])+"; relates to: Story #"+formatID(fx.StoryIDs[0]); got != want {
+		t.Errorf("the bug's links: got %q, want %q", got, want)
+	}
+}
+
+func TestStoreLinks(t *testing.T) {
+	eachStore(t, func(t *testing.T, store stores, fx storeFixtures) {
+		blocker := issueRef{Type: "story", ID: fx.StoryIDs[0]}
+		blocked := issueRef{Type: "bug", ID: fx.BugIDs[0]}
+
+		linkID, err := store.links.create(issueLink{Type: "blocks", From: blocker, To: blocked, UserID: fx.UserID})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = store.links.create(issueLink{Type: "blocks", From: blocker, To: blocked, UserID: fx.UserID})
+
+		if err != errLinkExists {
+			t.Errorf("linking twice: got %v, want errLinkExists", err)
+		}
+
+		for _, issue := range []issueRef{blocker, blocked} {
+			found, err := store.links.byIssue(issue)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(found) != 1 || found[0].ID != linkID || !found[0].From.same(blocker) || !found[0].To.same(blocked) {
+				t.Errorf("links of %s %d: got %+v", issue.Type, issue.ID, found)
+			}
+		}
+
+		err = store.links.destroy(linkID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = store.links.find(linkID)
+
+		if err != sql.ErrNoRows {
+			t.Errorf("finding a deleted link: got %v, want sql.ErrNoRows", err)
+		}
+	})
+}
+
+func TestMemoryLinkStore(t *testing.T) {
+	m := newMemoryApp(t)
+
+	w := m.call(m.links.store, "POST", url.Values{
+		"link_type":   {"blocks:inward"},
+		"target_type": {"bug"},
+		"target_id":   {"#" + formatID(m.fx.BugIDs[0])},
+	}, "story_id", formatID(m.fx.StoryIDs[0]))
+
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("linking: got %d, want %d: %s", w.Code, http.StatusSeeOther, w.Body)
+	}
+
+	found, err := m.store.links.byIssue(issueRef{Type: "story", ID: m.fx.StoryIDs[0]})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the inward choice links the other way: the bug blocks the story
+	if len(found) != 1 || found[0].From.Type != "bug" || found[0].To.ID != m.fx.StoryIDs[0] {
+		t.Errorf("links: got %+v, want the bug blocking the story", found)
+	}
+}
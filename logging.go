@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// requestIDKey is the context key of the request's ID.
+const requestIDKey = "request_id"
+
+// validRequestID limits the IDs accepted from the X-Request-ID header,
+// so a client can't put anything odd into the log.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestID returns the ID the request logging middleware gave the request.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+
+	return id
+}
+
+// redactedFields are the log fields whose values never reach the log.
+var redactedFields = []string{"password", "hash", "token", "secret", "cookie", "authorization", "session"}
+
+func isRedacted(field string) bool {
+	field = strings.ToLower(field)
+
+	for _, name := range redactedFields {
+		if strings.Contains(field, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// logHook adds the request ID to entries logged with a request's context,
+// eg: s.log.WithContext(r.Context()).Error(...), and redacts sensitive fields.
+type logHook struct{}
+
+func (logHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (logHook) Fire(entry *logrus.Entry) error {
+	if entry.Context != nil {
+		if id := requestID(entry.Context); id != "" {
+			entry.Data["request_id"] = id
+		}
+	}
+
+	for field := range entry.Data {
+		if isRedacted(field) {
+			entry.Data[field] = "[REDACTED]"
+		}
+	}
+
+	return nil
+}
+
+// newLogger returns the JSON logger writing to cfg.LogPath at cfg.LogLevel,
+// and the log file to close on exit. A LogPath of "stdout" or "stderr"
+// logs to that stream instead, and no file is returned.
+func newLogger() (*logrus.Logger, *os.File, error) {
+	level, err := logrus.ParseLevel(cfg.LogLevel)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logger := logrus.New()
+	logger.Formatter = &logrus.JSONFormatter{}
+	logger.Level = level
+	logger.AddHook(logHook{})
+
+	switch cfg.LogPath {
+	case "stdout":
+		logger.Out = os.Stdout
+		return logger, nil, nil
+	case "stderr":
+		logger.Out = os.Stderr
+		return logger, nil, nil
+	}
+
+	f, err := os.OpenFile(cfg.LogPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logger.Out = f
+
+	return logger, f, nil
+}
+
+// logRequests gives every request an ID, taken from a valid X-Request-ID header
+// or generated, and writes an access log line once the request is handled.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+
+		id := r.Header.Get("X-Request-ID")
+
+		if !validRequestID.MatchString(id) {
+			id = uuid.New().String()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		entry := log.WithContext(ctx).WithFields(logrus.Fields{
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"query":       redactQuery(r.URL.Query()),
+			"status":      recorder.status,
+			"bytes":       recorder.bytes,
+			"duration_ms": float64(time.Since(started).Microseconds()) / 1000,
+			"remote_addr": r.RemoteAddr,
+			"user_agent":  r.UserAgent(),
+		})
+
+		switch {
+		case recorder.status >= 500:
+			entry.Error("request")
+		case recorder.status >= 400:
+			entry.Warn("request")
+		default:
+			entry.Info("request")
+		}
+	})
+}
+
+// redactQuery encodes the query string with the values of sensitive parameters hidden.
+func redactQuery(query url.Values) string {
+	for name := range query {
+		if isRedacted(name) {
+			query[name] = []string{"REDACTED"}
+		}
+	}
+
+	return query.Encode()
+}
@@ -1,13 +1,11 @@
 package main
 
 import (
-	"database/sql"
-	"html/template"
-	"io/ioutil"
+	"flag"
+	"fmt"
 	"net/http"
 	"os"
-	"path/filepath"
-	"strings"
+	"strconv"
 
 	"github.com/joho/godotenv"
 	"github.com/julienschmidt/httprouter"
@@ -16,8 +14,9 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-var tpls *template.Template
-var db *sql.DB
+var tpls *templateRegistry
+var assets *staticAssets
+var db *sqlDB
 var admin *adminService
 var auth *authService
 var users *userService
@@ -25,10 +24,21 @@ var projects *projectService
 var features *featureService
 var stories *storyService
 var bugs *bugService
+var sprints *sprintService
+var boards *boardService
+var links *linkService
+var projectLabels *labelService
+var health *healthService
+var metrics *metricsService
+var jobs *jobService
 var log *logrus.Logger
 var mainLayout string
+var cfg config
 var bufpool *bpool.BufferPool
 
+// registeredRoutes lists the routes of the last newRouter as "METHOD /pattern".
+var registeredRoutes []string
+
 const (
 	ADMIN = 1
 	GUEST = 2
@@ -39,179 +49,278 @@ func init() {
 }
 
 func main() {
+	// .env is optional, settings can also come from the environment, flags or a config file
 	err := godotenv.Load()
 
-	if err != nil {
-		log.Fatal("Error loading .env file")
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintln(os.Stderr, "Error loading .env file:", err)
+		os.Exit(1)
 	}
 
-	connection := os.Getenv("POSTGRES_CONN_STRING")
+	var commandArgs []string
 
-	mainLayout = "dashboard_layout"
+	cfg, commandArgs, err = loadConfig(os.Args[1:])
 
-	f, e2 := os.OpenFile("log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err == flag.ErrHelp {
+		return
+	}
 
-	handleFatalError(e2, "Failed to open log file")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	defer f.Close()
+	mainLayout = cfg.MainLayout
 
-	log = logrus.New()
+	var logFile *os.File
 
-	log.Formatter = &logrus.JSONFormatter{}
+	log, logFile, err = newLogger()
 
-	log.Out = f
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to open log file:", err)
+		os.Exit(1)
+	}
 
-	templateFuncs := template.FuncMap{}
+	if logFile != nil {
+		defer logFile.Close()
+	}
 
-	tpls, err := findAndParseTemplates("templates", templateFuncs)
+	err = runCommand(commandArgs)
 
-	handleFatalError(err, "Failed to parse templates.")
+	if err == flag.ErrHelp {
+		return
+	}
 
-	db, e1 := sql.Open("postgres", connection)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 
-	handleFatalError(e1, "Failed to connect to db")
+		if logFile != nil {
+			logFile.Close()
+		}
 
-	defer db.Close()
+		os.Exit(1)
+	}
+}
 
-	router := httprouter.New()
+// connect opens the configured database.
+// SQLite databases are created and kept up to date by the app, so they are migrated here too.
+func connect() (*sqlDB, error) {
+	// single-node installs can use an SQLite file instead of Postgres
+	dbDialect := postgresDialect
+	connection := cfg.PostgresConn
 
-	router.ServeFiles("/resources/*filepath", http.Dir("public/assets"))
+	if cfg.DBDriver == "sqlite" {
+		dbDialect = sqliteDialect
+		connection = "file:" + cfg.SQLitePath + "?_foreign_keys=on"
+	}
 
-	admin = NewAdminService(db, log, tpls)
-	users = NewUserService(db, log, tpls)
-	auth = NewAuthService(db, log, tpls)
-	projects = NewProjectService(db, log, tpls)
-	features = NewFeatureService(db, log, tpls)
-	stories = NewStoryService(db, log, tpls)
-	bugs = NewBugService(db, log, tpls)
+	db, err := openDatabase(dbDialect, connection)
 
-	router.GET("/", auth.demo)
-	router.GET("/demo/:role", admin.demo)
-	router.GET("/admin", auth.guard(admin.index))
-	router.GET("/admin/users", auth.guard(admin.users))
-	router.POST("/admin/setUserRole", auth.guard(admin.setUserRole))
-	router.GET("/admin/roles", auth.guard(admin.roles))
-	router.GET("/admin/roles/new", auth.guard(admin.createRole))
-	router.POST("/roles/:role_id/update", auth.guard(admin.updateRole))
-	router.GET("/roles/:role_id/edit", auth.guard(admin.editRole))
-	router.POST("/roles", auth.guard(admin.storeRole))
-	router.GET("/roles/:role_id", auth.guard(admin.role))
-	router.DELETE("/roles/:role_id", auth.guard(admin.destroyRole))
-	router.POST("/admin/permissions/:role_id", auth.guard(admin.savePermissions))
-
-	router.GET("/users/:user_id", users.show)
-
-	router.GET("/users/:user_id/projects", auth.guard(users.projects))
-	router.GET("/users/:user_id/features", auth.guard(users.features))
-	router.GET("/users/:user_id/stories", auth.guard(users.stories))
-	router.GET("/users/:user_id/bugs", auth.guard(users.bugs))
+	if err != nil {
+		return nil, err
+	}
 
-	router.POST("/users", users.store)
-	router.DELETE("/users/:user_id", users.destroy)
+	// SQLite is limited to a single connection by openDatabase
+	if dbDialect != sqliteDialect {
+		db.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	}
 
-	router.GET("/dashboard", auth.guard(users.dashboard))
+	db.SetMaxIdleConns(cfg.DBMaxIdleConns)
+	db.SetConnMaxLifetime(cfg.DBConnMaxLifetime)
 
-	router.GET("/register", auth.showRegistrationForm)
-	router.POST("/register-user", auth.registerUser)
-	router.GET("/login", auth.showLoginForm)
-	router.POST("/login-user", auth.loginUser)
-	router.GET("/logout", auth.logout)
+	if dbDialect == sqliteDialect {
+		_, err = db.migrate()
 
-	router.GET("/projects/:project_id/edit", auth.guard(projects.edit))
-	router.POST("/projects/:project_id/update", auth.guard(projects.update))
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
 
-	// projects.show will redirect to create form if :project_id == "new"
-	router.GET("/projects/:project_id", auth.guard(projects.show))
+	return db, nil
+}
 
-	router.GET("/projects", auth.guard(projects.index))
-	router.POST("/projects", auth.guard(projects.store))
-	router.DELETE("/projects/:project_id", auth.guard(projects.destroy))
+// startServices parses the templates and sets up the services on top of db.
+func startServices(db *sqlDB) (stores, error) {
+	var err error
 
-	// features are the parent issue type that will have child stories and bugs
-	router.GET("/features", auth.guard(features.all))
-	router.GET("/projects/:project_id/features", auth.guard(features.index))
-	router.POST("/projects/:project_id/features", auth.guard(features.store))
+	// hashes only need working out again when files can change
+	assets = newStaticAssets(assetFiles(), !cfg.Dev)
 
-	router.GET("/projects/:project_id/features/new", auth.guard(features.create))
-	router.GET("/features/:feature_id/edit", auth.guard(features.edit))
-	router.POST("/features/:feature_id/update", auth.guard(features.update))
-	router.GET("/features/:feature_id", auth.guard(features.show))
-	router.DELETE("/features/:feature_id", auth.guard(features.destroy))
+	tpls, err = newTemplateRegistry(templateFiles(), log)
 
-	// Stories
-	router.GET("/stories", auth.guard(stories.all))
-	router.GET("/features/:feature_id/stories", auth.guard(stories.featureStories))
-	router.POST("/features/:feature_id/stories", auth.guard(stories.store))
+	if err != nil {
+		return stores{}, err
+	}
 
-	router.GET("/features/:feature_id/stories/new", auth.guard(stories.create))
-	router.GET("/stories/:story_id/edit", auth.guard(stories.edit))
-	router.GET("/stories/:story_id/restore", auth.guard(stories.restore))
-	router.POST("/stories/:story_id/update", auth.guard(stories.update))
-	router.GET("/stories/:story_id", auth.guard(stories.show))
-	router.DELETE("/stories/:story_id", auth.guard(stories.destroy))
+	store := newSQLStores(db)
+
+	admin = NewAdminService(store, log, tpls)
+	users = NewUserService(store, log, tpls)
+	auth = NewAuthService(store, log, tpls)
+	projects = NewProjectService(store, log, tpls)
+	features = NewFeatureService(store, log, tpls)
+	stories = NewStoryService(store, log, tpls)
+	bugs = NewBugService(store, log, tpls)
+	sprints = NewSprintService(store, log, tpls)
+	boards = NewBoardService(store, log, tpls)
+	links = NewLinkService(store, log, tpls)
+	projectLabels = NewLabelService(store, log, tpls)
+	health = NewHealthService(db, log)
+	metrics = NewMetricsService(store, db, log)
+	jobs = NewJobService(store, log, tpls)
+
+	// make sure every capability checked in code exists in the database
+	err = admin.syncCapabilities()
 
-	// Bugs
-	router.GET("/bugs", auth.guard(bugs.all))
-	router.GET("/features/:feature_id/bugs", auth.guard(bugs.featureBugs))
-	router.POST("/features/:feature_id/bugs", auth.guard(bugs.store))
-
-	router.GET("/features/:feature_id/bugs/new", auth.guard(bugs.create))
-	router.GET("/bugs/:bug_id/edit", auth.guard(bugs.edit))
-	router.POST("/bugs/:bug_id/update", auth.guard(bugs.update))
-	router.GET("/bugs/:bug_id", auth.guard(bugs.show))
-	router.DELETE("/bugs/:bug_id", auth.guard(bugs.destroy))
+	if err != nil {
+		log.Error("Failed to sync capabilities.", err)
+	}
 
-	log.Fatal(http.ListenAndServe(":8080", router))
+	return store, nil
 }
 
-func findAndParseTemplates(rootDir string, funcMap template.FuncMap) (*template.Template, error) {
-	// eg: "templates"
-	cleanRoot := filepath.Clean(rootDir)
-	// template names will begin with dir names after the root directory eg: "templates"
-	templateNameBeginningIndex := len(cleanRoot) + 1
-	root := template.New("")
+// newRouter registers every route on the services set up by startServices
+// and wraps them in the request logging middleware.
+func newRouter() http.Handler {
+	registeredRoutes = []string{}
 
-	err := filepath.Walk(cleanRoot, func(path string, info os.FileInfo, err error) error {
-		if !info.IsDir() && strings.HasSuffix(path, ".gohtml") {
+	router := instrumentedRouter{httprouter.New(), &registeredRoutes}
 
-			handleFatalError(err, "Failed to read template directory.")
+	router.PanicHandler = recoverPanic
+	router.NotFound = notFoundHandler
+	router.MethodNotAllowed = methodNotAllowedHandler
 
-			fileContents, err2 := ioutil.ReadFile(path)
+	router.GET("/resources/*filepath", assets.serve)
+	router.ServeFiles("/uploads/*filepath", http.Dir(cfg.UploadDir))
 
-			handleFatalError(err2, "Failed to read template file.")
+	router.GET("/healthz", health.liveness)
+	router.GET("/readyz", health.readiness)
+	router.GET("/metrics", metrics.serve)
 
-			// use full file path to name the template
-			name := path[templateNameBeginningIndex:]
+	router.GET("/", auth.demo)
+	router.GET("/demo/:role", admin.demo)
+	router.GET("/admin", auth.guard(admin.index, auth.requireAdminOr("admin")))
+	router.GET("/admin/users", auth.guard(admin.users, auth.requireAdminOr("read_users")))
+	router.POST("/admin/setUserRole", auth.guard(admin.setUserRole, auth.requireAdminOr("update_users")))
+	router.GET("/admin/roles", auth.guard(admin.roles, auth.requireAdminOr("read_role")))
+	router.GET("/admin/roles/new", auth.guard(admin.createRole, auth.requireAdminOr("create_role")))
+	router.GET("/admin/roles/diff", auth.guard(admin.diffRoles, auth.requireAdminOr("read_role")))
+	router.POST("/roles/:role_id/update", auth.guard(admin.updateRole, auth.requireAdminOr("update_role")))
+	router.GET("/roles/:role_id/edit", auth.guard(admin.editRole, auth.requireAdminOr("update_role")))
+	router.POST("/roles", auth.guard(admin.storeRole, auth.requireAdminOr("create_role")))
+	router.POST("/roles/:role_id/clone", auth.guard(admin.cloneRole, auth.requireAdminOr("create_role")))
+	router.GET("/roles/:role_id", auth.guard(admin.role, auth.requireAdminOr("read_role")))
+	router.DELETE("/roles/:role_id", auth.guard(admin.destroyRole, auth.requireAdminOr("delete_role")))
+	router.GET("/admin/roles/export", auth.guard(admin.exportRolesYAML, auth.requireAdminOr("read_role")))
+	router.GET("/admin/roles/apply", auth.guard(admin.showApplyRoles, auth.requireAdminOr("update_permissions")))
+	router.POST("/admin/roles/apply", auth.guard(admin.applyRolesYAML, auth.requireAdminOr("update_permissions")))
+	router.POST("/admin/permissions/:role_id", auth.guard(admin.savePermissions, auth.requireAdminOr("update_permissions")))
+
+	// admins can view the app as another user
+	router.POST("/admin/users/:user_id/impersonate", auth.guard(admin.impersonate, auth.require("impersonate_users")))
+	router.GET("/admin/impersonate/stop", auth.guard(admin.stopImpersonating))
+	router.GET("/admin/impersonations", auth.guard(admin.impersonations, auth.requireAdminOr("admin")))
+	router.GET("/admin/jobs", auth.guard(jobs.index, auth.requireAdminOr("manage_jobs")))
+	router.POST("/admin/jobs/:job_id/retry", auth.guard(jobs.retry, auth.requireAdminOr("manage_jobs")))
+	router.POST("/admin/jobs/:job_id/discard", auth.guard(jobs.discard, auth.requireAdminOr("manage_jobs")))
+	router.GET("/admin/labels", auth.guard(projectLabels.admin, auth.requireAdminOr("manage_labels")))
+	router.POST("/labels/:label_id/update", auth.guard(projectLabels.update, auth.requireAdminOr("manage_labels")))
+	router.POST("/labels/:label_id/merge", auth.guard(projectLabels.merge, auth.requireAdminOr("manage_labels")))
+
+	router.GET("/users/:user_id", auth.guard(users.show, auth.require("read_users")))
+
+	router.GET("/users/:user_id/projects", auth.guard(users.projects, auth.require("read_users", "read_projects_mine")))
+	router.GET("/users/:user_id/features", auth.guard(users.features, auth.require("read_features")))
+	router.GET("/users/:user_id/stories", auth.guard(users.stories, auth.require("read_stories_mine")))
+	router.GET("/users/:user_id/bugs", auth.guard(users.bugs, auth.require("read_bugs_mine")))
 
-			// New() adds the template by name
-			t := root.New(name).Funcs(funcMap)
+	router.POST("/users", users.store)
+	router.DELETE("/users/:user_id", auth.guard(users.destroy, auth.require("delete_users")))
 
-			// ensure each file can be parsed
-			_, err2 = t.Parse(string(fileContents))
-			handleFatalError(err2, "Failed to parse template file.")
-		}
+	router.GET("/dashboard", auth.guard(users.dashboard))
 
-		return nil
-	})
+	router.GET("/register", auth.showRegistrationForm)
+	router.POST("/register-user", auth.registerUser)
+	router.GET("/login", auth.showLoginForm)
+	router.POST("/login-user", auth.loginUser)
+	router.GET("/logout", auth.logout)
 
-	return root, err
-}
+	router.GET("/projects/:project_id/edit", auth.guard(projects.edit, auth.requireOwnOrOthers(projects, "update_projects")))
+	router.POST("/projects/:project_id/update", auth.guard(projects.update, auth.requireOwnOrOthers(projects, "update_projects")))
 
-func handleError(err error, msg string) bool {
+	// projects.show will redirect to create form if :project_id == "new"
+	router.GET("/projects/:project_id", auth.guard(projects.show, projects.canShow))
 
-	if err != nil {
-		log.Fatal(msg, err)
+	router.GET("/projects", auth.guard(projects.index, auth.requireAny("read_projects_mine", "read_projects_others")))
+	router.POST("/projects", auth.guard(projects.store, auth.require("create_projects")))
+	router.DELETE("/projects/:project_id", auth.guard(projects.destroy, auth.requireOwnOrOthers(projects, "delete_projects")))
 
-		return true
-	}
+	// features are the parent issue type that will have child stories and bugs
+	router.GET("/features", auth.guard(features.all, auth.require("read_features")))
+	router.GET("/projects/:project_id/features", auth.guard(features.index, auth.require("read_features")))
+	router.POST("/projects/:project_id/features", auth.guard(features.store, auth.require("create_features")))
 
-	return false
-}
+	router.GET("/projects/:project_id/features/new", auth.guard(features.create, auth.require("create_features")))
+	router.GET("/features/:feature_id/edit", auth.guard(features.edit, auth.require("update_features")))
+	router.POST("/features/:feature_id/update", auth.guard(features.update, auth.require("update_features")))
+	router.GET("/features/:feature_id", auth.guard(features.show, auth.require("read_features")))
+	router.DELETE("/features/:feature_id", auth.guard(features.destroy, auth.require("delete_features")))
 
-func handleFatalError(err error, msg string) {
-	if err != nil {
-		log.Fatal(msg, err)
-	}
+	// Stories
+	router.GET("/stories", auth.guard(stories.all, auth.requireAny("read_stories_mine", "read_stories_others")))
+	router.GET("/features/:feature_id/stories", auth.guard(stories.featureStories, auth.requireAny("read_stories_mine", "read_stories_others")))
+	router.POST("/features/:feature_id/stories", auth.guard(stories.store, auth.require("create_stories")))
+
+	router.GET("/features/:feature_id/stories/new", auth.guard(stories.create, auth.require("create_stories")))
+	router.GET("/stories/:story_id/edit", auth.guard(stories.edit, auth.requireOwnOrOthers(stories, "update_stories")))
+	router.GET("/stories/:story_id/restore", auth.guard(stories.restore, auth.requireOwnOrOthers(stories, "delete_stories")))
+	router.POST("/stories/:story_id/update", auth.guard(stories.update, auth.requireOwnOrOthers(stories, "update_stories")))
+	router.POST("/stories/:story_id/rank-before", auth.guard(stories.rankBefore, auth.requireOwnOrOthers(stories, "update_stories")))
+	router.POST("/stories/:story_id/rank-after", auth.guard(stories.rankAfter, auth.requireOwnOrOthers(stories, "update_stories")))
+	router.POST("/stories/:story_id/links", auth.guard(links.store, auth.requireOwnOrOthers(stories, "update_stories")))
+	router.POST("/stories/:story_id/links/:link_id/delete", auth.guard(links.destroy, auth.requireOwnOrOthers(stories, "update_stories")))
+	router.GET("/stories/:story_id", auth.guard(stories.show, auth.requireOwnOrOthers(stories, "read_stories")))
+	router.DELETE("/stories/:story_id", auth.guard(stories.destroy, auth.requireOwnOrOthers(stories, "delete_stories")))
+
+	// Bugs
+	router.GET("/bugs", auth.guard(bugs.all, auth.requireAny("read_bugs_mine", "read_bugs_others")))
+	router.GET("/features/:feature_id/bugs", auth.guard(bugs.featureBugs, auth.requireAny("read_bugs_mine", "read_bugs_others")))
+	router.POST("/features/:feature_id/bugs", auth.guard(bugs.store, auth.require("create_bugs")))
+
+	router.GET("/features/:feature_id/bugs/new", auth.guard(bugs.create, auth.require("create_bugs")))
+	router.GET("/bugs/:bug_id/edit", auth.guard(bugs.edit, auth.requireOwnOrOthers(bugs, "update_bugs")))
+	router.POST("/bugs/:bug_id/update", auth.guard(bugs.update, auth.requireOwnOrOthers(bugs, "update_bugs")))
+	router.POST("/bugs/:bug_id/rank-before", auth.guard(bugs.rankBefore, auth.requireOwnOrOthers(bugs, "update_bugs")))
+	router.POST("/bugs/:bug_id/rank-after", auth.guard(bugs.rankAfter, auth.requireOwnOrOthers(bugs, "update_bugs")))
+	router.POST("/bugs/:bug_id/links", auth.guard(links.store, auth.requireOwnOrOthers(bugs, "update_bugs")))
+	router.POST("/bugs/:bug_id/links/:link_id/delete", auth.guard(links.destroy, auth.requireOwnOrOthers(bugs, "update_bugs")))
+	router.GET("/bugs/:bug_id", auth.guard(bugs.show, auth.requireOwnOrOthers(bugs, "read_bugs")))
+	router.DELETE("/bugs/:bug_id", auth.guard(bugs.destroy, auth.requireOwnOrOthers(bugs, "delete_bugs")))
+
+	// Sprints
+	router.GET("/projects/:project_id/sprints", auth.guard(sprints.index, auth.require("read_features")))
+	router.POST("/projects/:project_id/sprints", auth.guard(sprints.store, auth.requireAdminOr("plan_sprints")))
+	router.GET("/sprints/:sprint_id", auth.guard(sprints.show, auth.require("read_features")))
+	router.POST("/sprints/:sprint_id/update", auth.guard(sprints.update, auth.requireAdminOr("plan_sprints")))
+	router.POST("/sprints/:sprint_id/start", auth.guard(sprints.start, auth.requireAdminOr("plan_sprints")))
+	router.POST("/sprints/:sprint_id/close", auth.guard(sprints.close, auth.requireAdminOr("plan_sprints")))
+	router.POST("/sprints/:sprint_id/add", auth.guard(sprints.add, auth.requireAdminOr("plan_sprints")))
+	router.POST("/sprints/:sprint_id/remove", auth.guard(sprints.remove, auth.requireAdminOr("plan_sprints")))
+
+	// Boards
+	router.GET("/projects/:project_id/board", auth.guard(boards.show, auth.require("read_features")))
+	router.POST("/projects/:project_id/board/move", auth.guard(boards.move, auth.requireAny("update_stories_mine", "update_stories_others", "update_bugs_mine", "update_bugs_others")))
+	router.GET("/projects/:project_id/board/columns", auth.guard(boards.columns, auth.requireOwnOrOthers(boards, "update_projects")))
+	router.POST("/projects/:project_id/board/columns", auth.guard(boards.addColumn, auth.requireOwnOrOthers(boards, "update_projects")))
+	router.POST("/board/columns/:column_id/update", auth.guard(boards.updateColumn, auth.requireOwnOrOthers(boards, "update_projects")))
+	router.POST("/board/columns/:column_id/delete", auth.guard(boards.destroyColumn, auth.requireOwnOrOthers(boards, "update_projects")))
+
+	// Labels
+	router.GET("/projects/:project_id/labels", auth.guard(projectLabels.index, auth.requireOwnOrOthers(projectLabels, "update_projects")))
+	router.POST("/projects/:project_id/labels", auth.guard(projectLabels.store, auth.requireOwnOrOthers(projectLabels, "update_projects")))
+
+	return logRequests(router.Router)
 }
 
 func deletedEntityNotice(message string, w http.ResponseWriter, r *http.Request, log *logrus.Logger) {
@@ -220,12 +329,12 @@ func deletedEntityNotice(message string, w http.ResponseWriter, r *http.Request,
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/admin/deleted_entity.gohtml")
+	view.make("admin/deleted_entity.gohtml")
 	err := view.exec(mainLayout, pageData)
 
 	if err != nil {
-		log.Error("Error: deletedEntityNotice", err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		log.WithContext(r.Context()).Error("Error: deletedEntityNotice", err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -233,3 +342,15 @@ func deletedEntityNotice(message string, w http.ResponseWriter, r *http.Request,
 	view.send(http.StatusOK)
 	return
 }
+
+// parseID reads an id from a route param or form value.
+// Anything that isn't an id becomes 0, which no store will find.
+func parseID(value string) int64 {
+	id, err := strconv.ParseInt(value, 10, 64)
+
+	if err != nil {
+		return 0
+	}
+
+	return id
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+// metricsService collects request, login and render metrics and serves them,
+// with the database pool stats and domain gauges, in the Prometheus text format.
+type metricsService struct {
+	stores stores
+	db     *sqlDB
+	log    *logrus.Logger
+
+	mu       sync.Mutex
+	requests map[requestLabels]int64
+	latency  map[routeLabels]*histogram
+	logins   map[string]int64
+	renders  map[string]*histogram
+}
+
+type requestLabels struct {
+	method string
+	route  string
+	status int
+}
+
+type routeLabels struct {
+	method string
+	route  string
+}
+
+// durationBuckets are the histogram upper bounds, in seconds.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram keeps cumulative bucket counts like Prometheus expects them.
+type histogram struct {
+	buckets []int64
+	count   int64
+	sum     float64
+}
+
+func (h *histogram) observe(seconds float64) {
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+
+	h.count++
+	h.sum += seconds
+}
+
+func NewMetricsService(store stores, db *sqlDB, logger *logrus.Logger) *metricsService {
+	return &metricsService{
+		stores:   store,
+		db:       db,
+		log:      logger,
+		requests: make(map[requestLabels]int64),
+		latency:  make(map[routeLabels]*histogram),
+		logins:   make(map[string]int64),
+		renders:  make(map[string]*histogram),
+	}
+}
+
+// statusRecorder remembers the status a handler responded with and how much it wrote.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+
+	return n, err
+}
+
+// instrument counts and times the requests a route handles, labelled by its pattern.
+func (s *metricsService) instrument(method, route string, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		started := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		next(recorder, r, ps)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		s.observeRequest(method, route, recorder.status, time.Since(started))
+	}
+}
+
+func (s *metricsService) observeRequest(method, route string, status int, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests[requestLabels{method, route, status}]++
+
+	key := routeLabels{method, route}
+
+	if s.latency[key] == nil {
+		s.latency[key] = &histogram{buckets: make([]int64, len(durationBuckets))}
+	}
+
+	s.latency[key].observe(elapsed.Seconds())
+}
+
+// login counts a login attempt by its result: success or failure.
+func (s *metricsService) login(result string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.logins[result]++
+}
+
+// render records how long a page's templates took to execute.
+func (s *metricsService) render(template string, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.renders[template] == nil {
+		s.renders[template] = &histogram{buckets: make([]int64, len(durationBuckets))}
+	}
+
+	s.renders[template].observe(elapsed.Seconds())
+}
+
+// serve writes every metric in the Prometheus text exposition format.
+// serve answers a scraper that sends the configured MetricsToken as a bearer token.
+func (s *metricsService) serve(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if cfg.MetricsToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	want := "Bearer " + cfg.MetricsToken
+
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var b strings.Builder
+
+	s.writeCollected(&b)
+	s.writeDBStats(&b)
+
+	err := s.writeDomain(&b)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error metrics.serve.domain.", err)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	io.WriteString(w, b.String())
+}
+
+func (s *metricsService) writeCollected(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	writeHeader(w, "goissuez_http_requests_total", "counter", "HTTP requests by method, route pattern and status.")
+
+	requestKeys := make([]requestLabels, 0, len(s.requests))
+
+	for key := range s.requests {
+		requestKeys = append(requestKeys, key)
+	}
+
+	sort.Slice(requestKeys, func(i, j int) bool {
+		a, b := requestKeys[i], requestKeys[j]
+
+		if a.route != b.route {
+			return a.route < b.route
+		}
+
+		if a.method != b.method {
+			return a.method < b.method
+		}
+
+		return a.status < b.status
+	})
+
+	for _, key := range requestKeys {
+		writeSample(w, "goissuez_http_requests_total",
+			labels("method", key.method, "route", key.route, "status", strconv.Itoa(key.status)),
+			float64(s.requests[key]))
+	}
+
+	writeHeader(w, "goissuez_http_request_duration_seconds", "histogram", "HTTP request latency by method and route pattern.")
+
+	routeKeys := make([]routeLabels, 0, len(s.latency))
+
+	for key := range s.latency {
+		routeKeys = append(routeKeys, key)
+	}
+
+	sort.Slice(routeKeys, func(i, j int) bool {
+		if routeKeys[i].route != routeKeys[j].route {
+			return routeKeys[i].route < routeKeys[j].route
+		}
+
+		return routeKeys[i].method < routeKeys[j].method
+	})
+
+	for _, key := range routeKeys {
+		writeHistogram(w, "goissuez_http_request_duration_seconds", []string{"method", key.method, "route", key.route}, s.latency[key])
+	}
+
+	writeHeader(w, "goissuez_logins_total", "counter", "Login attempts by result.")
+
+	for _, result := range []string{"success", "failure"} {
+		writeSample(w, "goissuez_logins_total", labels("result", result), float64(s.logins[result]))
+	}
+
+	writeHeader(w, "goissuez_template_render_duration_seconds", "histogram", "Time spent executing page templates, by content template.")
+
+	templates := make([]string, 0, len(s.renders))
+
+	for name := range s.renders {
+		templates = append(templates, name)
+	}
+
+	sort.Strings(templates)
+
+	for _, name := range templates {
+		writeHistogram(w, "goissuez_template_render_duration_seconds", []string{"template", name}, s.renders[name])
+	}
+}
+
+func (s *metricsService) writeDBStats(w io.Writer) {
+	stats := s.db.Stats()
+
+	gauges := []struct {
+		name  string
+		help  string
+		value float64
+	}{
+		{"goissuez_db_max_open_connections", "Most open connections the pool allows, 0 for no limit.", float64(stats.MaxOpenConnections)},
+		{"goissuez_db_open_connections", "Connections open, in use or idle.", float64(stats.OpenConnections)},
+		{"goissuez_db_in_use_connections", "Connections in use.", float64(stats.InUse)},
+		{"goissuez_db_idle_connections", "Idle connections.", float64(stats.Idle)},
+	}
+
+	for _, g := range gauges {
+		writeHeader(w, g.name, "gauge", g.help)
+		writeSample(w, g.name, "", g.value)
+	}
+
+	counters := []struct {
+		name  string
+		help  string
+		value float64
+	}{
+		{"goissuez_db_wait_count_total", "Times a query waited for a free connection.", float64(stats.WaitCount)},
+		{"goissuez_db_wait_duration_seconds_total", "Time spent waiting for a free connection.", stats.WaitDuration.Seconds()},
+		{"goissuez_db_max_idle_closed_total", "Connections closed because the pool had too many idle.", float64(stats.MaxIdleClosed)},
+		{"goissuez_db_max_lifetime_closed_total", "Connections closed for reaching their maximum lifetime.", float64(stats.MaxLifetimeClosed)},
+	}
+
+	for _, c := range counters {
+		writeHeader(w, c.name, "counter", c.help)
+		writeSample(w, c.name, "", c.value)
+	}
+}
+
+func (s *metricsService) writeDomain(w io.Writer) error {
+	counts, err := s.stores.bugs.openByProject()
+
+	if err != nil {
+		return err
+	}
+
+	writeHeader(w, "goissuez_open_bugs", "gauge", "Bugs that aren't done or deleted, by project.")
+
+	for _, c := range counts {
+		writeSample(w, "goissuez_open_bugs",
+			labels("project_id", strconv.FormatInt(c.ProjectID, 10), "project", c.ProjectName),
+			float64(c.Count))
+	}
+
+	jobCounts, err := s.stores.jobs.counts()
+
+	if err != nil {
+		return err
+	}
+
+	writeHeader(w, "goissuez_jobs", "gauge", "Background jobs, by status.")
+
+	for _, status := range []string{"queued", "running", "done", "dead"} {
+		writeSample(w, "goissuez_jobs", labels("status", status), float64(jobCounts[status]))
+	}
+
+	return nil
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeSample(w io.Writer, name, labelSet string, value float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, labelSet, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func writeHistogram(w io.Writer, name string, pairs []string, h *histogram) {
+	for i, bound := range durationBuckets {
+		le := append(append([]string{}, pairs...), "le", strconv.FormatFloat(bound, 'g', -1, 64))
+		writeSample(w, name+"_bucket", labels(le...), float64(h.buckets[i]))
+	}
+
+	writeSample(w, name+"_bucket", labels(append(append([]string{}, pairs...), "le", "+Inf")...), float64(h.count))
+	writeSample(w, name+"_sum", labels(pairs...), h.sum)
+	writeSample(w, name+"_count", labels(pairs...), float64(h.count))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats name, value pairs as a label set, eg: {method="GET",route="/bugs"}
+func labels(pairs ...string) string {
+	parts := []string{}
+
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+`="`+labelEscaper.Replace(pairs[i+1])+`"`)
+	}
+
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// instrumentedRouter wraps every route it registers with metrics.instrument,
+// so requests are counted under the route pattern rather than the raw path.
+// Each route is also listed in routes as "METHOD /pattern".
+type instrumentedRouter struct {
+	*httprouter.Router
+	routes *[]string
+}
+
+func (r instrumentedRouter) Handle(method, path string, handle httprouter.Handle) {
+	*r.routes = append(*r.routes, method+" "+path)
+
+	r.Router.Handle(method, path, metrics.instrument(method, path, handle))
+}
+
+func (r instrumentedRouter) GET(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodGet, path, handle)
+}
+
+func (r instrumentedRouter) POST(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodPost, path, handle)
+}
+
+func (r instrumentedRouter) DELETE(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodDelete, path, handle)
+}
+
+// ServeFiles serves files like httprouter's ServeFiles, through the instrumented GET.
+func (r instrumentedRouter) ServeFiles(path string, root http.FileSystem) {
+	fileServer := http.FileServer(root)
+
+	r.GET(path, func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		req.URL.Path = ps.ByName("filepath")
+		fileServer.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestOpenBugsGauge checks closing a bug takes it off the project's open bugs.
+func TestOpenBugsGauge(t *testing.T) {
+	store := newMemoryStores()
+	fx := seedStore(t, store)
+
+	metrics := NewMetricsService(store, nil, nil)
+
+	openBugs := func() string {
+		t.Helper()
+
+		var b strings.Builder
+
+		err := metrics.writeDomain(&b)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		prefix := `goissuez_open_bugs{project_id="` + formatID(fx.ProjectID) + `"`
+
+		for _, line := range strings.Split(b.String(), "\n") {
+			if strings.HasPrefix(line, prefix) {
+				return line[strings.LastIndex(line, " ")+1:]
+			}
+		}
+
+		t.Fatalf("no open bugs sample for the project in:\n%s", b.String())
+
+		return ""
+	}
+
+	if got := openBugs(); got != "2" {
+		t.Errorf("open bugs: got %s, want 2", got)
+	}
+
+	err := store.bugs.setDone(fx.BugIDs[0], true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := openBugs(); got != "1" {
+		t.Errorf("open bugs after closing one: got %s, want 1", got)
+	}
+
+	err = store.bugs.setDone(fx.BugIDs[0], false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := openBugs(); got != "2" {
+		t.Errorf("open bugs after reopening it: got %s, want 2", got)
+	}
+}
+
+// TestMetricsToken checks /metrics is only served to a scraper with the token.
+func TestMetricsToken(t *testing.T) {
+	a := testApplication(t)
+
+	defer func(token string) { cfg.MetricsToken = token }(cfg.MetricsToken)
+
+	scrape := func(authorization string) int {
+		t.Helper()
+
+		r, err := http.NewRequest("GET", a.server.URL+"/metrics", nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+
+		resp, err := http.DefaultClient.Do(r)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	cfg.MetricsToken = ""
+
+	if status := scrape("Bearer "); status != http.StatusNotFound {
+		t.Errorf("without a token configured: got %d, want %d", status, http.StatusNotFound)
+	}
+
+	cfg.MetricsToken = "s3cret"
+
+	cases := []struct {
+		authorization string
+		want          int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"s3cret", http.StatusUnauthorized},
+		{"Bearer s3cret", http.StatusOK},
+	}
+
+	for _, tc := range cases {
+		if status := scrape(tc.authorization); status != tc.want {
+			t.Errorf("%q: got %d, want %d", tc.authorization, status, tc.want)
+		}
+	}
+}
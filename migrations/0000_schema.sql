@@ -0,0 +1,141 @@
+-- The goissuez schema as it was before the numbered migrations.
+-- Everything here is IF NOT EXISTS, so running it against an existing install changes nothing,
+-- and `go-issuez migrate` can build a fresh database from scratch.
+CREATE TABLE IF NOT EXISTS goissuez.roles (
+    id serial PRIMARY KEY,
+    name varchar(255) NOT NULL,
+    description text NULL
+);
+
+CREATE TABLE IF NOT EXISTS goissuez.capabilities (
+    id serial PRIMARY KEY,
+    name varchar(255) NOT NULL,
+    description text NULL,
+    "group" varchar(255) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS goissuez.permissions (
+    role_id integer NOT NULL REFERENCES goissuez.roles (id) ON DELETE CASCADE,
+    capability_id integer NOT NULL REFERENCES goissuez.capabilities (id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, capability_id)
+);
+
+CREATE TABLE IF NOT EXISTS goissuez.users (
+    id serial PRIMARY KEY,
+    name varchar(255) NOT NULL,
+    email varchar(255) NOT NULL,
+    username varchar(255) NOT NULL UNIQUE,
+    password varchar(255) NOT NULL,
+    photo_url varchar(255) NULL,
+    role_id integer NULL REFERENCES goissuez.roles (id),
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_login timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at timestamp NULL
+);
+
+CREATE TABLE IF NOT EXISTS goissuez.sessions (
+    uuid varchar(255) PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES goissuez.users (id),
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS goissuez.projects (
+    id serial PRIMARY KEY,
+    name varchar(255) NOT NULL,
+    description text NULL,
+    user_id integer NOT NULL REFERENCES goissuez.users (id),
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at timestamp NULL
+);
+
+CREATE TABLE IF NOT EXISTS goissuez.features (
+    id serial PRIMARY KEY,
+    name varchar(255) NOT NULL,
+    description text NULL,
+    project_id integer NOT NULL REFERENCES goissuez.projects (id),
+    user_id integer NOT NULL REFERENCES goissuez.users (id),
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at timestamp NULL
+);
+
+CREATE TABLE IF NOT EXISTS goissuez.stories (
+    id serial PRIMARY KEY,
+    name varchar(255) NOT NULL,
+    description text NULL,
+    feature_id integer NOT NULL REFERENCES goissuez.features (id),
+    user_id integer NOT NULL REFERENCES goissuez.users (id),
+    assignee_id integer NULL REFERENCES goissuez.users (id),
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at timestamp NULL
+);
+
+CREATE TABLE IF NOT EXISTS goissuez.bugs (
+    id serial PRIMARY KEY,
+    name varchar(255) NOT NULL,
+    description text NULL,
+    feature_id integer NOT NULL REFERENCES goissuez.features (id),
+    user_id integer NOT NULL REFERENCES goissuez.users (id),
+    assignee_id integer NULL REFERENCES goissuez.users (id),
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at timestamp NULL
+);
+
+-- the app expects Admin to be role 1 and Guest to be role 2
+INSERT INTO goissuez.roles (id, name, description) VALUES
+    (1, 'Admin', 'Full access to everything.'),
+    (2, 'Guest', 'New registrations. Can see their own work.')
+ON CONFLICT (id) DO NOTHING;
+
+SELECT setval(pg_get_serial_sequence('goissuez.roles', 'id'), (SELECT max(id) FROM goissuez.roles));
+
+-- the rest are synced from the registry at startup,
+-- these are listed so the Admin role can be granted them up front
+INSERT INTO goissuez.capabilities (name, description, "group")
+SELECT c.name, c.description, c.grp
+FROM (VALUES
+    ('create_projects', 'Create new projects.', 'projects'),
+    ('read_projects_mine', 'View projects you created.', 'projects'),
+    ('read_projects_others', 'View projects created by others.', 'projects'),
+    ('update_projects_mine', 'Edit projects you created.', 'projects'),
+    ('update_projects_others', 'Edit projects created by others.', 'projects'),
+    ('delete_projects_mine', 'Delete projects you created.', 'projects'),
+    ('delete_projects_others', 'Delete projects created by others.', 'projects'),
+    ('create_features', 'Create project features.', 'features'),
+    ('read_features', 'View features.', 'features'),
+    ('update_features', 'Edit features.', 'features'),
+    ('delete_features', 'Delete features.', 'features'),
+    ('create_stories', 'Create stories.', 'stories'),
+    ('read_stories_mine', 'View stories you created or are assigned to.', 'stories'),
+    ('read_stories_others', 'View everyone else''s stories.', 'stories'),
+    ('update_stories_mine', 'Edit stories you created or are assigned to.', 'stories'),
+    ('update_stories_others', 'Edit everyone else''s stories.', 'stories'),
+    ('delete_stories_mine', 'Delete stories you created or are assigned to.', 'stories'),
+    ('delete_stories_others', 'Delete everyone else''s stories.', 'stories'),
+    ('create_bugs', 'Log bugs.', 'bugs'),
+    ('read_bugs_mine', 'View bugs you logged or are assigned to.', 'bugs'),
+    ('read_bugs_others', 'View everyone else''s bugs.', 'bugs'),
+    ('update_bugs_mine', 'Edit bugs you logged or are assigned to.', 'bugs'),
+    ('update_bugs_others', 'Edit everyone else''s bugs.', 'bugs'),
+    ('delete_bugs_mine', 'Delete bugs you logged or are assigned to.', 'bugs'),
+    ('delete_bugs_others', 'Delete everyone else''s bugs.', 'bugs'),
+    ('admin', 'Access the admin panel.', 'admin'),
+    ('read_users', 'View users.', 'admin'),
+    ('update_users', 'Change a user''s role.', 'admin'),
+    ('delete_users', 'Delete users.', 'admin'),
+    ('create_role', 'Create roles.', 'roles'),
+    ('read_role', 'View roles.', 'roles'),
+    ('update_role', 'Rename roles.', 'roles'),
+    ('delete_role', 'Delete roles.', 'roles'),
+    ('update_permissions', 'Assign capabilities to roles.', 'permissions')
+) AS c (name, description, grp)
+WHERE NOT EXISTS (SELECT 1 FROM goissuez.capabilities e WHERE e.name = c.name);
+
+-- only a brand new Admin role is granted everything
+INSERT INTO goissuez.permissions (role_id, capability_id)
+SELECT 1, c.id FROM goissuez.capabilities c
+WHERE NOT EXISTS (SELECT 1 FROM goissuez.permissions p WHERE p.role_id = 1);
@@ -0,0 +1,24 @@
+-- Admin impersonation ("view as user").
+-- Each row records one impersonation: who started it, whom they viewed as,
+-- and when it started and ended.
+CREATE TABLE IF NOT EXISTS goissuez.impersonations (
+    id serial PRIMARY KEY,
+    admin_id integer NOT NULL REFERENCES goissuez.users (id),
+    user_id integer NOT NULL REFERENCES goissuez.users (id),
+    started_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ended_at timestamp NULL
+);
+
+-- a session that is currently impersonating another user points at the open impersonation
+ALTER TABLE goissuez.sessions
+    ADD COLUMN IF NOT EXISTS impersonation_id integer NULL REFERENCES goissuez.impersonations (id);
+
+INSERT INTO goissuez.capabilities (name, description, "group")
+SELECT 'impersonate_users', 'View the app as another user.', 'admin'
+WHERE NOT EXISTS (SELECT 1 FROM goissuez.capabilities WHERE name = 'impersonate_users');
+
+-- grant the new capability to the Admin role
+INSERT INTO goissuez.permissions (role_id, capability_id)
+SELECT 1, c.id FROM goissuez.capabilities c
+WHERE c.name = 'impersonate_users'
+AND NOT EXISTS (SELECT 1 FROM goissuez.permissions p WHERE p.role_id = 1 AND p.capability_id = c.id);
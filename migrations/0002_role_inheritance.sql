@@ -0,0 +1,12 @@
+-- Role inheritance.
+-- A role may inherit every capability of a parent role, e.g. "Senior Dev" inherits "Developer".
+-- Deleting a parent role leaves its children in place without a parent.
+ALTER TABLE goissuez.roles
+    ADD COLUMN IF NOT EXISTS parent_id integer NULL REFERENCES goissuez.roles (id) ON DELETE SET NULL;
+
+-- a role can never be its own parent; longer cycles are refused by the app
+ALTER TABLE goissuez.roles
+    DROP CONSTRAINT IF EXISTS roles_parent_not_self;
+
+ALTER TABLE goissuez.roles
+    ADD CONSTRAINT roles_parent_not_self CHECK (parent_id IS NULL OR parent_id <> id);
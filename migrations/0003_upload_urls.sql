@@ -0,0 +1,6 @@
+-- User photos are now served from the configurable upload directory at /uploads
+-- instead of from the assets at /resources/img/users.
+-- The default upload directory is public/assets/img/users, so existing files stay where they are.
+UPDATE goissuez.users
+SET photo_url = 'uploads/' || substring(photo_url from 11)
+WHERE photo_url LIKE 'img/users/%';
@@ -0,0 +1,22 @@
+-- Background jobs.
+-- Workers claim queued jobs that are due with SELECT ... FOR UPDATE SKIP LOCKED,
+-- so any number of them can share the table. A failed job is queued again for later
+-- until it runs out of attempts, and is then left "dead" for an admin to retry or discard.
+-- unique_key stops a scheduled job being queued twice when several servers run the scheduler.
+CREATE TABLE IF NOT EXISTS goissuez.jobs (
+    id serial PRIMARY KEY,
+    kind varchar(255) NOT NULL,
+    payload text NOT NULL DEFAULT '{}',
+    status varchar(20) NOT NULL DEFAULT 'queued',
+    attempts integer NOT NULL DEFAULT 0,
+    max_attempts integer NOT NULL DEFAULT 5,
+    run_at timestamp NOT NULL,
+    locked_at timestamp NULL,
+    last_error text NULL,
+    unique_key varchar(255) NULL UNIQUE,
+    created_at timestamp NOT NULL,
+    finished_at timestamp NULL,
+    CONSTRAINT jobs_status CHECK (status IN ('queued', 'running', 'done', 'dead'))
+);
+
+CREATE INDEX IF NOT EXISTS jobs_due ON goissuez.jobs (run_at, id) WHERE status = 'queued';
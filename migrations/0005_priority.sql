@@ -0,0 +1,10 @@
+-- Triage fields.
+-- Stories and bugs have a priority from 0 (P0, drop everything) to 4 (P4),
+-- and bugs a severity. Existing issues start at P2 and major.
+ALTER TABLE goissuez.stories
+    ADD COLUMN IF NOT EXISTS priority smallint NOT NULL DEFAULT 2 CHECK (priority BETWEEN 0 AND 4);
+
+ALTER TABLE goissuez.bugs
+    ADD COLUMN IF NOT EXISTS priority smallint NOT NULL DEFAULT 2 CHECK (priority BETWEEN 0 AND 4),
+    ADD COLUMN IF NOT EXISTS severity varchar(20) NOT NULL DEFAULT 'major'
+        CHECK (severity IN ('blocker', 'critical', 'major', 'minor', 'trivial'));
@@ -0,0 +1,12 @@
+-- Estimates.
+-- Each project sizes its stories in points on a scale of its own, and may also
+-- track time estimates. Points are stored as a number whatever the scale, so they add up;
+-- T-shirt sizes are 1, 2, 3, 5, 8 and 13 points. A story without an estimate has NULL.
+ALTER TABLE goissuez.projects
+    ADD COLUMN IF NOT EXISTS point_scale varchar(20) NOT NULL DEFAULT 'fibonacci'
+        CHECK (point_scale IN ('fibonacci', 'tshirt', 'linear', 'none')),
+    ADD COLUMN IF NOT EXISTS track_time boolean NOT NULL DEFAULT true;
+
+ALTER TABLE goissuez.stories
+    ADD COLUMN IF NOT EXISTS points integer NULL CHECK (points > 0),
+    ADD COLUMN IF NOT EXISTS estimate_minutes integer NULL CHECK (estimate_minutes > 0);
@@ -0,0 +1,33 @@
+-- Sprints.
+-- A project plans its work in sprints: planned, then active, then closed.
+-- Only one sprint per project can be active at a time.
+-- Stories and bugs not in a sprint are in the project's backlog. completed_at marks
+-- them done; when a sprint closes, the unfinished ones go back to the backlog or on
+-- to the next sprint and the finished ones stay with the sprint they were done in.
+CREATE TABLE IF NOT EXISTS goissuez.sprints (
+    id serial PRIMARY KEY,
+    project_id integer NOT NULL REFERENCES goissuez.projects (id),
+    name varchar(255) NOT NULL,
+    goal text NULL,
+    starts_on date NOT NULL,
+    ends_on date NOT NULL,
+    state varchar(20) NOT NULL DEFAULT 'planned',
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL,
+    closed_at timestamp NULL,
+    CONSTRAINT sprints_state CHECK (state IN ('planned', 'active', 'closed')),
+    CONSTRAINT sprints_dates CHECK (ends_on >= starts_on)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS sprints_one_active ON goissuez.sprints (project_id) WHERE state = 'active';
+
+ALTER TABLE goissuez.stories
+    ADD COLUMN IF NOT EXISTS sprint_id integer NULL REFERENCES goissuez.sprints (id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS completed_at timestamp NULL;
+
+ALTER TABLE goissuez.bugs
+    ADD COLUMN IF NOT EXISTS sprint_id integer NULL REFERENCES goissuez.sprints (id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS completed_at timestamp NULL;
+
+CREATE INDEX IF NOT EXISTS stories_sprint ON goissuez.stories (sprint_id);
+CREATE INDEX IF NOT EXISTS bugs_sprint ON goissuez.bugs (sprint_id);
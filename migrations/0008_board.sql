@@ -0,0 +1,30 @@
+-- Kanban boards.
+-- Each project has its own board columns, left to right by position. Issues sit in a
+-- column; those without one, eg: created before the board existed, are shown in the first
+-- column, or the first done column once they're done. Moving an issue into a done column
+-- marks it done. A column's WIP limit is only a warning; NULL means no limit.
+CREATE TABLE IF NOT EXISTS goissuez.board_columns (
+    id serial PRIMARY KEY,
+    project_id integer NOT NULL REFERENCES goissuez.projects (id),
+    name varchar(100) NOT NULL,
+    position integer NOT NULL DEFAULT 0,
+    wip_limit integer NULL CHECK (wip_limit > 0),
+    done boolean NOT NULL DEFAULT false,
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS board_columns_project ON goissuez.board_columns (project_id, position);
+
+ALTER TABLE goissuez.stories
+    ADD COLUMN IF NOT EXISTS column_id integer NULL REFERENCES goissuez.board_columns (id) ON DELETE SET NULL;
+
+ALTER TABLE goissuez.bugs
+    ADD COLUMN IF NOT EXISTS column_id integer NULL REFERENCES goissuez.board_columns (id) ON DELETE SET NULL;
+
+-- every project starts with To Do, Doing and Done
+INSERT INTO goissuez.board_columns (project_id, name, position, done, created_at, updated_at)
+SELECT p.id, c.name, c.position, c.done, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+FROM goissuez.projects p
+CROSS JOIN (VALUES ('To Do', 1, false), ('Doing', 2, false), ('Done', 3, true)) AS c (name, position, done)
+WHERE NOT EXISTS (SELECT 1 FROM goissuez.board_columns b WHERE b.project_id = p.id);
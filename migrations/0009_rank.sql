@@ -0,0 +1,22 @@
+-- Story and bug ranks.
+-- Issues are listed in order of rank, a string compared byte by byte, so one can be moved
+-- between two others by giving it a rank between theirs without renumbering the rest.
+-- Existing issues are ranked in the order they were created. The "C" collation compares
+-- ranks byte by byte whatever the database's locale.
+ALTER TABLE goissuez.stories ADD COLUMN IF NOT EXISTS rank varchar(255) COLLATE "C" NOT NULL DEFAULT '';
+
+ALTER TABLE goissuez.bugs ADD COLUMN IF NOT EXISTS rank varchar(255) COLLATE "C" NOT NULL DEFAULT '';
+
+UPDATE goissuez.stories s
+SET rank = lpad(r.n::text, 6, '0')
+FROM (SELECT id, row_number() OVER (ORDER BY created_at, id) AS n FROM goissuez.stories) r
+WHERE r.id = s.id;
+
+UPDATE goissuez.bugs b
+SET rank = lpad(r.n::text, 6, '0')
+FROM (SELECT id, row_number() OVER (ORDER BY created_at, id) AS n FROM goissuez.bugs) r
+WHERE r.id = b.id;
+
+CREATE INDEX IF NOT EXISTS stories_rank ON goissuez.stories (rank);
+
+CREATE INDEX IF NOT EXISTS bugs_rank ON goissuez.bugs (rank);
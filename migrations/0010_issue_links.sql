@@ -0,0 +1,28 @@
+-- Issue links.
+-- A link goes from one story or bug to another, in any feature or project, and has a type
+-- read from the first to the second, eg: "blocks", or back, eg: "is blocked by". Each end is
+-- either a story or a bug, so it has a column for each and exactly one is set.
+CREATE TABLE IF NOT EXISTS goissuez.issue_links (
+    id serial PRIMARY KEY,
+    link_type varchar(30) NOT NULL,
+    from_story_id integer NULL REFERENCES goissuez.stories (id) ON DELETE CASCADE,
+    from_bug_id integer NULL REFERENCES goissuez.bugs (id) ON DELETE CASCADE,
+    to_story_id integer NULL REFERENCES goissuez.stories (id) ON DELETE CASCADE,
+    to_bug_id integer NULL REFERENCES goissuez.bugs (id) ON DELETE CASCADE,
+    user_id integer NOT NULL REFERENCES goissuez.users (id),
+    created_at timestamp NOT NULL,
+    CHECK ((from_story_id IS NULL) <> (from_bug_id IS NULL)),
+    CHECK ((to_story_id IS NULL) <> (to_bug_id IS NULL))
+);
+
+-- the same two issues can only be linked once with each type
+CREATE UNIQUE INDEX IF NOT EXISTS issue_links_unique ON goissuez.issue_links
+    (link_type, COALESCE(from_story_id, 0), COALESCE(from_bug_id, 0), COALESCE(to_story_id, 0), COALESCE(to_bug_id, 0));
+
+CREATE INDEX IF NOT EXISTS issue_links_to_story ON goissuez.issue_links (to_story_id);
+
+CREATE INDEX IF NOT EXISTS issue_links_to_bug ON goissuez.issue_links (to_bug_id);
+
+CREATE INDEX IF NOT EXISTS issue_links_from_story ON goissuez.issue_links (from_story_id);
+
+CREATE INDEX IF NOT EXISTS issue_links_from_bug ON goissuez.issue_links (from_bug_id);
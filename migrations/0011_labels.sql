@@ -0,0 +1,30 @@
+-- Labels.
+-- Each project has its own labels, eg: "frontend" or "regression", with a colour to show them in.
+-- Names are unique in a project whatever their case. Stories and bugs can have any number of
+-- their project's labels.
+CREATE TABLE IF NOT EXISTS goissuez.labels (
+    id serial PRIMARY KEY,
+    project_id integer NOT NULL REFERENCES goissuez.projects (id),
+    name varchar(50) NOT NULL,
+    color varchar(7) NOT NULL DEFAULT '#6c757d',
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS labels_project_name ON goissuez.labels (project_id, lower(name));
+
+CREATE TABLE IF NOT EXISTS goissuez.story_labels (
+    story_id integer NOT NULL REFERENCES goissuez.stories (id) ON DELETE CASCADE,
+    label_id integer NOT NULL REFERENCES goissuez.labels (id) ON DELETE CASCADE,
+    PRIMARY KEY (story_id, label_id)
+);
+
+CREATE INDEX IF NOT EXISTS story_labels_label ON goissuez.story_labels (label_id);
+
+CREATE TABLE IF NOT EXISTS goissuez.bug_labels (
+    bug_id integer NOT NULL REFERENCES goissuez.bugs (id) ON DELETE CASCADE,
+    label_id integer NOT NULL REFERENCES goissuez.labels (id) ON DELETE CASCADE,
+    PRIMARY KEY (bug_id, label_id)
+);
+
+CREATE INDEX IF NOT EXISTS bug_labels_label ON goissuez.bug_labels (label_id);
@@ -0,0 +1,29 @@
+-- Rank stories and bugs again, keeping their order.
+-- 0009 numbered them in decimal, so one rank in ten ends in "0", and lpad cut short the numbers
+-- past 999999. This counts up whole ranks as nextRank does: six base 36 digits, skipping those
+-- ending in "0", which is room for 35 * 36^5 issues. q is the first five digits and d the last.
+UPDATE goissuez.stories s
+SET rank = substr(r.digits, (r.q / 1679616 % 36)::int + 1, 1)
+        || substr(r.digits, (r.q / 46656 % 36)::int + 1, 1)
+        || substr(r.digits, (r.q / 1296 % 36)::int + 1, 1)
+        || substr(r.digits, (r.q / 36 % 36)::int + 1, 1)
+        || substr(r.digits, (r.q % 36)::int + 1, 1)
+        || substr(r.digits, r.d::int + 1, 1)
+FROM (
+    SELECT id, n / 35 AS q, n % 35 + 1 AS d, '0123456789abcdefghijklmnopqrstuvwxyz' AS digits
+    FROM (SELECT id, row_number() OVER (ORDER BY rank, id) - 1 AS n FROM goissuez.stories) o
+) r
+WHERE r.id = s.id;
+
+UPDATE goissuez.bugs b
+SET rank = substr(r.digits, (r.q / 1679616 % 36)::int + 1, 1)
+        || substr(r.digits, (r.q / 46656 % 36)::int + 1, 1)
+        || substr(r.digits, (r.q / 1296 % 36)::int + 1, 1)
+        || substr(r.digits, (r.q / 36 % 36)::int + 1, 1)
+        || substr(r.digits, (r.q % 36)::int + 1, 1)
+        || substr(r.digits, r.d::int + 1, 1)
+FROM (
+    SELECT id, n / 35 AS q, n % 35 + 1 AS d, '0123456789abcdefghijklmnopqrstuvwxyz' AS digits
+    FROM (SELECT id, row_number() OVER (ORDER BY rank, id) - 1 AS n FROM goissuez.bugs) o
+) r
+WHERE r.id = b.id;
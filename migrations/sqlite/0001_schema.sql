@@ -0,0 +1,149 @@
+-- The whole goissuez schema for SQLite.
+-- Postgres installs get theirs from the goissuez schema plus the migrations one level up;
+-- SQLite starts from this file and takes its own migrations from here on.
+-- Timestamps default to UTC with milliseconds, the same format the app writes.
+CREATE TABLE IF NOT EXISTS roles (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    name varchar(255) NOT NULL,
+    description text NULL,
+    parent_id integer NULL REFERENCES roles (id) ON DELETE SET NULL,
+    CONSTRAINT roles_parent_not_self CHECK (parent_id IS NULL OR parent_id <> id)
+);
+
+CREATE TABLE IF NOT EXISTS capabilities (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    name varchar(255) NOT NULL UNIQUE,
+    description text NULL,
+    "group" varchar(255) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    role_id integer NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    capability_id integer NOT NULL REFERENCES capabilities (id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, capability_id)
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    name varchar(255) NOT NULL,
+    email varchar(255) NOT NULL,
+    username varchar(255) NOT NULL UNIQUE,
+    password varchar(255) NOT NULL,
+    photo_url varchar(255) NULL,
+    role_id integer NULL REFERENCES roles (id),
+    created_at timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    updated_at timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    last_login timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    deleted_at timestamp NULL
+);
+
+CREATE TABLE IF NOT EXISTS impersonations (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    admin_id integer NOT NULL REFERENCES users (id),
+    user_id integer NOT NULL REFERENCES users (id),
+    started_at timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    ended_at timestamp NULL
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    uuid varchar(255) PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES users (id),
+    impersonation_id integer NULL REFERENCES impersonations (id),
+    created_at timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS projects (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    name varchar(255) NOT NULL,
+    description text NULL,
+    user_id integer NOT NULL REFERENCES users (id),
+    created_at timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    updated_at timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    deleted_at timestamp NULL
+);
+
+CREATE TABLE IF NOT EXISTS features (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    name varchar(255) NOT NULL,
+    description text NULL,
+    project_id integer NOT NULL REFERENCES projects (id),
+    user_id integer NOT NULL REFERENCES users (id),
+    created_at timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    updated_at timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    deleted_at timestamp NULL
+);
+
+CREATE TABLE IF NOT EXISTS stories (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    name varchar(255) NOT NULL,
+    description text NULL,
+    feature_id integer NOT NULL REFERENCES features (id),
+    user_id integer NOT NULL REFERENCES users (id),
+    assignee_id integer NULL REFERENCES users (id),
+    created_at timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    updated_at timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    deleted_at timestamp NULL
+);
+
+CREATE TABLE IF NOT EXISTS bugs (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    name varchar(255) NOT NULL,
+    description text NULL,
+    feature_id integer NOT NULL REFERENCES features (id),
+    user_id integer NOT NULL REFERENCES users (id),
+    assignee_id integer NULL REFERENCES users (id),
+    created_at timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    updated_at timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    deleted_at timestamp NULL
+);
+
+-- the app expects Admin to be role 1 and Guest to be role 2
+INSERT INTO roles (id, name, description) VALUES
+    (1, 'Admin', 'Full access to everything.'),
+    (2, 'Guest', 'New registrations. Can see their own work.');
+
+-- capabilities are kept in sync with the registry at startup,
+-- they're listed here so the Admin role can be granted all of them up front
+INSERT INTO capabilities (name, description, "group") VALUES
+    ('create_projects', 'Create new projects.', 'projects'),
+    ('read_projects_mine', 'View projects you created.', 'projects'),
+    ('read_projects_others', 'View projects created by others.', 'projects'),
+    ('update_projects_mine', 'Edit projects you created.', 'projects'),
+    ('update_projects_others', 'Edit projects created by others.', 'projects'),
+    ('delete_projects_mine', 'Delete projects you created.', 'projects'),
+    ('delete_projects_others', 'Delete projects created by others.', 'projects'),
+    ('create_features', 'Create project features.', 'features'),
+    ('read_features', 'View features.', 'features'),
+    ('update_features', 'Edit features.', 'features'),
+    ('delete_features', 'Delete features.', 'features'),
+    ('create_stories', 'Create stories.', 'stories'),
+    ('read_stories_mine', 'View stories you created or are assigned to.', 'stories'),
+    ('read_stories_others', 'View everyone else''s stories.', 'stories'),
+    ('update_stories_mine', 'Edit stories you created or are assigned to.', 'stories'),
+    ('update_stories_others', 'Edit everyone else''s stories.', 'stories'),
+    ('delete_stories_mine', 'Delete stories you created or are assigned to.', 'stories'),
+    ('delete_stories_others', 'Delete everyone else''s stories.', 'stories'),
+    ('create_bugs', 'Log bugs.', 'bugs'),
+    ('read_bugs_mine', 'View bugs you logged or are assigned to.', 'bugs'),
+    ('read_bugs_others', 'View everyone else''s bugs.', 'bugs'),
+    ('update_bugs_mine', 'Edit bugs you logged or are assigned to.', 'bugs'),
+    ('update_bugs_others', 'Edit everyone else''s bugs.', 'bugs'),
+    ('delete_bugs_mine', 'Delete bugs you logged or are assigned to.', 'bugs'),
+    ('delete_bugs_others', 'Delete everyone else''s bugs.', 'bugs'),
+    ('admin', 'Access the admin panel.', 'admin'),
+    ('read_users', 'View users.', 'admin'),
+    ('update_users', 'Change a user''s role.', 'admin'),
+    ('delete_users', 'Delete users.', 'admin'),
+    ('impersonate_users', 'View the app as another user.', 'admin'),
+    ('create_role', 'Create roles.', 'roles'),
+    ('read_role', 'View roles.', 'roles'),
+    ('update_role', 'Rename roles.', 'roles'),
+    ('delete_role', 'Delete roles.', 'roles'),
+    ('update_permissions', 'Assign capabilities to roles.', 'permissions');
+
+INSERT INTO permissions (role_id, capability_id)
+SELECT 1, id FROM capabilities;
+
+INSERT INTO permissions (role_id, capability_id)
+SELECT 2, id FROM capabilities
+WHERE name IN ('read_projects_mine', 'read_stories_mine', 'read_bugs_mine');
@@ -0,0 +1,19 @@
+-- Background jobs, as in the Postgres migration.
+-- SQLite has a single writer, so workers take turns claiming jobs without row locks.
+CREATE TABLE IF NOT EXISTS jobs (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    kind varchar(255) NOT NULL,
+    payload text NOT NULL DEFAULT '{}',
+    status varchar(20) NOT NULL DEFAULT 'queued',
+    attempts integer NOT NULL DEFAULT 0,
+    max_attempts integer NOT NULL DEFAULT 5,
+    run_at timestamp NOT NULL,
+    locked_at timestamp NULL,
+    last_error text NULL,
+    unique_key varchar(255) NULL UNIQUE,
+    created_at timestamp NOT NULL,
+    finished_at timestamp NULL,
+    CONSTRAINT jobs_status CHECK (status IN ('queued', 'running', 'done', 'dead'))
+);
+
+CREATE INDEX IF NOT EXISTS jobs_due ON jobs (run_at, id) WHERE status = 'queued';
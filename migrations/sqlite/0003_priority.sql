@@ -0,0 +1,7 @@
+-- Triage fields, as in the Postgres migration.
+ALTER TABLE stories ADD COLUMN priority integer NOT NULL DEFAULT 2 CHECK (priority BETWEEN 0 AND 4);
+
+ALTER TABLE bugs ADD COLUMN priority integer NOT NULL DEFAULT 2 CHECK (priority BETWEEN 0 AND 4);
+
+ALTER TABLE bugs ADD COLUMN severity varchar(20) NOT NULL DEFAULT 'major'
+    CHECK (severity IN ('blocker', 'critical', 'major', 'minor', 'trivial'));
@@ -0,0 +1,9 @@
+-- Estimates, as in the Postgres migration.
+ALTER TABLE projects ADD COLUMN point_scale varchar(20) NOT NULL DEFAULT 'fibonacci'
+    CHECK (point_scale IN ('fibonacci', 'tshirt', 'linear', 'none'));
+
+ALTER TABLE projects ADD COLUMN track_time boolean NOT NULL DEFAULT 1;
+
+ALTER TABLE stories ADD COLUMN points integer NULL CHECK (points > 0);
+
+ALTER TABLE stories ADD COLUMN estimate_minutes integer NULL CHECK (estimate_minutes > 0);
@@ -0,0 +1,28 @@
+-- Sprints, as in the Postgres migration.
+CREATE TABLE IF NOT EXISTS sprints (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    project_id integer NOT NULL REFERENCES projects (id),
+    name varchar(255) NOT NULL,
+    goal text NULL,
+    starts_on date NOT NULL,
+    ends_on date NOT NULL,
+    state varchar(20) NOT NULL DEFAULT 'planned',
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL,
+    closed_at timestamp NULL,
+    CONSTRAINT sprints_state CHECK (state IN ('planned', 'active', 'closed')),
+    CONSTRAINT sprints_dates CHECK (ends_on >= starts_on)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS sprints_one_active ON sprints (project_id) WHERE state = 'active';
+
+ALTER TABLE stories ADD COLUMN sprint_id integer NULL REFERENCES sprints (id) ON DELETE SET NULL;
+
+ALTER TABLE stories ADD COLUMN completed_at timestamp NULL;
+
+ALTER TABLE bugs ADD COLUMN sprint_id integer NULL REFERENCES sprints (id) ON DELETE SET NULL;
+
+ALTER TABLE bugs ADD COLUMN completed_at timestamp NULL;
+
+CREATE INDEX IF NOT EXISTS stories_sprint ON stories (sprint_id);
+CREATE INDEX IF NOT EXISTS bugs_sprint ON bugs (sprint_id);
@@ -0,0 +1,25 @@
+-- Kanban boards, as in the Postgres migration.
+CREATE TABLE IF NOT EXISTS board_columns (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    project_id integer NOT NULL REFERENCES projects (id),
+    name varchar(100) NOT NULL,
+    position integer NOT NULL DEFAULT 0,
+    wip_limit integer NULL CHECK (wip_limit > 0),
+    done boolean NOT NULL DEFAULT 0,
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS board_columns_project ON board_columns (project_id, position);
+
+ALTER TABLE stories ADD COLUMN column_id integer NULL REFERENCES board_columns (id) ON DELETE SET NULL;
+
+ALTER TABLE bugs ADD COLUMN column_id integer NULL REFERENCES board_columns (id) ON DELETE SET NULL;
+
+INSERT INTO board_columns (project_id, name, position, done, created_at, updated_at)
+SELECT p.id, c.name, c.position, c.done, strftime('%Y-%m-%d %H:%M:%f', 'now'), strftime('%Y-%m-%d %H:%M:%f', 'now')
+FROM projects p
+CROSS JOIN (SELECT 'To Do' AS name, 1 AS position, 0 AS done
+            UNION ALL SELECT 'Doing', 2, 0
+            UNION ALL SELECT 'Done', 3, 1) c
+WHERE NOT EXISTS (SELECT 1 FROM board_columns b WHERE b.project_id = p.id);
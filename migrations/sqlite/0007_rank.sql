@@ -0,0 +1,18 @@
+-- Story and bug ranks, as in the Postgres migration.
+ALTER TABLE stories ADD COLUMN rank varchar(255) NOT NULL DEFAULT '';
+
+ALTER TABLE bugs ADD COLUMN rank varchar(255) NOT NULL DEFAULT '';
+
+UPDATE stories
+SET rank = substr('000000' || (SELECT count(*) FROM stories o
+                                WHERE o.created_at < stories.created_at
+                                OR (o.created_at = stories.created_at AND o.id <= stories.id)), -6, 6);
+
+UPDATE bugs
+SET rank = substr('000000' || (SELECT count(*) FROM bugs o
+                                WHERE o.created_at < bugs.created_at
+                                OR (o.created_at = bugs.created_at AND o.id <= bugs.id)), -6, 6);
+
+CREATE INDEX IF NOT EXISTS stories_rank ON stories (rank);
+
+CREATE INDEX IF NOT EXISTS bugs_rank ON bugs (rank);
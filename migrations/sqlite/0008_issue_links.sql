@@ -0,0 +1,24 @@
+-- Issue links, as in the Postgres migration.
+CREATE TABLE IF NOT EXISTS issue_links (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    link_type varchar(30) NOT NULL,
+    from_story_id integer NULL REFERENCES stories (id) ON DELETE CASCADE,
+    from_bug_id integer NULL REFERENCES bugs (id) ON DELETE CASCADE,
+    to_story_id integer NULL REFERENCES stories (id) ON DELETE CASCADE,
+    to_bug_id integer NULL REFERENCES bugs (id) ON DELETE CASCADE,
+    user_id integer NOT NULL REFERENCES users (id),
+    created_at timestamp NOT NULL,
+    CHECK ((from_story_id IS NULL) <> (from_bug_id IS NULL)),
+    CHECK ((to_story_id IS NULL) <> (to_bug_id IS NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS issue_links_unique ON issue_links
+    (link_type, COALESCE(from_story_id, 0), COALESCE(from_bug_id, 0), COALESCE(to_story_id, 0), COALESCE(to_bug_id, 0));
+
+CREATE INDEX IF NOT EXISTS issue_links_to_story ON issue_links (to_story_id);
+
+CREATE INDEX IF NOT EXISTS issue_links_to_bug ON issue_links (to_bug_id);
+
+CREATE INDEX IF NOT EXISTS issue_links_from_story ON issue_links (from_story_id);
+
+CREATE INDEX IF NOT EXISTS issue_links_from_bug ON issue_links (from_bug_id);
@@ -0,0 +1,27 @@
+-- Labels, as in the Postgres migration.
+CREATE TABLE IF NOT EXISTS labels (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    project_id integer NOT NULL REFERENCES projects (id),
+    name varchar(50) NOT NULL,
+    color varchar(7) NOT NULL DEFAULT '#6c757d',
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS labels_project_name ON labels (project_id, lower(name));
+
+CREATE TABLE IF NOT EXISTS story_labels (
+    story_id integer NOT NULL REFERENCES stories (id) ON DELETE CASCADE,
+    label_id integer NOT NULL REFERENCES labels (id) ON DELETE CASCADE,
+    PRIMARY KEY (story_id, label_id)
+);
+
+CREATE INDEX IF NOT EXISTS story_labels_label ON story_labels (label_id);
+
+CREATE TABLE IF NOT EXISTS bug_labels (
+    bug_id integer NOT NULL REFERENCES bugs (id) ON DELETE CASCADE,
+    label_id integer NOT NULL REFERENCES labels (id) ON DELETE CASCADE,
+    PRIMARY KEY (bug_id, label_id)
+);
+
+CREATE INDEX IF NOT EXISTS bug_labels_label ON bug_labels (label_id);
@@ -0,0 +1,26 @@
+-- Rank stories and bugs again, keeping their order, as in the Postgres migration.
+UPDATE stories
+SET rank = substr(r.digits, r.q / 1679616 % 36 + 1, 1)
+        || substr(r.digits, r.q / 46656 % 36 + 1, 1)
+        || substr(r.digits, r.q / 1296 % 36 + 1, 1)
+        || substr(r.digits, r.q / 36 % 36 + 1, 1)
+        || substr(r.digits, r.q % 36 + 1, 1)
+        || substr(r.digits, r.d + 1, 1)
+FROM (
+    SELECT id, n / 35 AS q, n % 35 + 1 AS d, '0123456789abcdefghijklmnopqrstuvwxyz' AS digits
+    FROM (SELECT id, row_number() OVER (ORDER BY rank, id) - 1 AS n FROM stories) o
+) r
+WHERE r.id = stories.id;
+
+UPDATE bugs
+SET rank = substr(r.digits, r.q / 1679616 % 36 + 1, 1)
+        || substr(r.digits, r.q / 46656 % 36 + 1, 1)
+        || substr(r.digits, r.q / 1296 % 36 + 1, 1)
+        || substr(r.digits, r.q / 36 % 36 + 1, 1)
+        || substr(r.digits, r.q % 36 + 1, 1)
+        || substr(r.digits, r.d + 1, 1)
+FROM (
+    SELECT id, n / 35 AS q, n % 35 + 1 AS d, '0123456789abcdefghijklmnopqrstuvwxyz' AS digits
+    FROM (SELECT id, row_number() OVER (ORDER BY rank, id) - 1 AS n FROM bugs) o
+) r
+WHERE r.id = bugs.id;
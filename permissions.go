@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// permission is a route-level check declared in main.go and run by auth.guard
+// once the user has been loaded. An error of sql.ErrNoRows means the
+// entity named in the route doesn't exist.
+type permission func(authUser user, ps httprouter.Params) (bool, error)
+
+// ownable is implemented by services whose entities have owners,
+// so "mine" and "others" capabilities can be resolved at the route.
+type ownable interface {
+	// owners returns the ids of the users who own the entity named in the route params.
+	owners(ps httprouter.Params) ([]int64, error)
+}
+
+// require allows users that have all of the given capabilities.
+func (s *authService) require(capabilities ...string) permission {
+	return func(authUser user, _ httprouter.Params) (bool, error) {
+		return authUser.Can(capabilities), nil
+	}
+}
+
+// requireAny allows users that have at least one of the given capabilities.
+func (s *authService) requireAny(capabilities ...string) permission {
+	return func(authUser user, _ httprouter.Params) (bool, error) {
+		for _, c := range capabilities {
+			if authUser.Can([]string{c}) {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	}
+}
+
+// requireAdminOr allows members of the Admin role, and anyone else with the given capability.
+func (s *authService) requireAdminOr(capability string) permission {
+	return func(authUser user, _ httprouter.Params) (bool, error) {
+		return authUser.IsAdmin || authUser.Can([]string{capability}), nil
+	}
+}
+
+// requireOwnOrOthers checks capability+"_mine" when the user owns the entity
+// named in the route, and capability+"_others" when they don't.
+// eg: requireOwnOrOthers(bugs, "update_bugs")
+func (s *authService) requireOwnOrOthers(entities ownable, capability string) permission {
+	return func(authUser user, ps httprouter.Params) (bool, error) {
+		owners, err := entities.owners(ps)
+
+		if err != nil {
+			return false, err
+		}
+
+		if isOwner(authUser.ID, owners...) {
+			return authUser.Can([]string{capability + "_mine"}), nil
+		}
+
+		return authUser.Can([]string{capability + "_others"}), nil
+	}
+}
+
+// canOwnOrOthers is requireOwnOrOthers for handlers that only know the entity once they've read
+// the request: it checks capability+"_mine" when authUser is one of the owners, and capability+"_others" when not.
+func canOwnOrOthers(authUser user, capability string, owners ...int64) bool {
+	if isOwner(authUser.ID, owners...) {
+		return authUser.Can([]string{capability + "_mine"})
+	}
+
+	return authUser.Can([]string{capability + "_others"})
+}
+
+// readableStories keeps the stories the user may read, with read_stories_mine or read_stories_others.
+func readableStories(authUser user, stories []story) []story {
+	readable := []story{}
+
+	for _, storyData := range stories {
+		if canOwnOrOthers(authUser, "read_stories", storyData.UserID, storyData.AssigneeID) {
+			readable = append(readable, storyData)
+		}
+	}
+
+	return readable
+}
+
+// readableBugs keeps the bugs the user may read, with read_bugs_mine or read_bugs_others.
+func readableBugs(authUser user, bugs []bug) []bug {
+	readable := []bug{}
+
+	for _, bugData := range bugs {
+		if canOwnOrOthers(authUser, "read_bugs", bugData.UserID, bugData.AssigneeID) {
+			readable = append(readable, bugData)
+		}
+	}
+
+	return readable
+}
+
+// isOwner reports whether userID is one of the owners.
+// Creators and assignees both own stories and bugs.
+func isOwner(userID int64, owners ...int64) bool {
+	for _, id := range owners {
+		if id != 0 && id == userID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// currentUser returns the user loaded by auth.guard.
+func currentUser(r *http.Request) user {
+	authUser, _ := r.Context().Value("user").(user)
+
+	return authUser
+}
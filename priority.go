@@ -0,0 +1,236 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// priority is how soon a story or bug should be worked on,
+// from P0, drop everything, to P4, some day.
+type priority int
+
+const (
+	P0 priority = iota
+	P1
+	P2
+	P3
+	P4
+
+	defaultPriority = P2
+)
+
+// priorities are the choices offered in the forms, most urgent first.
+var priorities = []priority{P0, P1, P2, P3, P4}
+
+func (p priority) String() string {
+	return "P" + strconv.Itoa(int(p))
+}
+
+// Badge is the bootstrap badge class the priority is shown with.
+func (p priority) Badge() string {
+	switch p {
+	case P0:
+		return "badge-danger"
+	case P1:
+		return "badge-warning"
+	case P2:
+		return "badge-primary"
+	case P3:
+		return "badge-info"
+	}
+
+	return "badge-secondary"
+}
+
+// parsePriority accepts "P0" to "P4", or just the number.
+func parsePriority(value string) (priority, bool) {
+	n, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(value), "P"))
+
+	if err != nil || n < int(P0) || n > int(P4) {
+		return 0, false
+	}
+
+	return priority(n), true
+}
+
+// severity is how badly a bug hurts the people who run into it.
+type severity string
+
+const (
+	severityBlocker  severity = "blocker"
+	severityCritical severity = "critical"
+	severityMajor    severity = "major"
+	severityMinor    severity = "minor"
+	severityTrivial  severity = "trivial"
+
+	defaultSeverity = severityMajor
+)
+
+// severities are the choices offered in the bug forms, worst first.
+var severities = []severity{severityBlocker, severityCritical, severityMajor, severityMinor, severityTrivial}
+
+// Label is the severity as shown on the page.
+func (s severity) Label() string {
+	if s == "" {
+		return ""
+	}
+
+	return strings.ToUpper(string(s[:1])) + string(s[1:])
+}
+
+func (s severity) Badge() string {
+	switch s {
+	case severityBlocker:
+		return "badge-danger"
+	case severityCritical:
+		return "badge-warning"
+	case severityMajor:
+		return "badge-primary"
+	case severityMinor:
+		return "badge-info"
+	}
+
+	return "badge-secondary"
+}
+
+// rank orders severities worst first.
+func (s severity) rank() int {
+	for i, known := range severities {
+		if s == known {
+			return i
+		}
+	}
+
+	return len(severities)
+}
+
+func parseSeverity(value string) (severity, bool) {
+	s := severity(strings.ToLower(value))
+
+	return s, s.rank() < len(severities)
+}
+
+// readPriority reads the priority field of a story or bug form. A blank field keeps
+// the current priority. Only admins and roles with set_p0_priority can make an issue P0,
+// though anyone may leave a P0 issue as it is while editing it.
+func readPriority(authUser user, form url.Values, current priority) (priority, error) {
+	value := form.Get("priority")
+
+	if value == "" {
+		return current, nil
+	}
+
+	p, ok := parsePriority(value)
+
+	if !ok {
+		return current, validationError("Priority must be one of P0 to P4.")
+	}
+
+	if p == P0 && current != P0 && !canSetP0(authUser) {
+		return current, forbiddenError("You can't make an issue P0.")
+	}
+
+	return p, nil
+}
+
+// canSetP0 reports whether the user may make an issue P0.
+func canSetP0(authUser user) bool {
+	return authUser.IsAdmin || authUser.Can([]string{"set_p0_priority"})
+}
+
+// priorityField is what the priority_select template needs to offer the priorities on a form.
+// Users who can't set P0 see it disabled, unless the issue already is P0.
+type priorityField struct {
+	Current    priority
+	Priorities []priority
+	CanSetP0   bool
+}
+
+func newPriorityField(authUser user, current priority) priorityField {
+	return priorityField{Current: current, Priorities: priorities, CanSetP0: canSetP0(authUser)}
+}
+
+// readSeverity reads the severity field of a bug form. A blank field keeps the current severity.
+func readSeverity(form url.Values, current severity) (severity, error) {
+	value := form.Get("severity")
+
+	if value == "" {
+		return current, nil
+	}
+
+	s, ok := parseSeverity(value)
+
+	if !ok {
+		return current, validationError("Severity must be blocker, critical, major, minor or trivial.")
+	}
+
+	return s, nil
+}
+
+// sortOption is one of the orders a list can be sorted in with ?sort=.
+type sortOption struct {
+	Key   string
+	Label string
+}
+
+// bugSorts are the orders lists of bugs can be sorted in.
+// Without one, lists keep the store's order.
+var bugSorts = []sortOption{
+	{"priority", "Priority"},
+	{"severity", "Severity"},
+	{"newest", "Newest"},
+	{"updated", "Recently Updated"},
+	{"name", "Name"},
+}
+
+// sortLinks is what the issue_sorts template needs to link to each order.
+type sortLinks struct {
+	Current string
+	Options []sortOption
+	// Label is the label the list is filtered by, kept by the links.
+	Label string
+}
+
+// sortBugs sorts bugs in place by one of the bugSorts keys, returning links to the orders
+// with the one used marked. An unknown key leaves the order alone.
+// Priority sorts break ties on severity and severity sorts on priority; other ties keep their order.
+func sortBugs(bugs []bug, key string) sortLinks {
+	links := sortLinks{Options: bugSorts}
+
+	var less func(a, b bug) bool
+
+	switch key {
+	case "priority":
+		less = func(a, b bug) bool {
+			if a.Priority != b.Priority {
+				return a.Priority < b.Priority
+			}
+
+			return a.Severity.rank() < b.Severity.rank()
+		}
+	case "severity":
+		less = func(a, b bug) bool {
+			if a.Severity != b.Severity {
+				return a.Severity.rank() < b.Severity.rank()
+			}
+
+			return a.Priority < b.Priority
+		}
+	case "newest":
+		less = func(a, b bug) bool { return a.CreatedAt > b.CreatedAt }
+	case "updated":
+		less = func(a, b bug) bool { return a.UpdatedAt > b.UpdatedAt }
+	case "name":
+		less = func(a, b bug) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }
+	default:
+		return links
+	}
+
+	sort.SliceStable(bugs, func(i, j int) bool { return less(bugs[i], bugs[j]) })
+
+	links.Current = key
+
+	return links
+}
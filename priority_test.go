@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSortBugs(t *testing.T) {
+	// in rank order, which the store returns them in
+	ranked := []bug{
+		{Name: "a", Priority: P2, Severity: severityMinor},
+		{Name: "b", Priority: P0, Severity: severityMajor},
+		{Name: "c", Priority: P2, Severity: severityBlocker},
+		{Name: "d", Priority: P0, Severity: severityMajor},
+		{Name: "e", Priority: P1, Severity: severityTrivial},
+		{Name: "f", Priority: P2, Severity: severityMinor},
+	}
+
+	cases := []struct {
+		key     string
+		want    string
+		current string
+	}{
+		// priority, then severity, then rank
+		{"priority", "b,d,e,c,a,f", "priority"},
+		// severity, then priority, then rank
+		{"severity", "c,b,d,a,f,e", "severity"},
+		{"name", "a,b,c,d,e,f", "name"},
+		// an unknown order leaves them ranked
+		{"", "a,b,c,d,e,f", ""},
+		{"bogus", "a,b,c,d,e,f", ""},
+	}
+
+	for _, tc := range cases {
+		bugs := append([]bug{}, ranked...)
+
+		links := sortBugs(bugs, tc.key)
+
+		names := []string{}
+
+		for _, bugData := range bugs {
+			names = append(names, bugData.Name)
+		}
+
+		if got := strings.Join(names, ","); got != tc.want {
+			t.Errorf("sorting by %q: got %s, want %s", tc.key, got, tc.want)
+		}
+
+		if links.Current != tc.current {
+			t.Errorf("sorting by %q: current is %q, want %q", tc.key, links.Current, tc.current)
+		}
+	}
+}
+
+func TestReadPriority(t *testing.T) {
+	developer := user{RoleID: 2, Permissions: map[string]capability{}}
+	lead := user{RoleID: 3, Permissions: map[string]capability{"set_p0_priority": {}}}
+	administrator := user{RoleID: ADMIN, IsAdmin: true}
+
+	cases := []struct {
+		name     string
+		authUser user
+		value    string
+		current  priority
+		want     priority
+		status   int
+	}{
+		{"blank keeps the priority", developer, "", P3, P3, 0},
+		{"lowering", developer, "P4", P1, P4, 0},
+		{"just the number", developer, "1", P3, P1, 0},
+		{"out of range", developer, "P5", P3, P3, http.StatusUnprocessableEntity},
+		{"not a priority", developer, "urgent", P3, P3, http.StatusUnprocessableEntity},
+		{"P0 without set_p0_priority", developer, "P0", P2, P2, http.StatusForbidden},
+		{"keeping P0 without set_p0_priority", developer, "P0", P0, P0, 0},
+		{"P0 with set_p0_priority", lead, "P0", P2, P0, 0},
+		{"P0 as an admin", administrator, "p0", P2, P0, 0},
+	}
+
+	for _, tc := range cases {
+		got, err := readPriority(tc.authUser, url.Values{"priority": {tc.value}}, tc.current)
+
+		status := 0
+
+		if err != nil {
+			status = asAppError(err).kind.status()
+		}
+
+		if got != tc.want || status != tc.status {
+			t.Errorf("%s: got %s and %d, want %s and %d", tc.name, got, status, tc.want, tc.status)
+		}
+	}
+}
+
+// TestP0Guard checks the issue forms leave the priority alone
+// when someone without set_p0_priority tries to make an issue P0.
+func TestP0Guard(t *testing.T) {
+	m := newMemoryApp(t)
+
+	storyID, bugID := m.fx.StoryIDs[0], m.fx.BugIDs[0]
+
+	form := func(name string) url.Values {
+		return url.Values{"name": {name}, "priority": {"P0"}, "severity": {"major"}}
+	}
+
+	m.authUser.RoleID = 2
+	m.authUser.Permissions = map[string]capability{}
+
+	w := m.call(m.stories.update, "POST", form("Story 0"), "story_id", formatID(storyID))
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("making a story P0: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	w = m.call(m.bugs.update, "POST", form("Bug 0"), "bug_id", formatID(bugID))
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("making a bug P0: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	storyData, _ := m.store.stories.find(storyID)
+	bugData, _ := m.store.bugs.find(bugID)
+
+	if storyData.Priority != defaultPriority || bugData.Priority != defaultPriority {
+		t.Errorf("after being refused: got %s and %s, want both left %s", storyData.Priority, bugData.Priority, defaultPriority)
+	}
+
+	m.authUser.Permissions["set_p0_priority"] = capability{}
+
+	w = m.call(m.stories.update, "POST", form("Story 0"), "story_id", formatID(storyID))
+
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("making a story P0 with set_p0_priority: got %d, want %d", w.Code, http.StatusSeeOther)
+	}
+
+	storyData, _ = m.store.stories.find(storyID)
+
+	if storyData.Priority != P0 {
+		t.Errorf("with set_p0_priority: got %s, want P0", storyData.Priority)
+	}
+}
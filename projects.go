@@ -1,22 +1,16 @@
 package main
 
 import (
-	"context"
-	"database/sql"
-	"encoding/json"
 	"github.com/sirupsen/logrus"
-	"html/template"
 	"net/http"
-	"strconv"
-	"strings"
 
 	"github.com/julienschmidt/httprouter"
 )
 
 type projectService struct {
-	db   *sql.DB
-	log  *logrus.Logger
-	tpls *template.Template
+	stores stores
+	log    *logrus.Logger
+	tpls   *templateRegistry
 }
 
 type project struct {
@@ -24,91 +18,34 @@ type project struct {
 	Name        string
 	Description string
 	UserID      int64
-	CreatedAt   string
-	UpdatedAt   string
-	Features    []feature
+	// PointScale is how the project's stories are sized.
+	PointScale pointScale
+	// TrackTime turns on time estimates for the project's stories.
+	TrackTime bool
+	CreatedAt string
+	UpdatedAt string
+	DeletedAt string
+	Features  []feature
 }
 
-func NewProjectService(db *sql.DB, log *logrus.Logger, tpls *template.Template) *projectService {
-	return &projectService{db, log, tpls}
+func NewProjectService(store stores, log *logrus.Logger, tpls *templateRegistry) *projectService {
+	return &projectService{store, log, tpls}
 }
 
 func (s *projectService) index(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
+	authUser := currentUser(r)
 
-	if !authUser.Can([]string{"read_projects_mine"}) && !authUser.Can([]string{"read_projects_others"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	query := `
-SELECT
-id,
-name,
-description,
-user_id,
-created_at,
-updated_at
-FROM goissuez.projects
-WHERE deleted_at IS NULL
-ORDER BY created_at
-`
-	stmt, err := s.db.Prepare(query)
+	projects, err := s.stores.projects.all()
 
 	if err != nil {
 
-		s.log.Error("Error projects.index.prepare.", err)
+		s.log.WithContext(r.Context()).Error("Error projects.index.query.", err)
 
-		http.Error(w, "Error listing projects.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error listing projects.", nil))
 
 		return
 	}
 
-	defer stmt.Close()
-
-	rows, err := stmt.Query()
-
-	if err != nil {
-
-		s.log.Error("Error projects.index.query.", err)
-
-		http.Error(w, "Error listing projects.", http.StatusInternalServerError)
-
-		return
-	}
-
-	projects := []project{}
-
-	for rows.Next() {
-
-		projectData := project{}
-		description := sql.NullString{}
-
-		err := rows.Scan(
-			&projectData.ID,
-			&projectData.Name,
-			&description,
-			&projectData.UserID,
-			&projectData.CreatedAt,
-			&projectData.UpdatedAt,
-		)
-
-		if err != nil {
-
-			s.log.Error("Error projects.index.scan.", err)
-
-			http.Error(w, "Error listing projects.", http.StatusInternalServerError)
-
-			return
-		}
-
-		if description.Valid {
-			projectData.Description = description.String
-		}
-
-		projects = append(projects, projectData)
-	}
-
 	filteredProjectsList := []project{}
 
 	for _, p := range projects {
@@ -117,9 +54,9 @@ ORDER BY created_at
 			p.Description = "This project was created by the Demo Admin. It can be edited by the Demo Admin, though changes won't appear to persist."
 		}
 
-		if p.UserID == authUser.ID && authUser.Can([]string{"read_projects_mine"}) {
+		if isOwner(authUser.ID, p.UserID) && authUser.Can([]string{"read_projects_mine"}) {
 			filteredProjectsList = append(filteredProjectsList, p)
-		} else if p.UserID != authUser.ID && authUser.Can([]string{"read_projects_others"}) {
+		} else if !isOwner(authUser.ID, p.UserID) && authUser.Can([]string{"read_projects_others"}) {
 			filteredProjectsList = append(filteredProjectsList, p)
 		}
 	}
@@ -129,12 +66,12 @@ ORDER BY created_at
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/projects/projects.gohtml")
+	view.make("projects/projects.gohtml")
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -144,46 +81,32 @@ ORDER BY created_at
 
 func (s *projectService) store(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"create_projects"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	authUser := currentUser(r)
 
 	r.ParseForm()
 
-	projectName := r.PostForm.Get("name")
-	projectDescription := r.PostForm.Get("description")
-
-	query := `
-INSERT INTO goissuez.projects
-(name, description, user_id, created_at, updated_at)
-VALUES ($1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-RETURNING id
-`
-	stmt, err := s.db.Prepare(query)
+	projectData := project{
+		Name:        r.PostForm.Get("name"),
+		Description: r.PostForm.Get("description"),
+		UserID:      authUser.ID,
+		TrackTime:   r.PostForm.Get("track_time") != "",
+	}
 
-	if err != nil {
-		s.log.Error("Error projects.store.prepare.", err)
+	var err error
 
-		http.Error(w, "Error creating project.", http.StatusInternalServerError)
+	projectData.PointScale, err = readPointScale(r.PostForm, defaultPointScale)
 
+	if err != nil {
+		respondError(w, r, err)
 		return
 	}
 
-	defer stmt.Close()
-
-	var (
-		id int64
-	)
-
-	err = stmt.QueryRow(projectName, projectDescription, authUser.ID).Scan(&id)
+	_, err = s.stores.projects.create(projectData)
 
 	if err != nil {
-		s.log.Error("Error projects.store.queryrow.", err)
+		s.log.WithContext(r.Context()).Error("Error projects.store.queryrow.", err)
 
-		http.Error(w, "Error saving project.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error saving project.", nil))
 		return
 	}
 
@@ -191,75 +114,32 @@ RETURNING id
 }
 
 func (s *projectService) update(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
 	project_id := ps.ByName("project_id")
 
-	// ensure we have the correct permissions
-	{
-		projectData := project{}
-
-		stmt, err := s.db.Prepare(`SELECT user_id FROM goissuez.projects WHERE id = $1`)
-
-		if err != nil {
-			s.log.Error("Error projects.update.getproject.prepare.", err)
-
-			http.Error(w, "Error updating project.", http.StatusInternalServerError)
-			return
-		}
-
-		err = stmt.QueryRow(project_id).Scan(
-			&projectData.UserID,
-		)
-
-		if err != nil {
-			s.log.Error("Error projects.update.getproject.scan.", err)
-
-			http.Error(w, "Error updating project.", http.StatusInternalServerError)
-			return
-		}
-
-		if projectData.UserID == authUser.ID && !authUser.Can([]string{"update_projects_mine"}) {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		} else if projectData.UserID != authUser.ID && !authUser.Can([]string{"update_projects_others"}) {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-	}
-
 	r.ParseForm()
 
-	projectName := r.PostForm.Get("name")
-	projectDescription := r.PostForm.Get("description")
+	projectData := project{
+		ID:          parseID(project_id),
+		Name:        r.PostForm.Get("name"),
+		Description: r.PostForm.Get("description"),
+		TrackTime:   r.PostForm.Get("track_time") != "",
+	}
 
-	query := `
-UPDATE goissuez.projects
-SET
-name = $2,
-description = $3,
-updated_at = CURRENT_TIMESTAMP
-WHERE id = $1
-`
+	var err error
 
-	stmt, err := s.db.Prepare(query)
+	projectData.PointScale, err = readPointScale(r.PostForm, defaultPointScale)
 
 	if err != nil {
-		s.log.Error("Error projects.update.prepare.", err)
-
-		http.Error(w, "Error updating project.", http.StatusInternalServerError)
-
+		respondError(w, r, err)
 		return
 	}
 
-	defer stmt.Close()
-
-	_, err = stmt.Exec(project_id, projectName, projectDescription)
+	err = s.stores.projects.update(projectData)
 
 	if err != nil {
-		s.log.Error("Error projects.update.exec.", err)
+		s.log.WithContext(r.Context()).Error("Error projects.update.exec.", err)
 
-		http.Error(w, "Error updating project.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error updating project.", nil))
 
 		return
 	}
@@ -269,100 +149,30 @@ WHERE id = $1
 
 func (s *projectService) edit(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
-	authUser, _ := auth.getAuthUser(r)
-
-	project_id := ps.ByName("project_id")
-
-	// ensure we have the correct permissions
-	{
-		projectData := project{}
-
-		stmt, err := s.db.Prepare(`SELECT user_id FROM goissuez.projects WHERE id = $1`)
-
-		if err != nil {
-			s.log.Error("Error projects.edit.getproject.prepare.", err)
-
-			http.Error(w, "Error updating project.", http.StatusInternalServerError)
-			return
-		}
-
-		err = stmt.QueryRow(project_id).Scan(
-			&projectData.UserID,
-		)
-
-		if err != nil {
-			s.log.Error("Error projects.edit.getproject.scan.", err)
-
-			http.Error(w, "Error updating project.", http.StatusInternalServerError)
-			return
-		}
-
-		if projectData.UserID == authUser.ID && !authUser.Can([]string{"update_projects_mine"}) {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		} else if projectData.UserID != authUser.ID && !authUser.Can([]string{"update_projects_others"}) {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-	}
-
-	query := `
-SELECT
-id,
-name,
-description,
-user_id
-FROM goissuez.projects
-WHERE id = $1
-LIMIT 1
-`
-	stmt, err := s.db.Prepare(query)
-
-	if err != nil {
-		s.log.Error("Error projects.edit.prepare.", err)
-
-		http.Error(w, "Error editing project.", http.StatusInternalServerError)
-
-		return
-	}
-
-	defer stmt.Close()
-
-	row := stmt.QueryRow(project_id)
-
-	projectData := project{}
-	description := sql.NullString{}
-
-	err = row.Scan(
-		&projectData.ID,
-		&projectData.Name,
-		&description,
-		&projectData.UserID,
-	)
+	projectData, err := s.stores.projects.find(parseID(ps.ByName("project_id")))
 
 	if err != nil {
-		s.log.Error("Error projects.edit.scan.", err)
+		s.log.WithContext(r.Context()).Error("Error projects.edit.scan.", err)
 
-		http.Error(w, "Error editing project.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error editing project.", nil))
 
 		return
 	}
 
-	if description.Valid {
-		projectData.Description = description.String
-	}
-
-	pageData := page{Title: "Edit Project", Data: projectData}
+	pageData := page{Title: "Edit Project", Data: struct {
+		Project     project
+		PointScales []pointScale
+	}{projectData, pointScales}}
 
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/projects/edit.gohtml")
+	view.make("projects/edit.gohtml")
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -372,28 +182,24 @@ LIMIT 1
 
 func (s *projectService) show(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
-	authUser, _ := auth.getAuthUser(r)
-
 	project_id := ps.ByName("project_id")
 
 	if project_id == "new" {
 
-		if !authUser.Can([]string{"create_projects"}) {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		pageData := page{Title: "Create Project", Data: nil}
+		pageData := page{Title: "Create Project", Data: struct {
+			Project     project
+			PointScales []pointScale
+		}{project{PointScale: defaultPointScale, TrackTime: true}, pointScales}}
 
 		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 		view := viewService{w: w, r: r}
-		view.make("templates/projects/new.gohtml")
+		view.make("projects/new.gohtml")
 		err := view.exec(mainLayout, pageData)
 
 		if err != nil {
-			s.log.Error(err)
-			http.Error(w, "Error", http.StatusInternalServerError)
+			s.log.WithContext(r.Context()).Error(err)
+			respondError(w, r, internalError("Something went wrong.", nil))
 
 			return
 		}
@@ -403,57 +209,16 @@ func (s *projectService) show(w http.ResponseWriter, r *http.Request, ps httprou
 	}
 
 	// Get Project Details
-	query := `
-SELECT
-id,
-name,
-description,
-user_id,
-created_at,
-updated_at
-FROM goissuez.projects
-WHERE id = $1
-LIMIT 1
-`
-
-	stmt, err := s.db.Prepare(query)
-
-	if err != nil {
-		s.log.Error("Error projects.show.prepare.", err)
-
-		http.Error(w, "Error getting project.", http.StatusInternalServerError)
-
-		return
-	}
-
-	defer stmt.Close()
-
-	row := stmt.QueryRow(project_id)
-
-	projectData := project{}
-	description := sql.NullString{}
-
-	err = row.Scan(
-		&projectData.ID,
-		&projectData.Name,
-		&description,
-		&projectData.UserID,
-		&projectData.CreatedAt,
-		&projectData.UpdatedAt,
-	)
+	projectData, err := s.stores.projects.find(parseID(project_id))
 
 	if err != nil {
-		s.log.Error("Error projects.show.scan.", err)
+		s.log.WithContext(r.Context()).Error("Error projects.show.scan.", err)
 
-		http.Error(w, "Error getting project.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error getting project.", nil))
 
 		return
 	}
 
-	if description.Valid {
-		projectData.Description = description.String
-	}
-
 	// hack to ensure demo project description and name doesn't change
 	if projectData.ID == 2 {
 
@@ -461,127 +226,29 @@ LIMIT 1
 		projectData.Description = "This project was created by the Demo Admin. It can be edited by the Demo Admin, though changes won't appear to persist."
 	}
 
-	if projectData.UserID == authUser.ID {
-		if !authUser.Can([]string{"read_projects_mine"}) {
-
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-
-			return
-		}
-
-	} else {
-		if !authUser.Can([]string{"read_projects_others"}) {
-
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-
-			return
-		}
-	}
-
 	// Get Features for the Project
-
-	stmt, err = s.db.Prepare(`
-SELECT
-id,
-name,
-description,
-project_id,
-user_id,
-created_at,
-updated_at
-FROM goissuez.features
-WHERE project_id = $1
-`)
-
-	if err != nil {
-		s.log.Error("Error projects.show.prepare.features.", err)
-
-		http.Error(w, "Error getting project features.", http.StatusInternalServerError)
-
-		return
-	}
-
-	defer stmt.Close()
-
-	rows, err := stmt.Query(project_id)
+	projectData.Features, err = s.stores.features.byProject(projectData.ID)
 
 	if err != nil {
-		s.log.Error("Error projects.show.query.features.", err)
+		s.log.WithContext(r.Context()).Error("Error projects.show.query.features.", err)
 
-		http.Error(w, "Error getting project features.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error getting project features.", nil))
 
 		return
 	}
 
-	features := []feature{}
-
-	for rows.Next() {
-		featureData := feature{}
-		description := sql.NullString{}
-
-		err := rows.Scan(
-			&featureData.ID,
-			&featureData.Name,
-			&description,
-			&featureData.ProjectID,
-			&featureData.UserID,
-			&featureData.CreatedAt,
-			&featureData.UpdatedAt,
-		)
-
-		if err != nil {
-
-			s.log.Error("Error projects.show.scan.features.", err)
-
-			http.Error(w, "Error getting project features.", http.StatusInternalServerError)
-
-			return
-		}
-
-		if description.Valid {
-			featureData.Description = description.String
-		}
-
-		features = append(features, featureData)
-	}
-
-	projectData.Features = features
-
-	pageData := page{Title: projectData.Name, Data: projectData, Funcs: make(map[string]interface{})}
-
-	pageData.Funcs["ToJSON"] = func(data interface{}) string {
-
-		var featureData feature
-		var projectData project
-		var b []byte
-		var err error
-
-		featureData, feature_ok := data.(feature)
-		projectData, project_ok := data.(project)
-
-		if feature_ok {
-			b, err = json.Marshal(featureData)
-		} else if project_ok {
-			b, err = json.Marshal(projectData)
-		}
-
-		if err != nil {
-			return ""
-		}
-
-		return string(b)
-	}
+	pageData := page{Title: projectData.Name, Data: projectData}
 
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/projects/project.gohtml")
+	view.make("projects/project.gohtml")
 
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -590,214 +257,35 @@ WHERE project_id = $1
 }
 
 func (s *projectService) destroy(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
-	project_id := ps.ByName("project_id")
-
-	// ensure we have the correct permissions
-	{
-		projectData := project{}
-
-		stmt, err := s.db.Prepare(`SELECT user_id FROM goissuez.projects WHERE id = $1`)
-
-		if err != nil {
-			s.log.Error("Error projects.destroy.getproject.prepare.", err)
-
-			http.Error(w, "Error updating project.", http.StatusInternalServerError)
-			return
-		}
-
-		err = stmt.QueryRow(project_id).Scan(
-			&projectData.UserID,
-		)
-
-		if err != nil {
-			s.log.Error("Error projects.destroy.getproject.scan.", err)
-
-			http.Error(w, "Error updating project.", http.StatusInternalServerError)
-			return
-		}
-
-		if projectData.UserID == authUser.ID && !authUser.Can([]string{"delete_projects_mine"}) {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		} else if projectData.UserID != authUser.ID && !authUser.Can([]string{"delete_projects_others"}) {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-	}
-
-	tx, err := s.db.BeginTx(context.Background(), nil)
+	err := s.stores.projects.destroy(parseID(ps.ByName("project_id")))
 
 	if err != nil {
-		s.log.Error("Error projects.destroy.begintx.", err)
+		s.log.WithContext(r.Context()).Error("Error projects.destroy.exec.", err)
 
-		http.Error(w, "Cannot delete project.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error deleting project.", nil))
 		return
 	}
 
-	// DELETE Project
-	{
-		stmt, err := tx.Prepare(`UPDATE goissuez.projects SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1`)
-
-		if err != nil {
-			s.log.Error("Error projects.destroy.prepare.", err)
-
-			tx.Rollback()
-			http.Error(w, "Error deleting project.", http.StatusInternalServerError)
-			return
-		}
-
-		defer stmt.Close()
-
-		_, err = stmt.Exec(project_id)
-
-		if err != nil {
-			s.log.Error("Error projects.destroy.exec.", err)
-
-			tx.Rollback()
-			http.Error(w, "Error deleting project.", http.StatusInternalServerError)
-			return
-		}
-	}
-
-	// Compile a list of features that we'll be deleting.
-	// we'll need to reference this list of ids to delete associated bugs and stories.
-	feature_ids := []int64{}
-	{
-		// first save all feature IDs to make handling stories and bugs easier
-		stmt, err := tx.Prepare(`SELECT id from goissuez.features WHERE project_id = $1`)
-
-		if err != nil {
-			s.log.Error("Error projects.destroy.query.features.prepare.", err)
-
-			tx.Rollback()
-			http.Error(w, "Error deleting project.", http.StatusInternalServerError)
-			return
-		}
-
-		defer stmt.Close()
-
-		rows, err := stmt.Query(project_id)
-
-		if err != nil {
-			s.log.Error("Error projects.destroy.query.features.query.", err)
-
-			tx.Rollback()
-			http.Error(w, "Error deleting project.", http.StatusInternalServerError)
-			return
-		}
-
-		for rows.Next() {
-			var id int64
-
-			err := rows.Scan(
-				&id,
-			)
-
-			if err != nil {
-				s.log.Error("Error projects.destroy.query.features.scan.", err)
-
-				tx.Rollback()
-				http.Error(w, "Error deleting project.", http.StatusInternalServerError)
-				return
-			}
-
-			feature_ids = append(feature_ids, id)
-		}
-	}
-
-	// DELETE Features
-	{
-		stmt, err := tx.Prepare(`UPDATE goissuez.features SET deleted_at = CURRENT_TIMESTAMP WHERE project_id = $1`)
-
-		if err != nil {
-			s.log.Error("Error projects.destroy.delete.features.prepare.", err)
-
-			tx.Rollback()
-			http.Error(w, "Error deleting project.", http.StatusInternalServerError)
-			return
-		}
-
-		defer stmt.Close()
-
-		_, err = stmt.Exec(project_id)
-
-		if err != nil {
-			s.log.Error("Error projects.destroy.delete.features.exec.", err)
-
-			tx.Rollback()
-			http.Error(w, "Error deleting project.", http.StatusInternalServerError)
-			return
-		}
-
-	}
-
-	// create a string of feature ids we can reference to delete associated bugs and stories.
-	// why not just use postgres to cascade these changes? b/c we're using soft-deletes.
-	var deleted_feature_ids string
-	{
-		feature_id_strings := []string{}
-
-		for _, v := range feature_ids {
-			feature_id_strings = append(feature_id_strings, strconv.FormatInt(v, 10))
-		}
-
-		deleted_feature_ids = strings.Join(feature_id_strings, ", ")
-	}
-
-	// DELETE stories
-	{
-		stmt, err := tx.Prepare(`UPDATE goissuez.stories SET deleted_at = CURRENT_TIMESTAMP WHERE feature_id IN ($1)`)
-
-		if err != nil {
-			s.log.Error("Error projects.destroy.delete.stories.prepare.", err)
-
-			tx.Rollback()
-			http.Error(w, "Error deleting project.", http.StatusInternalServerError)
-			return
-		}
-
-		defer stmt.Close()
-
-		_, err = stmt.Exec(deleted_feature_ids)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
 
-		if err != nil {
-			s.log.Error("Error projects.destroy.delete.stories.exec.", err)
+// owners returns the creator of the project named in the route.
+func (s *projectService) owners(ps httprouter.Params) ([]int64, error) {
+	projectData, err := s.stores.projects.find(parseID(ps.ByName("project_id")))
 
-			tx.Rollback()
-			http.Error(w, "Error deleting project.", http.StatusInternalServerError)
-			return
-		}
+	if err != nil {
+		return nil, err
 	}
 
-	// DELETE Bugs
-	{
-		stmt, err := tx.Prepare(`UPDATE goissuez.bugs SET deleted_at = CURRENT_TIMESTAMP WHERE feature_id IN ($1)`)
-
-		if err != nil {
-			s.log.Error("Error projects.destroy.delete.bugs..prepare.", err)
-
-			tx.Rollback()
-			http.Error(w, "Error deleting project.", http.StatusInternalServerError)
-			return
-		}
-
-		defer stmt.Close()
-
-		_, err = stmt.Exec(deleted_feature_ids)
-
-		if err != nil {
-			s.log.Error("Error projects.destroy.delete.bugs.exec.", err)
+	return []int64{projectData.UserID}, nil
+}
 
-			tx.Rollback()
-			http.Error(w, "Error deleting project.", http.StatusInternalServerError)
-			return
-		}
+// canShow guards projects.show, which doubles as the "new project" form.
+func (s *projectService) canShow(authUser user, ps httprouter.Params) (bool, error) {
+	if ps.ByName("project_id") == "new" {
+		return auth.require("create_projects")(authUser, ps)
 	}
 
-	tx.Commit()
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	return auth.requireOwnOrOthers(s, "read_projects")(authUser, ps)
 }
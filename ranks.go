@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Stories and bugs are listed in order of rank: a string of base 36 digits compared byte by
+// byte, like a fraction. There is almost always room for a rank between two others, so an issue
+// can be moved without renumbering the rest. The first rankWidth digits are a whole number that
+// new issues count up; moving an issue only adds digits after them.
+//
+// No rank is given that ends in "0", since nothing comes between a rank and the same rank
+// followed by zeros, eg: "a" and "a0". Ranks from before this rule may still end in "0";
+// when two are left without room between them, the issues are ranked again with rerank.
+// So are they when moving into the same gap over and over makes a rank longer than
+// maxRankLength, well short of what the rank column holds.
+const (
+	rankDigits    = "0123456789abcdefghijklmnopqrstuvwxyz"
+	rankWidth     = 6
+	maxRankLength = 64
+)
+
+// nextRank is the rank for an issue added after last, the highest rank so far ("" if there's none).
+func nextRank(last string) string {
+	zero := rankDigits[0]
+	whole := []byte((last + strings.Repeat(string(zero), rankWidth))[:rankWidth])
+
+	// add one, carrying as needed
+	for i := rankWidth - 1; i >= 0; i-- {
+		d := strings.IndexByte(rankDigits, whole[i])
+
+		if d < len(rankDigits)-1 {
+			whole[i] = rankDigits[d+1]
+
+			// skip the whole numbers ending in "0", eg: "00000z" is followed by "000011"
+			if whole[rankWidth-1] == zero {
+				whole[rankWidth-1] = rankDigits[1]
+			}
+
+			return string(whole)
+		}
+
+		whole[i] = zero
+	}
+
+	// every whole number has been used; go on in the fraction
+	rank, _ := rankBetween(last, "")
+
+	return rank
+}
+
+// rankBetween returns a rank after before and before after, where before < after.
+// A blank before means from the start, and a blank after means to the end.
+// The rank doesn't end in "0". ok is false if there's no room between the two,
+// which happens when after is before followed by zeros, or isn't after it at all.
+func rankBetween(before, after string) (rank string, ok bool) {
+	zero := rankDigits[0]
+
+	// skip the digits the two have in common, reading a short before as padded with zeros
+	n := 0
+
+	for after != "" && n < len(after) && digitAt(before, n, zero) == after[n] {
+		n++
+	}
+
+	if after != "" && n == len(after) {
+		return "", false
+	}
+
+	low := strings.IndexByte(rankDigits, digitAt(before, n, zero))
+	high := len(rankDigits)
+
+	if after != "" {
+		high = strings.IndexByte(rankDigits, after[n])
+	}
+
+	if low < 0 || high < 0 || low >= high {
+		return "", false
+	}
+
+	prefix := after[:n]
+
+	if after == "" {
+		prefix = before[:n]
+	}
+
+	if high-low > 1 {
+		return prefix + string(rankDigits[(low+high+1)/2]), true
+	}
+
+	// the digits are adjacent: after cut short after this digit is between when after goes on,
+	// otherwise keep before's digit and go past the rest of it
+	if n+1 < len(after) {
+		return after[:n+1], true
+	}
+
+	rest := ""
+
+	if n+1 < len(before) {
+		rest = before[n+1:]
+	}
+
+	rank, _ = rankBetween(rest, "")
+
+	return prefix + string(rankDigits[low]) + rank, true
+}
+
+// digitAt is the nth digit of rank, or pad past its end.
+func digitAt(rank string, n int, pad byte) byte {
+	if n < len(rank) {
+		return rank[n]
+	}
+
+	return pad
+}
+
+// readRankTarget reads the id of the issue to rank another before or after from a JSON
+// body with the given key, eg: {"story_id": "3"}.
+func readRankTarget(r *http.Request, key string) (int64, error) {
+	var request map[string]string
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+
+	if err != nil || parseID(request[key]) == 0 {
+		return 0, validationError("Send the " + key + " to rank it next to.")
+	}
+
+	return parseID(request[key]), nil
+}
+
+// sendRank answers a rank-before or rank-after request with the issue's new rank.
+func sendRank(w http.ResponseWriter, rank string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	json.NewEncoder(w).Encode(struct {
+		Rank string `json:"rank"`
+	}{rank})
+}
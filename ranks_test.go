@@ -0,0 +1,246 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRankBetween(t *testing.T) {
+	cases := []struct {
+		before, after string
+		ok            bool
+	}{
+		// empty bounds
+		{"", "", true},
+		{"", "000001", true},
+		{"000001", "", true},
+		{"zzzzzz", "", true},
+		{"", "0001", true},
+		// a gap in the first digit that differs
+		{"000001", "000005", true},
+		{"a", "c", true},
+		// adjacent keys
+		{"000001", "000002", true},
+		{"00000z", "000011", true},
+		{"a", "b", true},
+		{"a", "b1", true},
+		{"az", "b", true},
+		{"azzz", "b", true},
+		{"000001i", "000002", true},
+		{"000001", "000001i", true},
+		{"000001", "0000011", true},
+		// keys ending in "0", left by nextRank and the migrations before they skipped them
+		{"00000z", "000010", true},
+		{"000010", "000011", true},
+		{"00001", "0000101", true},
+		{"a", "a01", true},
+		{"", "01", true},
+		// after is before followed by zeros, so nothing comes between
+		{"00001", "000010", false},
+		{"a", "a0", false},
+		{"a", "a000", false},
+		{"", "0", false},
+		{"", "00", false},
+		// reversed or equal bounds
+		{"000002", "000001", false},
+		{"b", "a", false},
+		{"000001", "000001", false},
+	}
+
+	for _, tc := range cases {
+		rank, ok := rankBetween(tc.before, tc.after)
+
+		if ok != tc.ok {
+			t.Errorf("rankBetween(%q, %q): got %q, %v, want ok %v", tc.before, tc.after, rank, ok, tc.ok)
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+
+		if rank <= tc.before || (tc.after != "" && rank >= tc.after) {
+			t.Errorf("rankBetween(%q, %q): got %q, which isn't between them", tc.before, tc.after, rank)
+		}
+
+		if strings.HasSuffix(rank, "0") {
+			t.Errorf("rankBetween(%q, %q): got %q, which ends in 0", tc.before, tc.after, rank)
+		}
+	}
+}
+
+// TestRankBetweenRepeated keeps putting a rank just after one bound, then just before the
+// other, which grows the ranks fastest, checking there's always room.
+func TestRankBetweenRepeated(t *testing.T) {
+	for _, bounds := range [][2]string{{"000001", "000002"}, {"", "000001"}, {"00000z", ""}, {"000010", "000011"}} {
+		before, after := bounds[0], bounds[1]
+
+		for i := 0; i < 200; i++ {
+			rank, ok := rankBetween(before, after)
+
+			if !ok || rank <= before || (after != "" && rank >= after) {
+				t.Fatalf("rankBetween(%q, %q): got %q, %v", before, after, rank, ok)
+			}
+
+			if i%2 == 0 {
+				before = rank
+			} else {
+				after = rank
+			}
+		}
+	}
+}
+
+func TestNextRank(t *testing.T) {
+	cases := []struct {
+		last string
+		want string
+	}{
+		{"", "000001"},
+		{"000001", "000002"},
+		{"000009", "00000a"},
+		// whole ranks ending in "0" are skipped
+		{"00000z", "000011"},
+		{"0000zz", "000101"},
+		{"00000z5", "000011"},
+		// ranks from before they were skipped
+		{"000010", "000011"},
+		{"00001", "000011"},
+		{"000001i", "000002"},
+		// every whole rank used; go on in the fraction
+		{"zzzzzz", "zzzzzzi"},
+		{"zzzzzzi", "zzzzzzr"},
+	}
+
+	for _, tc := range cases {
+		if got := nextRank(tc.last); got != tc.want {
+			t.Errorf("nextRank(%q): got %q, want %q", tc.last, got, tc.want)
+		}
+	}
+
+	// a run of new issues counts up without ranks ending in "0"
+	last := ""
+
+	for i := 0; i < 2000; i++ {
+		rank := nextRank(last)
+
+		if rank <= last || len(rank) != rankWidth || strings.HasSuffix(rank, "0") {
+			t.Fatalf("nextRank(%q): got %q", last, rank)
+		}
+
+		last = rank
+	}
+}
+
+// TestRerank moves an issue between ranks with no room left between them,
+// which ranks the others again first, keeping their order.
+func TestRerank(t *testing.T) {
+	ranks := memoryRanks{1: "00001", 2: "000010", 3: "000011", 4: "000012"}
+
+	err := ranks.moveNextTo(4, 2, true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !(ranks[1] < ranks[4] && ranks[4] < ranks[2] && ranks[2] < ranks[3]) {
+		t.Errorf("memory: got %v, want 1, 4, 2, 3", ranks)
+	}
+
+	a := testApplication(t)
+
+	a.reset(t)
+	defer a.reset(t)
+
+	store := newSQLStores(a.db)
+	fx := seedStore(t, store)
+
+	for i, rank := range []string{"00001", "000010", "000011"} {
+		_, err = a.db.Exec(`UPDATE goissuez.stories SET rank = $2 WHERE id = $1`, fx.StoryIDs[i], rank)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before, err := store.stories.all()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.stories.rankBefore(fx.StoryIDs[2], fx.StoryIDs[1])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := store.stories.all()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same order, but with the last story moved up one
+	want := []int64{}
+
+	for _, storyData := range before {
+		switch storyData.ID {
+		case fx.StoryIDs[1]:
+			want = append(want, fx.StoryIDs[2], fx.StoryIDs[1])
+		case fx.StoryIDs[2]:
+		default:
+			want = append(want, storyData.ID)
+		}
+	}
+
+	for i, storyData := range after {
+		if i >= len(want) || storyData.ID != want[i] {
+			t.Fatalf("sql: story %d is at %d, want the order %v", storyData.ID, i, want)
+		}
+
+		if strings.HasSuffix(storyData.Rank, "0") {
+			t.Errorf("sql: story %d is ranked %q", storyData.ID, storyData.Rank)
+		}
+	}
+}
+
+// TestRankSameGap keeps moving an issue in right after the same one, which makes the ranks
+// longer each time, checking they're ranked again before they get longer than maxRankLength.
+func TestRankSameGap(t *testing.T) {
+	eachStore(t, func(t *testing.T, store stores, fx storeFixtures) {
+		first := fx.StoryIDs[0]
+		moving := fx.StoryIDs[1:]
+
+		for i := 0; i < 400; i++ {
+			rank, err := store.stories.rankAfter(moving[i%2], first)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(rank) > maxRankLength {
+				t.Fatalf("move %d: got a rank of %d digits", i, len(rank))
+			}
+		}
+
+		stories, err := store.stories.byFeature(fx.FeatureID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the last moved is right after the first, ahead of the one moved before it
+		want := storyIDs([]story{{ID: first}, {ID: moving[1]}, {ID: moving[0]}})
+
+		if got := storyIDs(stories); got != want {
+			t.Errorf("order: got %s, want %s", got, want)
+		}
+
+		for _, storyData := range stories {
+			if len(storyData.Rank) > maxRankLength {
+				t.Errorf("story %d: got a rank of %d digits", storyData.ID, len(storyData.Rank))
+			}
+		}
+	})
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/yaml.v2"
+)
+
+// roleFile is the YAML form of every role and the capabilities assigned to it.
+// Only a role's own capabilities are listed; inherited ones come from `inherits`.
+type roleFile struct {
+	Roles []roleSpec `yaml:"roles"`
+}
+
+type roleSpec struct {
+	Name         string   `yaml:"name"`
+	Description  string   `yaml:"description,omitempty"`
+	Inherits     string   `yaml:"inherits,omitempty"`
+	Capabilities []string `yaml:"capabilities"`
+}
+
+// roleChange is what applying a roleFile would do to a single role.
+type roleChange struct {
+	Name           string
+	Create         bool
+	OldDescription string
+	NewDescription string
+	OldInherits    string
+	NewInherits    string
+	Add            []string
+	Remove         []string
+}
+
+func (c roleChange) empty() bool {
+	return !c.Create &&
+		c.OldDescription == c.NewDescription &&
+		c.OldInherits == c.NewInherits &&
+		len(c.Add) == 0 &&
+		len(c.Remove) == 0
+}
+
+// rolePlan is the diff between the roles in the database and a roleFile.
+// Roles that aren't in the file are left alone and listed as unmanaged.
+type rolePlan struct {
+	Changes   []roleChange
+	Unmanaged []string
+}
+
+func (p rolePlan) HasChanges() bool {
+	return len(p.Changes) > 0
+}
+
+// String renders the plan as a short diff, one line per change.
+func (p rolePlan) String() string {
+	if !p.HasChanges() {
+		return "No changes.\n"
+	}
+
+	b := strings.Builder{}
+
+	for _, c := range p.Changes {
+		if c.Create {
+			fmt.Fprintf(&b, "+ role %s\n", c.Name)
+		} else {
+			fmt.Fprintf(&b, "~ role %s\n", c.Name)
+		}
+
+		if c.OldDescription != c.NewDescription {
+			fmt.Fprintf(&b, "    description: %q -> %q\n", c.OldDescription, c.NewDescription)
+		}
+
+		if c.OldInherits != c.NewInherits {
+			fmt.Fprintf(&b, "    inherits: %q -> %q\n", c.OldInherits, c.NewInherits)
+		}
+
+		for _, name := range c.Add {
+			fmt.Fprintf(&b, "    + %s\n", name)
+		}
+
+		for _, name := range c.Remove {
+			fmt.Fprintf(&b, "    - %s\n", name)
+		}
+	}
+
+	return b.String()
+}
+
+var errNoAdminRole = validationError("no role would have the admin capability; refusing to lock everyone out of the admin panel")
+
+// exportRoles reads every role and its own capabilities.
+func (s *adminService) exportRoles() (roleFile, error) {
+	file := roleFile{Roles: []roleSpec{}}
+
+	roles, err := s.stores.roles.all()
+
+	if err != nil {
+		return file, err
+	}
+
+	names := make(map[int64]string)
+
+	for _, roleData := range roles {
+		names[roleData.ID] = roleData.Name
+	}
+
+	for _, roleData := range roles {
+		permissions, err := s.stores.roles.permissions(roleData.ID)
+
+		if err != nil {
+			return file, err
+		}
+
+		spec := roleSpec{
+			Name:         roleData.Name,
+			Description:  roleData.Description,
+			Inherits:     names[roleData.ParentID],
+			Capabilities: []string{},
+		}
+
+		for name := range permissions {
+			spec.Capabilities = append(spec.Capabilities, name)
+		}
+
+		sort.Strings(spec.Capabilities)
+
+		file.Roles = append(file.Roles, spec)
+	}
+
+	return file, nil
+}
+
+// parseRoleFile decodes YAML into a roleFile, rejecting unknown keys.
+func parseRoleFile(data []byte) (roleFile, error) {
+	file := roleFile{}
+
+	err := yaml.UnmarshalStrict(data, &file)
+
+	return file, err
+}
+
+// planRoles validates a roleFile against the database and works out what applying it would change.
+// Problems with the file are validation errors; any other error is the store's.
+func (s *adminService) planRoles(file roleFile) (rolePlan, error) {
+	plan := rolePlan{Changes: []roleChange{}, Unmanaged: []string{}}
+
+	current, err := s.exportRoles()
+
+	if err != nil {
+		return plan, err
+	}
+
+	capabilities, err := s.stores.roles.capabilities()
+
+	if err != nil {
+		return plan, err
+	}
+
+	known := make(map[string]bool)
+
+	for _, c := range capabilities {
+		known[c.Name] = true
+	}
+
+	// final is the state every role will be in after applying the file
+	final := make(map[string]roleSpec)
+	wanted := make(map[string]bool)
+
+	for _, spec := range current.Roles {
+		final[spec.Name] = spec
+	}
+
+	for _, spec := range file.Roles {
+		if strings.TrimSpace(spec.Name) == "" {
+			return plan, validationError("every role needs a name")
+		}
+
+		if wanted[spec.Name] {
+			return plan, validationError(fmt.Sprintf("role %q is listed more than once", spec.Name))
+		}
+
+		wanted[spec.Name] = true
+
+		for _, name := range spec.Capabilities {
+			if !isRegisteredCapability(name) || !known[name] {
+				return plan, validationError(fmt.Sprintf("role %q has unknown capability %q", spec.Name, name))
+			}
+		}
+
+		final[spec.Name] = spec
+	}
+
+	for _, spec := range file.Roles {
+		if spec.Inherits == "" {
+			continue
+		}
+
+		if _, ok := final[spec.Inherits]; !ok {
+			return plan, validationError(fmt.Sprintf("role %q inherits from unknown role %q", spec.Name, spec.Inherits))
+		}
+
+		if roleSpecCycle(final, spec.Name) {
+			return plan, validationError(fmt.Sprintf("role %q would inherit from itself", spec.Name))
+		}
+	}
+
+	if !roleSpecsHaveAdmin(final) {
+		return plan, errNoAdminRole
+	}
+
+	existing := make(map[string]roleSpec)
+
+	for _, spec := range current.Roles {
+		existing[spec.Name] = spec
+
+		if !wanted[spec.Name] {
+			plan.Unmanaged = append(plan.Unmanaged, spec.Name)
+		}
+	}
+
+	for _, spec := range file.Roles {
+		old, ok := existing[spec.Name]
+
+		change := roleChange{
+			Name:           spec.Name,
+			Create:         !ok,
+			OldDescription: old.Description,
+			NewDescription: spec.Description,
+			OldInherits:    old.Inherits,
+			NewInherits:    spec.Inherits,
+		}
+
+		change.Add, change.Remove = diffCapabilityNames(old.Capabilities, spec.Capabilities)
+
+		if !change.empty() {
+			plan.Changes = append(plan.Changes, change)
+		}
+	}
+
+	return plan, nil
+}
+
+// applyRoles applies a plan in a single transaction.
+func (s *adminService) applyRoles(plan rolePlan) error {
+	if !plan.HasChanges() {
+		return nil
+	}
+
+	err := s.stores.roles.apply(plan)
+
+	auth.cache.clear()
+
+	return err
+}
+
+// roleSpecCycle reports whether following `inherits` from name leads back to name.
+func roleSpecCycle(specs map[string]roleSpec, name string) bool {
+	seen := make(map[string]bool)
+
+	for current := specs[name].Inherits; current != ""; current = specs[current].Inherits {
+		if current == name {
+			return true
+		}
+
+		if seen[current] {
+			return false
+		}
+
+		seen[current] = true
+	}
+
+	return false
+}
+
+// roleSpecsHaveAdmin reports whether at least one role would have the admin capability,
+// either directly or through inheritance.
+func roleSpecsHaveAdmin(specs map[string]roleSpec) bool {
+	for name := range specs {
+		seen := make(map[string]bool)
+
+		for current := name; current != "" && !seen[current]; current = specs[current].Inherits {
+			seen[current] = true
+
+			for _, c := range specs[current].Capabilities {
+				if c == "admin" {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// diffCapabilityNames returns the names in want but not have, and in have but not want.
+func diffCapabilityNames(have, want []string) (add []string, remove []string) {
+	haveSet := make(map[string]bool)
+	wantSet := make(map[string]bool)
+
+	for _, name := range have {
+		haveSet[name] = true
+	}
+
+	for _, name := range want {
+		wantSet[name] = true
+
+		if !haveSet[name] {
+			add = append(add, name)
+		}
+	}
+
+	for _, name := range have {
+		if !wantSet[name] {
+			remove = append(remove, name)
+		}
+	}
+
+	sort.Strings(add)
+	sort.Strings(remove)
+
+	return add, remove
+}
+
+// exportRolesYAML downloads every role as YAML.
+func (s *adminService) exportRolesYAML(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	file, err := s.exportRoles()
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error admin.exportrolesyaml.exportroles.", err)
+
+		respondError(w, r, internalError("Error exporting roles.", nil))
+		return
+	}
+
+	out, err := yaml.Marshal(file)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error admin.exportrolesyaml.marshal.", err)
+
+		respondError(w, r, internalError("Error exporting roles.", nil))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-yaml; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="roles.yaml"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
+
+// showApplyRoles shows the form used to preview and apply a roles YAML file.
+func (s *adminService) showApplyRoles(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	s.renderApplyRoles(w, r, "", nil, "", http.StatusOK)
+}
+
+// applyRolesYAML previews or applies a roles YAML file.
+// The YAML can be pasted into the form or uploaded as a file.
+// With dry_run set nothing is saved and the plan is shown instead.
+func (s *adminService) applyRolesYAML(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	r.ParseMultipartForm(1 << 20)
+
+	source := r.PostFormValue("roles")
+
+	upload, _, err := r.FormFile("file")
+
+	if err == nil {
+		defer upload.Close()
+
+		data, err := ioutil.ReadAll(upload)
+
+		if err != nil {
+			s.log.WithContext(r.Context()).Error("Error admin.applyrolesyaml.readfile.", err)
+
+			respondError(w, r, validationError("Error reading file."))
+			return
+		}
+
+		source = string(data)
+	}
+
+	file, err := parseRoleFile([]byte(source))
+
+	if err != nil {
+		s.renderApplyRoles(w, r, source, nil, "Invalid YAML: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	plan, err := s.planRoles(file)
+
+	if err != nil {
+		// a problem with the file is shown with it; anything else is ours
+		if appErr := asAppError(err); appErr.kind == errValidation {
+			s.renderApplyRoles(w, r, source, nil, appErr.message, http.StatusUnprocessableEntity)
+			return
+		}
+
+		s.log.WithContext(r.Context()).Error("Error admin.applyrolesyaml.planroles.", err)
+
+		respondError(w, r, internalError("Error applying roles.", nil))
+		return
+	}
+
+	if r.PostFormValue("dry_run") != "" {
+		s.renderApplyRoles(w, r, source, &plan, "", http.StatusOK)
+		return
+	}
+
+	err = s.applyRoles(plan)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error admin.applyrolesyaml.applyroles.", err)
+
+		respondError(w, r, internalError("Error applying roles.", nil))
+		return
+	}
+
+	s.log.WithContext(r.Context()).Info("Applied roles from YAML. ", len(plan.Changes), " roles changed.")
+
+	http.Redirect(w, r, "/admin/roles", http.StatusSeeOther)
+}
+
+func (s *adminService) renderApplyRoles(w http.ResponseWriter, r *http.Request, source string, plan *rolePlan, message string, status int) {
+	pageData := page{
+		Title: "Apply Roles",
+		Data: struct {
+			Source string
+			Plan   *rolePlan
+			Error  string
+		}{
+			source,
+			plan,
+			message,
+		},
+	}
+
+	view := viewService{w: w, r: r}
+
+	view.make("admin/applyroles.gohtml")
+	err := view.exec(mainLayout, pageData)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error admin.applyroles.exec", err)
+		respondError(w, r, internalError("Error loading page.", nil))
+
+		return
+	}
+
+	view.send(status)
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Who may use a route. "guest" is someone who isn't logged in.
+var (
+	anyone   = []string{"guest", "developer", "qa", "manager", "admin"}
+	loggedIn = []string{"developer", "qa", "manager", "admin"}
+	testers  = []string{"qa", "manager", "admin"}
+	managers = []string{"manager", "admin"}
+	admins   = []string{"admin"}
+)
+
+// routeCase is one row of the permission matrix: a request
+// and the roles allowed to make it.
+type routeCase struct {
+	method string
+	// route is the pattern as registered in newRouter.
+	route string
+	// path overrides the path filled in from the route, eg: for another owner's story.
+	path  string
+	body  func(fx demoFixtures) *testBody
+	allow []string
+	// mutates marks GET routes that change data, so the database is reset after them.
+	mutates bool
+	// toLogin marks routes that send guests to the login page even when allowed.
+	toLogin bool
+}
+
+// permissionMatrix lists every route. With the demo fixtures, the project and feature
+// belong to manager_demo; stories are created by manager_demo, the first two assigned
+// to developer_demo; bugs are logged by qa_demo, the first assigned to developer_demo.
+func permissionMatrix(fx demoFixtures) []routeCase {
+	id := func(n int64) string { return strconv.FormatInt(n, 10) }
+
+	return []routeCase{
+		{method: "GET", route: "/resources/*filepath", allow: anyone},
+		{method: "GET", route: "/uploads/*filepath", allow: anyone},
+		{method: "GET", route: "/healthz", allow: anyone},
+		{method: "GET", route: "/readyz", allow: anyone},
+		// not found without a metrics token, which TestMetricsToken covers
+		{method: "GET", route: "/metrics", allow: anyone},
+
+		{method: "GET", route: "/", allow: anyone},
+		{method: "GET", route: "/demo/:role", allow: anyone},
+		{method: "GET", route: "/register", allow: anyone},
+		{method: "POST", route: "/register-user", allow: anyone, body: newUserBody},
+		{method: "GET", route: "/login", allow: anyone},
+		{method: "POST", route: "/login-user", allow: anyone, body: func(demoFixtures) *testBody {
+			return form(url.Values{"username": {"developer_demo"}, "password": {demoPassword}})
+		}},
+		{method: "GET", route: "/logout", allow: anyone, mutates: true, toLogin: true},
+		{method: "GET", route: "/dashboard", allow: loggedIn},
+
+		// admin
+		{method: "GET", route: "/admin", allow: admins},
+		{method: "GET", route: "/admin/users", allow: managers},
+		{method: "POST", route: "/admin/setUserRole", allow: admins, body: func(fx demoFixtures) *testBody {
+			return jsonBody(map[string]string{"user_id": id(fx.Users["developer_demo"]), "role_id": id(fx.Roles["QA"])})
+		}},
+		{method: "GET", route: "/admin/roles", allow: admins},
+		{method: "GET", route: "/admin/roles/new", allow: admins},
+		{method: "GET", route: "/admin/roles/diff", allow: admins},
+		{method: "POST", route: "/roles/:role_id/update", allow: admins},
+		{method: "GET", route: "/roles/:role_id/edit", allow: admins},
+		{method: "POST", route: "/roles", allow: admins},
+		{method: "POST", route: "/roles/:role_id/clone", allow: admins},
+		{method: "GET", route: "/roles/:role_id", allow: admins},
+		{method: "DELETE", route: "/roles/:role_id", allow: admins},
+		{method: "GET", route: "/admin/roles/export", allow: admins},
+		{method: "GET", route: "/admin/roles/apply", allow: admins},
+		{method: "POST", route: "/admin/roles/apply", allow: admins},
+		{method: "POST", route: "/admin/permissions/:role_id", allow: admins},
+		{method: "POST", route: "/admin/users/:user_id/impersonate", allow: admins},
+		{method: "GET", route: "/admin/impersonate/stop", allow: loggedIn},
+		{method: "GET", route: "/admin/impersonations", allow: admins},
+		{method: "GET", route: "/admin/jobs", allow: admins},
+		{method: "POST", route: "/admin/jobs/:job_id/retry", allow: admins},
+		{method: "POST", route: "/admin/jobs/:job_id/discard", allow: admins},
+		{method: "GET", route: "/admin/labels", allow: admins},
+		{method: "POST", route: "/labels/:label_id/update", allow: admins, body: labelBody},
+		{method: "POST", route: "/labels/:label_id/merge", allow: admins, body: func(fx demoFixtures) *testBody {
+			return form(url.Values{"into_id": {id(fx.LabelIDs[1])}})
+		}},
+
+		// users
+		{method: "GET", route: "/users/:user_id", allow: managers},
+		{method: "GET", route: "/users/:user_id/projects", allow: managers},
+		{method: "GET", route: "/users/:user_id/features", allow: loggedIn},
+		{method: "GET", route: "/users/:user_id/stories", allow: loggedIn},
+		{method: "GET", route: "/users/:user_id/bugs", allow: loggedIn},
+		// creating users isn't guarded
+		{method: "POST", route: "/users", allow: anyone, body: newUserBody},
+		{method: "DELETE", route: "/users/:user_id", allow: admins},
+
+		// projects
+		{method: "GET", route: "/projects", allow: loggedIn},
+		{method: "POST", route: "/projects", allow: managers},
+		{method: "GET", route: "/projects/:project_id", allow: loggedIn},
+		{method: "GET", route: "/projects/:project_id", path: "/projects/new", allow: managers},
+		{method: "GET", route: "/projects/:project_id/edit", allow: managers},
+		{method: "POST", route: "/projects/:project_id/update", allow: managers},
+		{method: "DELETE", route: "/projects/:project_id", allow: managers},
+
+		// features
+		{method: "GET", route: "/features", allow: loggedIn},
+		{method: "GET", route: "/projects/:project_id/features", allow: loggedIn},
+		{method: "POST", route: "/projects/:project_id/features", allow: managers},
+		{method: "GET", route: "/projects/:project_id/features/new", allow: managers},
+		{method: "GET", route: "/features/:feature_id", allow: loggedIn},
+		{method: "GET", route: "/features/:feature_id/edit", allow: managers},
+		{method: "POST", route: "/features/:feature_id/update", allow: managers},
+		{method: "DELETE", route: "/features/:feature_id", allow: managers},
+
+		// stories
+		{method: "GET", route: "/stories", allow: loggedIn},
+		{method: "GET", route: "/features/:feature_id/stories", allow: loggedIn},
+		{method: "POST", route: "/features/:feature_id/stories", allow: loggedIn},
+		{method: "GET", route: "/features/:feature_id/stories/new", allow: loggedIn},
+		{method: "GET", route: "/stories/:story_id", allow: loggedIn},
+		{method: "GET", route: "/stories/:story_id/edit", allow: []string{"developer", "manager", "admin"}},
+		{method: "GET", route: "/stories/:story_id/edit", path: "/stories/" + id(fx.StoryIDs[2]) + "/edit", allow: managers},
+		{method: "POST", route: "/stories/:story_id/update", allow: []string{"developer", "manager", "admin"}},
+		{method: "POST", route: "/stories/:story_id/rank-before", allow: []string{"developer", "manager", "admin"}, body: rankBody("story_id", fx.StoryIDs[1])},
+		{method: "POST", route: "/stories/:story_id/rank-after", allow: []string{"developer", "manager", "admin"}, body: rankBody("story_id", fx.StoryIDs[2])},
+		{method: "POST", route: "/stories/:story_id/links", allow: []string{"developer", "manager", "admin"}, body: linkBody("bug", fx.BugIDs[1])},
+		{method: "POST", route: "/stories/:story_id/links/:link_id/delete", allow: []string{"developer", "manager", "admin"}},
+		{method: "GET", route: "/stories/:story_id/restore", allow: managers, mutates: true},
+		{method: "DELETE", route: "/stories/:story_id", allow: managers},
+
+		// bugs
+		{method: "GET", route: "/bugs", allow: loggedIn},
+		{method: "GET", route: "/features/:feature_id/bugs", allow: loggedIn},
+		{method: "POST", route: "/features/:feature_id/bugs", allow: testers},
+		{method: "GET", route: "/features/:feature_id/bugs/new", allow: testers},
+		{method: "GET", route: "/bugs/:bug_id", allow: loggedIn},
+		{method: "GET", route: "/bugs/:bug_id/edit", allow: loggedIn},
+		{method: "GET", route: "/bugs/:bug_id/edit", path: "/bugs/" + id(fx.BugIDs[1]) + "/edit", allow: testers},
+		{method: "POST", route: "/bugs/:bug_id/update", allow: loggedIn},
+		{method: "POST", route: "/bugs/:bug_id/rank-before", allow: loggedIn, body: rankBody("bug_id", fx.BugIDs[1])},
+		{method: "POST", route: "/bugs/:bug_id/rank-after", allow: loggedIn, body: rankBody("bug_id", fx.BugIDs[1])},
+		{method: "POST", route: "/bugs/:bug_id/links", allow: loggedIn, body: linkBody("story", fx.StoryIDs[2])},
+		{method: "POST", route: "/bugs/:bug_id/links/:link_id/delete", allow: loggedIn},
+		{method: "DELETE", route: "/bugs/:bug_id", allow: managers},
+
+		// sprints
+		{method: "GET", route: "/projects/:project_id/sprints", allow: loggedIn},
+		{method: "POST", route: "/projects/:project_id/sprints", allow: managers, body: sprintBody},
+		{method: "GET", route: "/sprints/:sprint_id", allow: loggedIn},
+		{method: "POST", route: "/sprints/:sprint_id/update", allow: managers, body: sprintBody},
+		{method: "POST", route: "/sprints/:sprint_id/start", allow: managers},
+		{method: "POST", route: "/sprints/:sprint_id/close", allow: managers},
+		{method: "POST", route: "/sprints/:sprint_id/add", allow: managers, body: func(fx demoFixtures) *testBody {
+			return form(url.Values{"story_id": {id(fx.StoryIDs[2])}})
+		}},
+		{method: "POST", route: "/sprints/:sprint_id/remove", allow: managers, body: func(fx demoFixtures) *testBody {
+			return form(url.Values{"bug_id": {id(fx.BugIDs[0])}})
+		}},
+
+		// boards
+		{method: "GET", route: "/projects/:project_id/board", allow: loggedIn},
+		{method: "POST", route: "/projects/:project_id/board/move", allow: []string{"developer", "manager", "admin"}, body: func(fx demoFixtures) *testBody {
+			return jsonBody(map[string]string{"type": "story", "id": id(fx.StoryIDs[0]), "column_id": id(fx.ColumnIDs[2])})
+		}},
+		{method: "GET", route: "/projects/:project_id/board/columns", allow: managers},
+		{method: "POST", route: "/projects/:project_id/board/columns", allow: managers, body: columnBody},
+		{method: "POST", route: "/board/columns/:column_id/update", allow: managers, body: columnBody},
+		{method: "POST", route: "/board/columns/:column_id/delete", allow: managers},
+
+		// labels
+		{method: "GET", route: "/projects/:project_id/labels", allow: managers},
+		{method: "POST", route: "/projects/:project_id/labels", allow: managers, body: labelBody},
+	}
+}
+
+// labelBody is the form for a label.
+func labelBody(demoFixtures) *testBody {
+	return form(url.Values{"name": {"backend"}, "color": {"#0e8a16"}})
+}
+
+// columnBody is the form for a board column.
+func columnBody(demoFixtures) *testBody {
+	return form(url.Values{"name": {"Review"}, "wip_limit": {"3"}})
+}
+
+// rankBody names the story or bug to rank another next to.
+func rankBody(key string, targetID int64) func(demoFixtures) *testBody {
+	return func(demoFixtures) *testBody {
+		return jsonBody(map[string]string{key: strconv.FormatInt(targetID, 10)})
+	}
+}
+
+// linkBody links the issue in the route to the given one, which it blocks.
+func linkBody(targetType string, targetID int64) func(demoFixtures) *testBody {
+	return func(demoFixtures) *testBody {
+		return form(url.Values{"link_type": {"blocks"}, "target_type": {targetType}, "target_id": {strconv.FormatInt(targetID, 10)}})
+	}
+}
+
+// sprintBody is the form for a two week sprint.
+func sprintBody(demoFixtures) *testBody {
+	return form(url.Values{"name": {"Sprint 2"}, "starts_on": {"2021-06-14"}, "ends_on": {"2021-06-27"}})
+}
+
+// newUserBody is a registration for a user that doesn't exist yet.
+func newUserBody(demoFixtures) *testBody {
+	return multipartForm(url.Values{
+		"name":     {"Nova Newcomer"},
+		"username": {"nova"},
+		"email":    {"nova@example.com"},
+		"password": {"secret"},
+	})
+}
+
+// routePath fills in a route's parameters from the fixtures.
+func routePath(route string, fx demoFixtures) string {
+	id := func(n int64) string { return strconv.FormatInt(n, 10) }
+
+	return strings.NewReplacer(
+		":project_id", id(fx.ProjectID),
+		":feature_id", id(fx.FeatureID),
+		":story_id", id(fx.StoryIDs[0]),
+		":bug_id", id(fx.BugIDs[0]),
+		":user_id", id(fx.Users["developer_demo"]),
+		":role_id", id(fx.Roles["Developer"]),
+		":sprint_id", id(fx.SprintID),
+		":column_id", id(fx.ColumnIDs[0]),
+		":link_id", id(fx.LinkID),
+		":label_id", id(fx.LabelIDs[0]),
+		":job_id", "1",
+		// an unknown role, so the check doesn't log the client in as someone else
+		":role", "nobody",
+		"*filepath", "missing.txt",
+	).Replace(route)
+}
+
+func TestPermissionMatrix(t *testing.T) {
+	a := testApplication(t)
+
+	matrix := permissionMatrix(a.fixtures)
+
+	covered := make(map[string]bool)
+
+	for _, rc := range matrix {
+		covered[rc.method+" "+rc.route] = true
+	}
+
+	for _, route := range registeredRoutes {
+		if !covered[route] {
+			t.Errorf("%s isn't in the permission matrix", route)
+		}
+	}
+
+	a.reset(t)
+
+	dirty := false
+
+	for _, rc := range matrix {
+		path := rc.path
+
+		if path == "" {
+			path = routePath(rc.route, a.fixtures)
+		}
+
+		allowed := make(map[string]bool)
+
+		for _, role := range rc.allow {
+			allowed[role] = true
+		}
+
+		for _, role := range anyone {
+			if dirty {
+				a.reset(t)
+				dirty = false
+			}
+
+			c := a.client(t)
+
+			if role != "guest" {
+				c = a.loginAs(t, role)
+			}
+
+			var body *testBody
+
+			if rc.body != nil {
+				body = rc.body(a.fixtures)
+			}
+
+			resp := c.do(rc.method, path, body)
+
+			dirty = rc.method != http.MethodGet || rc.mutates
+
+			if rc.toLogin && role == "guest" {
+				if resp.StatusCode != http.StatusSeeOther {
+					t.Errorf("%s %s as guest: got %d, want 303", rc.method, path, resp.StatusCode)
+				}
+
+				continue
+			}
+
+			checkAccess(t, rc.method+" "+path+" as "+role, resp, role, allowed[role])
+		}
+	}
+}
+
+// checkAccess fails the test unless the response allowed or denied the request as expected.
+// Guests are sent to the login page, or get a 401 from anything but GET;
+// users without permission get a 403. An allowed request may still be refused
+// for other reasons, eg: a 404 or 422, but must not fail with a 5xx.
+func checkAccess(t *testing.T, name string, resp *http.Response, role string, allowed bool) {
+	t.Helper()
+
+	toLogin := resp.StatusCode == http.StatusSeeOther && resp.Header.Get("Location") == "/login"
+	denied := toLogin || resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden
+
+	switch {
+	case resp.StatusCode >= 500:
+		t.Errorf("%s: got %d: %s", name, resp.StatusCode, bodyString(resp))
+
+	case allowed && denied:
+		t.Errorf("%s: got %d, want it allowed", name, resp.StatusCode)
+
+	case !allowed && role == "guest" && resp.Request.Method == http.MethodGet && !toLogin:
+		t.Errorf("%s: got %d, want a redirect to /login", name, resp.StatusCode)
+
+	case !allowed && role == "guest" && resp.Request.Method != http.MethodGet && resp.StatusCode != http.StatusUnauthorized:
+		t.Errorf("%s: got %d, want 401", name, resp.StatusCode)
+
+	case !allowed && role != "guest" && resp.StatusCode != http.StatusForbidden:
+		t.Errorf("%s: got %d, want 403", name, resp.StatusCode)
+	}
+}
+
+// TestLoginEndsOtherSessions checks logging in again signs out the user's other
+// session at once, even though it was cached.
+func TestLoginEndsOtherSessions(t *testing.T) {
+	a := testApplication(t)
+
+	a.reset(t)
+
+	first := a.loginAs(t, "developer")
+
+	if resp := first.get("/dashboard"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("dashboard: got %d", resp.StatusCode)
+	}
+
+	second := a.loginAs(t, "developer")
+
+	if resp := first.get("/dashboard"); resp.StatusCode == http.StatusOK {
+		t.Errorf("dashboard in the first session after logging in again: got %d", resp.StatusCode)
+	}
+
+	if resp := second.get("/dashboard"); resp.StatusCode != http.StatusOK {
+		t.Errorf("dashboard in the second session: got %d", resp.StatusCode)
+	}
+}
+
+// TestDemoLogins checks every demo role can log in and load the dashboard,
+// and that the capabilities from the fixtures reach the session.
+func TestDemoLogins(t *testing.T) {
+	a := testApplication(t)
+
+	a.reset(t)
+
+	want := map[string][]string{
+		"developer": {"read_features", "update_stories_mine"},
+		"qa":        {"read_features", "create_bugs"},
+		"manager":   {"create_projects", "create_bugs", "read_users"},
+		"admin":     {"admin", "update_permissions"},
+	}
+
+	for _, role := range demoRoleNames {
+		c := a.loginAs(t, role)
+
+		resp := c.get("/dashboard")
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: dashboard got %d", role, resp.StatusCode)
+		}
+
+		authUser, err := auth.stores.sessions.user(sessionCookie(c))
+
+		if err != nil {
+			t.Fatalf("%s: session: %v", role, err)
+		}
+
+		permissions, err := admin.getRolePermissions(authUser.RoleID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names := []string{}
+
+		for name := range permissions {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, capability := range want[role] {
+			if _, ok := permissions[capability]; !ok {
+				t.Errorf("%s: missing %s, has %v", role, capability, names)
+			}
+		}
+	}
+}
+
+// sessionCookie returns the client's session id.
+func sessionCookie(c *testClient) string {
+	u, _ := url.Parse(c.app.server.URL)
+
+	for _, cookie := range c.http.Jar.Cookies(u) {
+		if cookie.Name == cfg.CookieName {
+			return cookie.Value
+		}
+	}
+
+	return ""
+}
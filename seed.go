@@ -0,0 +1,343 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// demoRoles are the roles behind the demo logins on the home page.
+var demoRoles = roleFile{Roles: []roleSpec{
+	{
+		Name:        "Developer",
+		Description: "Works on the stories and bugs assigned to them.",
+		Capabilities: []string{
+			"read_projects_mine", "read_projects_others",
+			"read_features",
+			"create_stories", "read_stories_mine", "read_stories_others", "update_stories_mine",
+			"read_bugs_mine", "read_bugs_others", "update_bugs_mine",
+		},
+	},
+	{
+		Name:        "QA",
+		Description: "Logs and verifies bugs.",
+		Inherits:    "Developer",
+		Capabilities: []string{
+			"create_bugs", "update_bugs_others",
+		},
+	},
+	{
+		Name:        "Manager",
+		Description: "Runs projects and plans the work.",
+		Inherits:    "QA",
+		Capabilities: []string{
+			"create_projects", "update_projects_mine", "delete_projects_mine",
+			"create_features", "update_features", "delete_features",
+			"update_stories_others", "delete_stories_mine", "delete_stories_others",
+			"delete_bugs_mine", "delete_bugs_others",
+			"set_p0_priority",
+			"plan_sprints",
+			"read_users",
+		},
+	},
+}}
+
+func runSeed(args []string) error {
+	flags := commandFlags("seed", "seed [-password password]",
+		"Load the demo roles and users (admin_demo, manager_demo, qa_demo and developer_demo)\n"+
+			"and a sample project. Does nothing if the demo users already exist.")
+	password := flags.String("password", "demo", "password for every demo user")
+
+	err := flags.Parse(args)
+
+	if err != nil {
+		return err
+	}
+
+	db, err := connect()
+
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	store, err := startServices(db)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = seedDemo(store, *password)
+
+	if err == errDemoLoaded {
+		fmt.Println("The demo data is already loaded.")
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Loaded the demo data. Every demo user's password is", *password)
+
+	return nil
+}
+
+// demoFixtures are the ids of the rows seedDemo creates.
+type demoFixtures struct {
+	// Users and Roles are keyed by username and role name.
+	Users     map[string]int64
+	Roles     map[string]int64
+	ProjectID int64
+	FeatureID int64
+	// StoryIDs and BugIDs are in the order they're listed in seedDemo.
+	StoryIDs []int64
+	BugIDs   []int64
+	// SprintID is the project's active sprint.
+	SprintID int64
+	// ColumnIDs are the project's board columns, left to right.
+	ColumnIDs []int64
+	// LinkID links the first bug to the first story, which it blocks.
+	LinkID int64
+	// LabelIDs are the project's labels: frontend, regression and customer-reported.
+	LabelIDs []int64
+}
+
+var errDemoLoaded = errors.New("the demo data is already loaded")
+
+// seedDemo loads the demo roles, users, project, feature, stories and bugs.
+// It returns errDemoLoaded if the demo users already exist.
+func seedDemo(store stores, password string) (demoFixtures, error) {
+	fixtures := demoFixtures{Users: make(map[string]int64)}
+
+	_, err := store.users.findByUsername("admin_demo")
+
+	if err == nil {
+		return fixtures, errDemoLoaded
+	}
+
+	if err != sql.ErrNoRows {
+		return fixtures, err
+	}
+
+	plan, err := admin.planRoles(demoRoles)
+
+	if err != nil {
+		return fixtures, err
+	}
+
+	err = admin.applyRoles(plan)
+
+	if err != nil {
+		return fixtures, err
+	}
+
+	roles, err := store.roles.all()
+
+	if err != nil {
+		return fixtures, err
+	}
+
+	roleIDs := map[string]int64{"Admin": ADMIN}
+
+	for _, roleData := range roles {
+		roleIDs[roleData.Name] = roleData.ID
+	}
+
+	fixtures.Roles = roleIDs
+
+	hash, err := hashPassword(password)
+
+	if err != nil {
+		return fixtures, err
+	}
+
+	demoUsers := []struct {
+		username string
+		name     string
+		role     string
+	}{
+		{"admin_demo", "Ada Admin", "Admin"},
+		{"manager_demo", "Morgan Manager", "Manager"},
+		{"qa_demo", "Quinn QA", "QA"},
+		{"developer_demo", "Devon Developer", "Developer"},
+	}
+
+	userIDs := fixtures.Users
+
+	for _, u := range demoUsers {
+		id, err := store.users.create(user{
+			Name:     u.name,
+			Email:    u.username + "@example.com",
+			Username: u.username,
+			Password: hash,
+			RoleID:   roleIDs[u.role],
+		})
+
+		if err != nil {
+			return fixtures, err
+		}
+
+		userIDs[u.username] = id
+	}
+
+	manager := userIDs["manager_demo"]
+	qa := userIDs["qa_demo"]
+	developer := userIDs["developer_demo"]
+
+	fixtures.ProjectID, err = store.projects.create(project{
+		Name:        "Go Issuez",
+		Description: "The issue tracker itself.",
+		UserID:      manager,
+		PointScale:  scaleFibonacci,
+		TrackTime:   true,
+	})
+
+	if err != nil {
+		return fixtures, err
+	}
+
+	fixtures.FeatureID, err = store.features.create(feature{
+		Name:        "Authentication",
+		Description: "Registering, logging in and out, and demo logins.",
+		ProjectID:   fixtures.ProjectID,
+		UserID:      manager,
+	})
+
+	if err != nil {
+		return fixtures, err
+	}
+
+	seedStories := []story{
+		{Name: "Log in with a username and password", AssigneeID: developer, Priority: P1, Points: 5, EstimateMinutes: 2 * minutesPerDay},
+		{Name: "Remember the session for a day", AssigneeID: developer, Priority: P2, Points: 2, EstimateMinutes: 3 * 60},
+		{Name: "Upload a profile photo when registering", Priority: P3},
+	}
+
+	for _, s := range seedStories {
+		s.FeatureID = fixtures.FeatureID
+		s.UserID = manager
+
+		id, err := store.stories.create(s)
+
+		if err != nil {
+			return fixtures, err
+		}
+
+		fixtures.StoryIDs = append(fixtures.StoryIDs, id)
+	}
+
+	seedBugs := []bug{
+		{Name: "Deleted stories still count towards a feature", Description: "The features page counts every story, deleted or not.", AssigneeID: developer, Priority: P2, Severity: severityMinor},
+		{Name: "Photo uploads with the same name overwrite each other", Priority: P1, Severity: severityCritical},
+	}
+
+	for _, b := range seedBugs {
+		b.FeatureID = fixtures.FeatureID
+		b.UserID = qa
+
+		id, err := store.bugs.create(b)
+
+		if err != nil {
+			return fixtures, err
+		}
+
+		fixtures.BugIDs = append(fixtures.BugIDs, id)
+	}
+
+	today := time.Now()
+
+	fixtures.SprintID, err = store.sprints.create(sprint{
+		ProjectID: fixtures.ProjectID,
+		Name:      "Sprint 1",
+		Goal:      "Logging in works end to end.",
+		StartsOn:  today.Format(dateLayout),
+		EndsOn:    today.AddDate(0, 0, sprintLength-1).Format(dateLayout),
+	})
+
+	if err != nil {
+		return fixtures, err
+	}
+
+	err = store.sprints.start(fixtures.SprintID)
+
+	if err != nil {
+		return fixtures, err
+	}
+
+	// the first two stories and the first bug are planned, the rest wait in the backlog
+	for _, id := range fixtures.StoryIDs[:2] {
+		err = store.stories.setSprint(id, fixtures.SprintID)
+
+		if err != nil {
+			return fixtures, err
+		}
+	}
+
+	err = store.bugs.setSprint(fixtures.BugIDs[0], fixtures.SprintID)
+
+	if err != nil {
+		return fixtures, err
+	}
+
+	columns, err := store.boards.columns(fixtures.ProjectID)
+
+	if err != nil {
+		return fixtures, err
+	}
+
+	for _, c := range columns {
+		fixtures.ColumnIDs = append(fixtures.ColumnIDs, c.ID)
+	}
+
+	// the first story is being worked on
+	err = store.stories.setColumn(fixtures.StoryIDs[0], fixtures.ColumnIDs[1])
+
+	if err != nil {
+		return fixtures, err
+	}
+
+	fixtures.LinkID, err = store.links.create(issueLink{
+		Type:   "blocks",
+		From:   issueRef{Type: "bug", ID: fixtures.BugIDs[0]},
+		To:     issueRef{Type: "story", ID: fixtures.StoryIDs[0]},
+		UserID: fixtures.Users["qa_demo"],
+	})
+
+	if err != nil {
+		return fixtures, err
+	}
+
+	seedLabels := []label{
+		{Name: "frontend", Color: "#1f6feb"},
+		{Name: "regression", Color: "#d73a4a"},
+		{Name: "customer-reported", Color: "#fbca04"},
+	}
+
+	for _, l := range seedLabels {
+		l.ProjectID = fixtures.ProjectID
+
+		id, err := store.labels.create(l)
+
+		if err != nil {
+			return fixtures, err
+		}
+
+		fixtures.LabelIDs = append(fixtures.LabelIDs, id)
+	}
+
+	// the photo upload story is frontend work, and the first bug a reported regression
+	err = store.labels.setStoryLabels(fixtures.StoryIDs[2], fixtures.LabelIDs[:1])
+
+	if err == nil {
+		err = store.labels.setBugLabels(fixtures.BugIDs[0], fixtures.LabelIDs[1:])
+	}
+
+	if err != nil {
+		return fixtures, err
+	}
+
+	return fixtures, nil
+}
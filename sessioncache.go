@@ -0,0 +1,121 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// sessionCache keeps the user and permissions resolved for each session,
+// so a request doesn't query them again for every check and render.
+// Entries last for ttl. Changes to users, roles or permissions drop the entries
+// they could affect straight away; a ttl of 0 turns the cache off.
+type sessionCache struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]sessionCacheEntry
+	lastSweep time.Time
+	// generation goes up on every invalidation, so a user loaded before one
+	// isn't stored after it.
+	generation uint64
+}
+
+type sessionCacheEntry struct {
+	user    user
+	expires time.Time
+}
+
+func newSessionCache(ttl time.Duration) *sessionCache {
+	return &sessionCache{ttl: ttl, entries: make(map[string]sessionCacheEntry), lastSweep: time.Now()}
+}
+
+// get returns the cached user of a session, if it hasn't expired.
+// The Permissions map is shared between requests and must not be changed.
+func (c *sessionCache) get(uuid string) (user, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[uuid]
+
+	if !ok {
+		return user{}, false
+	}
+
+	if time.Now().After(entry.expires) {
+		delete(c.entries, uuid)
+		return user{}, false
+	}
+
+	return entry.user, true
+}
+
+// begin is called before loading a session's user and its result passed to put.
+func (c *sessionCache) begin() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// put caches the user of a session, unless the cache was invalidated since begin.
+func (c *sessionCache) put(uuid string, userData user, generation uint64) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	now := time.Now()
+
+	c.entries[uuid] = sessionCacheEntry{user: userData, expires: now.Add(c.ttl)}
+
+	// sessions that stop making requests would otherwise stay forever
+	if now.Sub(c.lastSweep) > c.ttl {
+		for key, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+
+		c.lastSweep = now
+	}
+}
+
+// forget drops one session, eg: when it logs out or starts viewing as another user.
+func (c *sessionCache) forget(uuid string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	delete(c.entries, uuid)
+}
+
+// forgetUser drops every session of the user,
+// including those where an admin is viewing as them or they as someone else.
+func (c *sessionCache) forgetUser(userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	for key, entry := range c.entries {
+		if entry.user.ID == userID || (entry.user.Impersonator != nil && entry.user.Impersonator.ID == userID) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// clear drops every session. Role and permission changes reach any role
+// inheriting from the one changed, so they clear everything.
+func (c *sessionCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.entries = make(map[string]sessionCacheEntry)
+}
@@ -0,0 +1,687 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+type sprintService struct {
+	stores stores
+	log    *logrus.Logger
+	tpls   *templateRegistry
+}
+
+// sprintState is where a sprint is in its life: planned, then active, then closed.
+type sprintState string
+
+const (
+	sprintPlanned sprintState = "planned"
+	sprintActive  sprintState = "active"
+	sprintClosed  sprintState = "closed"
+)
+
+// Label is the state as shown on the page.
+func (s sprintState) Label() string {
+	if s == "" {
+		return ""
+	}
+
+	return strings.ToUpper(string(s[:1])) + string(s[1:])
+}
+
+// Badge is the bootstrap badge class the state is shown with.
+func (s sprintState) Badge() string {
+	switch s {
+	case sprintActive:
+		return "badge-success"
+	case sprintPlanned:
+		return "badge-info"
+	}
+
+	return "badge-secondary"
+}
+
+// errSprintActive is returned when starting a sprint while another of the project's sprints is active.
+var errSprintActive = errors.New("the project already has an active sprint")
+
+// sprintLength is how long a new sprint runs by default, first and last day included.
+const sprintLength = 14
+
+// dateLayout is how sprint dates are written in forms and stored.
+const dateLayout = "2006-01-02"
+
+type sprint struct {
+	ID        int64
+	ProjectID int64
+	Name      string
+	Goal      string
+	// StartsOn and EndsOn are the first and last days of the sprint, eg: "2021-06-01".
+	StartsOn  string
+	EndsOn    string
+	State     sprintState
+	CreatedAt string
+	UpdatedAt string
+	ClosedAt  string
+}
+
+// Closed reports whether the sprint is over. Closed sprints can't be changed.
+func (s sprint) Closed() bool {
+	return s.State == sprintClosed
+}
+
+// path is the sprint's planning page.
+func (s sprint) path() string {
+	return "/sprints/" + strconv.FormatInt(s.ID, 10)
+}
+
+// Done reports whether the story has been marked done.
+func (s story) Done() bool {
+	return s.CompletedAt != ""
+}
+
+// Done reports whether the bug has been marked done.
+func (b bug) Done() bool {
+	return b.CompletedAt != ""
+}
+
+// sprintProgress counts what's in a sprint and how much of it is done.
+type sprintProgress struct {
+	Issues     int
+	Done       int
+	Points     int
+	DonePoints int
+}
+
+// Open is how many stories and bugs in the sprint aren't done.
+func (p sprintProgress) Open() int {
+	return p.Issues - p.Done
+}
+
+func newSprintProgress(stories []story, bugs []bug) sprintProgress {
+	progress := sprintProgress{Issues: len(stories) + len(bugs)}
+
+	for _, st := range stories {
+		progress.Points += st.Points
+
+		if st.Done() {
+			progress.Done++
+			progress.DonePoints += st.Points
+		}
+	}
+
+	for _, b := range bugs {
+		if b.Done() {
+			progress.Done++
+		}
+	}
+
+	return progress
+}
+
+func NewSprintService(store stores, log *logrus.Logger, tpls *templateRegistry) *sprintService {
+	return &sprintService{store, log, tpls}
+}
+
+// canPlan reports whether the user may create, change, start and close sprints.
+func canPlan(authUser user) bool {
+	return authUser.IsAdmin || authUser.Can([]string{"plan_sprints"})
+}
+
+// readSprint reads the name, goal and dates of a sprint form into sprintData.
+func readSprint(form url.Values, sprintData *sprint) error {
+	sprintData.Name = strings.TrimSpace(form.Get("name"))
+	sprintData.Goal = strings.TrimSpace(form.Get("goal"))
+
+	if sprintData.Name == "" {
+		return validationError("Give the sprint a name.")
+	}
+
+	startsOn, err := time.Parse(dateLayout, form.Get("starts_on"))
+
+	if err != nil {
+		return validationError("Pick the day the sprint starts.")
+	}
+
+	endsOn, err := time.Parse(dateLayout, form.Get("ends_on"))
+
+	if err != nil {
+		return validationError("Pick the day the sprint ends.")
+	}
+
+	if endsOn.Before(startsOn) {
+		return validationError("A sprint can't end before it starts.")
+	}
+
+	sprintData.StartsOn = startsOn.Format(dateLayout)
+	sprintData.EndsOn = endsOn.Format(dateLayout)
+
+	return nil
+}
+
+// index lists a project's sprints with a form to plan the next one.
+func (s *sprintService) index(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectData, err := s.stores.projects.find(parseID(ps.ByName("project_id")))
+
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Project not found."))
+		return
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error sprints.index.project.", err)
+
+		respondError(w, r, internalError("Error listing sprints.", nil))
+		return
+	}
+
+	sprints, err := s.stores.sprints.byProject(projectData.ID)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error sprints.index.query.", err)
+
+		respondError(w, r, internalError("Error listing sprints.", nil))
+		return
+	}
+
+	// the next sprint starts the day after the latest one ends
+	startsOn := time.Now()
+
+	if len(sprints) > 0 {
+		latestEnd, err := time.Parse(dateLayout, sprints[0].EndsOn)
+
+		if err == nil && latestEnd.After(startsOn) {
+			startsOn = latestEnd.AddDate(0, 0, 1)
+		}
+	}
+
+	next := sprint{
+		Name:     "Sprint " + strconv.Itoa(len(sprints)+1),
+		StartsOn: startsOn.Format(dateLayout),
+		EndsOn:   startsOn.AddDate(0, 0, sprintLength-1).Format(dateLayout),
+	}
+
+	pageData := page{
+		Title: projectData.Name + " Sprints",
+		Data: struct {
+			Project project
+			Sprints []sprint
+			Next    sprint
+			CanPlan bool
+		}{
+			projectData,
+			sprints,
+			next,
+			canPlan(currentUser(r)),
+		},
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+
+	view := viewService{w: w, r: r}
+	view.make("sprints/index.gohtml")
+	err = view.exec(mainLayout, pageData)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
+
+		return
+	}
+
+	view.send(http.StatusOK)
+}
+
+func (s *sprintService) store(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectData, err := s.stores.projects.find(parseID(ps.ByName("project_id")))
+
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Project not found."))
+		return
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error sprints.store.project.", err)
+
+		respondError(w, r, internalError("Error saving sprint.", nil))
+		return
+	}
+
+	r.ParseForm()
+
+	sprintData := sprint{ProjectID: projectData.ID}
+
+	err = readSprint(r.PostForm, &sprintData)
+
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	id, err := s.stores.sprints.create(sprintData)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error sprints.store.exec.", err)
+
+		respondError(w, r, internalError("Error saving sprint.", nil))
+		return
+	}
+
+	http.Redirect(w, r, sprint{ID: id}.path(), http.StatusSeeOther)
+}
+
+// show is the sprint planning page: the project's backlog beside what's in the sprint.
+func (s *sprintService) show(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	sprintData, projectData, ok := s.find(w, r, ps, "show")
+
+	if !ok {
+		return
+	}
+
+	stories, err := s.stores.stories.bySprint(sprintData.ID)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error sprints.show.stories.", err)
+
+		respondError(w, r, internalError("Error getting sprint.", nil))
+		return
+	}
+
+	bugs, err := s.stores.bugs.bySprint(sprintData.ID)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error sprints.show.bugs.", err)
+
+		respondError(w, r, internalError("Error getting sprint.", nil))
+		return
+	}
+
+	// a closed sprint can't take anything more, so there's no backlog to offer
+	backlogStories, backlogBugs := []story{}, []bug{}
+
+	if !sprintData.Closed() {
+		backlogStories, err = s.stores.stories.backlog(projectData.ID)
+
+		if err != nil {
+			s.log.WithContext(r.Context()).Error("Error sprints.show.backlog.stories.", err)
+
+			respondError(w, r, internalError("Error getting backlog.", nil))
+			return
+		}
+
+		backlogBugs, err = s.stores.bugs.backlog(projectData.ID)
+
+		if err != nil {
+			s.log.WithContext(r.Context()).Error("Error sprints.show.backlog.bugs.", err)
+
+			respondError(w, r, internalError("Error getting backlog.", nil))
+			return
+		}
+	}
+
+	// only what the user may read is listed, or counted in the progress
+	authUser := currentUser(r)
+
+	stories, bugs = readableStories(authUser, stories), readableBugs(authUser, bugs)
+	backlogStories, backlogBugs = readableStories(authUser, backlogStories), readableBugs(authUser, backlogBugs)
+
+	// the project is needed to show story points on its scale
+	for i := range stories {
+		stories[i].Project = &projectData
+	}
+
+	for i := range backlogStories {
+		backlogStories[i].Project = &projectData
+	}
+
+	for _, list := range [][]story{stories, backlogStories} {
+		if err == nil {
+			err = withStoryLabels(s.stores, list)
+		}
+	}
+
+	for _, list := range [][]bug{bugs, backlogBugs} {
+		if err == nil {
+			err = withBugLabels(s.stores, list)
+		}
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error sprints.show.labels.", err)
+
+		respondError(w, r, internalError("Error getting sprint.", nil))
+		return
+	}
+
+	// progress is the whole sprint's, whichever label the lists are filtered by
+	progress := newSprintProgress(stories, bugs)
+
+	labelName := r.URL.Query().Get("label")
+
+	var storyFilter, bugFilter, backlogStoryFilter, backlogBugFilter labelFilter
+
+	stories, storyFilter = filterStories(stories, labelName)
+	bugs, bugFilter = filterBugs(bugs, labelName)
+	backlogStories, backlogStoryFilter = filterStories(backlogStories, labelName)
+	backlogBugs, backlogBugFilter = filterBugs(backlogBugs, labelName)
+
+	filter := combineLabelFilters(storyFilter, bugFilter, backlogStoryFilter, backlogBugFilter)
+
+	// unfinished issues can be carried over to any sprint that hasn't started
+	others, err := s.stores.sprints.byProject(projectData.ID)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error sprints.show.others.", err)
+
+		respondError(w, r, internalError("Error getting sprint.", nil))
+		return
+	}
+
+	carryTo := []sprint{}
+
+	for _, other := range others {
+		if other.State == sprintPlanned && other.ID != sprintData.ID {
+			carryTo = append(carryTo, other)
+		}
+	}
+
+	pageData := page{
+		Title: sprintData.Name,
+		Data: struct {
+			Sprint         sprint
+			Project        project
+			Stories        []story
+			Bugs           []bug
+			BacklogStories []story
+			BacklogBugs    []bug
+			Progress       sprintProgress
+			CarryTo        []sprint
+			CanPlan        bool
+			Labels         labelFilter
+		}{
+			sprintData,
+			projectData,
+			stories,
+			bugs,
+			backlogStories,
+			backlogBugs,
+			progress,
+			carryTo,
+			canPlan(currentUser(r)),
+			filter,
+		},
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+
+	view := viewService{w: w, r: r}
+	view.make("sprints/sprint.gohtml")
+	err = view.exec(mainLayout, pageData)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
+
+		return
+	}
+
+	view.send(http.StatusOK)
+}
+
+func (s *sprintService) update(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	sprintData, _, ok := s.find(w, r, ps, "update")
+
+	if !ok {
+		return
+	}
+
+	if sprintData.Closed() {
+		respondError(w, r, conflictError("Closed sprints can't be changed."))
+		return
+	}
+
+	r.ParseForm()
+
+	err := readSprint(r.PostForm, &sprintData)
+
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	err = s.stores.sprints.update(sprintData)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error sprints.update.exec.", err)
+
+		respondError(w, r, internalError("Error updating sprint.", nil))
+		return
+	}
+
+	http.Redirect(w, r, sprintData.path(), http.StatusSeeOther)
+}
+
+func (s *sprintService) start(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	sprintData, _, ok := s.find(w, r, ps, "start")
+
+	if !ok {
+		return
+	}
+
+	err := s.stores.sprints.start(sprintData.ID)
+
+	if err == sql.ErrNoRows {
+		respondError(w, r, conflictError("Only planned sprints can be started."))
+		return
+	}
+
+	if err == errSprintActive {
+		respondError(w, r, conflictError("Close the project's active sprint before starting another."))
+		return
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error sprints.start.exec.", err)
+
+		respondError(w, r, internalError("Error starting sprint.", nil))
+		return
+	}
+
+	http.Redirect(w, r, sprintData.path(), http.StatusSeeOther)
+}
+
+// close ends an active sprint. What isn't done goes back to the backlog,
+// or on to the planned sprint picked in carry_to.
+func (s *sprintService) close(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	sprintData, _, ok := s.find(w, r, ps, "close")
+
+	if !ok {
+		return
+	}
+
+	r.ParseForm()
+
+	carryTo := parseID(r.PostForm.Get("carry_to"))
+
+	if carryTo != 0 {
+		next, err := s.stores.sprints.find(carryTo)
+
+		if err != nil && err != sql.ErrNoRows {
+			s.log.WithContext(r.Context()).Error("Error sprints.close.next.", err)
+
+			respondError(w, r, internalError("Error closing sprint.", nil))
+			return
+		}
+
+		if err == sql.ErrNoRows || next.ProjectID != sprintData.ProjectID || next.State != sprintPlanned {
+			respondError(w, r, validationError("Unfinished work can only be carried to one of the project's planned sprints."))
+			return
+		}
+	}
+
+	_, err := s.stores.sprints.close(sprintData.ID, carryTo)
+
+	if err == sql.ErrNoRows {
+		respondError(w, r, conflictError("Only active sprints can be closed."))
+		return
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error sprints.close.exec.", err)
+
+		respondError(w, r, internalError("Error closing sprint.", nil))
+		return
+	}
+
+	http.Redirect(w, r, sprintData.path(), http.StatusSeeOther)
+}
+
+// add moves the story_id or bug_id in the form from the backlog into the sprint.
+func (s *sprintService) add(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s.move(w, r, ps, true)
+}
+
+// remove moves the story_id or bug_id in the form from the sprint back to the backlog.
+func (s *sprintService) remove(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s.move(w, r, ps, false)
+}
+
+func (s *sprintService) move(w http.ResponseWriter, r *http.Request, ps httprouter.Params, into bool) {
+	action := "remove"
+
+	if into {
+		action = "add"
+	}
+
+	sprintData, _, ok := s.find(w, r, ps, action)
+
+	if !ok {
+		return
+	}
+
+	if sprintData.Closed() {
+		respondError(w, r, conflictError("Closed sprints can't be changed."))
+		return
+	}
+
+	r.ParseForm()
+
+	var featureID, inSprint int64
+	var setSprint func(id, sprintID int64) error
+	var id int64
+	var done, deleted bool
+	var err error
+
+	if storyID := parseID(r.PostForm.Get("story_id")); storyID != 0 {
+		var storyData story
+
+		storyData, err = s.stores.stories.find(storyID)
+		id, featureID, inSprint, setSprint = storyData.ID, storyData.FeatureID, storyData.SprintID, s.stores.stories.setSprint
+		done, deleted = storyData.Done(), storyData.DeletedAt != ""
+	} else {
+		var bugData bug
+
+		bugData, err = s.stores.bugs.find(parseID(r.PostForm.Get("bug_id")))
+		id, featureID, inSprint, setSprint = bugData.ID, bugData.FeatureID, bugData.SprintID, s.stores.bugs.setSprint
+		done, deleted = bugData.Done(), bugData.DeletedAt != ""
+	}
+
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Story or bug not found."))
+		return
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error sprints."+action+".issue.", err)
+
+		respondError(w, r, internalError("Error planning sprint.", nil))
+		return
+	}
+
+	featureData, err := s.stores.features.find(featureID)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error sprints."+action+".feature.", err)
+
+		respondError(w, r, internalError("Error planning sprint.", nil))
+		return
+	}
+
+	if featureData.ProjectID != sprintData.ProjectID {
+		respondError(w, r, validationError("Only the project's own stories and bugs can join its sprints."))
+		return
+	}
+
+	sprintID := sprintData.ID
+
+	if into {
+		if deleted {
+			respondError(w, r, validationError("Deleted stories and bugs can't join a sprint."))
+			return
+		}
+
+		if done {
+			respondError(w, r, validationError("Done stories and bugs can't join a sprint."))
+			return
+		}
+
+		if inSprint != 0 && inSprint != sprintData.ID {
+			respondError(w, r, validationError("The story or bug is already planned in another sprint."))
+			return
+		}
+	} else {
+		// something already moved elsewhere stays where it is
+		if inSprint != sprintData.ID {
+			http.Redirect(w, r, sprintData.path(), http.StatusSeeOther)
+			return
+		}
+
+		sprintID = 0
+	}
+
+	err = setSprint(id, sprintID)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error sprints."+action+".exec.", err)
+
+		respondError(w, r, internalError("Error planning sprint.", nil))
+		return
+	}
+
+	http.Redirect(w, r, sprintData.path(), http.StatusSeeOther)
+}
+
+// find looks up the sprint in the route and its project, responding with an error if it can't.
+func (s *sprintService) find(w http.ResponseWriter, r *http.Request, ps httprouter.Params, action string) (sprint, project, bool) {
+	sprintData, err := s.stores.sprints.find(parseID(ps.ByName("sprint_id")))
+
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Sprint not found."))
+		return sprint{}, project{}, false
+	}
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error sprints."+action+".find.", err)
+
+		respondError(w, r, internalError("Error getting sprint.", nil))
+		return sprint{}, project{}, false
+	}
+
+	projectData, err := s.stores.projects.find(sprintData.ProjectID)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error sprints."+action+".project.", err)
+
+		respondError(w, r, internalError("Error getting sprint.", nil))
+		return sprint{}, project{}, false
+	}
+
+	return sprintData, projectData, true
+}
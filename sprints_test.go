@@ -0,0 +1,220 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestSprintAdd checks only open stories and bugs that aren't in another sprint can join one.
+func TestSprintAdd(t *testing.T) {
+	m := newMemoryApp(t)
+
+	fx := m.fx
+
+	other, err := m.store.sprints.create(sprint{ProjectID: fx.ProjectID, Name: "Sprint 2", StartsOn: "2021-06-15", EndsOn: "2021-06-28"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.store.stories.setSprint(fx.StoryIDs[1], other)
+	m.store.stories.setDone(fx.StoryIDs[2], true)
+	m.store.bugs.destroy(fx.BugIDs[1])
+
+	cases := []struct {
+		name    string
+		form    url.Values
+		status  int
+		planned int64
+	}{
+		{"an open story", url.Values{"story_id": {formatID(fx.StoryIDs[0])}}, http.StatusSeeOther, fx.SprintID},
+		{"a story already in the sprint", url.Values{"story_id": {formatID(fx.StoryIDs[0])}}, http.StatusSeeOther, fx.SprintID},
+		{"a story in another sprint", url.Values{"story_id": {formatID(fx.StoryIDs[1])}}, http.StatusUnprocessableEntity, other},
+		{"a done story", url.Values{"story_id": {formatID(fx.StoryIDs[2])}}, http.StatusUnprocessableEntity, 0},
+		{"an open bug", url.Values{"bug_id": {formatID(fx.BugIDs[0])}}, http.StatusSeeOther, fx.SprintID},
+		{"a deleted bug", url.Values{"bug_id": {formatID(fx.BugIDs[1])}}, http.StatusUnprocessableEntity, 0},
+	}
+
+	for _, tc := range cases {
+		w := m.call(m.sprints.add, "POST", tc.form, "sprint_id", formatID(fx.SprintID))
+
+		if w.Code != tc.status {
+			t.Errorf("adding %s: got %d, want %d", tc.name, w.Code, tc.status)
+		}
+
+		var planned int64
+
+		if id := tc.form.Get("story_id"); id != "" {
+			storyData, _ := m.store.stories.find(parseID(id))
+			planned = storyData.SprintID
+		} else {
+			bugData, _ := m.store.bugs.find(parseID(tc.form.Get("bug_id")))
+			planned = bugData.SprintID
+		}
+
+		if planned != tc.planned {
+			t.Errorf("adding %s: planned in %d, want %d", tc.name, planned, tc.planned)
+		}
+	}
+}
+
+// TestSprintClose checks closing a sprint carries over only what isn't done.
+func TestSprintClose(t *testing.T) {
+	eachStore(t, func(t *testing.T, store stores, fx storeFixtures) {
+		next, err := store.sprints.create(sprint{ProjectID: fx.ProjectID, Name: "Sprint 2", StartsOn: "2021-06-15", EndsOn: "2021-06-28"})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, id := range fx.StoryIDs[:2] {
+			store.stories.setSprint(id, fx.SprintID)
+		}
+
+		for _, id := range fx.BugIDs {
+			store.bugs.setSprint(id, fx.SprintID)
+		}
+
+		store.stories.setDone(fx.StoryIDs[1], true)
+		store.bugs.setDone(fx.BugIDs[1], true)
+
+		err = store.sprints.start(fx.SprintID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		moved, err := store.sprints.close(fx.SprintID, next)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if moved != 2 {
+			t.Errorf("closing: moved %d, want 2", moved)
+		}
+
+		want := map[int64]int64{fx.StoryIDs[0]: next, fx.StoryIDs[1]: fx.SprintID, fx.StoryIDs[2]: 0}
+
+		for id, sprintID := range want {
+			storyData, _ := store.stories.find(id)
+
+			if storyData.SprintID != sprintID {
+				t.Errorf("story %d: in sprint %d, want %d", id, storyData.SprintID, sprintID)
+			}
+		}
+
+		want = map[int64]int64{fx.BugIDs[0]: next, fx.BugIDs[1]: fx.SprintID}
+
+		for id, sprintID := range want {
+			bugData, _ := store.bugs.find(id)
+
+			if bugData.SprintID != sprintID {
+				t.Errorf("bug %d: in sprint %d, want %d", id, bugData.SprintID, sprintID)
+			}
+		}
+
+		_, err = store.sprints.close(fx.SprintID, next)
+
+		if err != sql.ErrNoRows {
+			t.Errorf("closing again: got %v, want sql.ErrNoRows", err)
+		}
+
+		// without a sprint to carry over to, what's open goes back to the backlog
+		err = store.sprints.start(next)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		moved, err = store.sprints.close(next, 0)
+
+		if err != nil || moved != 2 {
+			t.Errorf("closing into the backlog: moved %d, %v", moved, err)
+		}
+
+		backlog, _ := store.stories.backlog(fx.ProjectID)
+
+		if len(backlog) != 2 {
+			t.Errorf("backlog: got %d stories, want 2", len(backlog))
+		}
+	})
+}
+
+// TestSprintShowReadable checks the sprint and its backlog only list what the user may read.
+func TestSprintShowReadable(t *testing.T) {
+	a := testApplication(t)
+
+	a.reset(t)
+	defer a.reset(t)
+
+	store := newSQLStores(a.db)
+	fx := a.fixtures
+
+	// developers may only read their own stories and bugs
+	own, err := store.roles.permissions(fx.Roles["Developer"])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []int64{}
+
+	for name, capData := range own {
+		if name != "read_stories_others" && name != "read_bugs_others" {
+			ids = append(ids, capData.ID)
+		}
+	}
+
+	err = store.roles.setPermissions(fx.Roles["Developer"], ids)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth.cache.clear()
+
+	// the second planned story is nobody's but the manager's now
+	storyData, err := store.stories.find(fx.StoryIDs[1])
+
+	if err == nil {
+		storyData.AssigneeID = 0
+		err = store.stories.update(storyData)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := a.loginAs(t, "developer")
+
+	resp := c.get("/sprints/" + formatID(fx.SprintID))
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("showing the sprint: got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	body := bodyString(resp)
+
+	if !strings.Contains(body, "Log in with a username and password") || !strings.Contains(body, "Deleted stories still count towards a feature") {
+		t.Errorf("the developer's own story and bug aren't listed")
+	}
+
+	for _, name := range []string{
+		"Remember the session for a day",
+		"Upload a profile photo when registering",
+		"Photo uploads with the same name overwrite each other",
+	} {
+		if strings.Contains(body, name) {
+			t.Errorf("%q is listed, but isn't the developer's", name)
+		}
+	}
+
+	// the progress only counts the story and bug listed
+	if !strings.Contains(body, "<strong>0</strong> of 2 done") || !strings.Contains(body, "(0 of 5 story points)") {
+		t.Errorf("the progress counts issues the developer can't read")
+	}
+}
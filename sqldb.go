@@ -0,0 +1,325 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// dialect describes how a database differs from the Postgres
+// the queries are written for.
+type dialect struct {
+	// driver is the database/sql driver name.
+	driver string
+	// schema replaces the "goissuez." prefix on table names.
+	schema string
+	// migrations is the directory migrate reads .sql files from.
+	migrations string
+}
+
+var postgresDialect = dialect{driver: "postgres", schema: "goissuez.", migrations: "migrations"}
+
+// SQLite has no schemas, so the tables live unqualified in the database file.
+var sqliteDialect = dialect{driver: "sqlite3", schema: "", migrations: "migrations/sqlite"}
+
+// sqliteNow is CURRENT_TIMESTAMP with milliseconds,
+// so rows created in the same second still sort in order.
+const sqliteNow = "strftime('%Y-%m-%d %H:%M:%f', 'now')"
+
+var placeholders = regexp.MustCompile(`\$(\d+)`)
+
+// rebind rewrites a Postgres query for the dialect.
+// Both drivers scan timestamp columns into time.Time,
+// so timestamps reach the stores in the same format either way.
+func (d dialect) rebind(query string) string {
+	query = strings.Replace(query, "goissuez.", d.schema, -1)
+
+	if d.driver == sqliteDialect.driver {
+		// $1 would be bound by order of appearance, ?1 is bound by number
+		query = placeholders.ReplaceAllString(query, "?$1")
+		query = strings.Replace(query, "CURRENT_TIMESTAMP", sqliteNow, -1)
+	}
+
+	return query
+}
+
+// secondsBetween returns an expression for the whole seconds from one timestamp to another.
+func (d dialect) secondsBetween(from, to string) string {
+	if d.driver == sqliteDialect.driver {
+		return "CAST(strftime('%s', " + to + ") - strftime('%s', " + from + ") AS INTEGER)"
+	}
+
+	return "EXTRACT(EPOCH FROM (" + to + " - " + from + "))::bigint"
+}
+
+// timestamp converts a time for storing, in the format the database writes its own timestamps in.
+func (d dialect) timestamp(t time.Time) interface{} {
+	if d.driver == sqliteDialect.driver {
+		return t.UTC().Format("2006-01-02 15:04:05.000")
+	}
+
+	return t.UTC()
+}
+
+// skipLocked ends a SELECT that claims rows inside a transaction, so concurrent
+// claimers pass over the rows another has locked instead of waiting for them.
+// SQLite has a single writer, so there is never anyone else to skip.
+func (d dialect) skipLocked() string {
+	if d.driver == sqliteDialect.driver {
+		return ""
+	}
+
+	return " FOR UPDATE SKIP LOCKED"
+}
+
+// resetSequence returns a query that moves the table's id sequence past its rows,
+// for after rows were inserted with their ids. SQLite keeps track by itself.
+func (d dialect) resetSequence(table string) string {
+	if d.driver == sqliteDialect.driver {
+		return ""
+	}
+
+	return "SELECT setval(pg_get_serial_sequence('" + d.schema + table + "', 'id'), COALESCE(max(id), 0) + 1, false) FROM " + d.schema + table
+}
+
+// isUniqueViolation reports whether err is an INSERT or UPDATE breaking a unique constraint.
+func isUniqueViolation(err error) bool {
+	if pqErr, ok := err.(*pq.Error); ok {
+		return pqErr.Code == "23505"
+	}
+
+	if sqliteErr, ok := err.(sqlite3.Error); ok {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+
+	return false
+}
+
+// isForeignKeyViolation reports whether err is a row still being referenced, or referencing one that's missing.
+func isForeignKeyViolation(err error) bool {
+	if pqErr, ok := err.(*pq.Error); ok {
+		return pqErr.Code == "23503"
+	}
+
+	if sqliteErr, ok := err.(sqlite3.Error); ok {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey
+	}
+
+	return false
+}
+
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// insert runs an INSERT and returns the id of the new row.
+// Postgres hands it back with RETURNING; SQLite reports the last insert id.
+func (d dialect) insert(q queryer, query string, args ...interface{}) (int64, error) {
+	query = d.rebind(query)
+
+	if d.driver == postgresDialect.driver {
+		var id int64
+
+		err := q.QueryRow(query+" RETURNING id", args...).Scan(&id)
+
+		return id, err
+	}
+
+	result, err := q.Exec(query, args...)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// sqlDB runs queries written for Postgres against any supported dialect.
+type sqlDB struct {
+	*sql.DB
+	dialect dialect
+}
+
+func openDatabase(d dialect, source string) (*sqlDB, error) {
+	db, err := sql.Open(d.driver, source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if d.driver == sqliteDialect.driver {
+		// SQLite allows a single writer; sharing one connection avoids "database is locked"
+		db.SetMaxOpenConns(1)
+	}
+
+	return &sqlDB{db, d}, nil
+}
+
+func (db *sqlDB) Prepare(query string) (*sql.Stmt, error) {
+	return db.DB.Prepare(db.dialect.rebind(query))
+}
+
+func (db *sqlDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.rebind(query), args...)
+}
+
+func (db *sqlDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.rebind(query), args...)
+}
+
+func (db *sqlDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.dialect.rebind(query), args...)
+}
+
+func (db *sqlDB) insert(query string, args ...interface{}) (int64, error) {
+	return db.dialect.insert(db.DB, query, args...)
+}
+
+func (db *sqlDB) begin() (*sqlTx, error) {
+	tx, err := db.DB.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqlTx{tx, db.dialect}, nil
+}
+
+// sqlTx is a transaction that rewrites its queries like sqlDB.
+type sqlTx struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (tx *sqlTx) Prepare(query string) (*sql.Stmt, error) {
+	return tx.Tx.Prepare(tx.dialect.rebind(query))
+}
+
+func (tx *sqlTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rebind(query), args...)
+}
+
+func (tx *sqlTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.rebind(query), args...)
+}
+
+func (tx *sqlTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.rebind(query), args...)
+}
+
+func (tx *sqlTx) insert(query string, args ...interface{}) (int64, error) {
+	return tx.dialect.insert(tx.Tx, query, args...)
+}
+
+// pendingMigrations returns the names of the dialect's migrations
+// that haven't been applied yet, in the order they'll be applied.
+func (db *sqlDB) pendingMigrations() ([]string, error) {
+	// Postgres keeps the tables in their own schema
+	if db.dialect.schema != "" {
+		_, err := db.DB.Exec(`CREATE SCHEMA IF NOT EXISTS ` + strings.TrimSuffix(db.dialect.schema, "."))
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS goissuez.schema_migrations (
+    name varchar(255) PRIMARY KEY,
+    applied_at timestamp NOT NULL
+)`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := fs.ReadDir(migrationFiles(), db.dialect.migrations)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []string{}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".sql") {
+			continue
+		}
+
+		var applied int
+
+		err = db.QueryRow(`SELECT count(*) FROM goissuez.schema_migrations WHERE name = $1`, f.Name()).Scan(&applied)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if applied == 0 {
+			pending = append(pending, f.Name())
+		}
+	}
+
+	sort.Strings(pending)
+
+	return pending, nil
+}
+
+// migrate applies the pending migrations, each in its own transaction,
+// and returns the names of those it applied.
+// Migration files are written for their own database, so they are not rebound.
+func (db *sqlDB) migrate() ([]string, error) {
+	pending, err := db.pendingMigrations()
+
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []string{}
+
+	for _, name := range pending {
+		contents, err := fs.ReadFile(migrationFiles(), path.Join(db.dialect.migrations, name))
+
+		if err != nil {
+			return applied, err
+		}
+
+		tx, err := db.begin()
+
+		if err != nil {
+			return applied, err
+		}
+
+		_, err = tx.Tx.Exec(string(contents))
+
+		if err != nil {
+			tx.Rollback()
+			return applied, fmt.Errorf("%s: %v", name, err)
+		}
+
+		_, err = tx.Exec(`INSERT INTO goissuez.schema_migrations (name, applied_at) VALUES ($1, CURRENT_TIMESTAMP)`, name)
+
+		if err != nil {
+			tx.Rollback()
+			return applied, err
+		}
+
+		err = tx.Commit()
+
+		if err != nil {
+			return applied, err
+		}
+
+		applied = append(applied, name)
+	}
+
+	return applied, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// staticAssets serves the files at /resources. Templates link to them with
+// {{asset "bundle.js"}}, which adds a hash of the file's contents to the name,
+// eg: /resources/bundle.3f2a9c1e04.js, so browsers can keep them for good
+// and still fetch a new copy whenever the file changes.
+type staticAssets struct {
+	files fs.FS
+	// cache keeps the hashes once worked out. Off when files can change under us.
+	cache bool
+
+	mu     sync.Mutex
+	hashes map[string]string
+}
+
+// fingerprinted matches asset names carrying a hash, eg: bundle.3f2a9c1e04.js
+var fingerprinted = regexp.MustCompile(`^(.+)\.([0-9a-f]{10})(\.[^./]+)$`)
+
+func newStaticAssets(files fs.FS, cache bool) *staticAssets {
+	return &staticAssets{files: files, cache: cache, hashes: make(map[string]string)}
+}
+
+// hash returns the start of the SHA-256 of the named file.
+func (s *staticAssets) hash(name string) (string, error) {
+	if s.cache {
+		s.mu.Lock()
+		h, ok := s.hashes[name]
+		s.mu.Unlock()
+
+		if ok {
+			return h, nil
+		}
+	}
+
+	contents, err := fs.ReadFile(s.files, name)
+
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(contents)
+	h := hex.EncodeToString(sum[:])[:10]
+
+	if s.cache {
+		s.mu.Lock()
+		s.hashes[name] = h
+		s.mu.Unlock()
+	}
+
+	return h, nil
+}
+
+// url returns the fingerprinted URL of the named file,
+// or its plain URL if the file can't be read.
+func (s *staticAssets) url(name string) string {
+	h, err := s.hash(name)
+
+	if err != nil {
+		log.Warn("Asset not found: ", name)
+		return "/resources/" + name
+	}
+
+	ext := path.Ext(name)
+
+	return "/resources/" + strings.TrimSuffix(name, ext) + "." + h + ext
+}
+
+// serve sends fingerprinted files with headers letting them be cached for a year.
+// Anything else, including a fingerprint from an older build, is sent with an ETag
+// and must be revalidated.
+func (s *staticAssets) serve(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	name := strings.TrimPrefix(ps.ByName("filepath"), "/")
+
+	if m := fingerprinted.FindStringSubmatch(name); m != nil {
+		original := m[1] + m[3]
+
+		if h, err := s.hash(original); err == nil {
+			if h == m[2] {
+				w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			} else {
+				w.Header().Set("Cache-Control", "no-cache")
+			}
+
+			s.send(w, r, original, h)
+			return
+		}
+	}
+
+	h, err := s.hash(name)
+
+	if err != nil {
+		respondError(w, r, notFoundError("File not found."))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	s.send(w, r, name, h)
+}
+
+func (s *staticAssets) send(w http.ResponseWriter, r *http.Request, name string, hash string) {
+	f, err := s.files.Open(name)
+
+	if err != nil {
+		respondError(w, r, notFoundError("File not found."))
+		return
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+
+	if err != nil || info.IsDir() {
+		respondError(w, r, notFoundError("File not found."))
+		return
+	}
+
+	content, ok := f.(io.ReadSeeker)
+
+	if !ok {
+		contents, err := io.ReadAll(f)
+
+		if err != nil {
+			respondError(w, r, internalError("Error reading file.", err))
+			return
+		}
+
+		content = bytes.NewReader(contents)
+	}
+
+	w.Header().Set("ETag", `"`+hash+`"`)
+
+	http.ServeContent(w, r, name, info.ModTime(), content)
+}
+
+// assetURL is the asset template func.
+func assetURL(name string) string {
+	if assets == nil {
+		return "/resources/" + name
+	}
+
+	return assets.url(name)
+}
@@ -0,0 +1,254 @@
+package main
+
+import "time"
+
+// The store interfaces hold every query the app runs, so services never touch *sql.DB.
+// Postgres is the real backend; the in-memory stores let handlers be exercised with
+// httptest and no database.
+//
+// Lookups of a single entity return sql.ErrNoRows when it doesn't exist,
+// whichever backend is used, so auth.guard can answer with a 404.
+
+// stores bundles one of each store. Services take the whole bundle
+// because most pages read from more than one table.
+type stores struct {
+	users    userStore
+	sessions sessionStore
+	roles    roleStore
+	projects projectStore
+	features featureStore
+	stories  storyStore
+	bugs     bugStore
+	sprints  sprintStore
+	boards   boardStore
+	links    linkStore
+	labels   labelStore
+	jobs     jobStore
+}
+
+type userStore interface {
+	// all returns every user, including deleted users, oldest first.
+	all() ([]user, error)
+	find(id int64) (user, error)
+	// findByUsername also returns the password hash, for logging in.
+	findByUsername(username string) (user, error)
+	create(userData user) (int64, error)
+	setRole(userID, roleID int64) error
+	// setPassword replaces the user's password hash.
+	setPassword(userID int64, hash string) error
+	// destroy soft deletes the user and unassigns their stories and bugs.
+	destroy(id int64) error
+}
+
+type sessionStore interface {
+	// create starts a session and ends every other session the user has.
+	create(uuid string, userID int64) error
+	// user returns the user a session acts as, with their role and,
+	// while impersonating, the admin in Impersonator.
+	user(uuid string) (user, error)
+	startImpersonation(uuid string, adminID, userID int64) error
+	// endImpersonation closes any open impersonation for the session.
+	endImpersonation(uuid string) error
+	impersonations() ([]impersonation, error)
+	// purge deletes the sessions created before the given time, returning how many.
+	purge(before time.Time) (int64, error)
+}
+
+type roleStore interface {
+	// all returns every role ordered by name, with the name of its parent.
+	all() ([]role, error)
+	find(id int64) (role, error)
+	create(roleData role) (int64, error)
+	update(roleData role) error
+	destroy(id int64) error
+	// clone copies a role and its own permissions, returning the new role's id.
+	clone(id int64) (int64, error)
+
+	capabilities() ([]*capability, error)
+	// syncCapabilities inserts or updates the given capabilities. Others are left alone.
+	syncCapabilities(registry []capability) error
+	assignedCapabilityIDs() (map[int64]bool, error)
+
+	// permissions returns only the capabilities assigned to the role itself.
+	permissions(roleID int64) (map[string]capability, error)
+	// setPermissions replaces the role's own capabilities.
+	setPermissions(roleID int64, capabilityIDs []int64) error
+	// apply makes every change in the plan in a single transaction.
+	apply(plan rolePlan) error
+}
+
+type projectStore interface {
+	// all returns the projects that haven't been deleted, oldest first.
+	all() ([]project, error)
+	byUser(userID int64) ([]project, error)
+	find(id int64) (project, error)
+	// create adds the project with the defaultColumns on its board.
+	create(projectData project) (int64, error)
+	update(projectData project) error
+	// destroy soft deletes the project with its features, stories and bugs.
+	destroy(id int64) error
+}
+
+type featureStore interface {
+	// all returns the features that haven't been deleted with their story and bug counts.
+	all() ([]featureSummary, error)
+	byProject(projectID int64) ([]feature, error)
+	// assignedTo returns the features with a story or bug assigned to the user.
+	assignedTo(userID int64) ([]feature, error)
+	// find includes the project name.
+	find(id int64) (feature, error)
+	create(featureData feature) (int64, error)
+	update(featureData feature) error
+	destroy(id int64) error
+}
+
+type storyStore interface {
+	// all returns the stories that haven't been deleted, with their feature name.
+	all() ([]story, error)
+	byFeature(featureID int64) ([]story, error)
+	assignedTo(userID int64) ([]story, error)
+	// find includes the feature name.
+	find(id int64) (story, error)
+	create(storyData story) (int64, error)
+	update(storyData story) error
+	// createWithLabels adds the story with its labels in one transaction.
+	createWithLabels(storyData story, labelIDs []int64) (int64, error)
+	// save changes the story, replaces its labels and marks it done or not in one transaction.
+	save(storyData story, labelIDs []int64, done bool) error
+	destroy(id int64) error
+	// restore undeletes the story and its feature, returning the feature id.
+	restore(id int64) (int64, error)
+	// bySprint returns the stories planned in the sprint, done or not.
+	bySprint(sprintID int64) ([]story, error)
+	// backlog returns the project's stories that aren't in a sprint or done.
+	backlog(projectID int64) ([]story, error)
+	// setSprint moves the story into a sprint, or back to the backlog when sprintID is 0.
+	setSprint(id, sprintID int64) error
+	// setDone marks the story done, keeping when it was first done, or not done.
+	setDone(id int64, done bool) error
+	// byProject returns the stories that haven't been deleted in the project's features that haven't been.
+	byProject(projectID int64) ([]story, error)
+	// setColumn moves the story to a board column.
+	setColumn(id, columnID int64) error
+	// rankBefore and rankAfter rank the story next to the target, returning its new rank.
+	// They return sql.ErrNoRows if either doesn't exist.
+	rankBefore(id, targetID int64) (string, error)
+	rankAfter(id, targetID int64) (string, error)
+}
+
+type bugStore interface {
+	// all returns the bugs that haven't been deleted, with their feature name.
+	all() ([]bug, error)
+	byFeature(featureID int64) ([]bug, error)
+	assignedTo(userID int64) ([]bug, error)
+	// find includes the feature name.
+	find(id int64) (bug, error)
+	create(bugData bug) (int64, error)
+	update(bugData bug) error
+	// createWithLabels adds the bug with its labels in one transaction.
+	createWithLabels(bugData bug, labelIDs []int64) (int64, error)
+	// save changes the bug, replaces its labels and marks it done or not in one transaction.
+	save(bugData bug, labelIDs []int64, done bool) error
+	destroy(id int64) error
+	// openByProject counts the bugs that are neither done nor deleted in every project that hasn't been.
+	openByProject() ([]projectBugCount, error)
+	// bySprint returns the bugs planned in the sprint, fixed or not.
+	bySprint(sprintID int64) ([]bug, error)
+	// backlog returns the project's bugs that aren't in a sprint or done.
+	backlog(projectID int64) ([]bug, error)
+	// setSprint moves the bug into a sprint, or back to the backlog when sprintID is 0.
+	setSprint(id, sprintID int64) error
+	// setDone marks the bug done, keeping when it was first done, or not done.
+	setDone(id int64, done bool) error
+	// byProject returns the bugs that haven't been deleted in the project's features that haven't been.
+	byProject(projectID int64) ([]bug, error)
+	// setColumn moves the bug to a board column.
+	setColumn(id, columnID int64) error
+	// rankBefore and rankAfter rank the bug next to the target, returning its new rank.
+	// They return sql.ErrNoRows if either doesn't exist.
+	rankBefore(id, targetID int64) (string, error)
+	rankAfter(id, targetID int64) (string, error)
+}
+
+type linkStore interface {
+	// byIssue returns the links from and to the story or bug, with the names of the issues at both ends, oldest first.
+	byIssue(issue issueRef) ([]issueLink, error)
+	find(id int64) (issueLink, error)
+	// create returns errLinkExists if the issues are already linked with the type in the same direction.
+	create(linkData issueLink) (int64, error)
+	destroy(id int64) error
+}
+
+type labelStore interface {
+	// byProject returns the project's labels by name, with how many stories and bugs have each.
+	byProject(projectID int64) ([]label, error)
+	// all returns the labels of every project that hasn't been deleted, with the project's name
+	// and how many stories and bugs have each, by project then name.
+	all() ([]label, error)
+	find(id int64) (label, error)
+	// create and update return errLabelExists if the project has another label with the name, whatever its case.
+	create(labelData label) (int64, error)
+	// update changes the label's name and colour.
+	update(labelData label) error
+	// merge gives the stories and bugs with the label the label intoID instead, then deletes it.
+	// It returns sql.ErrNoRows if the label doesn't exist.
+	merge(id, intoID int64) error
+	// forStories and forBugs return the labels of each of the stories or bugs by name, keyed by their id.
+	forStories(ids []int64) (map[int64][]label, error)
+	forBugs(ids []int64) (map[int64][]label, error)
+	// setStoryLabels and setBugLabels replace the story's or bug's labels.
+	setStoryLabels(id int64, labelIDs []int64) error
+	setBugLabels(id int64, labelIDs []int64) error
+}
+
+type sprintStore interface {
+	// byProject returns the project's sprints, the latest first.
+	byProject(projectID int64) ([]sprint, error)
+	find(id int64) (sprint, error)
+	create(sprintData sprint) (int64, error)
+	// update changes the sprint's name, goal and dates.
+	update(sprintData sprint) error
+	// start makes a planned sprint active. It returns sql.ErrNoRows if the sprint isn't
+	// planned, and errSprintActive if another of the project's sprints is active.
+	start(id int64) error
+	// close closes an active sprint, moving the stories and bugs in it that aren't done
+	// to the sprint carryTo, or to the backlog when carryTo is 0. It returns how many
+	// were moved, or sql.ErrNoRows if the sprint isn't active.
+	close(id, carryTo int64) (int, error)
+}
+
+type boardStore interface {
+	// columns returns the project's board columns, left to right.
+	columns(projectID int64) ([]boardColumn, error)
+	findColumn(id int64) (boardColumn, error)
+	createColumn(columnData boardColumn) (int64, error)
+	// updateColumn changes the column's name, position, WIP limit and whether it's a done column.
+	updateColumn(columnData boardColumn) error
+	// destroyColumn deletes the column. The stories and bugs in it are left without a column.
+	destroyColumn(id int64) error
+}
+
+type jobStore interface {
+	// enqueue adds a job to run at runAt and returns its id. A job whose UniqueKey
+	// was used before isn't added again, and 0 is returned.
+	enqueue(jobData job, runAt time.Time) (int64, error)
+	// claim marks the next job due at now as running, counts the attempt and returns it,
+	// or sql.ErrNoRows when nothing is due. Jobs still running since before stale
+	// are taken to have lost their worker and are claimed again.
+	claim(now, stale time.Time) (job, error)
+	// complete marks a running job done.
+	complete(id int64) error
+	// fail records the error of a running job and queues it again at retryAt,
+	// or marks it dead when retryAt is zero.
+	fail(id int64, message string, retryAt time.Time) error
+	// retry queues a dead job again with a fresh set of attempts.
+	retry(id int64) error
+	// destroy deletes a dead job.
+	destroy(id int64) error
+	// counts returns the number of jobs in each status.
+	counts() (map[string]int, error)
+	// dead returns up to limit dead jobs, the most recent first.
+	dead(limit int) ([]job, error)
+	// purge deletes the jobs done before the given time, returning how many.
+	purge(before time.Time) (int64, error)
+}
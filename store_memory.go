@@ -0,0 +1,2449 @@
+package main
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// newMemoryStores returns stores that keep everything in memory.
+// Nothing is persisted; it's meant for exercising handlers without a database.
+func newMemoryStores() stores {
+	m := &memoryDB{
+		users:            make(map[int64]user),
+		sessions:         make(map[string]memorySession),
+		impersonationLog: make(map[int64]impersonation),
+		roles:            make(map[int64]role),
+		caps:             make(map[int64]capability),
+		grants:           make(map[int64]map[int64]bool),
+		projects:         make(map[int64]project),
+		features:         make(map[int64]feature),
+		stories:          make(map[int64]story),
+		bugs:             make(map[int64]bug),
+		sprints:          make(map[int64]sprint),
+		boardColumns:     make(map[int64]boardColumn),
+		issueLinks:       make(map[int64]issueLink),
+		labels:           make(map[int64]label),
+		storyLabels:      make(map[int64]map[int64]bool),
+		bugLabels:        make(map[int64]map[int64]bool),
+		jobs:             make(map[int64]memoryJob),
+		jobKeys:          make(map[string]bool),
+	}
+
+	return stores{
+		users:    &memoryUserStore{m},
+		sessions: &memorySessionStore{m},
+		roles:    &memoryRoleStore{m},
+		projects: &memoryProjectStore{m},
+		features: &memoryFeatureStore{m},
+		stories:  &memoryStoryStore{m},
+		bugs:     &memoryBugStore{m},
+		sprints:  &memorySprintStore{m},
+		boards:   &memoryBoardStore{m},
+		links:    &memoryLinkStore{m},
+		labels:   &memoryLabelStore{m},
+		jobs:     &memoryJobStore{m},
+	}
+}
+
+// memoryDB holds every table. One lock guards all of them,
+// the same way a transaction would.
+type memoryDB struct {
+	mu     sync.Mutex
+	nextID int64
+
+	users            map[int64]user
+	sessions         map[string]memorySession
+	impersonationLog map[int64]impersonation
+	roles            map[int64]role
+	caps             map[int64]capability
+	// grants maps a role id to the ids of its capabilities.
+	grants   map[int64]map[int64]bool
+	projects map[int64]project
+	features map[int64]feature
+	stories  map[int64]story
+	bugs     map[int64]bug
+	sprints  map[int64]sprint
+	// boardColumns are the board columns of every project.
+	boardColumns map[int64]boardColumn
+	// issueLinks are kept without the names of the issues at their ends.
+	issueLinks map[int64]issueLink
+	// labels are kept without their project's name or uses.
+	labels map[int64]label
+	// storyLabels and bugLabels map a story or bug id to the ids of its labels.
+	storyLabels map[int64]map[int64]bool
+	bugLabels   map[int64]map[int64]bool
+	jobs        map[int64]memoryJob
+	// jobKeys holds every unique key a job was enqueued with.
+	jobKeys map[string]bool
+}
+
+type memorySession struct {
+	UserID          int64
+	ImpersonationID int64
+	CreatedAt       time.Time
+}
+
+// memoryJob keeps the times a job is compared by alongside the job.
+type memoryJob struct {
+	job
+	runAt      time.Time
+	lockedAt   time.Time
+	finishedAt time.Time
+}
+
+func (m *memoryDB) id() int64 {
+	m.nextID++
+	return m.nextID
+}
+
+func (m *memoryDB) now() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+func sortedIDs(ids []int64) []int64 {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Users
+
+type memoryUserStore struct {
+	*memoryDB
+}
+
+func (s *memoryUserStore) all() ([]user, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []int64{}
+
+	for id := range s.users {
+		ids = append(ids, id)
+	}
+
+	users := []user{}
+
+	for _, id := range sortedIDs(ids) {
+		userData := s.users[id]
+		userData.Password = ""
+
+		users = append(users, userData)
+	}
+
+	return users, nil
+}
+
+func (s *memoryUserStore) find(id int64) (user, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userData, ok := s.users[id]
+
+	if !ok {
+		return user{}, sql.ErrNoRows
+	}
+
+	userData.Password = ""
+
+	return userData, nil
+}
+
+func (s *memoryUserStore) findByUsername(username string) (user, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, userData := range s.users {
+		if userData.Username == username {
+			return userData, nil
+		}
+	}
+
+	return user{}, sql.ErrNoRows
+}
+
+func (s *memoryUserStore) create(userData user) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	userData.ID = s.id()
+	userData.CreatedAt = now
+	userData.UpdatedAt = now
+	userData.LastLogin = now
+
+	s.users[userData.ID] = userData
+
+	return userData.ID, nil
+}
+
+func (s *memoryUserStore) setRole(userID, roleID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userData, ok := s.users[userID]
+
+	if !ok {
+		return nil
+	}
+
+	userData.RoleID = roleID
+	s.users[userID] = userData
+
+	return nil
+}
+
+func (s *memoryUserStore) setPassword(userID int64, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userData, ok := s.users[userID]
+
+	if !ok {
+		return nil
+	}
+
+	userData.Password = hash
+	userData.UpdatedAt = s.now()
+	s.users[userID] = userData
+
+	return nil
+}
+
+func (s *memoryUserStore) destroy(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userData, ok := s.users[id]
+
+	if ok {
+		userData.DeletedAt = s.now()
+		s.users[id] = userData
+	}
+
+	for storyID, storyData := range s.stories {
+		if storyData.AssigneeID == id {
+			storyData.AssigneeID = 0
+			s.stories[storyID] = storyData
+		}
+	}
+
+	for bugID, bugData := range s.bugs {
+		if bugData.AssigneeID == id {
+			bugData.AssigneeID = 0
+			s.bugs[bugID] = bugData
+		}
+	}
+
+	return nil
+}
+
+// Sessions
+
+type memorySessionStore struct {
+	*memoryDB
+}
+
+func (s *memorySessionStore) create(uuid string, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// a user only ever has one session
+	for other, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, other)
+		}
+	}
+
+	s.sessions[uuid] = memorySession{UserID: userID, CreatedAt: time.Now()}
+
+	return nil
+}
+
+func (s *memorySessionStore) user(uuid string) (user, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[uuid]
+
+	if !ok {
+		return user{}, sql.ErrNoRows
+	}
+
+	userID := session.UserID
+
+	var impersonator *user
+
+	if i, ok := s.impersonationLog[session.ImpersonationID]; ok && i.EndedAt == "" {
+		userID = i.User.ID
+
+		adminData := s.users[i.Admin.ID]
+		impersonator = &user{ID: adminData.ID, Name: adminData.Name, Username: adminData.Username}
+	}
+
+	userData, ok := s.users[userID]
+
+	if !ok {
+		return user{}, sql.ErrNoRows
+	}
+
+	roleData := s.roles[userData.RoleID]
+
+	userData.Password = ""
+	userData.Impersonator = impersonator
+	userData.Role = role{ID: roleData.ID, Name: roleData.Name, Description: roleData.Description}
+
+	return userData, nil
+}
+
+func (s *memorySessionStore) startImpersonation(uuid string, adminID, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[uuid]
+
+	if !ok {
+		return nil
+	}
+
+	i := impersonation{
+		ID:        s.id(),
+		Admin:     user{ID: adminID},
+		User:      user{ID: userID},
+		StartedAt: s.now(),
+	}
+
+	s.impersonationLog[i.ID] = i
+
+	session.ImpersonationID = i.ID
+	s.sessions[uuid] = session
+
+	return nil
+}
+
+func (s *memorySessionStore) endImpersonation(uuid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[uuid]
+
+	if !ok {
+		return nil
+	}
+
+	if i, ok := s.impersonationLog[session.ImpersonationID]; ok && i.EndedAt == "" {
+		i.EndedAt = s.now()
+		s.impersonationLog[i.ID] = i
+	}
+
+	session.ImpersonationID = 0
+	s.sessions[uuid] = session
+
+	return nil
+}
+
+func (s *memorySessionStore) impersonations() ([]impersonation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []int64{}
+
+	for id := range s.impersonationLog {
+		ids = append(ids, id)
+	}
+
+	impersonations := []impersonation{}
+
+	// newest first
+	ids = sortedIDs(ids)
+
+	for i := len(ids) - 1; i >= 0; i-- {
+		data := s.impersonationLog[ids[i]]
+
+		data.Admin.Name = s.users[data.Admin.ID].Name
+		data.User.Name = s.users[data.User.ID].Name
+
+		started, _ := time.Parse(time.RFC3339, data.StartedAt)
+		ended := time.Now().UTC()
+
+		if data.EndedAt != "" {
+			ended, _ = time.Parse(time.RFC3339, data.EndedAt)
+		}
+
+		data.Duration = ended.Sub(started).Truncate(time.Second).String()
+
+		impersonations = append(impersonations, data)
+	}
+
+	return impersonations, nil
+}
+
+func (s *memorySessionStore) purge(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+
+	for uuid, session := range s.sessions {
+		if session.CreatedAt.Before(before) {
+			delete(s.sessions, uuid)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// Roles
+
+type memoryRoleStore struct {
+	*memoryDB
+}
+
+// withParent fills in the name of the role's parent.
+func (s *memoryRoleStore) withParent(roleData role) role {
+	roleData.ParentName = s.roles[roleData.ParentID].Name
+	roleData.Capabilities = nil
+
+	return roleData
+}
+
+func (s *memoryRoleStore) all() ([]role, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	roles := []role{}
+
+	for _, roleData := range s.roles {
+		roles = append(roles, s.withParent(roleData))
+	}
+
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+
+	return roles, nil
+}
+
+func (s *memoryRoleStore) find(id int64) (role, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	roleData, ok := s.roles[id]
+
+	if !ok {
+		return role{}, sql.ErrNoRows
+	}
+
+	return s.withParent(roleData), nil
+}
+
+func (s *memoryRoleStore) create(roleData role) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	roleData.ID = s.id()
+	s.roles[roleData.ID] = s.withParent(roleData)
+
+	return roleData.ID, nil
+}
+
+func (s *memoryRoleStore) update(roleData role) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.roles[roleData.ID]; !ok {
+		return nil
+	}
+
+	s.roles[roleData.ID] = s.withParent(roleData)
+
+	return nil
+}
+
+func (s *memoryRoleStore) destroy(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.roles, id)
+	delete(s.grants, id)
+
+	// children lose their parent, like ON DELETE SET NULL
+	for childID, child := range s.roles {
+		if child.ParentID == id {
+			child.ParentID = 0
+			s.roles[childID] = child
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryRoleStore) clone(id int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	roleData, ok := s.roles[id]
+
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+
+	roleData.ID = s.id()
+	roleData.Name = "Copy of " + roleData.Name
+
+	s.roles[roleData.ID] = roleData
+	s.grants[roleData.ID] = make(map[int64]bool)
+
+	for capabilityID := range s.grants[id] {
+		s.grants[roleData.ID][capabilityID] = true
+	}
+
+	return roleData.ID, nil
+}
+
+func (s *memoryRoleStore) capabilities() ([]*capability, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []int64{}
+
+	for id := range s.caps {
+		ids = append(ids, id)
+	}
+
+	capabilities := []*capability{}
+
+	for _, id := range sortedIDs(ids) {
+		data := s.caps[id]
+		capabilities = append(capabilities, &data)
+	}
+
+	return capabilities, nil
+}
+
+func (s *memoryRoleStore) syncCapabilities(registry []capability) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make(map[string]int64)
+
+	for id, c := range s.caps {
+		ids[c.Name] = id
+	}
+
+	for _, c := range registry {
+		id, ok := ids[c.Name]
+
+		if !ok {
+			id = s.id()
+		}
+
+		c.ID = id
+		s.caps[id] = c
+	}
+
+	return nil
+}
+
+func (s *memoryRoleStore) assignedCapabilityIDs() (map[int64]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	assigned := make(map[int64]bool)
+
+	for _, capabilityIDs := range s.grants {
+		for id := range capabilityIDs {
+			assigned[id] = true
+		}
+	}
+
+	return assigned, nil
+}
+
+func (s *memoryRoleStore) permissions(roleID int64) (map[string]capability, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	permissions := make(map[string]capability)
+
+	for id := range s.grants[roleID] {
+		capData, ok := s.caps[id]
+
+		if ok {
+			permissions[capData.Name] = capData
+		}
+	}
+
+	return permissions, nil
+}
+
+func (s *memoryRoleStore) setPermissions(roleID int64, capabilityIDs []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.grants[roleID] = make(map[int64]bool)
+
+	for _, id := range capabilityIDs {
+		s.grants[roleID][id] = true
+	}
+
+	return nil
+}
+
+func (s *memoryRoleStore) apply(plan rolePlan) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	capabilityIDs := make(map[string]int64)
+
+	for id, c := range s.caps {
+		capabilityIDs[c.Name] = id
+	}
+
+	roleIDs := make(map[string]int64)
+
+	for id, roleData := range s.roles {
+		roleIDs[roleData.Name] = id
+	}
+
+	// create new roles first so they can be inherited from
+	for _, c := range plan.Changes {
+		if !c.Create {
+			continue
+		}
+
+		id := s.id()
+
+		s.roles[id] = role{ID: id, Name: c.Name}
+		s.grants[id] = make(map[int64]bool)
+		roleIDs[c.Name] = id
+	}
+
+	for _, c := range plan.Changes {
+		roleID := roleIDs[c.Name]
+
+		roleData := s.roles[roleID]
+		roleData.Description = c.NewDescription
+		roleData.ParentID = roleIDs[c.NewInherits]
+		s.roles[roleID] = roleData
+
+		if s.grants[roleID] == nil {
+			s.grants[roleID] = make(map[int64]bool)
+		}
+
+		for _, name := range c.Add {
+			s.grants[roleID][capabilityIDs[name]] = true
+		}
+
+		for _, name := range c.Remove {
+			delete(s.grants[roleID], capabilityIDs[name])
+		}
+	}
+
+	return nil
+}
+
+// Projects
+
+type memoryProjectStore struct {
+	*memoryDB
+}
+
+func (s *memoryProjectStore) list(keep func(project) bool) []project {
+	ids := []int64{}
+
+	for id, projectData := range s.projects {
+		if keep(projectData) {
+			ids = append(ids, id)
+		}
+	}
+
+	projects := []project{}
+
+	for _, id := range sortedIDs(ids) {
+		projects = append(projects, s.projects[id])
+	}
+
+	return projects
+}
+
+func (s *memoryProjectStore) all() ([]project, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list(func(p project) bool { return p.DeletedAt == "" }), nil
+}
+
+func (s *memoryProjectStore) byUser(userID int64) ([]project, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list(func(p project) bool { return p.UserID == userID }), nil
+}
+
+func (s *memoryProjectStore) find(id int64) (project, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	projectData, ok := s.projects[id]
+
+	if !ok {
+		return project{}, sql.ErrNoRows
+	}
+
+	return projectData, nil
+}
+
+func (s *memoryProjectStore) create(projectData project) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	projectData.ID = s.id()
+	projectData.CreatedAt = now
+	projectData.UpdatedAt = now
+	projectData.Features = nil
+
+	s.projects[projectData.ID] = projectData
+
+	for i, columnData := range defaultColumns {
+		columnData.ID = s.id()
+		columnData.ProjectID = projectData.ID
+		columnData.Position = i + 1
+
+		s.boardColumns[columnData.ID] = columnData
+	}
+
+	return projectData.ID, nil
+}
+
+func (s *memoryProjectStore) update(projectData project) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.projects[projectData.ID]
+
+	if !ok {
+		return nil
+	}
+
+	existing.Name = projectData.Name
+	existing.Description = projectData.Description
+	existing.PointScale = projectData.PointScale
+	existing.TrackTime = projectData.TrackTime
+	existing.UpdatedAt = s.now()
+
+	s.projects[projectData.ID] = existing
+
+	return nil
+}
+
+func (s *memoryProjectStore) destroy(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	if projectData, ok := s.projects[id]; ok {
+		projectData.DeletedAt = now
+		s.projects[id] = projectData
+	}
+
+	for featureID, featureData := range s.features {
+		if featureData.ProjectID != id {
+			continue
+		}
+
+		featureData.DeletedAt = now
+		s.features[featureID] = featureData
+
+		for storyID, storyData := range s.stories {
+			if storyData.FeatureID == featureID {
+				storyData.DeletedAt = now
+				s.stories[storyID] = storyData
+			}
+		}
+
+		for bugID, bugData := range s.bugs {
+			if bugData.FeatureID == featureID {
+				bugData.DeletedAt = now
+				s.bugs[bugID] = bugData
+			}
+		}
+	}
+
+	return nil
+}
+
+// Features
+
+type memoryFeatureStore struct {
+	*memoryDB
+}
+
+func (s *memoryFeatureStore) list(keep func(feature) bool) []feature {
+	ids := []int64{}
+
+	for id, featureData := range s.features {
+		if keep(featureData) {
+			ids = append(ids, id)
+		}
+	}
+
+	features := []feature{}
+
+	for _, id := range sortedIDs(ids) {
+		features = append(features, s.features[id])
+	}
+
+	return features
+}
+
+func (s *memoryFeatureStore) all() ([]featureSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	features := []featureSummary{}
+
+	for _, featureData := range s.list(func(f feature) bool { return f.DeletedAt == "" }) {
+		summary := featureSummary{Feature: featureData}
+		summary.Feature.Project = &project{ID: featureData.ProjectID, Name: s.projects[featureData.ProjectID].Name}
+
+		for _, storyData := range s.stories {
+			if storyData.FeatureID == featureData.ID {
+				summary.RelatedData.StoryCount++
+			}
+		}
+
+		for _, bugData := range s.bugs {
+			if bugData.FeatureID == featureData.ID {
+				summary.RelatedData.BugCount++
+			}
+		}
+
+		features = append(features, summary)
+	}
+
+	return features, nil
+}
+
+func (s *memoryFeatureStore) byProject(projectID int64) ([]feature, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list(func(f feature) bool { return f.ProjectID == projectID }), nil
+}
+
+func (s *memoryFeatureStore) assignedTo(userID int64) ([]feature, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	assigned := make(map[int64]bool)
+
+	for _, storyData := range s.stories {
+		if storyData.AssigneeID == userID {
+			assigned[storyData.FeatureID] = true
+		}
+	}
+
+	for _, bugData := range s.bugs {
+		if bugData.AssigneeID == userID {
+			assigned[bugData.FeatureID] = true
+		}
+	}
+
+	return s.list(func(f feature) bool { return assigned[f.ID] }), nil
+}
+
+func (s *memoryFeatureStore) find(id int64) (feature, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	featureData, ok := s.features[id]
+
+	if !ok {
+		return feature{}, sql.ErrNoRows
+	}
+
+	projectData := s.projects[featureData.ProjectID]
+
+	featureData.Project = &project{
+		ID:         featureData.ProjectID,
+		Name:       projectData.Name,
+		PointScale: projectData.PointScale,
+		TrackTime:  projectData.TrackTime,
+	}
+
+	return featureData, nil
+}
+
+func (s *memoryFeatureStore) create(featureData feature) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	featureData.ID = s.id()
+	featureData.CreatedAt = now
+	featureData.UpdatedAt = now
+	featureData.Project = nil
+	featureData.Stories = nil
+	featureData.Bugs = nil
+
+	s.features[featureData.ID] = featureData
+
+	return featureData.ID, nil
+}
+
+func (s *memoryFeatureStore) update(featureData feature) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.features[featureData.ID]
+
+	if !ok {
+		return nil
+	}
+
+	existing.Name = featureData.Name
+	existing.Description = featureData.Description
+	existing.UpdatedAt = s.now()
+
+	s.features[featureData.ID] = existing
+
+	return nil
+}
+
+func (s *memoryFeatureStore) destroy(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if featureData, ok := s.features[id]; ok {
+		featureData.DeletedAt = s.now()
+		s.features[id] = featureData
+	}
+
+	return nil
+}
+
+// Stories
+
+type memoryStoryStore struct {
+	*memoryDB
+}
+
+func (s *memoryStoryStore) list(keep func(story) bool) []story {
+	ids := []int64{}
+
+	for id, storyData := range s.stories {
+		if keep(storyData) {
+			ids = append(ids, id)
+		}
+	}
+
+	stories := []story{}
+
+	for _, id := range sortedIDs(ids) {
+		stories = append(stories, s.withFeature(s.stories[id]))
+	}
+
+	sort.SliceStable(stories, func(i, j int) bool { return stories[i].Rank < stories[j].Rank })
+
+	return stories
+}
+
+func (s *memoryStoryStore) withFeature(storyData story) story {
+	storyData.Feature = &feature{ID: storyData.FeatureID, Name: s.features[storyData.FeatureID].Name}
+
+	return storyData
+}
+
+func (s *memoryStoryStore) all() ([]story, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list(func(st story) bool { return st.DeletedAt == "" }), nil
+}
+
+func (s *memoryStoryStore) byFeature(featureID int64) ([]story, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list(func(st story) bool { return st.FeatureID == featureID && st.DeletedAt == "" }), nil
+}
+
+func (s *memoryStoryStore) assignedTo(userID int64) ([]story, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list(func(st story) bool { return st.AssigneeID == userID }), nil
+}
+
+func (s *memoryStoryStore) find(id int64) (story, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	storyData, ok := s.stories[id]
+
+	if !ok {
+		return story{}, sql.ErrNoRows
+	}
+
+	return s.withFeature(storyData), nil
+}
+
+func (s *memoryStoryStore) create(storyData story) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.add(storyData), nil
+}
+
+// createWithLabels holds the lock throughout, so the story and its labels are added together.
+func (s *memoryStoryStore) createWithLabels(storyData story, labelIDs []int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	set, err := s.labelSet(labelIDs)
+
+	if err != nil {
+		return 0, err
+	}
+
+	id := s.add(storyData)
+
+	if len(set) > 0 {
+		s.storyLabels[id] = set
+	}
+
+	return id, nil
+}
+
+// add needs the lock held.
+func (s *memoryStoryStore) add(storyData story) int64 {
+	now := s.now()
+
+	storyData.ID = s.id()
+	storyData.CreatedAt = now
+	storyData.UpdatedAt = now
+	storyData.Creator = nil
+	storyData.Assignee = nil
+	storyData.Feature = nil
+	storyData.Project = nil
+	storyData.Rank = nextRank(s.storyRanks().last())
+
+	s.stories[storyData.ID] = storyData
+
+	return storyData.ID
+}
+
+func (s *memoryStoryStore) update(storyData story) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.change(storyData)
+
+	return nil
+}
+
+// save holds the lock throughout, so the changes, the labels and the done state are saved together.
+func (s *memoryStoryStore) save(storyData story, labelIDs []int64, done bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.stories[storyData.ID]; !ok {
+		return nil
+	}
+
+	set, err := s.labelSet(labelIDs)
+
+	if err != nil {
+		return err
+	}
+
+	s.change(storyData)
+	s.storyLabels[storyData.ID] = set
+	s.markDone(storyData.ID, done)
+
+	return nil
+}
+
+// change needs the lock held.
+func (s *memoryStoryStore) change(storyData story) {
+	existing, ok := s.stories[storyData.ID]
+
+	if !ok {
+		return
+	}
+
+	existing.Name = storyData.Name
+	existing.Description = storyData.Description
+	existing.AssigneeID = storyData.AssigneeID
+	existing.Priority = storyData.Priority
+	existing.Points = storyData.Points
+	existing.EstimateMinutes = storyData.EstimateMinutes
+	existing.UpdatedAt = s.now()
+
+	s.stories[storyData.ID] = existing
+}
+
+func (s *memoryStoryStore) destroy(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if storyData, ok := s.stories[id]; ok {
+		storyData.DeletedAt = s.now()
+		s.stories[id] = storyData
+	}
+
+	return nil
+}
+
+func (s *memoryStoryStore) restore(id int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	storyData, ok := s.stories[id]
+
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+
+	storyData.DeletedAt = ""
+	s.stories[id] = storyData
+
+	if featureData, ok := s.features[storyData.FeatureID]; ok {
+		featureData.DeletedAt = ""
+		s.features[featureData.ID] = featureData
+	}
+
+	return storyData.FeatureID, nil
+}
+
+func (s *memoryStoryStore) bySprint(sprintID int64) ([]story, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list(func(st story) bool { return st.SprintID == sprintID && st.DeletedAt == "" }), nil
+}
+
+func (s *memoryStoryStore) backlog(projectID int64) ([]story, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list(func(st story) bool {
+		featureData := s.features[st.FeatureID]
+
+		return featureData.ProjectID == projectID && featureData.DeletedAt == "" &&
+			st.SprintID == 0 && st.CompletedAt == "" && st.DeletedAt == ""
+	}), nil
+}
+
+func (s *memoryStoryStore) setSprint(id, sprintID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if storyData, ok := s.stories[id]; ok {
+		storyData.SprintID = sprintID
+		storyData.UpdatedAt = s.now()
+		s.stories[id] = storyData
+	}
+
+	return nil
+}
+
+func (s *memoryStoryStore) setDone(id int64, done bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.markDone(id, done)
+
+	return nil
+}
+
+// markDone needs the lock held.
+func (s *memoryStoryStore) markDone(id int64, done bool) {
+	storyData, ok := s.stories[id]
+
+	if !ok {
+		return
+	}
+
+	if !done {
+		storyData.CompletedAt = ""
+	} else if storyData.CompletedAt == "" {
+		storyData.CompletedAt = s.now()
+	}
+
+	storyData.UpdatedAt = s.now()
+	s.stories[id] = storyData
+}
+
+func (s *memoryStoryStore) byProject(projectID int64) ([]story, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list(func(st story) bool {
+		featureData := s.features[st.FeatureID]
+
+		return featureData.ProjectID == projectID && featureData.DeletedAt == "" && st.DeletedAt == ""
+	}), nil
+}
+
+func (s *memoryStoryStore) setColumn(id, columnID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if storyData, ok := s.stories[id]; ok {
+		storyData.ColumnID = columnID
+		storyData.UpdatedAt = s.now()
+		s.stories[id] = storyData
+	}
+
+	return nil
+}
+
+// storyRanks is every story's rank by id.
+func (s *memoryStoryStore) storyRanks() memoryRanks {
+	ranks := memoryRanks{}
+
+	for id, storyData := range s.stories {
+		ranks[id] = storyData.Rank
+	}
+
+	return ranks
+}
+
+func (s *memoryStoryStore) rankBefore(id, targetID int64) (string, error) {
+	return s.rankNextTo(id, targetID, true)
+}
+
+func (s *memoryStoryStore) rankAfter(id, targetID int64) (string, error) {
+	return s.rankNextTo(id, targetID, false)
+}
+
+func (s *memoryStoryStore) rankNextTo(id, targetID int64, before bool) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.stories[id]; !ok {
+		return "", sql.ErrNoRows
+	}
+
+	ranks := s.storyRanks()
+
+	err := ranks.moveNextTo(id, targetID, before)
+
+	if err != nil {
+		return "", err
+	}
+
+	// moving may have ranked the others again
+	for other, rank := range ranks {
+		otherData := s.stories[other]
+		otherData.Rank = rank
+		s.stories[other] = otherData
+	}
+
+	return ranks[id], nil
+}
+
+// Bugs
+
+type memoryBugStore struct {
+	*memoryDB
+}
+
+func (s *memoryBugStore) list(keep func(bug) bool) []bug {
+	ids := []int64{}
+
+	for id, bugData := range s.bugs {
+		if keep(bugData) {
+			ids = append(ids, id)
+		}
+	}
+
+	bugs := []bug{}
+
+	for _, id := range sortedIDs(ids) {
+		bugs = append(bugs, s.withFeature(s.bugs[id]))
+	}
+
+	sort.SliceStable(bugs, func(i, j int) bool { return bugs[i].Rank < bugs[j].Rank })
+
+	return bugs
+}
+
+func (s *memoryBugStore) withFeature(bugData bug) bug {
+	bugData.Feature = &feature{ID: bugData.FeatureID, Name: s.features[bugData.FeatureID].Name}
+
+	return bugData
+}
+
+func (s *memoryBugStore) all() ([]bug, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list(func(b bug) bool { return b.DeletedAt == "" }), nil
+}
+
+func (s *memoryBugStore) byFeature(featureID int64) ([]bug, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list(func(b bug) bool { return b.FeatureID == featureID && b.DeletedAt == "" }), nil
+}
+
+func (s *memoryBugStore) assignedTo(userID int64) ([]bug, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list(func(b bug) bool { return b.AssigneeID == userID }), nil
+}
+
+func (s *memoryBugStore) find(id int64) (bug, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bugData, ok := s.bugs[id]
+
+	if !ok {
+		return bug{}, sql.ErrNoRows
+	}
+
+	return s.withFeature(bugData), nil
+}
+
+func (s *memoryBugStore) create(bugData bug) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.add(bugData), nil
+}
+
+// createWithLabels holds the lock throughout, so the bug and its labels are added together.
+func (s *memoryBugStore) createWithLabels(bugData bug, labelIDs []int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	set, err := s.labelSet(labelIDs)
+
+	if err != nil {
+		return 0, err
+	}
+
+	id := s.add(bugData)
+
+	if len(set) > 0 {
+		s.bugLabels[id] = set
+	}
+
+	return id, nil
+}
+
+// add needs the lock held.
+func (s *memoryBugStore) add(bugData bug) int64 {
+	now := s.now()
+
+	bugData.ID = s.id()
+	bugData.CreatedAt = now
+	bugData.UpdatedAt = now
+	bugData.Creator = nil
+	bugData.Assignee = nil
+	bugData.Feature = nil
+	bugData.Project = nil
+	bugData.Rank = nextRank(s.bugRanks().last())
+
+	s.bugs[bugData.ID] = bugData
+
+	return bugData.ID
+}
+
+func (s *memoryBugStore) update(bugData bug) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.change(bugData)
+
+	return nil
+}
+
+// save holds the lock throughout, so the changes, the labels and the done state are saved together.
+func (s *memoryBugStore) save(bugData bug, labelIDs []int64, done bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.bugs[bugData.ID]; !ok {
+		return nil
+	}
+
+	set, err := s.labelSet(labelIDs)
+
+	if err != nil {
+		return err
+	}
+
+	s.change(bugData)
+	s.bugLabels[bugData.ID] = set
+	s.markDone(bugData.ID, done)
+
+	return nil
+}
+
+// change needs the lock held.
+func (s *memoryBugStore) change(bugData bug) {
+	existing, ok := s.bugs[bugData.ID]
+
+	if !ok {
+		return
+	}
+
+	existing.Name = bugData.Name
+	existing.Description = bugData.Description
+	existing.AssigneeID = bugData.AssigneeID
+	existing.Priority = bugData.Priority
+	existing.Severity = bugData.Severity
+	existing.UpdatedAt = s.now()
+
+	s.bugs[bugData.ID] = existing
+}
+
+func (s *memoryBugStore) destroy(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if bugData, ok := s.bugs[id]; ok {
+		bugData.DeletedAt = s.now()
+		s.bugs[id] = bugData
+	}
+
+	return nil
+}
+
+func (s *memoryBugStore) openByProject() ([]projectBugCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []int64{}
+
+	for id, projectData := range s.projects {
+		if projectData.DeletedAt == "" {
+			ids = append(ids, id)
+		}
+	}
+
+	counts := []projectBugCount{}
+
+	for _, id := range sortedIDs(ids) {
+		c := projectBugCount{ProjectID: id, ProjectName: s.projects[id].Name}
+
+		for _, bugData := range s.bugs {
+			featureData := s.features[bugData.FeatureID]
+
+			if bugData.DeletedAt == "" && bugData.CompletedAt == "" && featureData.ProjectID == id && featureData.DeletedAt == "" {
+				c.Count++
+			}
+		}
+
+		counts = append(counts, c)
+	}
+
+	return counts, nil
+}
+
+func (s *memoryBugStore) bySprint(sprintID int64) ([]bug, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list(func(b bug) bool { return b.SprintID == sprintID && b.DeletedAt == "" }), nil
+}
+
+func (s *memoryBugStore) backlog(projectID int64) ([]bug, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list(func(b bug) bool {
+		featureData := s.features[b.FeatureID]
+
+		return featureData.ProjectID == projectID && featureData.DeletedAt == "" &&
+			b.SprintID == 0 && b.CompletedAt == "" && b.DeletedAt == ""
+	}), nil
+}
+
+func (s *memoryBugStore) setSprint(id, sprintID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if bugData, ok := s.bugs[id]; ok {
+		bugData.SprintID = sprintID
+		bugData.UpdatedAt = s.now()
+		s.bugs[id] = bugData
+	}
+
+	return nil
+}
+
+func (s *memoryBugStore) setDone(id int64, done bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.markDone(id, done)
+
+	return nil
+}
+
+// markDone needs the lock held.
+func (s *memoryBugStore) markDone(id int64, done bool) {
+	bugData, ok := s.bugs[id]
+
+	if !ok {
+		return
+	}
+
+	if !done {
+		bugData.CompletedAt = ""
+	} else if bugData.CompletedAt == "" {
+		bugData.CompletedAt = s.now()
+	}
+
+	bugData.UpdatedAt = s.now()
+	s.bugs[id] = bugData
+}
+
+func (s *memoryBugStore) byProject(projectID int64) ([]bug, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list(func(b bug) bool {
+		featureData := s.features[b.FeatureID]
+
+		return featureData.ProjectID == projectID && featureData.DeletedAt == "" && b.DeletedAt == ""
+	}), nil
+}
+
+func (s *memoryBugStore) setColumn(id, columnID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if bugData, ok := s.bugs[id]; ok {
+		bugData.ColumnID = columnID
+		bugData.UpdatedAt = s.now()
+		s.bugs[id] = bugData
+	}
+
+	return nil
+}
+
+// bugRanks is every bug's rank by id.
+func (s *memoryBugStore) bugRanks() memoryRanks {
+	ranks := memoryRanks{}
+
+	for id, bugData := range s.bugs {
+		ranks[id] = bugData.Rank
+	}
+
+	return ranks
+}
+
+func (s *memoryBugStore) rankBefore(id, targetID int64) (string, error) {
+	return s.rankNextTo(id, targetID, true)
+}
+
+func (s *memoryBugStore) rankAfter(id, targetID int64) (string, error) {
+	return s.rankNextTo(id, targetID, false)
+}
+
+func (s *memoryBugStore) rankNextTo(id, targetID int64, before bool) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.bugs[id]; !ok {
+		return "", sql.ErrNoRows
+	}
+
+	ranks := s.bugRanks()
+
+	err := ranks.moveNextTo(id, targetID, before)
+
+	if err != nil {
+		return "", err
+	}
+
+	// moving may have ranked the others again
+	for other, rank := range ranks {
+		otherData := s.bugs[other]
+		otherData.Rank = rank
+		s.bugs[other] = otherData
+	}
+
+	return ranks[id], nil
+}
+
+// memoryRanks are the ranks of every story or bug by id.
+type memoryRanks map[int64]string
+
+// last is the highest rank, "" if there's none.
+func (ranks memoryRanks) last() string {
+	last := ""
+
+	for _, rank := range ranks {
+		if rank > last {
+			last = rank
+		}
+	}
+
+	return last
+}
+
+// moveNextTo gives id a rank between the target's and the next one before or after it,
+// leaving out id's own. When there's no room there, or only for a rank longer than
+// maxRankLength, every rank is renumbered first.
+func (ranks memoryRanks) moveNextTo(id, targetID int64, before bool) error {
+	target, ok := ranks[targetID]
+
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	neighbour := ""
+
+	for other, rank := range ranks {
+		if other == id {
+			continue
+		}
+
+		if before && rank < target && rank > neighbour {
+			neighbour = rank
+		}
+
+		if !before && rank > target && (neighbour == "" || rank < neighbour) {
+			neighbour = rank
+		}
+	}
+
+	var rank string
+
+	if before {
+		rank, ok = rankBetween(neighbour, target)
+	} else {
+		rank, ok = rankBetween(target, neighbour)
+	}
+
+	if !ok || len(rank) > maxRankLength {
+		ranks.rerank()
+
+		return ranks.moveNextTo(id, targetID, before)
+	}
+
+	ranks[id] = rank
+
+	return nil
+}
+
+// rerank gives every story or bug a whole rank, keeping their order, like the SQL store's rerank.
+func (ranks memoryRanks) rerank() {
+	ids := []int64{}
+
+	for id := range ranks {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		if ranks[ids[i]] != ranks[ids[j]] {
+			return ranks[ids[i]] < ranks[ids[j]]
+		}
+
+		return ids[i] < ids[j]
+	})
+
+	rank := ""
+
+	for _, id := range ids {
+		rank = nextRank(rank)
+		ranks[id] = rank
+	}
+}
+
+// Sprints
+
+type memorySprintStore struct {
+	*memoryDB
+}
+
+func (s *memorySprintStore) byProject(projectID int64) ([]sprint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sprints := []sprint{}
+
+	for _, sprintData := range s.sprints {
+		if sprintData.ProjectID == projectID {
+			sprints = append(sprints, sprintData)
+		}
+	}
+
+	sort.Slice(sprints, func(i, j int) bool {
+		if sprints[i].StartsOn != sprints[j].StartsOn {
+			return sprints[i].StartsOn > sprints[j].StartsOn
+		}
+
+		return sprints[i].ID > sprints[j].ID
+	})
+
+	return sprints, nil
+}
+
+func (s *memorySprintStore) find(id int64) (sprint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sprintData, ok := s.sprints[id]
+
+	if !ok {
+		return sprint{}, sql.ErrNoRows
+	}
+
+	return sprintData, nil
+}
+
+func (s *memorySprintStore) create(sprintData sprint) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	sprintData.ID = s.id()
+	sprintData.State = sprintPlanned
+	sprintData.CreatedAt = now
+	sprintData.UpdatedAt = now
+	sprintData.ClosedAt = ""
+
+	s.sprints[sprintData.ID] = sprintData
+
+	return sprintData.ID, nil
+}
+
+func (s *memorySprintStore) update(sprintData sprint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.sprints[sprintData.ID]
+
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	existing.Name = sprintData.Name
+	existing.Goal = sprintData.Goal
+	existing.StartsOn = sprintData.StartsOn
+	existing.EndsOn = sprintData.EndsOn
+	existing.UpdatedAt = s.now()
+
+	s.sprints[sprintData.ID] = existing
+
+	return nil
+}
+
+func (s *memorySprintStore) start(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sprintData, ok := s.sprints[id]
+
+	if !ok || sprintData.State != sprintPlanned {
+		return sql.ErrNoRows
+	}
+
+	for _, other := range s.sprints {
+		if other.ProjectID == sprintData.ProjectID && other.State == sprintActive {
+			return errSprintActive
+		}
+	}
+
+	sprintData.State = sprintActive
+	sprintData.UpdatedAt = s.now()
+	s.sprints[id] = sprintData
+
+	return nil
+}
+
+func (s *memorySprintStore) close(id, carryTo int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sprintData, ok := s.sprints[id]
+
+	if !ok || sprintData.State != sprintActive {
+		return 0, sql.ErrNoRows
+	}
+
+	now := s.now()
+
+	sprintData.State = sprintClosed
+	sprintData.ClosedAt = now
+	sprintData.UpdatedAt = now
+	s.sprints[id] = sprintData
+
+	moved := 0
+
+	for storyID, storyData := range s.stories {
+		if storyData.SprintID == id && storyData.CompletedAt == "" && storyData.DeletedAt == "" {
+			storyData.SprintID = carryTo
+			storyData.UpdatedAt = now
+			s.stories[storyID] = storyData
+			moved++
+		}
+	}
+
+	for bugID, bugData := range s.bugs {
+		if bugData.SprintID == id && bugData.CompletedAt == "" && bugData.DeletedAt == "" {
+			bugData.SprintID = carryTo
+			bugData.UpdatedAt = now
+			s.bugs[bugID] = bugData
+			moved++
+		}
+	}
+
+	return moved, nil
+}
+
+// Boards
+
+type memoryBoardStore struct {
+	*memoryDB
+}
+
+func (s *memoryBoardStore) columns(projectID int64) ([]boardColumn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	columns := []boardColumn{}
+
+	for _, columnData := range s.boardColumns {
+		if columnData.ProjectID == projectID {
+			columns = append(columns, columnData)
+		}
+	}
+
+	sort.Slice(columns, func(i, j int) bool {
+		if columns[i].Position != columns[j].Position {
+			return columns[i].Position < columns[j].Position
+		}
+
+		return columns[i].ID < columns[j].ID
+	})
+
+	return columns, nil
+}
+
+func (s *memoryBoardStore) findColumn(id int64) (boardColumn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	columnData, ok := s.boardColumns[id]
+
+	if !ok {
+		return boardColumn{}, sql.ErrNoRows
+	}
+
+	return columnData, nil
+}
+
+func (s *memoryBoardStore) createColumn(columnData boardColumn) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	columnData.ID = s.id()
+	s.boardColumns[columnData.ID] = columnData
+
+	return columnData.ID, nil
+}
+
+func (s *memoryBoardStore) updateColumn(columnData boardColumn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.boardColumns[columnData.ID]
+
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	existing.Name = columnData.Name
+	existing.Position = columnData.Position
+	existing.WIPLimit = columnData.WIPLimit
+	existing.Done = columnData.Done
+
+	s.boardColumns[columnData.ID] = existing
+
+	return nil
+}
+
+func (s *memoryBoardStore) destroyColumn(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.boardColumns[id]; !ok {
+		return sql.ErrNoRows
+	}
+
+	delete(s.boardColumns, id)
+
+	for storyID, storyData := range s.stories {
+		if storyData.ColumnID == id {
+			storyData.ColumnID = 0
+			s.stories[storyID] = storyData
+		}
+	}
+
+	for bugID, bugData := range s.bugs {
+		if bugData.ColumnID == id {
+			bugData.ColumnID = 0
+			s.bugs[bugID] = bugData
+		}
+	}
+
+	return nil
+}
+
+// Links
+
+type memoryLinkStore struct {
+	*memoryDB
+}
+
+// withNames fills in the names of the issues at both ends of the link.
+func (s *memoryLinkStore) withNames(linkData issueLink) issueLink {
+	for _, end := range []*issueRef{&linkData.From, &linkData.To} {
+		if end.Type == "bug" {
+			end.Name, end.Deleted = s.bugs[end.ID].Name, s.bugs[end.ID].DeletedAt != ""
+		} else {
+			end.Name, end.Deleted = s.stories[end.ID].Name, s.stories[end.ID].DeletedAt != ""
+		}
+	}
+
+	return linkData
+}
+
+func (s *memoryLinkStore) byIssue(issue issueRef) ([]issueLink, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []int64{}
+
+	for id, linkData := range s.issueLinks {
+		if linkData.From.same(issue) || linkData.To.same(issue) {
+			ids = append(ids, id)
+		}
+	}
+
+	links := []issueLink{}
+
+	for _, id := range sortedIDs(ids) {
+		links = append(links, s.withNames(s.issueLinks[id]))
+	}
+
+	return links, nil
+}
+
+func (s *memoryLinkStore) find(id int64) (issueLink, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	linkData, ok := s.issueLinks[id]
+
+	if !ok {
+		return issueLink{}, sql.ErrNoRows
+	}
+
+	return s.withNames(linkData), nil
+}
+
+func (s *memoryLinkStore) create(linkData issueLink) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.issueLinks {
+		if existing.Type == linkData.Type && existing.From.same(linkData.From) && existing.To.same(linkData.To) {
+			return 0, errLinkExists
+		}
+	}
+
+	linkData.ID = s.id()
+	linkData.CreatedAt = s.now()
+	linkData.From = issueRef{Type: linkData.From.Type, ID: linkData.From.ID}
+	linkData.To = issueRef{Type: linkData.To.Type, ID: linkData.To.ID}
+
+	s.issueLinks[linkData.ID] = linkData
+
+	return linkData.ID, nil
+}
+
+func (s *memoryLinkStore) destroy(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.issueLinks[id]; !ok {
+		return sql.ErrNoRows
+	}
+
+	delete(s.issueLinks, id)
+
+	return nil
+}
+
+// Labels
+
+type memoryLabelStore struct {
+	*memoryDB
+}
+
+// withUses fills in the name of the label's project and how many stories and bugs that haven't been deleted have it.
+func (s *memoryLabelStore) withUses(labelData label) label {
+	labelData.Project = s.projects[labelData.ProjectID].Name
+	labelData.Uses = 0
+
+	for id, labelIDs := range s.storyLabels {
+		if labelIDs[labelData.ID] && s.stories[id].DeletedAt == "" {
+			labelData.Uses++
+		}
+	}
+
+	for id, labelIDs := range s.bugLabels {
+		if labelIDs[labelData.ID] && s.bugs[id].DeletedAt == "" {
+			labelData.Uses++
+		}
+	}
+
+	return labelData
+}
+
+func (s *memoryLabelStore) byProject(projectID int64) ([]label, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []int64{}
+
+	for id, labelData := range s.labels {
+		if labelData.ProjectID == projectID {
+			ids = append(ids, id)
+		}
+	}
+
+	labels := []label{}
+
+	for _, id := range sortedIDs(ids) {
+		labels = append(labels, s.withUses(s.labels[id]))
+	}
+
+	return sortLabels(labels), nil
+}
+
+func (s *memoryLabelStore) all() ([]label, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []int64{}
+
+	for id, labelData := range s.labels {
+		if s.projects[labelData.ProjectID].DeletedAt == "" {
+			ids = append(ids, id)
+		}
+	}
+
+	labels := []label{}
+
+	for _, id := range sortedIDs(ids) {
+		labels = append(labels, s.withUses(s.labels[id]))
+	}
+
+	sortLabels(labels)
+
+	sort.SliceStable(labels, func(i, j int) bool {
+		return strings.ToLower(labels[i].Project) < strings.ToLower(labels[j].Project)
+	})
+
+	return labels, nil
+}
+
+func (s *memoryLabelStore) find(id int64) (label, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	labelData, ok := s.labels[id]
+
+	if !ok {
+		return label{}, sql.ErrNoRows
+	}
+
+	return s.withUses(labelData), nil
+}
+
+// taken reports whether another of the project's labels has the name, whatever its case.
+func (s *memoryLabelStore) taken(labelData label) bool {
+	for id, existing := range s.labels {
+		if id != labelData.ID && existing.ProjectID == labelData.ProjectID && strings.EqualFold(existing.Name, labelData.Name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *memoryLabelStore) create(labelData label) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	labelData.ID = 0
+
+	if s.taken(labelData) {
+		return 0, errLabelExists
+	}
+
+	labelData.ID = s.id()
+	labelData.Project = ""
+	labelData.Uses = 0
+	labelData.CreatedAt = s.now()
+	labelData.UpdatedAt = labelData.CreatedAt
+
+	s.labels[labelData.ID] = labelData
+
+	return labelData.ID, nil
+}
+
+func (s *memoryLabelStore) update(labelData label) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.labels[labelData.ID]
+
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	if s.taken(label{ID: existing.ID, ProjectID: existing.ProjectID, Name: labelData.Name}) {
+		return errLabelExists
+	}
+
+	existing.Name = labelData.Name
+	existing.Color = labelData.Color
+	existing.UpdatedAt = s.now()
+
+	s.labels[existing.ID] = existing
+
+	return nil
+}
+
+func (s *memoryLabelStore) merge(id, intoID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.labels[id]; !ok {
+		return sql.ErrNoRows
+	}
+
+	for _, issues := range []map[int64]map[int64]bool{s.storyLabels, s.bugLabels} {
+		for _, labelIDs := range issues {
+			if labelIDs[id] {
+				delete(labelIDs, id)
+				labelIDs[intoID] = true
+			}
+		}
+	}
+
+	delete(s.labels, id)
+
+	return nil
+}
+
+// issueLabels returns the labels of the issues by name, keyed by their id.
+func (s *memoryLabelStore) issueLabels(issues map[int64]map[int64]bool, ids []int64) map[int64][]label {
+	labels := make(map[int64][]label)
+
+	for _, id := range ids {
+		if _, done := labels[id]; done {
+			continue
+		}
+
+		labelIDs := []int64{}
+
+		for labelID := range issues[id] {
+			labelIDs = append(labelIDs, labelID)
+		}
+
+		for _, labelID := range sortedIDs(labelIDs) {
+			labelData := s.labels[labelID]
+			labels[id] = append(labels[id], label{ID: labelData.ID, ProjectID: labelData.ProjectID, Name: labelData.Name, Color: labelData.Color})
+		}
+
+		if labels[id] != nil {
+			sortLabels(labels[id])
+		}
+	}
+
+	return labels
+}
+
+func (s *memoryLabelStore) forStories(ids []int64) (map[int64][]label, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.issueLabels(s.storyLabels, ids), nil
+}
+
+func (s *memoryLabelStore) forBugs(ids []int64) (map[int64][]label, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.issueLabels(s.bugLabels, ids), nil
+}
+
+// setIssueLabels replaces the labels of the issue id, returning sql.ErrNoRows if any of the labels don't exist.
+func (s *memoryLabelStore) setIssueLabels(issues map[int64]map[int64]bool, id int64, labelIDs []int64) error {
+	set, err := s.labelSet(labelIDs)
+
+	if err != nil {
+		return err
+	}
+
+	issues[id] = set
+
+	return nil
+}
+
+// labelSet returns the labels as a set, or sql.ErrNoRows if any of them don't exist.
+// It needs the lock held.
+func (s *memoryDB) labelSet(labelIDs []int64) (map[int64]bool, error) {
+	set := make(map[int64]bool)
+
+	for _, labelID := range labelIDs {
+		if _, ok := s.labels[labelID]; !ok {
+			return nil, sql.ErrNoRows
+		}
+
+		set[labelID] = true
+	}
+
+	return set, nil
+}
+
+func (s *memoryLabelStore) setStoryLabels(id int64, labelIDs []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.setIssueLabels(s.storyLabels, id, labelIDs)
+}
+
+func (s *memoryLabelStore) setBugLabels(id int64, labelIDs []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.setIssueLabels(s.bugLabels, id, labelIDs)
+}
+
+// Jobs
+
+type memoryJobStore struct {
+	*memoryDB
+}
+
+func (s *memoryJobStore) enqueue(jobData job, runAt time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if jobData.UniqueKey != "" {
+		if s.jobKeys[jobData.UniqueKey] {
+			return 0, nil
+		}
+
+		s.jobKeys[jobData.UniqueKey] = true
+	}
+
+	jobData.ID = s.id()
+	jobData.Status = "queued"
+	jobData.Attempts = 0
+	jobData.RunAt = runAt.UTC().Format(time.RFC3339)
+	jobData.CreatedAt = s.now()
+
+	s.jobs[jobData.ID] = memoryJob{job: jobData, runAt: runAt}
+
+	return jobData.ID, nil
+}
+
+func (s *memoryJobStore) claim(now, stale time.Time) (job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next *memoryJob
+
+	for id := range s.jobs {
+		j := s.jobs[id]
+
+		due := (j.Status == "queued" && !j.runAt.After(now)) || (j.Status == "running" && j.lockedAt.Before(stale))
+
+		if !due {
+			continue
+		}
+
+		if next == nil || j.runAt.Before(next.runAt) || (j.runAt.Equal(next.runAt) && j.ID < next.ID) {
+			next = &j
+		}
+	}
+
+	if next == nil {
+		return job{}, sql.ErrNoRows
+	}
+
+	next.Status = "running"
+	next.Attempts++
+	next.lockedAt = now
+
+	s.jobs[next.ID] = *next
+
+	return next.job, nil
+}
+
+func (s *memoryJobStore) complete(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	j.Status = "done"
+	j.LastError = ""
+	j.finishedAt = time.Now()
+	j.FinishedAt = s.now()
+
+	s.jobs[id] = j
+
+	return nil
+}
+
+func (s *memoryJobStore) fail(id int64, message string, retryAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	j.LastError = message
+
+	if retryAt.IsZero() {
+		j.Status = "dead"
+		j.finishedAt = time.Now()
+		j.FinishedAt = s.now()
+	} else {
+		j.Status = "queued"
+		j.runAt = retryAt
+		j.RunAt = retryAt.UTC().Format(time.RFC3339)
+	}
+
+	s.jobs[id] = j
+
+	return nil
+}
+
+func (s *memoryJobStore) retry(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+
+	if !ok || j.Status != "dead" {
+		return sql.ErrNoRows
+	}
+
+	j.Status = "queued"
+	j.Attempts = 0
+	j.runAt = time.Now()
+	j.RunAt = s.now()
+	j.finishedAt = time.Time{}
+	j.FinishedAt = ""
+
+	s.jobs[id] = j
+
+	return nil
+}
+
+func (s *memoryJobStore) destroy(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+
+	if !ok || j.Status != "dead" {
+		return sql.ErrNoRows
+	}
+
+	delete(s.jobs, id)
+
+	return nil
+}
+
+func (s *memoryJobStore) counts() (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := map[string]int{"queued": 0, "running": 0, "done": 0, "dead": 0}
+
+	for _, j := range s.jobs {
+		counts[j.Status]++
+	}
+
+	return counts, nil
+}
+
+func (s *memoryJobStore) dead(limit int) ([]job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dead := []memoryJob{}
+
+	for _, j := range s.jobs {
+		if j.Status == "dead" {
+			dead = append(dead, j)
+		}
+	}
+
+	sort.Slice(dead, func(i, k int) bool {
+		if dead[i].finishedAt.Equal(dead[k].finishedAt) {
+			return dead[i].ID > dead[k].ID
+		}
+
+		return dead[i].finishedAt.After(dead[k].finishedAt)
+	})
+
+	jobs := []job{}
+
+	for i := 0; i < len(dead) && i < limit; i++ {
+		jobs = append(jobs, dead[i].job)
+	}
+
+	return jobs, nil
+}
+
+func (s *memoryJobStore) purge(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+
+	for id, j := range s.jobs {
+		if j.Status == "done" && j.finishedAt.Before(before) {
+			delete(s.jobs, id)
+			n++
+		}
+	}
+
+	return n, nil
+}
@@ -0,0 +1,1048 @@
+package main
+
+// The memory store stands in for the database in the handler tests below, which
+// call the handlers directly instead of going through the router and a session.
+// The store tests run each case on both stores, so the memory store can't drift
+// from the SQL store the app uses.
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+// storeFixtures are the rows seedStore adds: a project owned by the user, with one
+// feature, its stories and bugs, two labels and a planned sprint.
+type storeFixtures struct {
+	UserID    int64
+	ProjectID int64
+	FeatureID int64
+	StoryIDs  []int64
+	BugIDs    []int64
+	LabelIDs  []int64
+	SprintID  int64
+}
+
+// seedStore adds the storeFixtures through the store's own methods,
+// so it works the same on an empty memory store and on the seeded database.
+func seedStore(t *testing.T, store stores) storeFixtures {
+	t.Helper()
+
+	var fx storeFixtures
+	var err error
+
+	check := func(id int64, err error) int64 {
+		t.Helper()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return id
+	}
+
+	fx.UserID = check(store.users.create(user{Name: "Store Test", Email: "store_test@example.com", Username: "store_test", Password: "-"}))
+	fx.ProjectID = check(store.projects.create(project{Name: "Store Test", UserID: fx.UserID, PointScale: scaleFibonacci}))
+	fx.FeatureID = check(store.features.create(feature{Name: "Store Test", ProjectID: fx.ProjectID, UserID: fx.UserID}))
+
+	for i := 0; i < 3; i++ {
+		fx.StoryIDs = append(fx.StoryIDs, check(store.stories.create(story{
+			Name:      "Story " + strconv.Itoa(i),
+			FeatureID: fx.FeatureID,
+			UserID:    fx.UserID,
+			Priority:  defaultPriority,
+		})))
+	}
+
+	for i := 0; i < 2; i++ {
+		fx.BugIDs = append(fx.BugIDs, check(store.bugs.create(bug{
+			Name:      "Bug " + strconv.Itoa(i),
+			FeatureID: fx.FeatureID,
+			UserID:    fx.UserID,
+			Priority:  defaultPriority,
+			Severity:  defaultSeverity,
+		})))
+	}
+
+	for _, name := range []string{"backend", "flaky"} {
+		fx.LabelIDs = append(fx.LabelIDs, check(store.labels.create(label{ProjectID: fx.ProjectID, Name: name, Color: "#0366d6"})))
+	}
+
+	fx.SprintID, err = store.sprints.create(sprint{ProjectID: fx.ProjectID, Name: "Sprint 1", StartsOn: "2021-06-01", EndsOn: "2021-06-14"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fx
+}
+
+// eachStore runs the test on a fresh memory store and on the test database,
+// when there is one, each seeded with the storeFixtures.
+func eachStore(t *testing.T, test func(t *testing.T, store stores, fx storeFixtures)) {
+	t.Run("memory", func(t *testing.T) {
+		store := newMemoryStores()
+
+		test(t, store, seedStore(t, store))
+	})
+
+	t.Run("sql", func(t *testing.T) {
+		a := testApplication(t)
+
+		a.reset(t)
+		defer a.reset(t)
+
+		store := newSQLStores(a.db)
+
+		test(t, store, seedStore(t, store))
+	})
+}
+
+// memoryApp is a set of services on a memory store seeded with the storeFixtures.
+type memoryApp struct {
+	store    stores
+	fx       storeFixtures
+	links    *linkService
+	labels   *labelService
+	sprints  *sprintService
+	boards   *boardService
+	stories  *storyService
+	bugs     *bugService
+	authUser user
+}
+
+func newMemoryApp(t *testing.T) *memoryApp {
+	t.Helper()
+
+	quiet := logrus.New()
+	quiet.SetOutput(ioutil.Discard)
+
+	store := newMemoryStores()
+	fx := seedStore(t, store)
+
+	authUser, err := store.users.find(fx.UserID)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the handlers only render pages on success, which these tests don't look at
+	return &memoryApp{
+		store:    store,
+		fx:       fx,
+		links:    NewLinkService(store, quiet, nil),
+		labels:   NewLabelService(store, quiet, nil),
+		sprints:  NewSprintService(store, quiet, nil),
+		boards:   NewBoardService(store, quiet, nil),
+		stories:  NewStoryService(store, quiet, nil),
+		bugs:     NewBugService(store, quiet, nil),
+		authUser: authUser,
+	}
+}
+
+// call runs the handler as authUser, posting the form if there is one. Errors come
+// back as JSON, so nothing needs rendering. ps are the route's parameters as name, value pairs.
+func (m *memoryApp) call(handle httprouter.Handle, method string, form url.Values, ps ...string) *httptest.ResponseRecorder {
+	var params httprouter.Params
+
+	for i := 0; i+1 < len(ps); i += 2 {
+		params = append(params, httprouter.Param{Key: ps[i], Value: ps[i+1]})
+	}
+
+	r := httptest.NewRequest(method, "/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Accept", "application/json")
+	r = r.WithContext(context.WithValue(r.Context(), "user", m.authUser))
+
+	w := httptest.NewRecorder()
+
+	handle(w, r, params)
+
+	return w
+}
+
+func formatID(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+func TestStoreUsers(t *testing.T) {
+	eachStore(t, func(t *testing.T, store stores, fx storeFixtures) {
+		found, err := store.users.find(fx.UserID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if found.Username != "store_test" || found.Email != "store_test@example.com" || found.Password != "" {
+			t.Errorf("finding the user: got %+v", found)
+		}
+
+		roleID, err := store.roles.create(role{Name: "Store Test"})
+
+		if err == nil {
+			err = store.users.setRole(fx.UserID, roleID)
+		}
+
+		if err == nil {
+			err = store.users.setPassword(fx.UserID, "hash")
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found, err = store.users.find(fx.UserID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if found.RoleID != roleID {
+			t.Errorf("the user's role: got %d, want %d", found.RoleID, roleID)
+		}
+
+		found, err = store.users.findByUsername("store_test")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if found.ID != fx.UserID || found.Password != "hash" {
+			t.Errorf("finding the user by username: got %+v, want the new hash", found)
+		}
+
+		_, err = store.users.findByUsername("nobody")
+
+		if err != sql.ErrNoRows {
+			t.Errorf("finding a missing username: got %v, want sql.ErrNoRows", err)
+		}
+
+		storyData, err := store.stories.find(fx.StoryIDs[0])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		storyData.AssigneeID = fx.UserID
+
+		err = store.stories.update(storyData)
+
+		if err == nil {
+			err = store.users.destroy(fx.UserID)
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// deleted users are still listed, and lose their stories
+		all, err := store.users.all()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		listed := false
+
+		for _, userData := range all {
+			if userData.ID == fx.UserID {
+				listed = userData.DeletedAt != "" && userData.Password == ""
+			}
+		}
+
+		if !listed {
+			t.Errorf("users: got %+v, want the deleted user without their password", all)
+		}
+
+		storyData, err = store.stories.find(fx.StoryIDs[0])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if storyData.AssigneeID != 0 {
+			t.Errorf("story of a deleted user: got assignee %d, want none", storyData.AssigneeID)
+		}
+	})
+}
+
+func TestStoreSessions(t *testing.T) {
+	eachStore(t, func(t *testing.T, store stores, fx storeFixtures) {
+		adminID, err := store.users.create(user{Name: "Store Admin", Email: "store_admin@example.com", Username: "store_admin", Password: "-"})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		first, second, adminSession := uuid.New().String(), uuid.New().String(), uuid.New().String()
+
+		for _, session := range []struct {
+			uuid   string
+			userID int64
+		}{{first, fx.UserID}, {second, fx.UserID}, {adminSession, adminID}} {
+			if err := store.sessions.create(session.uuid, session.userID); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// logging in again ends the first session
+		_, err = store.sessions.user(first)
+
+		if err != sql.ErrNoRows {
+			t.Errorf("the user's first session: got %v, want sql.ErrNoRows", err)
+		}
+
+		found, err := store.sessions.user(second)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if found.ID != fx.UserID || found.Impersonator != nil {
+			t.Errorf("the user's session: got %+v", found)
+		}
+
+		err = store.sessions.startImpersonation(adminSession, adminID, fx.UserID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found, err = store.sessions.user(adminSession)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if found.ID != fx.UserID || found.Impersonator == nil || found.Impersonator.ID != adminID {
+			t.Errorf("impersonating: got %+v, want the user with the admin as impersonator", found)
+		}
+
+		err = store.sessions.endImpersonation(adminSession)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found, err = store.sessions.user(adminSession)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if found.ID != adminID || found.Impersonator != nil {
+			t.Errorf("after impersonating: got %+v, want the admin", found)
+		}
+
+		impersonations, err := store.sessions.impersonations()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(impersonations) == 0 || impersonations[0].Admin.Name != "Store Admin" || impersonations[0].User.Name != "Store Test" || impersonations[0].EndedAt == "" {
+			t.Errorf("impersonations: got %+v, want the ended one first", impersonations)
+		}
+
+		n, err := store.sessions.purge(time.Now().Add(time.Hour))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n < 2 {
+			t.Errorf("purging: got %d sessions, want at least 2", n)
+		}
+
+		_, err = store.sessions.user(second)
+
+		if err != sql.ErrNoRows {
+			t.Errorf("a purged session: got %v, want sql.ErrNoRows", err)
+		}
+	})
+}
+
+func TestStoreRoles(t *testing.T) {
+	eachStore(t, func(t *testing.T, store stores, fx storeFixtures) {
+		err := store.roles.syncCapabilities(capabilityRegistry)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		capabilities, err := store.roles.capabilities()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		capabilityIDs := make(map[string]int64)
+
+		for _, c := range capabilities {
+			capabilityIDs[c.Name] = c.ID
+		}
+
+		if len(capabilityIDs) < len(capabilityRegistry) || capabilityIDs["read_stories_mine"] == 0 {
+			t.Fatalf("capabilities: got %d, want at least the %d registered", len(capabilityIDs), len(capabilityRegistry))
+		}
+
+		parentID, err := store.roles.create(role{Name: "Store Parent"})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		childID, err := store.roles.create(role{Name: "Store Child", ParentID: parentID})
+
+		if err == nil {
+			err = store.roles.setPermissions(parentID, []int64{capabilityIDs["read_stories_mine"], capabilityIDs["read_bugs_mine"]})
+		}
+
+		if err == nil {
+			err = store.roles.update(role{ID: childID, Name: "Store Child", Description: "Inherits", ParentID: parentID})
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		child, err := store.roles.find(childID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if child.Description != "Inherits" || child.ParentID != parentID || child.ParentName != "Store Parent" {
+			t.Errorf("finding the role: got %+v, want it with its parent", child)
+		}
+
+		// permissions are only the role's own
+		own, err := store.roles.permissions(childID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(own) != 0 {
+			t.Errorf("the child's permissions: got %v, want none", own)
+		}
+
+		copyID, err := store.roles.clone(parentID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		copied, err := store.roles.permissions(copyID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := copied["read_bugs_mine"]; len(copied) != 2 || !ok {
+			t.Errorf("the copy's permissions: got %v, want the parent's", copied)
+		}
+
+		copyData, err := store.roles.find(copyID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if copyData.Name != "Copy of Store Parent" {
+			t.Errorf("the copy: got %q, want Copy of Store Parent", copyData.Name)
+		}
+
+		err = store.roles.destroy(copyID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = store.roles.find(copyID)
+
+		if err != sql.ErrNoRows {
+			t.Errorf("finding a deleted role: got %v, want sql.ErrNoRows", err)
+		}
+	})
+}
+
+func TestStoreProjects(t *testing.T) {
+	eachStore(t, func(t *testing.T, store stores, fx storeFixtures) {
+		err := store.projects.update(project{ID: fx.ProjectID, Name: "Renamed", Description: "Tracked", PointScale: scaleFibonacci, TrackTime: true})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := store.projects.find(fx.ProjectID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if found.Name != "Renamed" || found.Description != "Tracked" || !found.TrackTime || found.UserID != fx.UserID || found.PointScale != scaleFibonacci {
+			t.Errorf("finding the project: got %+v", found)
+		}
+
+		owned, err := store.projects.byUser(fx.UserID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(owned) != 1 || owned[0].ID != fx.ProjectID {
+			t.Errorf("the user's projects: got %+v, want the one", owned)
+		}
+
+		columns, err := store.boards.columns(fx.ProjectID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(columns) != len(defaultColumns) {
+			t.Errorf("board columns: got %+v, want the defaults", columns)
+		}
+
+		err = store.projects.destroy(fx.ProjectID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		all, err := store.projects.all()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, projectData := range all {
+			if projectData.ID == fx.ProjectID {
+				t.Errorf("projects: got the deleted project")
+			}
+		}
+
+		stories, err := store.stories.byFeature(fx.FeatureID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bugs, err := store.bugs.byFeature(fx.FeatureID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(stories) != 0 || len(bugs) != 0 {
+			t.Errorf("issues of a deleted project: got %d stories and %d bugs, want none", len(stories), len(bugs))
+		}
+	})
+}
+
+func TestStoreFeatures(t *testing.T) {
+	eachStore(t, func(t *testing.T, store stores, fx storeFixtures) {
+		err := store.features.update(feature{ID: fx.FeatureID, Name: "Renamed", Description: "Changed", ProjectID: fx.ProjectID})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := store.features.find(fx.FeatureID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if found.Name != "Renamed" || found.Description != "Changed" || found.Project == nil || found.Project.Name != "Store Test" {
+			t.Errorf("finding the feature: got %+v, want it with its project", found)
+		}
+
+		inProject, err := store.features.byProject(fx.ProjectID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(inProject) != 1 || inProject[0].ID != fx.FeatureID {
+			t.Errorf("the project's features: got %+v, want the one", inProject)
+		}
+
+		assigned, err := store.features.assignedTo(fx.UserID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(assigned) != 0 {
+			t.Errorf("features assigned to the user: got %+v, want none", assigned)
+		}
+
+		bugData, err := store.bugs.find(fx.BugIDs[0])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bugData.AssigneeID = fx.UserID
+
+		err = store.bugs.update(bugData)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assigned, err = store.features.assignedTo(fx.UserID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(assigned) != 1 || assigned[0].ID != fx.FeatureID {
+			t.Errorf("features assigned to the user: got %+v, want the one with their bug", assigned)
+		}
+
+		summaries, err := store.features.all()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		counted := false
+
+		for _, summary := range summaries {
+			if summary.Feature.ID == fx.FeatureID {
+				counted = summary.RelatedData.StoryCount == 3 && summary.RelatedData.BugCount == 2
+			}
+		}
+
+		if !counted {
+			t.Errorf("feature summaries: got %+v, want 3 stories and 2 bugs", summaries)
+		}
+
+		err = store.features.destroy(fx.FeatureID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		inProject, err = store.features.byProject(fx.ProjectID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// byProject lists deleted features too
+		if len(inProject) != 1 || inProject[0].DeletedAt == "" {
+			t.Errorf("features after deleting: got %+v, want the deleted one", inProject)
+		}
+	})
+}
+
+func TestStoreStories(t *testing.T) {
+	eachStore(t, func(t *testing.T, store stores, fx storeFixtures) {
+		stories, err := store.stories.byFeature(fx.FeatureID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := storyIDs(stories); got != storyIDs([]story{{ID: fx.StoryIDs[0]}, {ID: fx.StoryIDs[1]}, {ID: fx.StoryIDs[2]}}) {
+			t.Errorf("the feature's stories: got %s, want them in the order they were made", got)
+		}
+
+		storyData, err := store.stories.find(fx.StoryIDs[1])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if storyData.Feature == nil || storyData.Feature.Name != "Store Test" {
+			t.Errorf("finding the story: got %+v, want it with its feature", storyData)
+		}
+
+		storyData.Name = "Renamed"
+		storyData.AssigneeID = fx.UserID
+		storyData.Points = 5
+		storyData.EstimateMinutes = 90
+		storyData.Priority = P1
+
+		err = store.stories.update(storyData)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assigned, err := store.stories.assignedTo(fx.UserID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(assigned) != 1 || assigned[0].Name != "Renamed" || assigned[0].Points != 5 || assigned[0].EstimateMinutes != 90 || assigned[0].Priority != P1 {
+			t.Errorf("stories assigned to the user: got %+v, want the updated one", assigned)
+		}
+
+		err = store.stories.setDone(fx.StoryIDs[1], true)
+
+		if err == nil {
+			err = store.stories.destroy(fx.StoryIDs[0])
+		}
+
+		if err == nil {
+			err = store.features.destroy(fx.FeatureID)
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		backlog, err := store.stories.backlog(fx.ProjectID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(backlog) != 0 {
+			t.Errorf("backlog of a deleted feature: got %s", storyIDs(backlog))
+		}
+
+		// restoring a story brings back its feature
+		featureID, err := store.stories.restore(fx.StoryIDs[0])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if featureID != fx.FeatureID {
+			t.Errorf("restoring: got feature %d, want %d", featureID, fx.FeatureID)
+		}
+
+		backlog, err = store.stories.backlog(fx.ProjectID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the done story isn't in the backlog
+		if got := storyIDs(backlog); got != storyIDs([]story{{ID: fx.StoryIDs[0]}, {ID: fx.StoryIDs[2]}}) {
+			t.Errorf("backlog: got %s", got)
+		}
+
+		storyData, err = store.stories.find(fx.StoryIDs[1])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if storyData.CompletedAt == "" {
+			t.Errorf("a done story: got no completed at")
+		}
+
+		err = store.stories.setDone(fx.StoryIDs[1], false)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		storyData, err = store.stories.find(fx.StoryIDs[1])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if storyData.CompletedAt != "" {
+			t.Errorf("a reopened story: got completed at %q", storyData.CompletedAt)
+		}
+	})
+}
+
+func TestStoreBugs(t *testing.T) {
+	eachStore(t, func(t *testing.T, store stores, fx storeFixtures) {
+		bugs, err := store.bugs.byFeature(fx.FeatureID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(bugs) != 2 || bugs[0].ID != fx.BugIDs[0] || bugs[1].ID != fx.BugIDs[1] {
+			t.Errorf("the feature's bugs: got %+v, want them in the order they were made", bugs)
+		}
+
+		bugData, err := store.bugs.find(fx.BugIDs[0])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bugData.Feature == nil || bugData.Feature.Name != "Store Test" || bugData.Severity != defaultSeverity {
+			t.Errorf("finding the bug: got %+v, want it with its feature", bugData)
+		}
+
+		bugData.Name = "Renamed"
+		bugData.AssigneeID = fx.UserID
+		bugData.Severity = severityCritical
+
+		err = store.bugs.update(bugData)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assigned, err := store.bugs.assignedTo(fx.UserID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(assigned) != 1 || assigned[0].Name != "Renamed" || assigned[0].Severity != severityCritical {
+			t.Errorf("bugs assigned to the user: got %+v, want the updated one", assigned)
+		}
+
+		open := func() int {
+			t.Helper()
+
+			counts, err := store.bugs.openByProject()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, c := range counts {
+				if c.ProjectID == fx.ProjectID {
+					return c.Count
+				}
+			}
+
+			t.Fatalf("open bugs: got %+v, want the project", counts)
+
+			return 0
+		}
+
+		if n := open(); n != 2 {
+			t.Errorf("open bugs: got %d, want 2", n)
+		}
+
+		err = store.bugs.destroy(fx.BugIDs[1])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n := open(); n != 1 {
+			t.Errorf("open bugs after deleting one: got %d, want 1", n)
+		}
+
+		err = store.bugs.setDone(fx.BugIDs[0], true)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n := open(); n != 0 {
+			t.Errorf("open bugs after closing the other: got %d, want 0", n)
+		}
+
+		projectBugs, err := store.bugs.byProject(fx.ProjectID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(projectBugs) != 1 || projectBugs[0].ID != fx.BugIDs[0] {
+			t.Errorf("the project's bugs: got %+v, want the one left", projectBugs)
+		}
+	})
+}
+
+// TestStoreSaveBug checks a bug, its labels and its done state are saved all together or not at all.
+func TestStoreSaveBug(t *testing.T) {
+	eachStore(t, func(t *testing.T, store stores, fx storeFixtures) {
+		backend, flaky := fx.LabelIDs[0], fx.LabelIDs[1]
+		missing := int64(999999)
+
+		_, err := store.bugs.createWithLabels(bug{Name: "Mislabelled", FeatureID: fx.FeatureID, UserID: fx.UserID, Priority: defaultPriority, Severity: defaultSeverity}, []int64{backend, missing})
+
+		if err == nil {
+			t.Error("creating a bug with a missing label: got no error")
+		}
+
+		bugs, err := store.bugs.byFeature(fx.FeatureID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(bugs) != 2 {
+			t.Errorf("bugs after the failed create: got %d, want the 2 seeded", len(bugs))
+		}
+
+		id, err := store.bugs.createWithLabels(bug{Name: "Labelled", FeatureID: fx.FeatureID, UserID: fx.UserID, Priority: defaultPriority, Severity: defaultSeverity}, []int64{backend})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bugData, err := store.bugs.find(id)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bugData.Name = "Fixed"
+
+		err = store.bugs.save(bugData, []int64{flaky}, true)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bugData.Name = "Half saved"
+
+		err = store.bugs.save(bugData, []int64{backend, missing}, false)
+
+		if err == nil {
+			t.Error("saving a bug with a missing label: got no error")
+		}
+
+		saved, err := store.bugs.find(id)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		labels, err := store.labels.forBugs([]int64{id})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the first save, and nothing of the second
+		if saved.Name != "Fixed" || !saved.Done() || labelNames(labels[id]) != "flaky" {
+			t.Errorf("the saved bug: got %+v with labels %+v", saved, labels[id])
+		}
+	})
+}
+
+// storyIDs lists the stories' ids, in order.
+func storyIDs(stories []story) string {
+	ids := []string{}
+
+	for _, storyData := range stories {
+		ids = append(ids, formatID(storyData.ID))
+	}
+
+	return strings.Join(ids, ",")
+}
+
+// TestMemoryStoryHandlers adds, edits and deletes a story through its handlers.
+// TestMemoryMissingFeature checks adding an issue to a feature that doesn't exist is not found.
+func TestMemoryMissingFeature(t *testing.T) {
+	m := newMemoryApp(t)
+
+	for _, store := range []httprouter.Handle{m.stories.store, m.bugs.store} {
+		w := m.call(store, "POST", url.Values{"name": {"Lost"}}, "feature_id", "999999")
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("adding to a missing feature: got %d, want %d: %s", w.Code, http.StatusNotFound, w.Body)
+		}
+	}
+}
+
+func TestMemoryStoryHandlers(t *testing.T) {
+	m := newMemoryApp(t)
+
+	backend := formatID(m.fx.LabelIDs[0])
+	feature := []string{"feature_id", formatID(m.fx.FeatureID)}
+
+	cases := []struct {
+		name   string
+		form   url.Values
+		status int
+	}{
+		{"points off the scale", url.Values{"name": {"Off the scale"}, "points": {"4"}}, http.StatusUnprocessableEntity},
+		{"a P0 without the capability", url.Values{"name": {"Urgent"}, "priority": {"P0"}}, http.StatusForbidden},
+		{"a label from another project", url.Values{"name": {"Mislabelled"}, "labels": {"999"}}, http.StatusUnprocessableEntity},
+		{"a sized, labelled story", url.Values{"name": {"Sized"}, "points": {"5"}, "priority": {"P1"}, "labels": {backend}}, http.StatusSeeOther},
+	}
+
+	for _, tc := range cases {
+		w := m.call(m.stories.store, "POST", tc.form, feature...)
+
+		if w.Code != tc.status {
+			t.Errorf("%s: got %d, want %d: %s", tc.name, w.Code, tc.status, w.Body)
+		}
+	}
+
+	stories, err := m.store.stories.byFeature(m.fx.FeatureID)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the valid story was added
+	if len(stories) != 4 {
+		t.Fatalf("stories: got %d, want the 3 seeded and 1 added", len(stories))
+	}
+
+	added := stories[3]
+
+	if added.Name != "Sized" || added.Points != 5 || added.Priority != P1 || added.UserID != m.fx.UserID {
+		t.Errorf("the added story: got %+v", added)
+	}
+
+	labels, _ := m.store.labels.forStories([]int64{added.ID})
+
+	if got := labelNames(labels[added.ID]); got != "backend" {
+		t.Errorf("the added story's labels: got %q, want backend", got)
+	}
+
+	story := []string{"story_id", formatID(added.ID)}
+
+	// the form leaves out points and labels it no longer has
+	w := m.call(m.stories.update, "POST", url.Values{"name": {"Renamed"}}, story...)
+
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("updating: got %d, want %d: %s", w.Code, http.StatusSeeOther, w.Body)
+	}
+
+	updated, err := m.store.stories.find(added.ID)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels, _ = m.store.labels.forStories([]int64{added.ID})
+
+	if updated.Name != "Renamed" || updated.Points != 0 || updated.Priority != P1 || len(labels[added.ID]) != 0 {
+		t.Errorf("the updated story: got %+v with labels %+v", updated, labels[added.ID])
+	}
+
+	w = m.call(m.stories.destroy, "DELETE", nil, story...)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("deleting: got %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	stories, err = m.store.stories.byFeature(m.fx.FeatureID)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stories) != 3 {
+		t.Errorf("stories after deleting: got %d, want the 3 seeded", len(stories))
+	}
+}
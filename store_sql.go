@@ -0,0 +1,3549 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// newSQLStores returns stores backed by the goissuez tables.
+// The queries are written for Postgres; db rewrites them for other dialects.
+func newSQLStores(db *sqlDB) stores {
+	return stores{
+		users:    &sqlUserStore{db},
+		sessions: &sqlSessionStore{db},
+		roles:    &sqlRoleStore{db},
+		projects: &sqlProjectStore{db},
+		features: &sqlFeatureStore{db},
+		stories:  &sqlStoryStore{db},
+		bugs:     &sqlBugStore{db},
+		sprints:  &sqlSprintStore{db},
+		boards:   &sqlBoardStore{db},
+		links:    &sqlLinkStore{db},
+		labels:   &sqlLabelStore{db},
+		jobs:     &sqlJobStore{db},
+	}
+}
+
+// nullID stores an unset (zero) id as NULL.
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
+
+// nullInt stores an unset (zero) number as NULL.
+func nullInt(n int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(n), Valid: n != 0}
+}
+
+// affectedOne returns sql.ErrNoRows when an UPDATE or DELETE matched nothing.
+func affectedOne(result sql.Result) error {
+	n, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Users
+
+type sqlUserStore struct {
+	db *sqlDB
+}
+
+func (s *sqlUserStore) all() ([]user, error) {
+	users := []user{}
+
+	stmt, err := s.db.Prepare(`
+SELECT
+id,
+name,
+email,
+username,
+photo_url,
+role_id,
+created_at,
+updated_at,
+last_login,
+deleted_at
+FROM goissuez.users
+ORDER BY created_at
+`)
+
+	if err != nil {
+		return users, err
+	}
+
+	defer stmt.Close()
+
+	rows, err := stmt.Query()
+
+	if err != nil {
+		return users, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+
+		userData := user{}
+		var photo_url sql.NullString
+		var role_id sql.NullInt64
+		var deleted_at sql.NullString
+
+		err := rows.Scan(
+			&userData.ID,
+			&userData.Name,
+			&userData.Email,
+			&userData.Username,
+			&photo_url,
+			&role_id,
+			&userData.CreatedAt,
+			&userData.UpdatedAt,
+			&userData.LastLogin,
+			&deleted_at,
+		)
+
+		if err != nil {
+			return users, err
+		}
+
+		userData.PhotoUrl = photo_url.String
+		userData.RoleID = role_id.Int64
+		userData.DeletedAt = deleted_at.String
+
+		users = append(users, userData)
+	}
+
+	return users, rows.Err()
+}
+
+func (s *sqlUserStore) find(id int64) (user, error) {
+	stmt, err := s.db.Prepare(`
+SELECT
+id,
+name,
+username,
+photo_url,
+email,
+role_id,
+created_at,
+updated_at,
+last_login,
+deleted_at
+FROM goissuez.users
+WHERE id = $1
+LIMIT 1
+`)
+
+	if err != nil {
+		return user{}, err
+	}
+
+	defer stmt.Close()
+
+	userData := user{}
+
+	var photo_url sql.NullString
+	var role_id sql.NullInt64
+	var deleted_at sql.NullString
+
+	err = stmt.QueryRow(id).Scan(
+		&userData.ID,
+		&userData.Name,
+		&userData.Username,
+		&photo_url,
+		&userData.Email,
+		&role_id,
+		&userData.CreatedAt,
+		&userData.UpdatedAt,
+		&userData.LastLogin,
+		&deleted_at,
+	)
+
+	if err != nil {
+		return user{}, err
+	}
+
+	userData.PhotoUrl = photo_url.String
+	userData.RoleID = role_id.Int64
+	userData.DeletedAt = deleted_at.String
+
+	return userData, nil
+}
+
+func (s *sqlUserStore) findByUsername(username string) (user, error) {
+	userData := user{}
+
+	stmt, err := s.db.Prepare(`SELECT id, name, email, username, password, photo_url FROM goissuez.users u WHERE u.username = $1 LIMIT 1`)
+
+	if err != nil {
+		return userData, err
+	}
+
+	defer stmt.Close()
+
+	var photo_url sql.NullString
+
+	err = stmt.QueryRow(username).Scan(
+		&userData.ID,
+		&userData.Name,
+		&userData.Email,
+		&userData.Username,
+		&userData.Password,
+		&photo_url,
+	)
+
+	if err != nil {
+		return userData, err
+	}
+
+	userData.PhotoUrl = photo_url.String
+
+	return userData, nil
+}
+
+func (s *sqlUserStore) create(userData user) (int64, error) {
+	photo_url := sql.NullString{String: userData.PhotoUrl, Valid: userData.PhotoUrl != ""}
+
+	return s.db.insert(`
+INSERT INTO goissuez.users (name, email, password, username, photo_url, role_id, created_at, updated_at, last_login)
+VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+`,
+		userData.Name,
+		userData.Email,
+		userData.Password,
+		userData.Username,
+		photo_url,
+		nullID(userData.RoleID),
+	)
+}
+
+func (s *sqlUserStore) setRole(userID, roleID int64) error {
+	stmt, err := s.db.Prepare(`
+UPDATE goissuez.users
+SET role_id = $2
+WHERE id = $1
+`)
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	_, err = stmt.Exec(userID, roleID)
+
+	return err
+}
+
+func (s *sqlUserStore) destroy(id int64) error {
+	tx, err := s.db.begin()
+
+	if err != nil {
+		return err
+	}
+
+	queries := []string{
+		`UPDATE goissuez.users SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1`,
+		`UPDATE goissuez.stories SET assignee_id = NULL WHERE assignee_id = $1`,
+		`UPDATE goissuez.bugs SET assignee_id = NULL WHERE assignee_id = $1`,
+	}
+
+	for _, query := range queries {
+		_, err = tx.Exec(query, id)
+
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlUserStore) setPassword(userID int64, hash string) error {
+	stmt, err := s.db.Prepare(`
+UPDATE goissuez.users
+SET password = $2, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1
+`)
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	_, err = stmt.Exec(userID, hash)
+
+	return err
+}
+
+// Sessions
+
+type sqlSessionStore struct {
+	db *sqlDB
+}
+
+func (s *sqlSessionStore) create(uuid string, userID int64) error {
+	stmt, err := s.db.Prepare(`INSERT into goissuez.sessions (uuid, user_id, created_at) values ($1, $2, CURRENT_TIMESTAMP)`)
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	_, err = stmt.Exec(uuid, userID)
+
+	if err != nil {
+		return err
+	}
+
+	// clear out old sessions
+	// it's easier to just record a new session and clear old ones
+	// rather than update existing records with a new uuid
+	_, err = s.db.Exec(`DELETE from goissuez.sessions WHERE user_id = $1 AND uuid != $2`, userID, uuid)
+
+	return err
+}
+
+func (s *sqlSessionStore) user(uuid string) (user, error) {
+	userData := user{Role: role{}}
+
+	stmt, err := s.db.Prepare(`
+SELECT
+u.id,
+u.name,
+u.username,
+u.email,
+u.created_at,
+u.updated_at,
+u.last_login,
+u.role_id,
+r.name as role_name,
+r.description as role_description,
+a.id as impersonator_id,
+a.name as impersonator_name,
+a.username as impersonator_username
+FROM goissuez.sessions s
+LEFT JOIN goissuez.impersonations i ON i.id = s.impersonation_id AND i.ended_at IS NULL
+INNER JOIN goissuez.users u ON u.id = COALESCE(i.user_id, s.user_id)
+LEFT JOIN goissuez.users a ON a.id = i.admin_id
+LEFT JOIN goissuez.roles r ON r.id = u.role_id
+WHERE s.uuid = $1
+LIMIT 1
+`)
+
+	if err != nil {
+		return userData, err
+	}
+
+	defer stmt.Close()
+
+	roleID := sql.NullInt64{}
+	role_name := sql.NullString{}
+	role_description := sql.NullString{}
+	impersonatorID := sql.NullInt64{}
+	impersonatorName := sql.NullString{}
+	impersonatorUsername := sql.NullString{}
+
+	err = stmt.QueryRow(uuid).Scan(
+		&userData.ID,
+		&userData.Name,
+		&userData.Username,
+		&userData.Email,
+		&userData.CreatedAt,
+		&userData.UpdatedAt,
+		&userData.LastLogin,
+		&roleID,
+		&role_name,
+		&role_description,
+		&impersonatorID,
+		&impersonatorName,
+		&impersonatorUsername,
+	)
+
+	if err != nil {
+		return userData, err
+	}
+
+	if impersonatorID.Valid {
+		userData.Impersonator = &user{
+			ID:       impersonatorID.Int64,
+			Name:     impersonatorName.String,
+			Username: impersonatorUsername.String,
+		}
+	}
+
+	userData.RoleID = roleID.Int64
+	userData.Role.ID = roleID.Int64
+	userData.Role.Name = role_name.String
+	userData.Role.Description = role_description.String
+
+	return userData, nil
+}
+
+func (s *sqlSessionStore) startImpersonation(uuid string, adminID, userID int64) error {
+	tx, err := s.db.begin()
+
+	if err != nil {
+		return err
+	}
+
+	impersonationID, err := tx.insert(`
+INSERT INTO goissuez.impersonations
+(admin_id, user_id, started_at)
+VALUES ($1, $2, CURRENT_TIMESTAMP)
+`, adminID, userID)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE goissuez.sessions SET impersonation_id = $2 WHERE uuid = $1`, uuid, impersonationID)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlSessionStore) endImpersonation(uuid string) error {
+	tx, err := s.db.begin()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+UPDATE goissuez.impersonations
+SET ended_at = CURRENT_TIMESTAMP
+WHERE ended_at IS NULL
+AND id = (SELECT impersonation_id FROM goissuez.sessions WHERE uuid = $1)
+`, uuid)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE goissuez.sessions SET impersonation_id = NULL WHERE uuid = $1`, uuid)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlSessionStore) impersonations() ([]impersonation, error) {
+	impersonations := []impersonation{}
+
+	stmt, err := s.db.Prepare(`
+SELECT
+i.id,
+a.id,
+a.name,
+u.id,
+u.name,
+i.started_at,
+i.ended_at,
+` + s.db.dialect.secondsBetween("i.started_at", "COALESCE(i.ended_at, CURRENT_TIMESTAMP)") + ` as seconds
+FROM goissuez.impersonations i
+JOIN goissuez.users a
+ON a.id = i.admin_id
+JOIN goissuez.users u
+ON u.id = i.user_id
+ORDER BY i.started_at DESC
+`)
+
+	if err != nil {
+		return impersonations, err
+	}
+
+	defer stmt.Close()
+
+	rows, err := stmt.Query()
+
+	if err != nil {
+		return impersonations, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		data := impersonation{}
+		ended_at := sql.NullString{}
+		var seconds int64
+
+		err := rows.Scan(
+			&data.ID,
+			&data.Admin.ID,
+			&data.Admin.Name,
+			&data.User.ID,
+			&data.User.Name,
+			&data.StartedAt,
+			&ended_at,
+			&seconds,
+		)
+
+		if err != nil {
+			return impersonations, err
+		}
+
+		data.EndedAt = ended_at.String
+		data.Duration = (time.Duration(seconds) * time.Second).String()
+
+		impersonations = append(impersonations, data)
+	}
+
+	return impersonations, rows.Err()
+}
+
+func (s *sqlSessionStore) purge(before time.Time) (int64, error) {
+	result, err := s.db.Exec(`DELETE FROM goissuez.sessions WHERE created_at < $1`, s.db.dialect.timestamp(before))
+
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Roles
+
+type sqlRoleStore struct {
+	db *sqlDB
+}
+
+func (s *sqlRoleStore) all() ([]role, error) {
+	roles := []role{}
+
+	stmt, err := s.db.Prepare(`
+SELECT
+r.id,
+r.name,
+r.description,
+r.parent_id,
+p.name
+FROM goissuez.roles r
+LEFT JOIN goissuez.roles p
+ON p.id = r.parent_id
+ORDER BY r.name
+`)
+
+	if err != nil {
+		return roles, err
+	}
+
+	defer stmt.Close()
+
+	rows, err := stmt.Query()
+
+	if err != nil {
+		return roles, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		roleData, err := scanRole(rows)
+
+		if err != nil {
+			return roles, err
+		}
+
+		roles = append(roles, roleData)
+	}
+
+	return roles, rows.Err()
+}
+
+func (s *sqlRoleStore) find(id int64) (role, error) {
+	stmt, err := s.db.Prepare(`
+SELECT
+r.id,
+r.name,
+r.description,
+r.parent_id,
+p.name
+FROM goissuez.roles r
+LEFT JOIN goissuez.roles p
+ON p.id = r.parent_id
+WHERE r.id = $1
+LIMIT 1
+`)
+
+	if err != nil {
+		return role{}, err
+	}
+
+	defer stmt.Close()
+
+	return scanRole(stmt.QueryRow(id))
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRole(row scanner) (role, error) {
+	roleData := role{}
+
+	description := sql.NullString{}
+	parentID := sql.NullInt64{}
+	parentName := sql.NullString{}
+
+	err := row.Scan(
+		&roleData.ID,
+		&roleData.Name,
+		&description,
+		&parentID,
+		&parentName,
+	)
+
+	if err != nil {
+		return role{}, err
+	}
+
+	roleData.Description = description.String
+	roleData.ParentID = parentID.Int64
+	roleData.ParentName = parentName.String
+
+	return roleData, nil
+}
+
+func (s *sqlRoleStore) create(roleData role) (int64, error) {
+	return s.db.insert(`
+INSERT INTO goissuez.roles
+(name, description, parent_id)
+VALUES ($1, $2, $3)
+`, roleData.Name, roleData.Description, nullID(roleData.ParentID))
+}
+
+func (s *sqlRoleStore) update(roleData role) error {
+	stmt, err := s.db.Prepare(`
+UPDATE goissuez.roles
+SET name = $2,
+description = $3,
+parent_id = $4
+WHERE id = $1
+`)
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	_, err = stmt.Exec(roleData.ID, roleData.Name, roleData.Description, nullID(roleData.ParentID))
+
+	return err
+}
+
+func (s *sqlRoleStore) destroy(id int64) error {
+	stmt, err := s.db.Prepare(`DELETE from goissuez.roles WHERE id = $1`)
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	_, err = stmt.Exec(id)
+
+	return err
+}
+
+func (s *sqlRoleStore) clone(id int64) (int64, error) {
+	tx, err := s.db.begin()
+
+	if err != nil {
+		return 0, err
+	}
+
+	// make sure the role exists, or we'd quietly insert nothing
+	var exists int64
+
+	err = tx.QueryRow(`SELECT id FROM goissuez.roles WHERE id = $1`, id).Scan(&exists)
+
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	cloneID, err := tx.insert(`
+INSERT INTO goissuez.roles
+(name, description, parent_id)
+SELECT 'Copy of ' || name, description, parent_id
+FROM goissuez.roles
+WHERE id = $1
+`, id)
+
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+INSERT INTO goissuez.permissions
+(role_id, capability_id)
+SELECT $2, capability_id
+FROM goissuez.permissions
+WHERE role_id = $1
+`, id, cloneID)
+
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return cloneID, tx.Commit()
+}
+
+func (s *sqlRoleStore) capabilities() ([]*capability, error) {
+	capabilities := []*capability{}
+
+	stmt, err := s.db.Prepare(`
+SELECT
+id,
+name,
+description,
+"group"
+FROM goissuez.capabilities
+`)
+
+	if err != nil {
+		return capabilities, err
+	}
+
+	defer stmt.Close()
+
+	rows, err := stmt.Query()
+
+	if err != nil {
+		return capabilities, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		data := capability{}
+
+		description := sql.NullString{}
+		err := rows.Scan(
+			&data.ID,
+			&data.Name,
+			&description,
+			&data.Group,
+		)
+
+		if err != nil {
+			return capabilities, err
+		}
+
+		data.Description = description.String
+
+		capabilities = append(capabilities, &data)
+	}
+
+	return capabilities, rows.Err()
+}
+
+func (s *sqlRoleStore) syncCapabilities(registry []capability) error {
+	tx, err := s.db.begin()
+
+	if err != nil {
+		return err
+	}
+
+	update, err := tx.Prepare(`
+UPDATE goissuez.capabilities
+SET description = $2, "group" = $3
+WHERE name = $1
+`)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	defer update.Close()
+
+	insert, err := tx.Prepare(`
+INSERT INTO goissuez.capabilities
+(name, description, "group")
+SELECT $1, $2, $3
+WHERE NOT EXISTS (SELECT 1 FROM goissuez.capabilities WHERE name = $1)
+`)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	defer insert.Close()
+
+	for _, c := range registry {
+		_, err = update.Exec(c.Name, c.Description, c.Group)
+
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		_, err = insert.Exec(c.Name, c.Description, c.Group)
+
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlRoleStore) assignedCapabilityIDs() (map[int64]bool, error) {
+	assigned := make(map[int64]bool)
+
+	stmt, err := s.db.Prepare(`SELECT DISTINCT capability_id FROM goissuez.permissions`)
+
+	if err != nil {
+		return assigned, err
+	}
+
+	defer stmt.Close()
+
+	rows, err := stmt.Query()
+
+	if err != nil {
+		return assigned, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+
+		if err != nil {
+			return assigned, err
+		}
+
+		assigned[id] = true
+	}
+
+	return assigned, rows.Err()
+}
+
+func (s *sqlRoleStore) permissions(roleID int64) (map[string]capability, error) {
+	permissions := make(map[string]capability)
+
+	stmt, err := s.db.Prepare(`
+SELECT
+p.capability_id,
+c.name,
+c.description,
+c."group"
+FROM goissuez.permissions p
+JOIN goissuez.capabilities c
+ON c.id = p.capability_id
+WHERE role_id = $1
+`)
+
+	if err != nil {
+		return permissions, err
+	}
+
+	defer stmt.Close()
+
+	rows, err := stmt.Query(roleID)
+
+	if err != nil {
+		return permissions, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		capData := capability{}
+
+		description := sql.NullString{}
+
+		err := rows.Scan(
+			&capData.ID,
+			&capData.Name,
+			&description,
+			&capData.Group,
+		)
+
+		if err != nil {
+			return permissions, err
+		}
+
+		capData.Description = description.String
+
+		permissions[capData.Name] = capData
+	}
+
+	return permissions, rows.Err()
+}
+
+func (s *sqlRoleStore) setPermissions(roleID int64, capabilityIDs []int64) error {
+	tx, err := s.db.begin()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM goissuez.permissions WHERE role_id = $1`, roleID)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+INSERT INTO goissuez.permissions
+(role_id, capability_id)
+VALUES ($1, $2)
+`)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	defer stmt.Close()
+
+	for _, id := range capabilityIDs {
+		_, err = stmt.Exec(roleID, id)
+
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlRoleStore) apply(plan rolePlan) error {
+	capabilities, err := s.capabilities()
+
+	if err != nil {
+		return err
+	}
+
+	capabilityIDs := make(map[string]int64)
+
+	for _, c := range capabilities {
+		capabilityIDs[c.Name] = c.ID
+	}
+
+	roles, err := s.all()
+
+	if err != nil {
+		return err
+	}
+
+	roleIDs := make(map[string]int64)
+
+	for _, roleData := range roles {
+		roleIDs[roleData.Name] = roleData.ID
+	}
+
+	tx, err := s.db.begin()
+
+	if err != nil {
+		return err
+	}
+
+	updateRole, err := tx.Prepare(`
+UPDATE goissuez.roles
+SET description = $2,
+parent_id = $3
+WHERE id = $1
+`)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	defer updateRole.Close()
+
+	grant, err := tx.Prepare(`
+INSERT INTO goissuez.permissions
+(role_id, capability_id)
+VALUES ($1, $2)
+`)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	defer grant.Close()
+
+	revoke, err := tx.Prepare(`
+DELETE FROM goissuez.permissions
+WHERE role_id = $1
+AND capability_id = $2
+`)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	defer revoke.Close()
+
+	// create new roles first so they can be inherited from
+	for _, c := range plan.Changes {
+		if !c.Create {
+			continue
+		}
+
+		id, err := tx.insert(`
+INSERT INTO goissuez.roles
+(name, description)
+VALUES ($1, $2)
+`, c.Name, c.NewDescription)
+
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		roleIDs[c.Name] = id
+	}
+
+	for _, c := range plan.Changes {
+		roleID := roleIDs[c.Name]
+
+		parentID := sql.NullInt64{}
+
+		if c.NewInherits != "" {
+			parentID = sql.NullInt64{Int64: roleIDs[c.NewInherits], Valid: true}
+		}
+
+		_, err = updateRole.Exec(roleID, c.NewDescription, parentID)
+
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		for _, name := range c.Add {
+			_, err = grant.Exec(roleID, capabilityIDs[name])
+
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+
+		for _, name := range c.Remove {
+			_, err = revoke.Exec(roleID, capabilityIDs[name])
+
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Projects
+
+type sqlProjectStore struct {
+	db *sqlDB
+}
+
+func (s *sqlProjectStore) all() ([]project, error) {
+	return s.query(`
+SELECT
+id,
+name,
+description,
+user_id,
+point_scale,
+track_time,
+created_at,
+updated_at
+FROM goissuez.projects
+WHERE deleted_at IS NULL
+ORDER BY created_at
+`)
+}
+
+func (s *sqlProjectStore) byUser(userID int64) ([]project, error) {
+	return s.query(`
+SELECT
+id,
+name,
+description,
+user_id,
+point_scale,
+track_time,
+created_at,
+updated_at
+FROM goissuez.projects
+WHERE user_id = $1
+ORDER BY created_at
+`, userID)
+}
+
+func (s *sqlProjectStore) query(query string, args ...interface{}) ([]project, error) {
+	projects := []project{}
+
+	stmt, err := s.db.Prepare(query)
+
+	if err != nil {
+		return projects, err
+	}
+
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+
+	if err != nil {
+		return projects, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		projectData := project{}
+		description := sql.NullString{}
+
+		err := rows.Scan(
+			&projectData.ID,
+			&projectData.Name,
+			&description,
+			&projectData.UserID,
+			&projectData.PointScale,
+			&projectData.TrackTime,
+			&projectData.CreatedAt,
+			&projectData.UpdatedAt,
+		)
+
+		if err != nil {
+			return projects, err
+		}
+
+		projectData.Description = description.String
+
+		projects = append(projects, projectData)
+	}
+
+	return projects, rows.Err()
+}
+
+func (s *sqlProjectStore) find(id int64) (project, error) {
+	stmt, err := s.db.Prepare(`
+SELECT
+id,
+name,
+description,
+user_id,
+point_scale,
+track_time,
+created_at,
+updated_at,
+deleted_at
+FROM goissuez.projects
+WHERE id = $1
+LIMIT 1
+`)
+
+	if err != nil {
+		return project{}, err
+	}
+
+	defer stmt.Close()
+
+	projectData := project{}
+	description := sql.NullString{}
+	deleted_at := sql.NullString{}
+
+	err = stmt.QueryRow(id).Scan(
+		&projectData.ID,
+		&projectData.Name,
+		&description,
+		&projectData.UserID,
+		&projectData.PointScale,
+		&projectData.TrackTime,
+		&projectData.CreatedAt,
+		&projectData.UpdatedAt,
+		&deleted_at,
+	)
+
+	if err != nil {
+		return project{}, err
+	}
+
+	projectData.Description = description.String
+	projectData.DeletedAt = deleted_at.String
+
+	return projectData, nil
+}
+
+func (s *sqlProjectStore) create(projectData project) (int64, error) {
+	tx, err := s.db.begin()
+
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := tx.insert(`
+INSERT INTO goissuez.projects
+(name, description, user_id, point_scale, track_time, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+`, projectData.Name, projectData.Description, projectData.UserID, projectData.PointScale, projectData.TrackTime)
+
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	for i, columnData := range defaultColumns {
+		_, err = tx.Exec(`
+INSERT INTO goissuez.board_columns
+(project_id, name, position, wip_limit, done, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+`, id, columnData.Name, i+1, nullInt(columnData.WIPLimit), columnData.Done)
+
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	return id, tx.Commit()
+}
+
+func (s *sqlProjectStore) update(projectData project) error {
+	stmt, err := s.db.Prepare(`
+UPDATE goissuez.projects
+SET
+name = $2,
+description = $3,
+point_scale = $4,
+track_time = $5,
+updated_at = CURRENT_TIMESTAMP
+WHERE id = $1
+`)
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	_, err = stmt.Exec(projectData.ID, projectData.Name, projectData.Description, projectData.PointScale, projectData.TrackTime)
+
+	return err
+}
+
+// destroy soft deletes the project and everything in it.
+// why not just use postgres to cascade these changes? b/c we're using soft-deletes.
+func (s *sqlProjectStore) destroy(id int64) error {
+	tx, err := s.db.begin()
+
+	if err != nil {
+		return err
+	}
+
+	// stories and bugs first, while their features can still be found by project
+	queries := []string{
+		`UPDATE goissuez.stories SET deleted_at = CURRENT_TIMESTAMP WHERE feature_id IN (SELECT id FROM goissuez.features WHERE project_id = $1)`,
+		`UPDATE goissuez.bugs SET deleted_at = CURRENT_TIMESTAMP WHERE feature_id IN (SELECT id FROM goissuez.features WHERE project_id = $1)`,
+		`UPDATE goissuez.features SET deleted_at = CURRENT_TIMESTAMP WHERE project_id = $1`,
+		`UPDATE goissuez.projects SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1`,
+	}
+
+	for _, query := range queries {
+		_, err = tx.Exec(query, id)
+
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Features
+
+type sqlFeatureStore struct {
+	db *sqlDB
+}
+
+func (s *sqlFeatureStore) all() ([]featureSummary, error) {
+	features := []featureSummary{}
+
+	stmt, err := s.db.Prepare(`
+SELECT
+f.id,
+f.name,
+f.user_id,
+f.created_at,
+f.updated_at,
+count(DISTINCT s.id) as count_stories,
+count(DISTINCT b.id) as count_bugs,
+p.name as project_name
+FROM goissuez.features f
+LEFT JOIN goissuez.stories s
+ON f.id = s.feature_id
+LEFT JOIN goissuez.bugs b
+ON f.id = b.feature_id
+JOIN goissuez.projects p
+ON p.id = f.project_id
+WHERE f.deleted_at IS NULL
+GROUP BY f.id, p.id
+ORDER BY f.updated_at
+`)
+
+	if err != nil {
+		return features, err
+	}
+
+	defer stmt.Close()
+
+	rows, err := stmt.Query()
+
+	if err != nil {
+		return features, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		featureData := featureSummary{Feature: feature{Project: &project{}}}
+
+		err := rows.Scan(
+			&featureData.Feature.ID,
+			&featureData.Feature.Name,
+			&featureData.Feature.UserID,
+			&featureData.Feature.CreatedAt,
+			&featureData.Feature.UpdatedAt,
+			&featureData.RelatedData.StoryCount,
+			&featureData.RelatedData.BugCount,
+			&featureData.Feature.Project.Name,
+		)
+
+		if err != nil {
+			return features, err
+		}
+
+		features = append(features, featureData)
+	}
+
+	return features, rows.Err()
+}
+
+func (s *sqlFeatureStore) byProject(projectID int64) ([]feature, error) {
+	return s.query(`
+SELECT
+id,
+name,
+description,
+project_id,
+user_id,
+created_at,
+updated_at,
+deleted_at
+FROM goissuez.features
+WHERE project_id = $1
+ORDER BY created_at
+`, projectID)
+}
+
+func (s *sqlFeatureStore) assignedTo(userID int64) ([]feature, error) {
+	return s.query(`
+SELECT
+id,
+name,
+description,
+project_id,
+user_id,
+created_at,
+updated_at,
+deleted_at
+FROM goissuez.features
+WHERE id IN (
+	SELECT feature_id FROM goissuez.bugs WHERE assignee_id = $1
+	UNION
+	SELECT feature_id FROM goissuez.stories WHERE assignee_id = $1
+)
+ORDER BY created_at
+`, userID)
+}
+
+func (s *sqlFeatureStore) query(query string, args ...interface{}) ([]feature, error) {
+	features := []feature{}
+
+	stmt, err := s.db.Prepare(query)
+
+	if err != nil {
+		return features, err
+	}
+
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+
+	if err != nil {
+		return features, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		featureData := feature{}
+		description := sql.NullString{}
+		deleted_at := sql.NullString{}
+
+		err := rows.Scan(
+			&featureData.ID,
+			&featureData.Name,
+			&description,
+			&featureData.ProjectID,
+			&featureData.UserID,
+			&featureData.CreatedAt,
+			&featureData.UpdatedAt,
+			&deleted_at,
+		)
+
+		if err != nil {
+			return features, err
+		}
+
+		featureData.Description = description.String
+		featureData.DeletedAt = deleted_at.String
+
+		features = append(features, featureData)
+	}
+
+	return features, rows.Err()
+}
+
+func (s *sqlFeatureStore) find(id int64) (feature, error) {
+	stmt, err := s.db.Prepare(`
+SELECT
+f.id,
+f.name,
+f.description,
+f.project_id,
+f.user_id,
+f.created_at,
+f.updated_at,
+f.deleted_at,
+p.name as project_name,
+p.point_scale,
+p.track_time
+FROM goissuez.features f
+JOIN goissuez.projects p
+ON p.id = f.project_id
+WHERE f.id = $1
+LIMIT 1
+`)
+
+	if err != nil {
+		return feature{}, err
+	}
+
+	defer stmt.Close()
+
+	featureData := feature{Project: &project{}}
+	description := sql.NullString{}
+	deleted_at := sql.NullString{}
+
+	err = stmt.QueryRow(id).Scan(
+		&featureData.ID,
+		&featureData.Name,
+		&description,
+		&featureData.ProjectID,
+		&featureData.UserID,
+		&featureData.CreatedAt,
+		&featureData.UpdatedAt,
+		&deleted_at,
+		&featureData.Project.Name,
+		&featureData.Project.PointScale,
+		&featureData.Project.TrackTime,
+	)
+
+	if err != nil {
+		return feature{}, err
+	}
+
+	featureData.Description = description.String
+	featureData.DeletedAt = deleted_at.String
+	featureData.Project.ID = featureData.ProjectID
+
+	return featureData, nil
+}
+
+func (s *sqlFeatureStore) create(featureData feature) (int64, error) {
+	return s.db.insert(`
+INSERT INTO goissuez.features
+(name, description, project_id, user_id, created_at, updated_at)
+VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+`, featureData.Name, featureData.Description, featureData.ProjectID, featureData.UserID)
+}
+
+func (s *sqlFeatureStore) update(featureData feature) error {
+	stmt, err := s.db.Prepare(`
+UPDATE goissuez.features
+SET name = $2, description = $3, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1
+`)
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	_, err = stmt.Exec(featureData.ID, featureData.Name, featureData.Description)
+
+	return err
+}
+
+func (s *sqlFeatureStore) destroy(id int64) error {
+	stmt, err := s.db.Prepare(`UPDATE goissuez.features SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1`)
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	_, err = stmt.Exec(id)
+
+	return err
+}
+
+// Stories
+
+type sqlStoryStore struct {
+	db *sqlDB
+}
+
+func (s *sqlStoryStore) all() ([]story, error) {
+	return s.query(`
+SELECT
+s.id,
+s.name,
+s.description,
+s.feature_id,
+s.user_id,
+s.assignee_id,
+s.created_at,
+s.updated_at,
+s.deleted_at,
+s.priority,
+s.points,
+s.estimate_minutes,
+s.sprint_id,
+s.completed_at,
+s.column_id,
+s.rank,
+f.name as feature_name
+FROM goissuez.stories s
+JOIN goissuez.features f
+ON f.id = s.feature_id
+WHERE s.deleted_at IS NULL
+ORDER BY s.rank, s.id
+`)
+}
+
+func (s *sqlStoryStore) byFeature(featureID int64) ([]story, error) {
+	return s.query(`
+SELECT
+s.id,
+s.name,
+s.description,
+s.feature_id,
+s.user_id,
+s.assignee_id,
+s.created_at,
+s.updated_at,
+s.deleted_at,
+s.priority,
+s.points,
+s.estimate_minutes,
+s.sprint_id,
+s.completed_at,
+s.column_id,
+s.rank,
+f.name as feature_name
+FROM goissuez.stories s
+JOIN goissuez.features f
+ON f.id = s.feature_id
+WHERE s.feature_id = $1
+AND s.deleted_at IS NULL
+ORDER BY s.rank, s.id
+`, featureID)
+}
+
+func (s *sqlStoryStore) assignedTo(userID int64) ([]story, error) {
+	return s.query(`
+SELECT
+s.id,
+s.name,
+s.description,
+s.feature_id,
+s.user_id,
+s.assignee_id,
+s.created_at,
+s.updated_at,
+s.deleted_at,
+s.priority,
+s.points,
+s.estimate_minutes,
+s.sprint_id,
+s.completed_at,
+s.column_id,
+s.rank,
+f.name as feature_name
+FROM goissuez.stories s
+JOIN goissuez.features f
+ON f.id = s.feature_id
+WHERE s.assignee_id = $1
+ORDER BY s.rank, s.id
+`, userID)
+}
+
+func (s *sqlStoryStore) query(query string, args ...interface{}) ([]story, error) {
+	stories := []story{}
+
+	stmt, err := s.db.Prepare(query)
+
+	if err != nil {
+		return stories, err
+	}
+
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+
+	if err != nil {
+		return stories, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		storyData, err := scanStory(rows)
+
+		if err != nil {
+			return stories, err
+		}
+
+		stories = append(stories, storyData)
+	}
+
+	return stories, rows.Err()
+}
+
+func scanStory(row scanner) (story, error) {
+	storyData := story{Feature: &feature{}}
+
+	// this could be null if there is no assignee
+	var assigneeID sql.NullInt64
+	// and these if the story hasn't been estimated
+	var points, estimate sql.NullInt64
+	// and these while it's in the backlog, off the board or not done
+	var sprintID, columnID sql.NullInt64
+	var completedAt sql.NullString
+	description := sql.NullString{}
+	deleted_at := sql.NullString{}
+
+	err := row.Scan(
+		&storyData.ID,
+		&storyData.Name,
+		&description,
+		&storyData.FeatureID,
+		&storyData.UserID,
+		&assigneeID,
+		&storyData.CreatedAt,
+		&storyData.UpdatedAt,
+		&deleted_at,
+		&storyData.Priority,
+		&points,
+		&estimate,
+		&sprintID,
+		&completedAt,
+		&columnID,
+		&storyData.Rank,
+		&storyData.Feature.Name,
+	)
+
+	if err != nil {
+		return story{}, err
+	}
+
+	storyData.Points = int(points.Int64)
+	storyData.EstimateMinutes = int(estimate.Int64)
+	storyData.SprintID = sprintID.Int64
+	storyData.CompletedAt = completedAt.String
+	storyData.ColumnID = columnID.Int64
+
+	storyData.Description = description.String
+	storyData.AssigneeID = assigneeID.Int64
+	storyData.DeletedAt = deleted_at.String
+	storyData.Feature.ID = storyData.FeatureID
+
+	return storyData, nil
+}
+
+func (s *sqlStoryStore) find(id int64) (story, error) {
+	stmt, err := s.db.Prepare(`
+SELECT
+s.id,
+s.name,
+s.description,
+s.feature_id,
+s.user_id,
+s.assignee_id,
+s.created_at,
+s.updated_at,
+s.deleted_at,
+s.priority,
+s.points,
+s.estimate_minutes,
+s.sprint_id,
+s.completed_at,
+s.column_id,
+s.rank,
+f.name
+FROM goissuez.stories s
+JOIN goissuez.features f
+ON f.id = s.feature_id
+WHERE s.id = $1
+LIMIT 1
+`)
+
+	if err != nil {
+		return story{}, err
+	}
+
+	defer stmt.Close()
+
+	return scanStory(stmt.QueryRow(id))
+}
+
+// create ranks the new story after all the others.
+func (s *sqlStoryStore) create(storyData story) (int64, error) {
+	return s.createWithLabels(storyData, nil)
+}
+
+func (s *sqlStoryStore) createWithLabels(storyData story, labelIDs []int64) (int64, error) {
+	tx, err := s.db.begin()
+
+	if err != nil {
+		return 0, err
+	}
+
+	rank, err := lastRank(tx, "goissuez.stories")
+
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	id, err := tx.insert(`
+INSERT INTO goissuez.stories
+(name, description, feature_id, user_id, assignee_id, priority, points, estimate_minutes, rank, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+`,
+		storyData.Name,
+		storyData.Description,
+		storyData.FeatureID,
+		storyData.UserID,
+		nullID(storyData.AssigneeID),
+		storyData.Priority,
+		nullInt(storyData.Points),
+		nullInt(storyData.EstimateMinutes),
+		nextRank(rank),
+	)
+
+	if err == nil && len(labelIDs) > 0 {
+		err = setIssueLabelsIn(tx, "goissuez.story_labels", "story_id", id, labelIDs)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+func (s *sqlStoryStore) update(storyData story) error {
+	return updateStory(s.db, storyData)
+}
+
+func updateStory(q queryer, storyData story) error {
+	_, err := q.Exec(`
+UPDATE goissuez.stories
+SET name = $2, description = $3, assignee_id = $4, priority = $5, points = $6, estimate_minutes = $7, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1
+`,
+		storyData.ID,
+		storyData.Name,
+		storyData.Description,
+		nullID(storyData.AssigneeID),
+		storyData.Priority,
+		nullInt(storyData.Points),
+		nullInt(storyData.EstimateMinutes),
+	)
+
+	return err
+}
+
+func (s *sqlStoryStore) save(storyData story, labelIDs []int64, done bool) error {
+	tx, err := s.db.begin()
+
+	if err != nil {
+		return err
+	}
+
+	err = updateStory(tx, storyData)
+
+	if err == nil {
+		err = setIssueLabelsIn(tx, "goissuez.story_labels", "story_id", storyData.ID, labelIDs)
+	}
+
+	if err == nil {
+		err = setCompleted(tx, "goissuez.stories", storyData.ID, done)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStoryStore) destroy(id int64) error {
+	stmt, err := s.db.Prepare(`UPDATE goissuez.stories SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1`)
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	_, err = stmt.Exec(id)
+
+	return err
+}
+
+func (s *sqlStoryStore) restore(id int64) (int64, error) {
+	tx, err := s.db.begin()
+
+	if err != nil {
+		return 0, err
+	}
+
+	var featureID int64
+
+	err = tx.QueryRow(`SELECT feature_id FROM goissuez.stories WHERE id = $1`, id).Scan(&featureID)
+
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	_, err = tx.Exec(`UPDATE goissuez.stories SET deleted_at = NULL WHERE id = $1`, id)
+
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	_, err = tx.Exec(`UPDATE goissuez.features SET deleted_at = NULL where id = $1`, featureID)
+
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return featureID, tx.Commit()
+}
+
+func (s *sqlStoryStore) bySprint(sprintID int64) ([]story, error) {
+	return s.query(`
+SELECT
+s.id,
+s.name,
+s.description,
+s.feature_id,
+s.user_id,
+s.assignee_id,
+s.created_at,
+s.updated_at,
+s.deleted_at,
+s.priority,
+s.points,
+s.estimate_minutes,
+s.sprint_id,
+s.completed_at,
+s.column_id,
+s.rank,
+f.name as feature_name
+FROM goissuez.stories s
+JOIN goissuez.features f
+ON f.id = s.feature_id
+WHERE s.sprint_id = $1
+AND s.deleted_at IS NULL
+ORDER BY s.rank, s.id
+`, sprintID)
+}
+
+func (s *sqlStoryStore) backlog(projectID int64) ([]story, error) {
+	return s.query(`
+SELECT
+s.id,
+s.name,
+s.description,
+s.feature_id,
+s.user_id,
+s.assignee_id,
+s.created_at,
+s.updated_at,
+s.deleted_at,
+s.priority,
+s.points,
+s.estimate_minutes,
+s.sprint_id,
+s.completed_at,
+s.column_id,
+s.rank,
+f.name as feature_name
+FROM goissuez.stories s
+JOIN goissuez.features f
+ON f.id = s.feature_id
+WHERE f.project_id = $1
+AND s.sprint_id IS NULL
+AND s.completed_at IS NULL
+AND s.deleted_at IS NULL
+AND f.deleted_at IS NULL
+ORDER BY s.rank, s.id
+`, projectID)
+}
+
+func (s *sqlStoryStore) setSprint(id, sprintID int64) error {
+	stmt, err := s.db.Prepare(`UPDATE goissuez.stories SET sprint_id = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1`)
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	_, err = stmt.Exec(id, nullID(sprintID))
+
+	return err
+}
+
+func (s *sqlStoryStore) setDone(id int64, done bool) error {
+	return setCompleted(s.db, "goissuez.stories", id, done)
+}
+
+func (s *sqlStoryStore) byProject(projectID int64) ([]story, error) {
+	return s.query(`
+SELECT
+s.id,
+s.name,
+s.description,
+s.feature_id,
+s.user_id,
+s.assignee_id,
+s.created_at,
+s.updated_at,
+s.deleted_at,
+s.priority,
+s.points,
+s.estimate_minutes,
+s.sprint_id,
+s.completed_at,
+s.column_id,
+s.rank,
+f.name as feature_name
+FROM goissuez.stories s
+JOIN goissuez.features f
+ON f.id = s.feature_id
+WHERE f.project_id = $1
+AND s.deleted_at IS NULL
+AND f.deleted_at IS NULL
+ORDER BY s.rank, s.id
+`, projectID)
+}
+
+func (s *sqlStoryStore) setColumn(id, columnID int64) error {
+	stmt, err := s.db.Prepare(`UPDATE goissuez.stories SET column_id = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1`)
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	_, err = stmt.Exec(id, nullID(columnID))
+
+	return err
+}
+
+// Bugs
+
+type sqlBugStore struct {
+	db *sqlDB
+}
+
+func (s *sqlBugStore) all() ([]bug, error) {
+	return s.query(`
+SELECT
+b.id,
+b.name,
+b.description,
+b.feature_id,
+b.user_id,
+b.assignee_id,
+b.created_at,
+b.updated_at,
+b.deleted_at,
+b.priority,
+b.severity,
+b.sprint_id,
+b.completed_at,
+b.column_id,
+b.rank,
+f.name as feature_name
+FROM goissuez.bugs b
+JOIN goissuez.features f
+ON f.id = b.feature_id
+WHERE b.deleted_at IS NULL
+ORDER BY b.rank, b.id
+`)
+}
+
+func (s *sqlBugStore) byFeature(featureID int64) ([]bug, error) {
+	return s.query(`
+SELECT
+b.id,
+b.name,
+b.description,
+b.feature_id,
+b.user_id,
+b.assignee_id,
+b.created_at,
+b.updated_at,
+b.deleted_at,
+b.priority,
+b.severity,
+b.sprint_id,
+b.completed_at,
+b.column_id,
+b.rank,
+f.name as feature_name
+FROM goissuez.bugs b
+JOIN goissuez.features f
+ON f.id = b.feature_id
+WHERE b.feature_id = $1
+AND b.deleted_at IS NULL
+ORDER BY b.rank, b.id
+`, featureID)
+}
+
+func (s *sqlBugStore) assignedTo(userID int64) ([]bug, error) {
+	return s.query(`
+SELECT
+b.id,
+b.name,
+b.description,
+b.feature_id,
+b.user_id,
+b.assignee_id,
+b.created_at,
+b.updated_at,
+b.deleted_at,
+b.priority,
+b.severity,
+b.sprint_id,
+b.completed_at,
+b.column_id,
+b.rank,
+f.name as feature_name
+FROM goissuez.bugs b
+JOIN goissuez.features f
+ON f.id = b.feature_id
+WHERE b.assignee_id = $1
+ORDER BY b.rank, b.id
+`, userID)
+}
+
+func (s *sqlBugStore) query(query string, args ...interface{}) ([]bug, error) {
+	bugs := []bug{}
+
+	stmt, err := s.db.Prepare(query)
+
+	if err != nil {
+		return bugs, err
+	}
+
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+
+	if err != nil {
+		return bugs, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		bugData, err := scanBug(rows)
+
+		if err != nil {
+			return bugs, err
+		}
+
+		bugs = append(bugs, bugData)
+	}
+
+	return bugs, rows.Err()
+}
+
+func scanBug(row scanner) (bug, error) {
+	bugData := bug{Feature: &feature{}}
+
+	// this could be null if there is no assignee
+	var assigneeID sql.NullInt64
+	// and these while it's in the backlog, off the board or not done
+	var sprintID, columnID sql.NullInt64
+	var completedAt sql.NullString
+	description := sql.NullString{}
+	deleted_at := sql.NullString{}
+
+	err := row.Scan(
+		&bugData.ID,
+		&bugData.Name,
+		&description,
+		&bugData.FeatureID,
+		&bugData.UserID,
+		&assigneeID,
+		&bugData.CreatedAt,
+		&bugData.UpdatedAt,
+		&deleted_at,
+		&bugData.Priority,
+		&bugData.Severity,
+		&sprintID,
+		&completedAt,
+		&columnID,
+		&bugData.Rank,
+		&bugData.Feature.Name,
+	)
+
+	if err != nil {
+		return bug{}, err
+	}
+
+	bugData.Description = description.String
+	bugData.AssigneeID = assigneeID.Int64
+	bugData.DeletedAt = deleted_at.String
+	bugData.SprintID = sprintID.Int64
+	bugData.CompletedAt = completedAt.String
+	bugData.ColumnID = columnID.Int64
+	bugData.Feature.ID = bugData.FeatureID
+
+	return bugData, nil
+}
+
+func (s *sqlBugStore) find(id int64) (bug, error) {
+	stmt, err := s.db.Prepare(`
+SELECT
+b.id,
+b.name,
+b.description,
+b.feature_id,
+b.user_id,
+b.assignee_id,
+b.created_at,
+b.updated_at,
+b.deleted_at,
+b.priority,
+b.severity,
+b.sprint_id,
+b.completed_at,
+b.column_id,
+b.rank,
+f.name
+FROM goissuez.bugs b
+JOIN goissuez.features f
+ON f.id = b.feature_id
+WHERE b.id = $1
+LIMIT 1
+`)
+
+	if err != nil {
+		return bug{}, err
+	}
+
+	defer stmt.Close()
+
+	return scanBug(stmt.QueryRow(id))
+}
+
+// create ranks the new bug after all the others.
+func (s *sqlBugStore) create(bugData bug) (int64, error) {
+	return s.createWithLabels(bugData, nil)
+}
+
+func (s *sqlBugStore) createWithLabels(bugData bug, labelIDs []int64) (int64, error) {
+	tx, err := s.db.begin()
+
+	if err != nil {
+		return 0, err
+	}
+
+	rank, err := lastRank(tx, "goissuez.bugs")
+
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	id, err := tx.insert(`
+INSERT INTO goissuez.bugs
+(name, description, feature_id, user_id, assignee_id, priority, severity, rank, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+`,
+		bugData.Name,
+		bugData.Description,
+		bugData.FeatureID,
+		bugData.UserID,
+		nullID(bugData.AssigneeID),
+		bugData.Priority,
+		bugData.Severity,
+		nextRank(rank),
+	)
+
+	if err == nil && len(labelIDs) > 0 {
+		err = setIssueLabelsIn(tx, "goissuez.bug_labels", "bug_id", id, labelIDs)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+func (s *sqlBugStore) update(bugData bug) error {
+	return updateBug(s.db, bugData)
+}
+
+func updateBug(q queryer, bugData bug) error {
+	_, err := q.Exec(`
+UPDATE goissuez.bugs
+SET name = $2, description = $3, assignee_id = $4, priority = $5, severity = $6, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1
+`, bugData.ID, bugData.Name, bugData.Description, nullID(bugData.AssigneeID), bugData.Priority, bugData.Severity)
+
+	return err
+}
+
+func (s *sqlBugStore) save(bugData bug, labelIDs []int64, done bool) error {
+	tx, err := s.db.begin()
+
+	if err != nil {
+		return err
+	}
+
+	err = updateBug(tx, bugData)
+
+	if err == nil {
+		err = setIssueLabelsIn(tx, "goissuez.bug_labels", "bug_id", bugData.ID, labelIDs)
+	}
+
+	if err == nil {
+		err = setCompleted(tx, "goissuez.bugs", bugData.ID, done)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlBugStore) destroy(id int64) error {
+	stmt, err := s.db.Prepare(`UPDATE goissuez.bugs SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1`)
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	_, err = stmt.Exec(id)
+
+	return err
+}
+
+func (s *sqlBugStore) openByProject() ([]projectBugCount, error) {
+	rows, err := s.db.Query(`
+SELECT
+p.id,
+p.name,
+count(b.id)
+FROM goissuez.projects p
+LEFT JOIN goissuez.features f
+ON f.project_id = p.id
+AND f.deleted_at IS NULL
+LEFT JOIN goissuez.bugs b
+ON b.feature_id = f.id
+AND b.deleted_at IS NULL
+AND b.completed_at IS NULL
+WHERE p.deleted_at IS NULL
+GROUP BY p.id, p.name
+ORDER BY p.id
+`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	counts := []projectBugCount{}
+
+	for rows.Next() {
+		c := projectBugCount{}
+
+		err = rows.Scan(&c.ProjectID, &c.ProjectName, &c.Count)
+
+		if err != nil {
+			return nil, err
+		}
+
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+func (s *sqlBugStore) bySprint(sprintID int64) ([]bug, error) {
+	return s.query(`
+SELECT
+b.id,
+b.name,
+b.description,
+b.feature_id,
+b.user_id,
+b.assignee_id,
+b.created_at,
+b.updated_at,
+b.deleted_at,
+b.priority,
+b.severity,
+b.sprint_id,
+b.completed_at,
+b.column_id,
+b.rank,
+f.name as feature_name
+FROM goissuez.bugs b
+JOIN goissuez.features f
+ON f.id = b.feature_id
+WHERE b.sprint_id = $1
+AND b.deleted_at IS NULL
+ORDER BY b.rank, b.id
+`, sprintID)
+}
+
+func (s *sqlBugStore) backlog(projectID int64) ([]bug, error) {
+	return s.query(`
+SELECT
+b.id,
+b.name,
+b.description,
+b.feature_id,
+b.user_id,
+b.assignee_id,
+b.created_at,
+b.updated_at,
+b.deleted_at,
+b.priority,
+b.severity,
+b.sprint_id,
+b.completed_at,
+b.column_id,
+b.rank,
+f.name as feature_name
+FROM goissuez.bugs b
+JOIN goissuez.features f
+ON f.id = b.feature_id
+WHERE f.project_id = $1
+AND b.sprint_id IS NULL
+AND b.completed_at IS NULL
+AND b.deleted_at IS NULL
+AND f.deleted_at IS NULL
+ORDER BY b.rank, b.id
+`, projectID)
+}
+
+func (s *sqlBugStore) setSprint(id, sprintID int64) error {
+	stmt, err := s.db.Prepare(`UPDATE goissuez.bugs SET sprint_id = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1`)
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	_, err = stmt.Exec(id, nullID(sprintID))
+
+	return err
+}
+
+func (s *sqlBugStore) setDone(id int64, done bool) error {
+	return setCompleted(s.db, "goissuez.bugs", id, done)
+}
+
+func (s *sqlBugStore) byProject(projectID int64) ([]bug, error) {
+	return s.query(`
+SELECT
+b.id,
+b.name,
+b.description,
+b.feature_id,
+b.user_id,
+b.assignee_id,
+b.created_at,
+b.updated_at,
+b.deleted_at,
+b.priority,
+b.severity,
+b.sprint_id,
+b.completed_at,
+b.column_id,
+b.rank,
+f.name as feature_name
+FROM goissuez.bugs b
+JOIN goissuez.features f
+ON f.id = b.feature_id
+WHERE f.project_id = $1
+AND b.deleted_at IS NULL
+AND f.deleted_at IS NULL
+ORDER BY b.rank, b.id
+`, projectID)
+}
+
+func (s *sqlBugStore) setColumn(id, columnID int64) error {
+	stmt, err := s.db.Prepare(`UPDATE goissuez.bugs SET column_id = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1`)
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	_, err = stmt.Exec(id, nullID(columnID))
+
+	return err
+}
+
+// Sprints
+
+type sqlSprintStore struct {
+	db *sqlDB
+}
+
+const sprintColumns = `id, project_id, name, goal, starts_on, ends_on, state, created_at, updated_at, closed_at`
+
+func scanSprint(row scanner) (sprint, error) {
+	sprintData := sprint{}
+
+	goal := sql.NullString{}
+	closedAt := sql.NullString{}
+
+	err := row.Scan(
+		&sprintData.ID,
+		&sprintData.ProjectID,
+		&sprintData.Name,
+		&goal,
+		&sprintData.StartsOn,
+		&sprintData.EndsOn,
+		&sprintData.State,
+		&sprintData.CreatedAt,
+		&sprintData.UpdatedAt,
+		&closedAt,
+	)
+
+	if err != nil {
+		return sprint{}, err
+	}
+
+	sprintData.Goal = goal.String
+	sprintData.ClosedAt = closedAt.String
+	// dates scan as midnight timestamps
+	sprintData.StartsOn = dateOnly(sprintData.StartsOn)
+	sprintData.EndsOn = dateOnly(sprintData.EndsOn)
+
+	return sprintData, nil
+}
+
+func (s *sqlSprintStore) byProject(projectID int64) ([]sprint, error) {
+	rows, err := s.db.Query(`SELECT `+sprintColumns+` FROM goissuez.sprints WHERE project_id = $1 ORDER BY starts_on DESC, id DESC`, projectID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	sprints := []sprint{}
+
+	for rows.Next() {
+		sprintData, err := scanSprint(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sprints = append(sprints, sprintData)
+	}
+
+	return sprints, rows.Err()
+}
+
+func (s *sqlSprintStore) find(id int64) (sprint, error) {
+	return scanSprint(s.db.QueryRow(`SELECT `+sprintColumns+` FROM goissuez.sprints WHERE id = $1`, id))
+}
+
+func (s *sqlSprintStore) create(sprintData sprint) (int64, error) {
+	return s.db.insert(`
+INSERT INTO goissuez.sprints
+(project_id, name, goal, starts_on, ends_on, state, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+`,
+		sprintData.ProjectID,
+		sprintData.Name,
+		sprintData.Goal,
+		sprintData.StartsOn,
+		sprintData.EndsOn,
+		sprintPlanned,
+	)
+}
+
+func (s *sqlSprintStore) update(sprintData sprint) error {
+	result, err := s.db.Exec(`
+UPDATE goissuez.sprints
+SET name = $2, goal = $3, starts_on = $4, ends_on = $5, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1
+`, sprintData.ID, sprintData.Name, sprintData.Goal, sprintData.StartsOn, sprintData.EndsOn)
+
+	if err != nil {
+		return err
+	}
+
+	return affectedOne(result)
+}
+
+func (s *sqlSprintStore) start(id int64) error {
+	result, err := s.db.Exec(`
+UPDATE goissuez.sprints
+SET state = $2, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1
+AND state = $3
+`, id, sprintActive, sprintPlanned)
+
+	// sprints_one_active allows a single active sprint per project
+	if isUniqueViolation(err) {
+		return errSprintActive
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return affectedOne(result)
+}
+
+func (s *sqlSprintStore) close(id, carryTo int64) (int, error) {
+	tx, err := s.db.begin()
+
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec(`
+UPDATE goissuez.sprints
+SET state = $2, closed_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1
+AND state = $3
+`, id, sprintClosed, sprintActive)
+
+	if err == nil {
+		err = affectedOne(result)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	moved := 0
+
+	for _, table := range []string{"goissuez.stories", "goissuez.bugs"} {
+		result, err := tx.Exec(`
+UPDATE `+table+`
+SET sprint_id = $2, updated_at = CURRENT_TIMESTAMP
+WHERE sprint_id = $1
+AND completed_at IS NULL
+AND deleted_at IS NULL
+`, id, nullID(carryTo))
+
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+
+		n, err := result.RowsAffected()
+
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+
+		moved += int(n)
+	}
+
+	return moved, tx.Commit()
+}
+
+// dateOnly trims a date scanned as a timestamp, eg: "2021-06-01T00:00:00Z", to "2021-06-01".
+func dateOnly(value string) string {
+	if len(value) > 10 {
+		return value[:10]
+	}
+
+	return value
+}
+
+// Ranks
+
+// lastRank is the highest rank given to a story or bug in table, "" if there's none.
+// setCompleted marks the story or bug done, keeping when it was first done, or not done.
+func setCompleted(q queryer, table string, id int64, done bool) error {
+	query := `UPDATE ` + table + ` SET completed_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $1`
+
+	if done {
+		query = `UPDATE ` + table + ` SET completed_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND completed_at IS NULL`
+	}
+
+	_, err := q.Exec(query, id)
+
+	return err
+}
+
+func lastRank(tx *sqlTx, table string) (string, error) {
+	var rank sql.NullString
+
+	err := tx.QueryRow(`SELECT MAX(rank) FROM ` + table).Scan(&rank)
+
+	return rank.String, err
+}
+
+// rankNextTo gives the story or bug id in table a rank between the target's and the
+// next rank before or after it. It returns sql.ErrNoRows if either doesn't exist.
+func rankNextTo(db *sqlDB, table string, id, targetID int64, before bool) (string, error) {
+	tx, err := db.begin()
+
+	if err != nil {
+		return "", err
+	}
+
+	rank, ok, err := rankNextToIn(tx, table, id, targetID, before)
+
+	// two old ranks left no room, or only a long rank; rank everything again and try once more
+	if err == nil && (!ok || len(rank) > maxRankLength) {
+		err = rerank(tx, table)
+
+		if err == nil {
+			rank, _, err = rankNextToIn(tx, table, id, targetID, before)
+		}
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	result, err := tx.Exec(`UPDATE `+table+` SET rank = $2 WHERE id = $1`, id, rank)
+
+	if err == nil {
+		err = affectedOne(result)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	return rank, tx.Commit()
+}
+
+// rankNextToIn works out the rank for rankNextTo, or ok is false if there's no room next to the target.
+func rankNextToIn(tx *sqlTx, table string, id, targetID int64, before bool) (rank string, ok bool, err error) {
+	var target string
+	var neighbour sql.NullString
+
+	err = tx.QueryRow(`SELECT rank FROM `+table+` WHERE id = $1`, targetID).Scan(&target)
+
+	if err == nil && before {
+		err = tx.QueryRow(`SELECT MAX(rank) FROM `+table+` WHERE rank < $1 AND id <> $2`, target, id).Scan(&neighbour)
+	} else if err == nil {
+		err = tx.QueryRow(`SELECT MIN(rank) FROM `+table+` WHERE rank > $1 AND id <> $2`, target, id).Scan(&neighbour)
+	}
+
+	if err != nil {
+		return "", false, err
+	}
+
+	if before {
+		rank, ok = rankBetween(neighbour.String, target)
+	} else {
+		rank, ok = rankBetween(target, neighbour.String)
+	}
+
+	return rank, ok, nil
+}
+
+// rerank gives every story or bug in table a whole rank, keeping their order.
+func rerank(tx *sqlTx, table string) error {
+	rows, err := tx.Query(`SELECT id FROM ` + table + ` ORDER BY rank, id`)
+
+	if err != nil {
+		return err
+	}
+
+	ids := []int64{}
+
+	for rows.Next() {
+		var id int64
+
+		err = rows.Scan(&id)
+
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		ids = append(ids, id)
+	}
+
+	err = rows.Close()
+
+	if err == nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	rank := ""
+
+	for _, id := range ids {
+		rank = nextRank(rank)
+
+		_, err = tx.Exec(`UPDATE `+table+` SET rank = $2 WHERE id = $1`, id, rank)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *sqlStoryStore) rankBefore(id, targetID int64) (string, error) {
+	return rankNextTo(s.db, "goissuez.stories", id, targetID, true)
+}
+
+func (s *sqlStoryStore) rankAfter(id, targetID int64) (string, error) {
+	return rankNextTo(s.db, "goissuez.stories", id, targetID, false)
+}
+
+func (s *sqlBugStore) rankBefore(id, targetID int64) (string, error) {
+	return rankNextTo(s.db, "goissuez.bugs", id, targetID, true)
+}
+
+func (s *sqlBugStore) rankAfter(id, targetID int64) (string, error) {
+	return rankNextTo(s.db, "goissuez.bugs", id, targetID, false)
+}
+
+// Links
+
+type sqlLinkStore struct {
+	db *sqlDB
+}
+
+// linkSelect reads links with the names of the issues at both ends, and whether they've been deleted.
+const linkSelect = `
+SELECT
+l.id,
+l.link_type,
+l.from_story_id,
+l.from_bug_id,
+l.to_story_id,
+l.to_bug_id,
+l.user_id,
+l.created_at,
+COALESCE(fs.name, fb.name, ''),
+COALESCE(fs.deleted_at, fb.deleted_at) IS NOT NULL,
+COALESCE(ts.name, tb.name, ''),
+COALESCE(ts.deleted_at, tb.deleted_at) IS NOT NULL
+FROM goissuez.issue_links l
+LEFT JOIN goissuez.stories fs ON fs.id = l.from_story_id
+LEFT JOIN goissuez.bugs fb ON fb.id = l.from_bug_id
+LEFT JOIN goissuez.stories ts ON ts.id = l.to_story_id
+LEFT JOIN goissuez.bugs tb ON tb.id = l.to_bug_id
+`
+
+func scanLink(row scanner) (issueLink, error) {
+	linkData := issueLink{}
+
+	// one of each pair is null, depending on whether the end is a story or a bug
+	var fromStoryID, fromBugID, toStoryID, toBugID sql.NullInt64
+
+	err := row.Scan(
+		&linkData.ID,
+		&linkData.Type,
+		&fromStoryID,
+		&fromBugID,
+		&toStoryID,
+		&toBugID,
+		&linkData.UserID,
+		&linkData.CreatedAt,
+		&linkData.From.Name,
+		&linkData.From.Deleted,
+		&linkData.To.Name,
+		&linkData.To.Deleted,
+	)
+
+	if err != nil {
+		return issueLink{}, err
+	}
+
+	linkData.From.Type, linkData.From.ID = linkEnd(fromStoryID, fromBugID)
+	linkData.To.Type, linkData.To.ID = linkEnd(toStoryID, toBugID)
+
+	return linkData, nil
+}
+
+// linkEnd is the type and id of the issue at one end of a link from its story and bug columns.
+func linkEnd(storyID, bugID sql.NullInt64) (string, int64) {
+	if bugID.Valid {
+		return "bug", bugID.Int64
+	}
+
+	return "story", storyID.Int64
+}
+
+// linkColumns are the story and bug columns for an end of a link to the issue.
+func linkColumns(issue issueRef) (storyID, bugID sql.NullInt64) {
+	if issue.Type == "bug" {
+		return sql.NullInt64{}, nullID(issue.ID)
+	}
+
+	return nullID(issue.ID), sql.NullInt64{}
+}
+
+func (s *sqlLinkStore) byIssue(issue issueRef) ([]issueLink, error) {
+	column := "story_id"
+
+	if issue.Type == "bug" {
+		column = "bug_id"
+	}
+
+	rows, err := s.db.Query(linkSelect+`WHERE l.from_`+column+` = $1 OR l.to_`+column+` = $1 ORDER BY l.created_at, l.id`, issue.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	links := []issueLink{}
+
+	for rows.Next() {
+		linkData, err := scanLink(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		links = append(links, linkData)
+	}
+
+	return links, rows.Err()
+}
+
+func (s *sqlLinkStore) find(id int64) (issueLink, error) {
+	return scanLink(s.db.QueryRow(linkSelect+`WHERE l.id = $1`, id))
+}
+
+func (s *sqlLinkStore) create(linkData issueLink) (int64, error) {
+	fromStoryID, fromBugID := linkColumns(linkData.From)
+	toStoryID, toBugID := linkColumns(linkData.To)
+
+	id, err := s.db.insert(`
+INSERT INTO goissuez.issue_links
+(link_type, from_story_id, from_bug_id, to_story_id, to_bug_id, user_id, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP)
+`,
+		linkData.Type,
+		fromStoryID,
+		fromBugID,
+		toStoryID,
+		toBugID,
+		linkData.UserID,
+	)
+
+	if isUniqueViolation(err) {
+		return 0, errLinkExists
+	}
+
+	return id, err
+}
+
+func (s *sqlLinkStore) destroy(id int64) error {
+	result, err := s.db.Exec(`DELETE FROM goissuez.issue_links WHERE id = $1`, id)
+
+	if err != nil {
+		return err
+	}
+
+	return affectedOne(result)
+}
+
+// Labels
+
+type sqlLabelStore struct {
+	db *sqlDB
+}
+
+// labelSelect reads labels with their project's name and how many stories and bugs that haven't been deleted have them.
+const labelSelect = `
+SELECT
+l.id,
+l.project_id,
+l.name,
+l.color,
+p.name,
+(SELECT COUNT(*) FROM goissuez.story_labels sl JOIN goissuez.stories s ON s.id = sl.story_id WHERE sl.label_id = l.id AND s.deleted_at IS NULL)
++ (SELECT COUNT(*) FROM goissuez.bug_labels bl JOIN goissuez.bugs b ON b.id = bl.bug_id WHERE bl.label_id = l.id AND b.deleted_at IS NULL),
+l.created_at,
+l.updated_at
+FROM goissuez.labels l
+JOIN goissuez.projects p ON p.id = l.project_id
+`
+
+func scanLabel(row scanner) (label, error) {
+	labelData := label{}
+
+	err := row.Scan(
+		&labelData.ID,
+		&labelData.ProjectID,
+		&labelData.Name,
+		&labelData.Color,
+		&labelData.Project,
+		&labelData.Uses,
+		&labelData.CreatedAt,
+		&labelData.UpdatedAt,
+	)
+
+	return labelData, err
+}
+
+func (s *sqlLabelStore) query(query string, args ...interface{}) ([]label, error) {
+	rows, err := s.db.Query(query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	labels := []label{}
+
+	for rows.Next() {
+		labelData, err := scanLabel(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		labels = append(labels, labelData)
+	}
+
+	return labels, rows.Err()
+}
+
+func (s *sqlLabelStore) byProject(projectID int64) ([]label, error) {
+	return s.query(labelSelect+`WHERE l.project_id = $1 ORDER BY lower(l.name), l.id`, projectID)
+}
+
+func (s *sqlLabelStore) all() ([]label, error) {
+	return s.query(labelSelect + `WHERE p.deleted_at IS NULL ORDER BY lower(p.name), p.id, lower(l.name), l.id`)
+}
+
+func (s *sqlLabelStore) find(id int64) (label, error) {
+	return scanLabel(s.db.QueryRow(labelSelect+`WHERE l.id = $1`, id))
+}
+
+func (s *sqlLabelStore) create(labelData label) (int64, error) {
+	id, err := s.db.insert(`
+INSERT INTO goissuez.labels
+(project_id, name, color, created_at, updated_at)
+VALUES ($1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+`,
+		labelData.ProjectID,
+		labelData.Name,
+		labelData.Color,
+	)
+
+	// labels_project_name allows a name once per project, whatever its case
+	if isUniqueViolation(err) {
+		return 0, errLabelExists
+	}
+
+	return id, err
+}
+
+func (s *sqlLabelStore) update(labelData label) error {
+	result, err := s.db.Exec(`
+UPDATE goissuez.labels
+SET name = $2, color = $3, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1
+`, labelData.ID, labelData.Name, labelData.Color)
+
+	if isUniqueViolation(err) {
+		return errLabelExists
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return affectedOne(result)
+}
+
+func (s *sqlLabelStore) merge(id, intoID int64) error {
+	tx, err := s.db.begin()
+
+	if err != nil {
+		return err
+	}
+
+	// issues that already have both keep a single link
+	for _, table := range []struct{ name, column string }{
+		{"goissuez.story_labels", "story_id"},
+		{"goissuez.bug_labels", "bug_id"},
+	} {
+		_, err = tx.Exec(`
+INSERT INTO `+table.name+` (`+table.column+`, label_id)
+SELECT `+table.column+`, CAST($2 AS integer)
+FROM `+table.name+`
+WHERE label_id = $1
+AND `+table.column+` NOT IN (SELECT `+table.column+` FROM `+table.name+` WHERE label_id = $2)
+`, id, intoID)
+
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// the label's own links go with it
+	result, err := tx.Exec(`DELETE FROM goissuez.labels WHERE id = $1`, id)
+
+	if err == nil {
+		err = affectedOne(result)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// labelQueryIDs is how many issues issueLabels asks for at once, well under the
+// number of parameters a query can have (32766 in SQLite, 65535 in Postgres).
+const labelQueryIDs = 500
+
+// issueLabels reads the labels of the issues in a join table by name, keyed by the issue's id in column.
+func (s *sqlLabelStore) issueLabels(table, column string, ids []int64) (map[int64][]label, error) {
+	labels := make(map[int64][]label)
+
+	// an issue asked for twice would get its labels twice from two batches
+	seen := make(map[int64]bool)
+	unique := []int64{}
+
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	ids = unique
+
+	for len(ids) > 0 {
+		n := len(ids)
+
+		if n > labelQueryIDs {
+			n = labelQueryIDs
+		}
+
+		err := s.issueLabelsIn(table, column, ids[:n], labels)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ids = ids[n:]
+	}
+
+	return labels, nil
+}
+
+// issueLabelsIn adds the labels of the issues ids to labels, for issueLabels.
+func (s *sqlLabelStore) issueLabelsIn(table, column string, ids []int64, labels map[int64][]label) error {
+	params := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+
+	for i, id := range ids {
+		params[i] = "$" + strconv.Itoa(i+1)
+		args[i] = id
+	}
+
+	rows, err := s.db.Query(`
+SELECT j.`+column+`, l.id, l.project_id, l.name, l.color
+FROM `+table+` j
+JOIN goissuez.labels l ON l.id = j.label_id
+WHERE j.`+column+` IN (`+strings.Join(params, ", ")+`)
+ORDER BY lower(l.name), l.id
+`, args...)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var issueID int64
+		labelData := label{}
+
+		err := rows.Scan(&issueID, &labelData.ID, &labelData.ProjectID, &labelData.Name, &labelData.Color)
+
+		if err != nil {
+			return err
+		}
+
+		labels[issueID] = append(labels[issueID], labelData)
+	}
+
+	return rows.Err()
+}
+
+func (s *sqlLabelStore) forStories(ids []int64) (map[int64][]label, error) {
+	return s.issueLabels("goissuez.story_labels", "story_id", ids)
+}
+
+func (s *sqlLabelStore) forBugs(ids []int64) (map[int64][]label, error) {
+	return s.issueLabels("goissuez.bug_labels", "bug_id", ids)
+}
+
+// setIssueLabels replaces the labels of the issue id in a join table.
+func (s *sqlLabelStore) setIssueLabels(table, column string, id int64, labelIDs []int64) error {
+	tx, err := s.db.begin()
+
+	if err != nil {
+		return err
+	}
+
+	err = setIssueLabelsIn(tx, table, column, id, labelIDs)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// setIssueLabelsIn replaces the issue's labels as part of the transaction.
+func setIssueLabelsIn(tx *sqlTx, table, column string, id int64, labelIDs []int64) error {
+	_, err := tx.Exec(`DELETE FROM `+table+` WHERE `+column+` = $1`, id)
+
+	for _, labelID := range labelIDs {
+		if err != nil {
+			break
+		}
+
+		_, err = tx.Exec(`INSERT INTO `+table+` (`+column+`, label_id) VALUES ($1, $2)`, id, labelID)
+	}
+
+	return err
+}
+
+func (s *sqlLabelStore) setStoryLabels(id int64, labelIDs []int64) error {
+	return s.setIssueLabels("goissuez.story_labels", "story_id", id, labelIDs)
+}
+
+func (s *sqlLabelStore) setBugLabels(id int64, labelIDs []int64) error {
+	return s.setIssueLabels("goissuez.bug_labels", "bug_id", id, labelIDs)
+}
+
+// Boards
+
+type sqlBoardStore struct {
+	db *sqlDB
+}
+
+const boardColumnColumns = `id, project_id, name, position, wip_limit, done`
+
+func scanBoardColumn(row scanner) (boardColumn, error) {
+	columnData := boardColumn{}
+
+	// no limit is NULL
+	var wipLimit sql.NullInt64
+
+	err := row.Scan(
+		&columnData.ID,
+		&columnData.ProjectID,
+		&columnData.Name,
+		&columnData.Position,
+		&wipLimit,
+		&columnData.Done,
+	)
+
+	if err != nil {
+		return boardColumn{}, err
+	}
+
+	columnData.WIPLimit = int(wipLimit.Int64)
+
+	return columnData, nil
+}
+
+func (s *sqlBoardStore) columns(projectID int64) ([]boardColumn, error) {
+	rows, err := s.db.Query(`SELECT `+boardColumnColumns+` FROM goissuez.board_columns WHERE project_id = $1 ORDER BY position, id`, projectID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	columns := []boardColumn{}
+
+	for rows.Next() {
+		columnData, err := scanBoardColumn(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		columns = append(columns, columnData)
+	}
+
+	return columns, rows.Err()
+}
+
+func (s *sqlBoardStore) findColumn(id int64) (boardColumn, error) {
+	return scanBoardColumn(s.db.QueryRow(`SELECT `+boardColumnColumns+` FROM goissuez.board_columns WHERE id = $1`, id))
+}
+
+func (s *sqlBoardStore) createColumn(columnData boardColumn) (int64, error) {
+	return s.db.insert(`
+INSERT INTO goissuez.board_columns
+(project_id, name, position, wip_limit, done, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+`, columnData.ProjectID, columnData.Name, columnData.Position, nullInt(columnData.WIPLimit), columnData.Done)
+}
+
+func (s *sqlBoardStore) updateColumn(columnData boardColumn) error {
+	result, err := s.db.Exec(`
+UPDATE goissuez.board_columns
+SET name = $2, position = $3, wip_limit = $4, done = $5, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1
+`, columnData.ID, columnData.Name, columnData.Position, nullInt(columnData.WIPLimit), columnData.Done)
+
+	if err != nil {
+		return err
+	}
+
+	return affectedOne(result)
+}
+
+func (s *sqlBoardStore) destroyColumn(id int64) error {
+	// the column's stories and bugs are left without one by ON DELETE SET NULL
+	result, err := s.db.Exec(`DELETE FROM goissuez.board_columns WHERE id = $1`, id)
+
+	if err != nil {
+		return err
+	}
+
+	return affectedOne(result)
+}
+
+// Jobs
+
+type sqlJobStore struct {
+	db *sqlDB
+}
+
+const jobColumns = `id, kind, payload, status, attempts, max_attempts, run_at, last_error, unique_key, created_at, finished_at`
+
+func scanJob(row scanner) (job, error) {
+	jobData := job{}
+
+	lastError := sql.NullString{}
+	uniqueKey := sql.NullString{}
+	finishedAt := sql.NullString{}
+
+	err := row.Scan(
+		&jobData.ID,
+		&jobData.Kind,
+		&jobData.Payload,
+		&jobData.Status,
+		&jobData.Attempts,
+		&jobData.MaxAttempts,
+		&jobData.RunAt,
+		&lastError,
+		&uniqueKey,
+		&jobData.CreatedAt,
+		&finishedAt,
+	)
+
+	if err != nil {
+		return job{}, err
+	}
+
+	jobData.LastError = lastError.String
+	jobData.UniqueKey = uniqueKey.String
+	jobData.FinishedAt = finishedAt.String
+
+	return jobData, nil
+}
+
+func (s *sqlJobStore) enqueue(jobData job, runAt time.Time) (int64, error) {
+	uniqueKey := sql.NullString{String: jobData.UniqueKey, Valid: jobData.UniqueKey != ""}
+	now := s.db.dialect.timestamp(time.Now())
+
+	id, err := s.db.insert(`
+INSERT INTO goissuez.jobs
+(kind, payload, max_attempts, run_at, unique_key, created_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+`, jobData.Kind, jobData.Payload, jobData.MaxAttempts, s.db.dialect.timestamp(runAt), uniqueKey, now)
+
+	if isUniqueViolation(err) {
+		return 0, nil
+	}
+
+	return id, err
+}
+
+func (s *sqlJobStore) claim(now, stale time.Time) (job, error) {
+	tx, err := s.db.begin()
+
+	if err != nil {
+		return job{}, err
+	}
+
+	defer tx.Rollback()
+
+	var id int64
+
+	err = tx.QueryRow(`
+SELECT id FROM goissuez.jobs
+WHERE (status = 'queued' AND run_at <= $1)
+OR (status = 'running' AND locked_at < $2)
+ORDER BY run_at, id
+LIMIT 1`+s.db.dialect.skipLocked(), s.db.dialect.timestamp(now), s.db.dialect.timestamp(stale)).Scan(&id)
+
+	if err != nil {
+		return job{}, err
+	}
+
+	_, err = tx.Exec(`
+UPDATE goissuez.jobs
+SET status = 'running', attempts = attempts + 1, locked_at = $2
+WHERE id = $1
+`, id, s.db.dialect.timestamp(now))
+
+	if err != nil {
+		return job{}, err
+	}
+
+	jobData, err := scanJob(tx.QueryRow(`SELECT `+jobColumns+` FROM goissuez.jobs WHERE id = $1`, id))
+
+	if err != nil {
+		return job{}, err
+	}
+
+	return jobData, tx.Commit()
+}
+
+func (s *sqlJobStore) complete(id int64) error {
+	_, err := s.db.Exec(`
+UPDATE goissuez.jobs
+SET status = 'done', locked_at = NULL, last_error = NULL, finished_at = $2
+WHERE id = $1
+`, id, s.db.dialect.timestamp(time.Now()))
+
+	return err
+}
+
+func (s *sqlJobStore) fail(id int64, message string, retryAt time.Time) error {
+	if retryAt.IsZero() {
+		_, err := s.db.Exec(`
+UPDATE goissuez.jobs
+SET status = 'dead', locked_at = NULL, last_error = $2, finished_at = $3
+WHERE id = $1
+`, id, message, s.db.dialect.timestamp(time.Now()))
+
+		return err
+	}
+
+	_, err := s.db.Exec(`
+UPDATE goissuez.jobs
+SET status = 'queued', locked_at = NULL, last_error = $2, run_at = $3
+WHERE id = $1
+`, id, message, s.db.dialect.timestamp(retryAt))
+
+	return err
+}
+
+func (s *sqlJobStore) retry(id int64) error {
+	result, err := s.db.Exec(`
+UPDATE goissuez.jobs
+SET status = 'queued', attempts = 0, run_at = $2, finished_at = NULL
+WHERE id = $1 AND status = 'dead'
+`, id, s.db.dialect.timestamp(time.Now()))
+
+	if err != nil {
+		return err
+	}
+
+	return affectedOne(result)
+}
+
+func (s *sqlJobStore) destroy(id int64) error {
+	result, err := s.db.Exec(`DELETE FROM goissuez.jobs WHERE id = $1 AND status = 'dead'`, id)
+
+	if err != nil {
+		return err
+	}
+
+	return affectedOne(result)
+}
+
+func (s *sqlJobStore) counts() (map[string]int, error) {
+	counts := map[string]int{"queued": 0, "running": 0, "done": 0, "dead": 0}
+
+	rows, err := s.db.Query(`SELECT status, count(*) FROM goissuez.jobs GROUP BY status`)
+
+	if err != nil {
+		return counts, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var status string
+		var count int
+
+		err = rows.Scan(&status, &count)
+
+		if err != nil {
+			return counts, err
+		}
+
+		counts[status] = count
+	}
+
+	return counts, rows.Err()
+}
+
+func (s *sqlJobStore) dead(limit int) ([]job, error) {
+	jobs := []job{}
+
+	rows, err := s.db.Query(`
+SELECT `+jobColumns+`
+FROM goissuez.jobs
+WHERE status = 'dead'
+ORDER BY finished_at DESC, id DESC
+LIMIT $1
+`, limit)
+
+	if err != nil {
+		return jobs, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		jobData, err := scanJob(rows)
+
+		if err != nil {
+			return jobs, err
+		}
+
+		jobs = append(jobs, jobData)
+	}
+
+	return jobs, rows.Err()
+}
+
+func (s *sqlJobStore) purge(before time.Time) (int64, error) {
+	result, err := s.db.Exec(`DELETE FROM goissuez.jobs WHERE status = 'done' AND finished_at < $1`, s.db.dialect.timestamp(before))
+
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -2,20 +2,17 @@ package main
 
 import (
 	"database/sql"
-	"github.com/sirupsen/logrus"
-	"html/template"
 	"net/http"
 	"strconv"
 
-	"context"
-	"encoding/json"
 	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
 )
 
 type storyService struct {
-	db   *sql.DB
-	log  *logrus.Logger
-	tpls *template.Template
+	stores stores
+	log    *logrus.Logger
+	tpls   *templateRegistry
 }
 
 type story struct {
@@ -25,99 +22,51 @@ type story struct {
 	FeatureID   int64
 	UserID      int64
 	AssigneeID  int64
-	CreatedAt   string
-	UpdatedAt   string
-	DeletedAt   string
-	Creator     *user
-	Assignee    *user
-	Feature     *feature
-	Project     *project
+	Priority    priority
+	// Points is the story's size on its project's scale, 0 if it hasn't been sized.
+	Points int
+	// EstimateMinutes is how long the story was first expected to take, 0 if it hasn't been estimated.
+	EstimateMinutes int
+	// SprintID is the sprint the story is planned in, 0 while it's in the project's backlog.
+	SprintID int64
+	// CompletedAt is when the story was marked done.
+	CompletedAt string
+	// ColumnID is the board column the story was last moved to, 0 if it never was.
+	ColumnID int64
+	// Rank orders the story among the others; lists are sorted by it.
+	Rank      string
+	CreatedAt string
+	UpdatedAt string
+	DeletedAt string
+	Creator   *user
+	Assignee  *user
+	Feature   *feature
+	Project   *project
+	// Labels are filled in by withStoryLabels on the pages that show them.
+	Labels []label
 }
 
-func NewStoryService(db *sql.DB, log *logrus.Logger, tpls *template.Template) *storyService {
-	return &storyService{db, log, tpls}
+func NewStoryService(store stores, log *logrus.Logger, tpls *templateRegistry) *storyService {
+	return &storyService{store, log, tpls}
 }
 
 func (s *storyService) all(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"read_stories_mine"}) && !authUser.Can([]string{"read_stories_others"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	stories := []story{}
-
-	stmt, err := s.db.Prepare(`
-SELECT
-s.id,
-s.name,
-s.feature_id,
-s.user_id,
-s.assignee_id,
-s.created_at,
-s.updated_at,
-f.name as feature_name
-FROM goissuez.stories s
-JOIN goissuez.features f
-ON f.id = s.feature_id
-WHERE s.deleted_at IS NULL
-ORDER BY s.updated_at
-`)
-
-	if err != nil {
-		s.log.Error("Error stories.all.prepare.", err)
-		http.Error(w, "Error listing stories.", http.StatusInternalServerError)
+	authUser := currentUser(r)
 
-		return
-	}
-
-	defer stmt.Close()
-
-	rows, err := stmt.Query()
+	stories, err := s.stores.stories.all()
 
 	if err != nil {
-		s.log.Error("Error stories.all.query.", err)
-		http.Error(w, "Error listing stories.", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error("Error stories.all.query.", err)
+		respondError(w, r, internalError("Error listing stories.", nil))
 
 		return
 	}
 
-	for rows.Next() {
-		storyData := story{Feature: &feature{}}
-
-		var assignee_id sql.NullInt64
-
-		err := rows.Scan(
-			&storyData.ID,
-			&storyData.Name,
-			&storyData.FeatureID,
-			&storyData.UserID,
-			&assignee_id,
-			&storyData.CreatedAt,
-			&storyData.UpdatedAt,
-			&storyData.Feature.Name,
-		)
-
-		if err != nil {
-			s.log.Error("Error stories.all.scan.", err)
-			http.Error(w, "Error listing stories.", http.StatusInternalServerError)
-
-			return
-		}
-
-		if assignee_id.Valid {
-			storyData.AssigneeID = assignee_id.Int64
-		}
-
-		stories = append(stories, storyData)
-	}
-
 	// filter with permission checks
 	filteredStories := []story{}
 	{
 		for _, s := range stories {
-			if s.AssigneeID == authUser.ID {
+			if isOwner(authUser.ID, s.UserID, s.AssigneeID) {
 				if authUser.Can([]string{"read_stories_mine"}) {
 					filteredStories = append(filteredStories, s)
 				}
@@ -132,7 +81,18 @@ ORDER BY s.updated_at
 		stories = filteredStories
 	}
 
-	users, err := getUsers(s.db)
+	err = withStoryLabels(s.stores, stories)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error stories.all.labels.", err)
+		respondError(w, r, internalError("Error listing stories.", nil))
+
+		return
+	}
+
+	stories, filter := filterStories(stories, r.URL.Query().Get("label"))
+
+	users, err := s.stores.users.all()
 
 	usersByID := make(map[int64]*user)
 
@@ -165,21 +125,22 @@ ORDER BY s.updated_at
 		Title: "Stories",
 		Data: struct {
 			Stories []story
+			Labels  labelFilter
 		}{
 			stories,
+			filter,
 		},
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/stories/all.gohtml")
+	view.make("stories/all.gohtml")
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error(err)
-
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -191,131 +152,40 @@ ORDER BY s.updated_at
 // First, we'll get the feature details, and then
 // we'll query the related stories separately.
 func (s *storyService) featureStories(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"read_stories_mine"}) && !authUser.Can([]string{"read_stories_others"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
 
-	featureData := feature{}
+	authUser := currentUser(r)
 
-	parentFeatureID := ps.ByName("feature_id")
-
-	stmt, err := s.db.Prepare(`SELECT id, name, description, project_id, deleted_at FROM goissuez.features WHERE id = $1`)
-
-	if err != nil {
-		s.log.Error("Error stories.index.prepare.feature.", err)
-
-		http.Error(w, "Error listing stories.", http.StatusInternalServerError)
-
-		return
-	}
-
-	defer stmt.Close()
-
-	row := stmt.QueryRow(parentFeatureID)
-
-	deleted_at := sql.NullString{}
-
-	err = row.Scan(
-		&featureData.ID,
-		&featureData.Name,
-		&featureData.Description,
-		&featureData.ProjectID,
-		&deleted_at,
-	)
+	featureData, err := s.stores.features.find(parseID(ps.ByName("feature_id")))
 
 	if err != nil {
-		s.log.Error("Error stories.index.scan.feature.", err)
+		s.log.WithContext(r.Context()).Error("Error stories.index.feature.", err)
 
-		http.Error(w, "Error listing stories.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error listing stories.", nil))
 
 		return
 	}
 
-	if deleted_at.Valid {
+	if featureData.DeletedAt != "" {
 		deletedEntityNotice("This feature has been deleted, so you cannot view its stories.", w, r, s.log)
 		return
 	}
 
-	query := `
-SELECT
-id,
-name,
-description,
-feature_id,
-user_id,
-assignee_id,
-created_at,
-updated_at
-FROM goissuez.stories
-WHERE feature_id = $1
-AND deleted_at IS NULL
-ORDER BY created_at
-`
-	stmt, err = s.db.Prepare(query)
+	stories, err := s.stores.stories.byFeature(featureData.ID)
 
 	if err != nil {
 
-		s.log.Error("Error stories.index.prepare.", err)
+		s.log.WithContext(r.Context()).Error("Error stories.index.query.", err)
 
-		http.Error(w, "Error listing stories.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error listing stories.", nil))
 
 		return
 	}
 
-	defer stmt.Close()
-
-	rows, err := stmt.Query(parentFeatureID)
-
-	if err != nil {
-
-		s.log.Error("Error stories.index.query.", err)
-
-		http.Error(w, "Error listing stories.", http.StatusInternalServerError)
-
-		return
-	}
-
-	stories := []story{}
-	for rows.Next() {
-
-		storyData := story{}
-		var assigneeID sql.NullInt64
-
-		err := rows.Scan(
-			&storyData.ID,
-			&storyData.Name,
-			&storyData.Description,
-			&storyData.FeatureID,
-			&storyData.UserID,
-			&assigneeID,
-			&storyData.CreatedAt,
-			&storyData.UpdatedAt,
-		)
-
-		if err != nil {
-
-			s.log.Error("Error stories.index.scan.", err)
-
-			http.Error(w, "Error listing stories.", http.StatusInternalServerError)
-
-			return
-		}
-
-		if assigneeID.Valid {
-			storyData.AssigneeID = assigneeID.Int64
-		}
-
-		stories = append(stories, storyData)
-	}
-
 	// filter with permission checks
 	filteredStories := []story{}
 	{
 		for _, s := range stories {
-			if s.AssigneeID == authUser.ID || s.UserID == authUser.ID {
+			if isOwner(authUser.ID, s.UserID, s.AssigneeID) {
 				if authUser.Can([]string{"read_stories_mine"}) {
 					filteredStories = append(filteredStories, s)
 				}
@@ -329,17 +199,34 @@ ORDER BY created_at
 		featureData.Stories = filteredStories
 	}
 
-	pageData := page{Title: "Stories", Data: featureData}
+	err = withStoryLabels(s.stores, featureData.Stories)
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error stories.index.labels.", err)
+
+		respondError(w, r, internalError("Error listing stories.", nil))
+
+		return
+	}
+
+	var filter labelFilter
+
+	featureData.Stories, filter = filterStories(featureData.Stories, r.URL.Query().Get("label"))
+
+	pageData := page{Title: "Stories", Data: struct {
+		feature
+		Labels labelFilter
+	}{featureData, filter}}
 
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/stories/stories.gohtml")
+	view.make("stories/stories.gohtml")
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -348,63 +235,70 @@ ORDER BY created_at
 }
 
 func (s *storyService) store(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	authUser := currentUser(r)
+
+	feature_id := ps.ByName("feature_id")
 
-	authUser, _ := auth.getAuthUser(r)
+	r.ParseForm()
 
-	if !authUser.Can([]string{"create_stories"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
+	storyData := story{
+		Name:        r.PostForm.Get("name"),
+		Description: r.PostForm.Get("description"),
+		FeatureID:   parseID(feature_id),
+		UserID:      authUser.ID,
+		AssigneeID:  parseID(r.PostForm.Get("assignee_id")),
 	}
 
-	feature_id := ps.ByName("feature_id")
+	featureData, err := s.stores.features.find(storyData.FeatureID)
 
-	r.ParseForm()
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Feature not found."))
+		return
+	}
 
-	name := r.PostForm.Get("name")
-	description := r.PostForm.Get("description")
-	assignee_id := r.PostForm.Get("assignee_id")
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error stories.store.feature.", err)
 
-	query := `
-INSERT INTO goissuez.stories
-(name, description, feature_id, user_id, assignee_id, created_at, updated_at)
-VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-RETURNING id
-`
-	stmt, err := s.db.Prepare(query)
+		respondError(w, r, internalError("Error saving story.", nil))
+		return
+	}
+
+	storyData.Priority, err = readPriority(authUser, r.PostForm, defaultPriority)
 
 	if err != nil {
-		s.log.Error("Error stories.store.prepare.", err)
+		respondError(w, r, err)
+		return
+	}
 
-		http.Error(w, "Error creating story.", http.StatusInternalServerError)
+	err = readEstimates(r.PostForm, *featureData.Project, &storyData)
 
+	if err != nil {
+		respondError(w, r, err)
 		return
 	}
 
-	defer stmt.Close()
+	projectLabels, err := s.stores.labels.byProject(featureData.ProjectID)
 
-	var assignee sql.NullInt64
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error stories.store.labels.", err)
 
-	if assignee_id == "0" {
-		assignee = sql.NullInt64{}
-	} else {
-		v, err := strconv.ParseInt(assignee_id, 10, 64)
+		respondError(w, r, internalError("Error saving story.", nil))
+		return
+	}
 
-		if err != nil {
-			assignee = sql.NullInt64{}
-		}
+	labelIDs, err := readLabelIDs(r.PostForm, projectLabels)
 
-		assignee = sql.NullInt64{
-			Int64: v,
-			Valid: true,
-		}
+	if err != nil {
+		respondError(w, r, err)
+		return
 	}
 
-	_, err = stmt.Exec(name, description, feature_id, authUser.ID, assignee)
+	_, err = s.stores.stories.createWithLabels(storyData, labelIDs)
 
 	if err != nil {
-		s.log.Error("Error stories.store.exec.", err)
+		s.log.WithContext(r.Context()).Error("Error stories.store.exec.", err)
 
-		http.Error(w, "Error saving story.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error saving story.", nil))
 		return
 	}
 
@@ -412,105 +306,89 @@ RETURNING id
 }
 
 func (s *storyService) update(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
+	authUser := currentUser(r)
 
 	story_id := ps.ByName("story_id")
 
-	// ensure we have the correct permissions
-	{
-		storyData := story{}
-
-		stmt, err := s.db.Prepare(`SELECT user_id, assignee_id FROM goissuez.stories WHERE id = $1`)
+	existing, err := s.stores.stories.find(parseID(story_id))
 
-		if err != nil {
-			s.log.Error("Error stories.update.prepare.", err)
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Story not found."))
+		return
+	}
 
-			http.Error(w, "Error updating story.", http.StatusInternalServerError)
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error stories.update.find.", err)
 
-			return
-		}
+		respondError(w, r, internalError("Error updating story.", nil))
 
-		assignee_id := sql.NullInt64{}
+		return
+	}
 
-		err = stmt.QueryRow(story_id).Scan(
-			&storyData.UserID,
-			&assignee_id,
-		)
+	r.ParseForm()
 
-		if err != nil {
-			s.log.Error("Error stories.update.scan.", err)
+	storyData := story{
+		ID:              existing.ID,
+		Name:            r.PostForm.Get("name"),
+		Description:     r.PostForm.Get("description"),
+		AssigneeID:      parseID(r.PostForm.Get("assignee_id")),
+		Points:          existing.Points,
+		EstimateMinutes: existing.EstimateMinutes,
+	}
 
-			http.Error(w, "Error updating story.", http.StatusInternalServerError)
+	storyData.Priority, err = readPriority(authUser, r.PostForm, existing.Priority)
 
-			return
-		}
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
 
-		if assignee_id.Valid {
-			storyData.AssigneeID = assignee_id.Int64
-		} else {
-			storyData.AssigneeID = 0
-		}
+	featureData, err := s.stores.features.find(existing.FeatureID)
 
-		if storyData.UserID == authUser.ID || storyData.AssigneeID == authUser.ID {
-			if !authUser.Can([]string{"update_stories_mine"}) {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-		} else {
-			if !authUser.Can([]string{"update_stories_others"}) {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-		}
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Feature not found."))
+		return
 	}
 
-	r.ParseForm()
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error stories.update.feature.", err)
 
-	name := r.PostForm.Get("name")
-	description := r.PostForm.Get("description")
-	assignee_id := r.PostForm.Get("assignee_id")
+		respondError(w, r, internalError("Error updating story.", nil))
 
-	query := `
-UPDATE goissuez.stories
-SET name = $2, description = $3, assignee_id = $4, updated_at = CURRENT_TIMESTAMP
-WHERE id = $1
-`
+		return
+	}
 
-	stmt, err := s.db.Prepare(query)
+	err = readEstimates(r.PostForm, *featureData.Project, &storyData)
 
 	if err != nil {
-		s.log.Error("Error stories.update.prepare.", err)
-
-		http.Error(w, "Error updating story.", http.StatusInternalServerError)
-
+		respondError(w, r, err)
 		return
 	}
 
-	defer stmt.Close()
+	projectLabels, err := s.stores.labels.byProject(featureData.ProjectID)
 
-	var assignee sql.NullInt64
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error stories.update.labels.", err)
 
-	if assignee_id == "0" {
-		assignee = sql.NullInt64{}
-	} else {
-		v, err := strconv.ParseInt(assignee_id, 10, 64)
+		respondError(w, r, internalError("Error updating story.", nil))
 
-		if err != nil {
-			assignee = sql.NullInt64{}
-		} else {
-			assignee = sql.NullInt64{
-				Int64: v,
-				Valid: true,
-			}
-		}
+		return
 	}
 
-	_, err = stmt.Exec(story_id, name, description, assignee)
+	labelIDs, err := readLabelIDs(r.PostForm, projectLabels)
 
 	if err != nil {
-		s.log.Error("Error stories.update.exec.", err)
+		respondError(w, r, err)
+		return
+	}
 
-		http.Error(w, "Error updating story.", http.StatusInternalServerError)
+	// the done box is unchecked to reopen the story
+	err = s.stores.stories.save(storyData, labelIDs, r.PostForm.Get("done") != "")
+
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error stories.update.exec.", err)
+
+		respondError(w, r, internalError("Error updating story.", nil))
 
 		return
 	}
@@ -519,105 +397,70 @@ WHERE id = $1
 }
 
 func (s *storyService) edit(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
+	storyData, err := s.stores.stories.find(parseID(ps.ByName("story_id")))
 
-	if !authUser.Can([]string{"update_stories_mine"}) && !authUser.Can([]string{"update_stories_others"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	story_id := ps.ByName("story_id")
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error stories.edit.scan.", err)
 
-	query := `
-SELECT
-id,
-name,
-description,
-feature_id,
-user_id,
-assignee_id,
-created_at,
-updated_at
+		respondError(w, r, internalError("Error editing story.", nil))
 
-FROM goissuez.stories
-WHERE id = $1
-LIMIT 1
-`
+		return
+	}
 
-	stmt, err := s.db.Prepare(query)
+	featureData, err := s.stores.features.find(storyData.FeatureID)
 
 	if err != nil {
-		s.log.Error("Error stories.edit.prepare.", err)
+		s.log.WithContext(r.Context()).Error("Error stories.edit.feature.", err)
 
-		http.Error(w, "Error editing story.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error editing story.", nil))
 
 		return
 	}
 
-	defer stmt.Close()
-
-	row := stmt.QueryRow(story_id)
+	projectLabels, err := s.stores.labels.byProject(featureData.ProjectID)
 
-	storyData := story{}
-	var assigneeID sql.NullInt64
+	var storyLabels map[int64][]label
 
-	err = row.Scan(
-		&storyData.ID,
-		&storyData.Name,
-		&storyData.Description,
-		&storyData.FeatureID,
-		&storyData.UserID,
-		&assigneeID,
-		&storyData.CreatedAt,
-		&storyData.UpdatedAt,
-	)
+	if err == nil {
+		storyLabels, err = s.stores.labels.forStories([]int64{storyData.ID})
+	}
 
 	if err != nil {
-		s.log.Error("Error stories.edit.scan.", err)
+		s.log.WithContext(r.Context()).Error("Error stories.edit.labels.", err)
 
-		http.Error(w, "Error editing story.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error editing story.", nil))
 
 		return
 	}
 
-	if assigneeID.Int64 == authUser.ID || storyData.UserID == authUser.ID {
-		if !authUser.Can([]string{"update_stories_mine"}) {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-	} else {
-		if !authUser.Can([]string{"update_stories_others"}) {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-	}
-
-	if assigneeID.Valid {
-		storyData.AssigneeID = assigneeID.Int64
-	}
-
-	users, _ := getUsers(s.db)
+	users, _ := s.stores.users.all()
 
 	pageData := page{
 		Title: "Edit Story - " + storyData.Name,
 		Data: struct {
-			Story story
-			Users []user
+			Story    story
+			Users    []user
+			Priority priorityField
+			Estimate estimateField
+			Labels   labelPicker
 		}{
 			storyData,
 			users,
+			newPriorityField(currentUser(r), storyData.Priority),
+			newEstimateField(*featureData.Project, storyData),
+			newLabelPicker(projectLabels, storyLabels[storyData.ID]),
 		},
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/stories/edit.gohtml")
+	view.make("stories/edit.gohtml")
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -627,77 +470,52 @@ LIMIT 1
 
 // Show the new / create feature form.
 func (s *storyService) create(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
 
-	if !authUser.Can([]string{"create_stories"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	parentFeatureID := ps.ByName("feature_id")
-
-	query := `
-SELECT
-id,
-name,
-description,
-user_id,
-created_at,
-updated_at
-FROM goissuez.features
-WHERE id = $1
-LIMIT 1
-`
-
-	stmt, err := s.db.Prepare(query)
+	featureData, err := s.stores.features.find(parseID(ps.ByName("feature_id")))
 
 	if err != nil {
-		s.log.Error("Error stories.create.prepare.", err)
+		s.log.WithContext(r.Context()).Error("Error stories.create.scan.", err)
 
-		http.Error(w, "Error creating story", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error creating story.", nil))
 
 		return
 	}
 
-	defer stmt.Close()
-
-	row := stmt.QueryRow(parentFeatureID)
-
-	featureData := feature{}
-
-	err = row.Scan(
-		&featureData.ID,
-		&featureData.Name,
-		&featureData.Description,
-		&featureData.UserID,
-		&featureData.CreatedAt,
-		&featureData.UpdatedAt,
-	)
+	projectLabels, err := s.stores.labels.byProject(featureData.ProjectID)
 
 	if err != nil {
-		s.log.Error("Error stories.create.scan.", err)
+		s.log.WithContext(r.Context()).Error("Error stories.create.labels.", err)
 
-		http.Error(w, "Error creating story.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error creating story.", nil))
 
 		return
 	}
 
-	users, _ := getUsers(s.db)
+	users, _ := s.stores.users.all()
 
 	pageData := page{Title: "Create a Story for " + featureData.Name, Data: struct {
-		Feature feature
-		Users   []user
-	}{Feature: featureData, Users: users}}
+		Feature  feature
+		Users    []user
+		Priority priorityField
+		Estimate estimateField
+		Labels   labelPicker
+	}{
+		Feature:  featureData,
+		Users:    users,
+		Priority: newPriorityField(currentUser(r), defaultPriority),
+		Estimate: newEstimateField(*featureData.Project, story{}),
+		Labels:   newLabelPicker(projectLabels, nil),
+	}}
 
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/stories/new.gohtml")
+	view.make("stories/new.gohtml")
 	err = view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -706,291 +524,168 @@ LIMIT 1
 }
 
 func (s *storyService) show(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"read_stories_mine"}) && !authUser.Can([]string{"read_stories_others"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	story_id := ps.ByName("story_id")
-
-	query := `
-SELECT
-s.id,
-s.name,
-s.description,
-s.feature_id,
-s.user_id,
-s.assignee_id,
-s.created_at,
-s.updated_at,
-s.deleted_at,
-f.id,
-f.name
-FROM goissuez.stories s
-JOIN goissuez.features f
-ON f.id = s.feature_id
-WHERE s.id = $1
-LIMIT 1
-`
-
-	stmt, err := s.db.Prepare(query)
+	storyData, err := s.stores.stories.find(parseID(ps.ByName("story_id")))
 
 	if err != nil {
-		s.log.Error("Error stories.show.prepare.", err)
+		s.log.WithContext(r.Context()).Error("Error stories.show.scan.", err)
 
-		http.Error(w, "Error getting story.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error getting story.", nil))
 
 		return
 	}
 
-	defer stmt.Close()
-
-	row := stmt.QueryRow(story_id)
-
-	storyData := story{Feature: &feature{}}
-
-	// this could be null if there is no assignee
-	var assigneeID sql.NullInt64
-	deleted_at := sql.NullString{}
-
-	err = row.Scan(
-		&storyData.ID,
-		&storyData.Name,
-		&storyData.Description,
-		&storyData.FeatureID,
-		&storyData.UserID,
-		&assigneeID,
-		&storyData.CreatedAt,
-		&storyData.UpdatedAt,
-		&deleted_at,
-		&storyData.Feature.ID,
-		&storyData.Feature.Name,
-	)
-
-	if err != nil {
-		s.log.Error("Error stories.show.scan.", err)
-
-		http.Error(w, "Error getting story.", http.StatusInternalServerError)
-
-		return
-	}
-
-	if assigneeID.Int64 == authUser.ID || storyData.UserID == authUser.ID {
-		if !authUser.Can([]string{"read_stories_mine"}) {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-	} else {
-		if !authUser.Can([]string{"read_stories_others"}) {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-	}
-
-	if deleted_at.Valid {
-		storyData.DeletedAt = deleted_at.String
-	}
-
-	if assigneeID.Valid {
-
-		storyData.AssigneeID = assigneeID.Int64
-
-		id := strconv.FormatInt(assigneeID.Int64, 10)
-
-		assignee, err := getUserByID(s.db, id)
+	if storyData.AssigneeID != 0 {
+		assignee, err := s.stores.users.find(storyData.AssigneeID)
 
 		if err != nil {
-			s.log.Error("Error stories.show.getUserByID", err)
+			s.log.WithContext(r.Context()).Error("Error stories.show.getUserByID", err)
 		} else {
 			storyData.Assignee = &assignee
 		}
 	}
 
-	creator, err := getUserByID(s.db, strconv.FormatInt(storyData.UserID, 10))
+	creator, err := s.stores.users.find(storyData.UserID)
 
 	if err != nil {
-		s.log.Error("Error stories.show.getUserByID", err)
+		s.log.WithContext(r.Context()).Error("Error stories.show.getUserByID", err)
 	} else {
 		storyData.Creator = &creator
 	}
 
-	pageData := page{Title: "Story Details", Data: storyData, Funcs: make(map[string]interface{})}
+	featureData, err := s.stores.features.find(storyData.FeatureID)
 
-	pageData.Funcs["ToJSON"] = func(storyData story) string {
-		b, err := json.Marshal(storyData)
-
-		if err != nil {
-			return ""
-		}
-
-		return string(b)
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error stories.show.feature.", err)
+	} else {
+		storyData.Project = featureData.Project
 	}
 
-	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
-
-	view := viewService{w: w, r: r}
-	view.make("templates/stories/story.gohtml")
-	err = view.exec(mainLayout, pageData)
+	storyLabels, err := s.stores.labels.forStories([]int64{storyData.ID})
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error("Error stories.show.labels.", err)
+
+		respondError(w, r, internalError("Error getting story labels.", nil))
 
 		return
 	}
 
-	view.send(http.StatusOK)
-}
-
-func (s *storyService) restore(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	storyData.Labels = storyLabels[storyData.ID]
 
-	story_id := ps.ByName("story_id")
-	storyData := story{}
-
-	tx, err := s.db.BeginTx(context.Background(), nil)
+	links, err := loadIssueLinks(
+		s.stores,
+		issueRef{Type: "story", ID: storyData.ID, Name: storyData.Name},
+		canOwnOrOthers(currentUser(r), "update_stories", storyData.UserID, storyData.AssigneeID),
+	)
 
 	if err != nil {
-		s.log.Error("Error stories.restore.begintx.", err)
+		s.log.WithContext(r.Context()).Error("Error stories.show.links.", err)
 
-		http.Error(w, "Error restoring story.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error getting story links.", nil))
 
 		return
 	}
 
-	// restore the story
-	{
-		stmt, err := tx.Prepare(`UPDATE goissuez.stories SET deleted_at = NULL WHERE id = $1 RETURNING feature_id`)
-
-		if err != nil {
-			s.log.Error("Error stories.restore.prepare.", err)
+	pageData := page{Title: "Story Details", Data: struct {
+		story
+		Links issueLinks
+	}{storyData, links}}
 
-			tx.Rollback()
-			http.Error(w, "Error restoring story.", http.StatusInternalServerError)
-			return
-		}
-
-		err = stmt.QueryRow(story_id).Scan(
-			&storyData.FeatureID,
-		)
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
-		if err != nil {
-			s.log.Error("Error stories.restore.scan.", err)
+	view := viewService{w: w, r: r}
+	view.make("stories/story.gohtml")
+	err = view.exec(mainLayout, pageData)
 
-			tx.Rollback()
-			http.Error(w, "Error restoring story.", http.StatusInternalServerError)
-			return
-		}
+	if err != nil {
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
+		return
 	}
 
-	// restore feature
-	{
-		stmt, err := tx.Prepare(`UPDATE goissuez.features SET deleted_at = NULL where id = $1`)
-
-		if err != nil {
-			s.log.Error("Error stories.restore.featurerestore.prepare.", err)
-
-			tx.Rollback()
-			http.Error(w, "Error restoring story.", http.StatusInternalServerError)
-			return
-		}
+	view.send(http.StatusOK)
+}
 
-		_, err = stmt.Exec(storyData.FeatureID)
+func (s *storyService) restore(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	featureID, err := s.stores.stories.restore(parseID(ps.ByName("story_id")))
 
-		if err != nil {
-			s.log.Error("Error stories.restore.featurerestore.exec.", err)
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error stories.restore.exec.", err)
 
-			tx.Rollback()
-			http.Error(w, "Error restoring story.", http.StatusInternalServerError)
-			return
-		}
+		respondError(w, r, internalError("Error restoring story.", nil))
+		return
 	}
 
-	tx.Commit()
-
-	feature_id := strconv.FormatInt(storyData.FeatureID, 10)
+	feature_id := strconv.FormatInt(featureID, 10)
 	http.Redirect(w, r, "/features/"+feature_id, http.StatusSeeOther)
 }
 
 func (s *storyService) destroy(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
-	story_id := ps.ByName("story_id")
+	err := s.stores.stories.destroy(parseID(ps.ByName("story_id")))
 
-	// ensure we have the correct permissions
-	{
-		storyData := story{}
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error stories.destroy.exec.", err)
 
-		stmt, err := s.db.Prepare(`SELECT user_id, assignee_id FROM goissuez.stories WHERE id = $1`)
+		respondError(w, r, internalError("Error deleting story.", nil))
 
-		if err != nil {
-			s.log.Error("Error stories.destroy.prepare.", err)
+		return
+	}
 
-			http.Error(w, "Error deleting story.", http.StatusInternalServerError)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Success"))
+}
 
-			return
-		}
+// rankBefore moves the story just before another in every list, for drag and drop on the feature page.
+func (s *storyService) rankBefore(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s.rank(w, r, ps, s.stores.stories.rankBefore)
+}
 
-		assignee_id := sql.NullInt64{}
+// rankAfter moves the story just after another in every list.
+func (s *storyService) rankAfter(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s.rank(w, r, ps, s.stores.stories.rankAfter)
+}
 
-		err = stmt.QueryRow(story_id).Scan(
-			&storyData.UserID,
-			&assignee_id,
-		)
+// rank ranks the story in the route next to the one named by a JSON story_id.
+func (s *storyService) rank(w http.ResponseWriter, r *http.Request, ps httprouter.Params, rankNextTo func(id, targetID int64) (string, error)) {
+	id := parseID(ps.ByName("story_id"))
 
-		if err != nil {
-			s.log.Error("Error stories.destroy.scan.", err)
+	targetID, err := readRankTarget(r, "story_id")
 
-			http.Error(w, "Error deleting story.", http.StatusInternalServerError)
+	if err == nil && targetID == id {
+		err = validationError("A story can't be ranked next to itself.")
+	}
 
-			return
-		}
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
 
-		if assignee_id.Valid {
-			storyData.AssigneeID = assignee_id.Int64
-		} else {
-			storyData.AssigneeID = 0
-		}
+	rank, err := rankNextTo(id, targetID)
 
-		if storyData.UserID == authUser.ID || storyData.AssigneeID == authUser.ID {
-			if !authUser.Can([]string{"delete_stories_mine"}) {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-		} else {
-			if !authUser.Can([]string{"delete_stories_others"}) {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-		}
+	if err == sql.ErrNoRows {
+		respondError(w, r, notFoundError("Story not found."))
+		return
 	}
 
-	stmt, err := s.db.Prepare(`UPDATE goissuez.stories SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1`)
-
 	if err != nil {
-		s.log.Error("Error stories.destroy.prepare.", err)
-
-		http.Error(w, "Error deleting story.", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error("Error stories.rank.exec.", err)
 
+		respondError(w, r, internalError("Error ranking story.", nil))
 		return
 	}
 
-	defer stmt.Close()
+	sendRank(w, rank)
+}
 
-	_, err = stmt.Exec(story_id)
+// owners returns the creator and assignee of the story named in the route.
+func (s *storyService) owners(ps httprouter.Params) ([]int64, error) {
+	storyData, err := s.stores.stories.find(parseID(ps.ByName("story_id")))
 
 	if err != nil {
-		s.log.Error("Error stories.destroy.exec.", err)
-
-		http.Error(w, "Error deleting story.", http.StatusInternalServerError)
-
-		return
+		return nil, err
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Success"))
+	return []int64{storyData.UserID, storyData.AssigneeID}, nil
 }
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// templateFuncs are available in every template.
+var templateFuncs = template.FuncMap{
+	"ToJSON": toJSON,
+	"asset":  assetURL,
+}
+
+// toJSON encodes data for data-* attributes read by the page modules.
+func toJSON(data interface{}) string {
+	b, err := json.Marshal(data)
+
+	if err != nil {
+		return ""
+	}
+
+	return string(b)
+}
+
+// templateRegistry parses every page together with the layouts once,
+// so requests only execute templates. Pages are named relative to the
+// templates directory, eg: "bugs/bug.gohtml".
+type templateRegistry struct {
+	files fs.FS
+	log   *logrus.Logger
+
+	mu    sync.RWMutex
+	pages map[string]*template.Template
+}
+
+func newTemplateRegistry(files fs.FS, logger *logrus.Logger) (*templateRegistry, error) {
+	registry := &templateRegistry{files: files, log: logger}
+
+	return registry, registry.load()
+}
+
+// load parses the layouts and then each page on a copy of them,
+// and swaps the result in only if everything parsed.
+func (t *templateRegistry) load() error {
+	layouts, err := template.New("").Funcs(templateFuncs).ParseFS(t.files, "layouts/*.gohtml")
+
+	if err != nil {
+		return err
+	}
+
+	pages := make(map[string]*template.Template)
+
+	err = t.walk(func(name string) error {
+		contents, err := fs.ReadFile(t.files, name)
+
+		if err != nil {
+			return err
+		}
+
+		tpl, err := layouts.Clone()
+
+		if err != nil {
+			return err
+		}
+
+		// naming the page lets pages without a layout be executed directly
+		_, err = tpl.New(name).Parse(string(contents))
+
+		if err != nil {
+			return err
+		}
+
+		pages[name] = tpl
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.pages = pages
+	t.mu.Unlock()
+
+	return nil
+}
+
+// walk calls fn for every page, skipping the layouts.
+func (t *templateRegistry) walk(fn func(name string) error) error {
+	return fs.WalkDir(t.files, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || !strings.HasSuffix(name, ".gohtml") || strings.HasPrefix(name, "layouts/") {
+			return nil
+		}
+
+		return fn(name)
+	})
+}
+
+// page returns the named page parsed with the layouts.
+func (t *templateRegistry) page(name string) (*template.Template, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	tpl, ok := t.pages[name]
+
+	if !ok {
+		return nil, fmt.Errorf("template %q not found", name)
+	}
+
+	return tpl, nil
+}
+
+// execute runs a page that doesn't use a layout.
+func (t *templateRegistry) execute(w io.Writer, name string, data interface{}) error {
+	tpl, err := t.page(name)
+
+	if err != nil {
+		return err
+	}
+
+	return tpl.ExecuteTemplate(w, name, data)
+}
+
+// hasLayout reports whether the templates are loaded with the given layout.
+func (t *templateRegistry) hasLayout(layout string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, tpl := range t.pages {
+		return tpl.Lookup(layout) != nil
+	}
+
+	return false
+}
+
+// watch reloads the templates whenever a file in the templates directory
+// is added, removed or changed, until stop is closed. It checks every interval.
+// A template that fails to parse is logged and the previous templates are kept.
+func (t *templateRegistry) watch(interval time.Duration, stop <-chan struct{}) {
+	last := t.fingerprint()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		current := t.fingerprint()
+
+		if current == last {
+			continue
+		}
+
+		last = current
+
+		err := t.load()
+
+		if err != nil {
+			t.log.Error("Error templates.watch.load.", err)
+			continue
+		}
+
+		t.log.Info("Reloaded templates.")
+	}
+}
+
+// fingerprint sums up the names, sizes and modification times of the template files.
+func (t *templateRegistry) fingerprint() string {
+	var b strings.Builder
+
+	fs.WalkDir(t.files, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+
+		if err == nil {
+			fmt.Fprintf(&b, "%s %d %d\n", name, info.Size(), info.ModTime().UnixNano())
+		}
+
+		return nil
+	})
+
+	return b.String()
+}
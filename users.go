@@ -1,30 +1,29 @@
 package main
 
 import (
-	"context"
-	"database/sql"
 	"github.com/sirupsen/logrus"
-	"html/template"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
-	"github.com/lib/pq"
 )
 
 type userService struct {
-	db   *sql.DB
-	log  *logrus.Logger
-	tpls *template.Template
+	stores stores
+	log    *logrus.Logger
+	tpls   *templateRegistry
 }
 
 type user struct {
-	ID          int64
-	Name        string
-	Username    string
-	Password    string
+	ID       int64
+	Name     string
+	Username string
+	// Password is the bcrypt hash. It is never sent to the browser.
+	Password    string `json:"-"`
 	PhotoUrl    string
 	Email       string
 	CreatedAt   string
@@ -36,12 +35,16 @@ type user struct {
 	RoleID      int64
 	Role        role
 	Permissions map[string]capability
+	// Impersonator is the admin viewing the app as this user, if any.
+	Impersonator *user
 }
 
 // can checks the authenticated user permissions.
 // if the user isn't authenticated, then false will be returned
 func (u *user) Can(capabilities []string) bool {
 
+	flagUnknownCapabilities(capabilities)
+
 	if u.RoleID == 0 {
 		return false
 	}
@@ -58,62 +61,42 @@ func (u *user) Can(capabilities []string) bool {
 	return true
 }
 
-func NewUserService(db *sql.DB, logger *logrus.Logger, tpls *template.Template) *userService {
-	return &userService{db, logger, tpls}
-}
+// Initials stands in for the user's photo when they haven't uploaded one, eg: "JD" for Jane Doe.
+func (u user) Initials() string {
+	initials := ""
 
-func (s *userService) index(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	for i, word := range strings.Fields(u.Name) {
+		if i == 2 {
+			break
+		}
 
-	s.log.Error("Listing users.")
+		initials += strings.ToUpper(string([]rune(word)[:1]))
+	}
 
-	stmt, err := s.db.Prepare(`select id, name, email, username, photo_url from goissuez.users`)
+	return initials
+}
 
-	if handleError(err, "Error listing users.") {
-		http.Error(w, err.Error(), 500)
+func NewUserService(store stores, logger *logrus.Logger, tpls *templateRegistry) *userService {
+	return &userService{store, logger, tpls}
+}
 
-		return
-	}
+func (s *userService) index(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 
-	defer stmt.Close()
+	s.log.WithContext(r.Context()).Debug("Listing users.")
 
-	rows, err := stmt.Query()
+	users, err := s.stores.users.all()
 
-	if handleError(err, "Error listing users.") {
-		http.Error(w, err.Error(), 500)
+	if err != nil {
+		respondError(w, r, internalError("Error listing users.", err))
 
 		return
 	}
 
-	users := []user{}
-
-	for rows.Next() {
-
-		userData := user{}
-		var photo_url sql.NullString
-
-		if err := rows.Scan(
-			&userData.ID,
-			&userData.Name,
-			&userData.Email,
-			&userData.Username,
-			&photo_url,
-			&userData.CreatedAt,
-			&userData.UpdatedAt,
-			&userData.LastLogin,
-		); err != nil {
-			log.Fatal(err)
-		}
-
-		if photo_url.Valid {
-			userData.PhotoUrl = photo_url.String
-		}
+	err = s.tpls.execute(w, "users/users.gohtml", struct{ Users []user }{users})
 
-		users = append(users, userData)
+	if err != nil {
+		s.log.WithContext(r.Context()).Error("Error users.index.exec.", err)
 	}
-
-	err = s.tpls.ExecuteTemplate(w, "users/users.gohtml", struct{ Users []user }{users})
-
-	handleError(err, "Failed to execute users.gohtml.")
 }
 
 func (s *userService) store(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
@@ -136,35 +119,34 @@ func (s *userService) store(w http.ResponseWriter, r *http.Request, _ httprouter
 	if len(form_names) > 0 && form_names[0] != "" {
 		name = form_names[0]
 	} else {
-		http.Error(w, "NAME is required.", http.StatusUnprocessableEntity)
+		respondError(w, r, validationError("NAME is required."))
 		return
 	}
 
 	if len(form_usernames) > 0 && form_usernames[0] != "" {
 		username = form_usernames[0]
 	} else {
-		http.Error(w, "USERNAME is required.", http.StatusUnprocessableEntity)
+		respondError(w, r, validationError("USERNAME is required."))
 		return
 	}
 
 	if len(form_emails) > 0 && form_emails[0] != "" {
 		email = form_emails[0]
 	} else {
-		http.Error(w, "EMAIL is required.", http.StatusUnprocessableEntity)
+		respondError(w, r, validationError("EMAIL is required."))
 		return
 	}
 
 	if len(form_passwords) > 0 && form_passwords[0] != "" {
 		password = form_passwords[0]
 	} else {
-		http.Error(w, "PASSWORD is required.", http.StatusUnprocessableEntity)
+		respondError(w, r, validationError("PASSWORD is required."))
 		return
 	}
 
 	// Profile photo is NOT required
-	// must use sql.NullString to ensure we have NULL set in the DB
-	// when we don't have a file.
-	var photoPathToSave sql.NullString
+	// the store saves NULL when we don't have a file.
+	var photoPathToSave string
 	{
 		// the profile_url is not required
 		pic, pic_header, err := r.FormFile("pic")
@@ -175,20 +157,22 @@ func (s *userService) store(w http.ResponseWriter, r *http.Request, _ httprouter
 			bs, err := ioutil.ReadAll(pic)
 
 			if err != nil {
-				http.Error(w, err.Error(), 500)
+				respondError(w, r, internalError("Error saving the photo.", err))
 
 				return
 			}
 
-			photoPathToSave.String = "img/users/" + pic_header.Filename
-			photoPathToSave.Valid = true
+			// only keep the base name so uploads can't be written outside the upload dir
+			filename := filepath.Base(pic_header.Filename)
 
-			dstPath := filepath.Join("./public/assets/" + photoPathToSave.String)
+			photoPathToSave = "uploads/" + filename
+
+			dstPath := filepath.Join(cfg.UploadDir, filename)
 
 			dst, err := os.Create(dstPath)
 
 			if err != nil {
-				http.Error(w, err.Error(), 500)
+				respondError(w, r, internalError("Error saving the photo.", err))
 
 				return
 			}
@@ -199,180 +183,88 @@ func (s *userService) store(w http.ResponseWriter, r *http.Request, _ httprouter
 			_, err = dst.Write(bs)
 
 			if err != nil {
-				http.Error(w, err.Error(), 500)
+				respondError(w, r, internalError("Error saving the photo.", err))
 
 				return
 			}
 		}
 	}
 
-	s.log.Error("Creating user: ", name, email, password, username, photoPathToSave)
-
-	stmt, err := s.db.Prepare(`
-insert into goissuez.users (name, email, password, username, photo_url, created_at, updated_at, last_login )
-VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP) RETURNING id
-`)
+	s.log.WithContext(r.Context()).Debug("Creating user ", username)
 
-	if handleError(err, "Failed to prepare statement.") {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-
-		return
+	userData := user{
+		Name:     name,
+		Email:    email,
+		Password: password,
+		Username: username,
+		PhotoUrl: photoPathToSave,
 	}
 
-	defer stmt.Close()
+	id, err := s.stores.users.create(userData)
 
-	var (
-		id int64
-	)
+	if isUniqueViolation(err) {
+		respondError(w, r, conflictError("That username or email is already taken."))
 
-	err = stmt.QueryRow(name, email, password, username, photoPathToSave).Scan(&id)
+		return
+	}
 
-	if handleError(err, "Failed to query  row.") {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err != nil {
+		respondError(w, r, internalError("Error creating user.", err))
 
 		return
 	}
 
-	s.log.Error("Created user - ", string(id))
+	s.log.WithContext(r.Context()).Info("Created user - ", id)
 
 	// redirect to GET("/users/:id")
 	// this redirect will not work if the status isn't 303
-	http.Redirect(w, r, "/users/"+string(id), http.StatusSeeOther)
+	http.Redirect(w, r, "/users/"+strconv.FormatInt(id, 10), http.StatusSeeOther)
 }
 
 func (s *userService) show(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"read_users"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
 	user_id := ps.ByName("user_id")
 
-	// TODO: get user by id:
-	stmt, e1 := s.db.Prepare(`select * from goissuez.users where id = $1 limit 1`)
+	userProfile, e2 := s.stores.users.find(parseID(user_id))
 
-	if handleError(e1, "Failed to prepare show user.") {
-		http.Error(w, e1.Error(), 500)
+	if e2 != nil {
+		respondError(w, r, e2)
 
 		return
 	}
 
-	defer stmt.Close()
-
-	row := stmt.QueryRow(user_id)
+	e3 := s.tpls.execute(w, "users/user.gohtml", struct{ User user }{userProfile})
 
-	var (
-		id         int64
-		name       string
-		email      string
-		password   string
-		username   string
-		photo_url  string
-		created_at string
-		updated_at string
-		last_login string
-	)
-
-	e2 := row.Scan(&id, &name, &email, &password, &username, &photo_url, &created_at, &updated_at, &last_login)
-
-	if handleError(e2, "Failed to scan user row.") {
-		http.Error(w, e2.Error(), 500)
-
-		return
+	if e3 != nil {
+		s.log.WithContext(r.Context()).Error("Error users.show.exec.", e3)
 	}
-
-	userProfile := user{
-		ID:        id,
-		Name:      name,
-		Username:  username,
-		Email:     email,
-		CreatedAt: created_at,
-		UpdatedAt: updated_at,
-		LastLogin: last_login,
-	}
-
-	e3 := s.tpls.ExecuteTemplate(w, "users/user.gohtml", struct{ User user }{userProfile})
-
-	handleError(e3, "Failed to execture user.gohtml")
 }
 
 func (s *userService) projects(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"read_users", "read_projects_mine"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
 	user_id := ps.ByName("user_id")
 
-	// can ignore 'ok' b/c this route is behind auth.guard()
-	// authUser, _ := r.Context().Value("user").(user)
-
-	stmt, err := s.db.Prepare(`
-SELECT id, name, description, user_id, created_at, updated_at
-FROM goissuez.projects
-WHERE user_id = $1
-`)
+	projects, err := s.stores.projects.byUser(parseID(user_id))
 
 	if err != nil {
-		s.log.Error(err)
+		s.log.WithContext(r.Context()).Error(err)
 
-		http.Error(w, "Error listing user projects.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error listing user projects.", nil))
 		return
 	}
 
-	defer stmt.Close()
-
-	rows, err := stmt.Query(user_id)
-
-	if err != nil {
-		s.log.Error(err)
-
-		http.Error(w, "Error listing user projects.", http.StatusInternalServerError)
-		return
-	}
-
-	projects := []project{}
-
-	for rows.Next() {
-		projectData := project{}
-
-		err := rows.Scan(
-			&projectData.ID,
-			&projectData.Name,
-			&projectData.Description,
-			&projectData.UserID,
-			&projectData.CreatedAt,
-			&projectData.UpdatedAt,
-		)
-
-		if err != nil {
-			s.log.Error(err)
-
-			http.Error(w, "Error listing user projects.", http.StatusInternalServerError)
-			return
-		}
-
-		projects = append(projects, projectData)
-	}
-
 	pageData := page{Title: "User Projects", Data: projects}
 
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/users/projects.gohtml")
+	view.make("users/projects.gohtml")
 
 	err = view.exec("dashboard_layout", pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -383,137 +275,29 @@ WHERE user_id = $1
 // List the features with which this user is involved.
 // A user is involved in a feature if they are assigned
 // to either a story or a bug associated with that feature.
+// A feature should be considered "MINE" if that user is involved with them somehow.
 func (s *userService) features(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
-	authUser, _ := auth.getAuthUser(r)
-
-	// a feature should be considered "MINE" if that user is involved with them somehow
-	if !authUser.Can([]string{"read_features"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	user_id := ps.ByName("user_id")
-	feature_ids := []int64{}
-	features := []feature{}
-	userData, err := getUserByID(s.db, user_id)
-
-	if err != nil {
-		s.log.Error("Error users.features.query.user.", err)
-
-		http.Error(w, "Error listing user features.", http.StatusInternalServerError)
-
-		return
-	}
-
-	stmt, err := s.db.Prepare(`
-SELECT
-b.feature_id as bug_feature_id,
-s.feature_id as story_feature_id
-FROM
-   (SELECT DISTINCT feature_id FROM goissuez.bugs b WHERE b.assignee_id = $1 ) b
-   FULL OUTER JOIN
-   	(SELECT DISTINCT feature_id FROM goissuez.stories s WHERE s.assignee_id = $1) s
-   ON b.feature_id = s.feature_id
-`)
-
-	if err != nil {
-		s.log.Error("Error users.features.prepare.feature_ids.", err)
-
-		http.Error(w, "Error listing features.", http.StatusInternalServerError)
-
-		return
-	}
-
-	defer stmt.Close()
-
-	rows, err := stmt.Query(user_id)
-
-	if err != nil {
-		s.log.Error("Error users.features.query.feature_ids.", err)
-
-		http.Error(w, "Error listing features.", http.StatusInternalServerError)
-
-		return
-	}
-
-	for rows.Next() {
-
-		var bugFeatureID sql.NullInt64
-		var storyFeatureID sql.NullInt64
-
-		if err := rows.Scan(
-			&bugFeatureID,
-			&storyFeatureID,
-		); err != nil {
-			s.log.Error("Error users.features.scan.", err)
-
-			http.Error(w, "Error listing features.", http.StatusInternalServerError)
-
-			return
-		}
-
-		if bugFeatureID.Valid {
-			feature_ids = append(feature_ids, bugFeatureID.Int64)
-		} else if storyFeatureID.Valid {
-			feature_ids = append(feature_ids, storyFeatureID.Int64)
-		}
-
-	}
-
-	stmt, err = s.db.Prepare(`
-SELECT
-id,
-name,
-description,
-created_at,
-updated_at
-FROM goissuez.features
-WHERE id = ANY($1)
-`)
+	userData, err := s.stores.users.find(parseID(ps.ByName("user_id")))
 
 	if err != nil {
-		s.log.Error("Error users.features.prepare.", err)
+		s.log.WithContext(r.Context()).Error("Error users.features.query.user.", err)
 
-		http.Error(w, "Error listing features.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error listing user features.", nil))
 
 		return
 	}
 
-	defer stmt.Close()
-
-	rows, err = stmt.Query(pq.Array(feature_ids))
+	features, err := s.stores.features.assignedTo(userData.ID)
 
 	if err != nil {
-		s.log.Error("Error users.features.query.", err)
+		s.log.WithContext(r.Context()).Error("Error users.features.query.", err)
 
-		http.Error(w, "Error listing features.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error listing features.", nil))
 
 		return
 	}
 
-	for rows.Next() {
-		featureData := feature{}
-
-		err := rows.Scan(
-			&featureData.ID,
-			&featureData.Name,
-			&featureData.Description,
-			&featureData.CreatedAt,
-			&featureData.UpdatedAt,
-		)
-
-		if err != nil {
-			s.log.Error("Error users.features.scan.", err)
-
-			http.Error(w, "Error listing features.", http.StatusInternalServerError)
-
-			return
-		}
-
-		features = append(features, featureData)
-	}
-
 	pageData := page{
 		Title: userData.Name + " - Features",
 		Data: struct {
@@ -528,13 +312,13 @@ WHERE id = ANY($1)
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/users/features.gohtml")
+	view.make("users/features.gohtml")
 
 	err = view.exec("dashboard_layout", pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -543,101 +327,55 @@ WHERE id = ANY($1)
 }
 
 func (s *userService) stories(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"read_stories_mine"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	user_id := ps.ByName("user_id")
-	stories := []story{}
-	userData, err := getUserByID(s.db, user_id)
+	userData, err := s.stores.users.find(parseID(ps.ByName("user_id")))
 
 	if err != nil {
-		s.log.Error("Error users.stories.query.user.", err)
+		s.log.WithContext(r.Context()).Error("Error users.stories.query.user.", err)
 
-		http.Error(w, "Error listing user stories.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error listing user stories.", nil))
 
 		return
 	}
 
-	stmt, err := s.db.Prepare(`
-SELECT
-id,
-name,
-assignee_id,
-created_at,
-updated_at
-FROM goissuez.stories
-WHERE assignee_id = $1
-ORDER BY updated_at
-`)
-
-	if err != nil {
-		s.log.Error("Error users.stories.prepare.", err)
-
-		http.Error(w, "Error listing user stories.", http.StatusInternalServerError)
+	stories, err := s.stores.stories.assignedTo(userData.ID)
 
-		return
+	if err == nil {
+		err = withStoryLabels(s.stores, stories)
 	}
 
-	defer stmt.Close()
-
-	rows, err := stmt.Query(user_id)
-
 	if err != nil {
-		s.log.Error("Error users.stories.query.", err)
+		s.log.WithContext(r.Context()).Error("Error users.stories.query.", err)
 
-		http.Error(w, "Error listing user stories.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error listing user stories.", nil))
 
 		return
 	}
 
-	for rows.Next() {
-
-		storyData := story{}
-
-		err := rows.Scan(
-			&storyData.ID,
-			&storyData.Name,
-			&storyData.AssigneeID,
-			&storyData.CreatedAt,
-			&storyData.UpdatedAt,
-		)
-
-		if err != nil {
-			s.log.Error("Error users.stories.scan.", err)
-
-			http.Error(w, "Error listing user stories.", http.StatusInternalServerError)
-
-			return
-		}
-
-		stories = append(stories, storyData)
-	}
+	stories, filter := filterStories(stories, r.URL.Query().Get("label"))
 
 	pageData := page{
 		Title: userData.Name + " - Stories",
 		Data: struct {
 			Stories  []story
 			Assignee user
+			Labels   labelFilter
 		}{
 			stories,
 			userData,
+			filter,
 		},
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/users/stories.gohtml")
+	view.make("users/stories.gohtml")
 
 	err = view.exec("dashboard_layout", pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -646,101 +384,55 @@ ORDER BY updated_at
 }
 
 func (s *userService) bugs(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"read_bugs_mine"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	user_id := ps.ByName("user_id")
-	bugs := []bug{}
-	userData, err := getUserByID(s.db, user_id)
+	userData, err := s.stores.users.find(parseID(ps.ByName("user_id")))
 
 	if err != nil {
-		s.log.Error("Error users.bugs.query.user.", err)
+		s.log.WithContext(r.Context()).Error("Error users.bugs.query.user.", err)
 
-		http.Error(w, "Error listing user bugs.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error listing user bugs.", nil))
 
 		return
 	}
 
-	stmt, err := s.db.Prepare(`
-SELECT
-id,
-name,
-assignee_id,
-created_at,
-updated_at
-FROM goissuez.bugs
-WHERE assignee_id = $1
-ORDER BY updated_at
-`)
-
-	if err != nil {
-		s.log.Error("Error users.bugs.prepare.", err)
-
-		http.Error(w, "Error listing user bugs.", http.StatusInternalServerError)
+	bugs, err := s.stores.bugs.assignedTo(userData.ID)
 
-		return
+	if err == nil {
+		err = withBugLabels(s.stores, bugs)
 	}
 
-	defer stmt.Close()
-
-	rows, err := stmt.Query(user_id)
-
 	if err != nil {
-		s.log.Error("Error users.bugs.query.", err)
+		s.log.WithContext(r.Context()).Error("Error users.bugs.query.", err)
 
-		http.Error(w, "Error listing user bugs.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Error listing user bugs.", nil))
 
 		return
 	}
 
-	for rows.Next() {
-
-		bugData := bug{}
-
-		err := rows.Scan(
-			&bugData.ID,
-			&bugData.Name,
-			&bugData.AssigneeID,
-			&bugData.CreatedAt,
-			&bugData.UpdatedAt,
-		)
-
-		if err != nil {
-			s.log.Error("Error users.bugs.scan.", err)
-
-			http.Error(w, "Error listing user bugs.", http.StatusInternalServerError)
-
-			return
-		}
-
-		bugs = append(bugs, bugData)
-	}
+	bugs, filter := filterBugs(bugs, r.URL.Query().Get("label"))
 
 	pageData := page{
 		Title: userData.Name + " - Bugs",
 		Data: struct {
 			Bugs     []bug
 			Assignee user
+			Labels   labelFilter
 		}{
 			bugs,
 			userData,
+			filter,
 		},
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/users/bugs.gohtml")
+	view.make("users/bugs.gohtml")
 
 	err = view.exec("dashboard_layout", pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -750,20 +442,20 @@ ORDER BY updated_at
 
 func (s *userService) dashboard(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 
-	authUser, _ := auth.getAuthUser(r)
+	authUser := currentUser(r)
 
 	pageData := page{Title: "User Dashboard - " + authUser.Name, Data: nil}
 
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
 	view := viewService{w: w, r: r}
-	view.make("templates/users/dashboard.gohtml")
+	view.make("users/dashboard.gohtml")
 
 	err := view.exec(mainLayout, pageData)
 
 	if err != nil {
-		s.log.Error(err)
-		http.Error(w, "Error", http.StatusInternalServerError)
+		s.log.WithContext(r.Context()).Error(err)
+		respondError(w, r, internalError("Something went wrong.", nil))
 
 		return
 	}
@@ -774,260 +466,17 @@ func (s *userService) dashboard(w http.ResponseWriter, r *http.Request, _ httpro
 // destory user SOFT DELETES a user by just adding a deleted_at field.
 func (s *userService) destroy(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
-	authUser, _ := auth.getAuthUser(r)
-
-	if !authUser.Can([]string{"delete_users"}) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	user_id := ps.ByName("user_id")
-
-	ctx := context.Background()
-
-	tx, err := s.db.BeginTx(ctx, nil)
-
-	if err != nil {
-		s.log.Error("Error users.destroy.begintx.", err)
-
-		http.Error(w, "Cannot delete user.", http.StatusInternalServerError)
-		return
-	}
-
-	stmt, err := tx.Prepare(`
-UPDATE goissuez.users
-SET deleted_at = CURRENT_TIMESTAMP
-WHERE id = $1
-`)
+	err := s.stores.users.destroy(parseID(ps.ByName("user_id")))
 
 	if err != nil {
-		s.log.Error("Error users.destroy.prepare.", err)
-
-		tx.Rollback()
-
-		http.Error(w, "Cannot delete user.", http.StatusInternalServerError)
-		return
-	}
-
-	defer stmt.Close()
-
-	_, err = stmt.Exec(user_id)
-
-	if err != nil {
-		s.log.Error("Error users.destroy.exec.", err)
-
-		tx.Rollback()
-
-		http.Error(w, "Cannot delete user.", http.StatusInternalServerError)
-		return
-	}
-
-	// update all stories
-	stmt, err = tx.Prepare(`
-UPDATE goissuez.stories
-SET assignee_id = NULL
-WHERE assignee_id = $1
-`)
-
-	if err != nil {
-		s.log.Error("Error users.destroy.update.stories.prepare.", err)
-
-		tx.Rollback()
-
-		http.Error(w, "Cannot delete user.", http.StatusInternalServerError)
-		return
-	}
-
-	defer stmt.Close()
-
-	_, err = stmt.Exec(user_id)
-
-	if err != nil {
-		s.log.Error("Error users.destroy.update.stories.exec.", err)
-
-		tx.Rollback()
-
-		http.Error(w, "Cannot delete user.", http.StatusInternalServerError)
-		return
-	}
-
-	// update all bugs
-	stmt, err = tx.Prepare(`
-UPDATE goissuez.bugs
-SET assignee_id = NULL
-WHERE assignee_id = $1
-`)
-
-	if err != nil {
-		s.log.Error("Error users.destroy.update.bugs.prepare.", err)
-
-		tx.Rollback()
+		s.log.WithContext(r.Context()).Error("Error users.destroy.exec.", err)
 
-		http.Error(w, "Cannot delete user.", http.StatusInternalServerError)
+		respondError(w, r, internalError("Cannot delete user.", nil))
 		return
 	}
 
-	defer stmt.Close()
-
-	_, err = stmt.Exec(user_id)
-
-	if err != nil {
-		s.log.Error("Error users.destroy.update.bugs.exec.", err)
-
-		tx.Rollback()
-
-		http.Error(w, "Cannot delete user.", http.StatusInternalServerError)
-		return
-	}
-
-	err = tx.Commit()
-
-	if err != nil {
-		s.log.Error("Error users.destroy.committx.", err)
-
-		http.Error(w, "Cannot delete user.", http.StatusInternalServerError)
-		return
-	}
+	auth.cache.forgetUser(parseID(ps.ByName("user_id")))
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 }
-
-func getUserByUsername(db *sql.DB, username string) (user, error) {
-	userData := user{}
-
-	stmt, err := db.Prepare(`SELECT id, name, email, username, password, photo_url FROM goissuez.users u WHERE u.username = $1 LIMIT 1`)
-
-	if err != nil {
-		return userData, err
-	}
-
-	defer stmt.Close()
-
-	row := stmt.QueryRow(username)
-
-	var photo_url sql.NullString
-
-	err = row.Scan(
-		&userData.ID,
-		&userData.Name,
-		&userData.Email,
-		&userData.Username,
-		&userData.Password,
-		&photo_url,
-	)
-
-	if err != nil {
-		return userData, err
-	}
-
-	if photo_url.Valid {
-		userData.PhotoUrl = photo_url.String
-	}
-
-	return userData, nil
-}
-
-func getUserByID(db *sql.DB, user_id string) (user, error) {
-
-	stmt, err := db.Prepare(`
-SELECT
-id,
-name,
-username,
-photo_url,
-email,
-last_login
-FROM goissuez.users
-WHERE id = $1
-LIMIT 1
-`)
-
-	if err != nil {
-		return user{}, err
-	}
-
-	defer stmt.Close()
-
-	row := stmt.QueryRow(user_id)
-
-	userData := user{}
-
-	var photo_url sql.NullString
-
-	err = row.Scan(
-		&userData.ID,
-		&userData.Name,
-		&userData.Username,
-		&photo_url,
-		&userData.Email,
-		&userData.LastLogin,
-	)
-
-	if err != nil {
-		return user{}, err
-	}
-
-	if photo_url.Valid {
-		userData.PhotoUrl = photo_url.String
-	}
-
-	return userData, nil
-}
-
-func getUsers(db *sql.DB) ([]user, error) {
-	users := []user{}
-
-	stmt, err := db.Prepare(`
-SELECT
-id,
-name,
-email,
-username,
-photo_url,
-created_at,
-updated_at,
-last_login
-FROM goissuez.users
-ORDER BY created_at
-`)
-
-	if err != nil {
-		return []user{}, err
-	}
-
-	defer stmt.Close()
-
-	rows, err := stmt.Query()
-
-	if err != nil {
-		return []user{}, err
-	}
-
-	for rows.Next() {
-
-		userData := user{}
-		var photo_url sql.NullString
-
-		if err := rows.Scan(
-			&userData.ID,
-			&userData.Name,
-			&userData.Email,
-			&userData.Username,
-			&photo_url,
-			&userData.CreatedAt,
-			&userData.UpdatedAt,
-			&userData.LastLogin,
-		); err != nil {
-			return []user{}, err
-		}
-
-		if photo_url.Valid {
-			userData.PhotoUrl = photo_url.String
-		}
-
-		users = append(users, userData)
-	}
-
-	return users, nil
-}
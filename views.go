@@ -2,39 +2,36 @@ package main
 
 import (
 	"bytes"
-	"html/template"
 	"net/http"
+	"time"
 )
 
 type page struct {
+	// BaseURL is filled in from the config for scripts that build absolute URLs.
+	BaseURL    string
 	Title      string
 	Data       interface{}
 	Content    interface{}
 	AuthUser   user
 	IsLoggedIn bool
-	Funcs      map[string]interface{}
 }
 
 type viewService struct {
 	w http.ResponseWriter
 	r *http.Request
-	t *template.Template
 	b *bytes.Buffer
+	// name is the page's template, eg: "bugs/bug.gohtml"
+	name string
 }
 
 func NewView(w http.ResponseWriter, r *http.Request) *viewService {
 	return &viewService{w: w, r: r}
 }
 
-func (s *viewService) make(filesnames ...string) {
-
-	// get ALL available layouts
-	tpls := template.Must(template.New("").ParseGlob("templates/layouts/*.gohtml"))
-
-	// now parse the specific content files we want
-	tpls = template.Must(tpls.ParseFiles(filesnames...))
-
-	s.t = tpls
+// make picks the page to render from the template registry,
+// named relative to the templates directory, eg: "bugs/bug.gohtml"
+func (s *viewService) make(name string) {
+	s.name = name
 }
 
 func (s *viewService) exec(layout string, data interface{}) error {
@@ -47,6 +44,8 @@ func (s *viewService) exec(layout string, data interface{}) error {
 		pageData = data.(page)
 	}
 
+	pageData.BaseURL = cfg.BaseURL
+
 	authUser, ok := auth.getAuthUser(s.r)
 
 	if ok {
@@ -57,9 +56,21 @@ func (s *viewService) exec(layout string, data interface{}) error {
 		pageData.IsLoggedIn = false
 	}
 
+	t, err := tpls.page(s.name)
+
+	if err != nil {
+		return err
+	}
+
 	s.b = bufpool.Get()
 
-	return s.t.ExecuteTemplate(s.b, layout, pageData)
+	started := time.Now()
+
+	err = t.ExecuteTemplate(s.b, layout, pageData)
+
+	metrics.render(s.name, time.Since(started))
+
+	return err
 }
 
 func (s *viewService) send(status int) {